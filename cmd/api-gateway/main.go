@@ -17,6 +17,7 @@ func main() {
 		{"auth", "services/auth/main.go"},
 		{"pds", "services/pds/main.go"},
 		{"bgs", "services/bgs/main.go"},
+		{"plc", "services/plc/main.go"},
 		{"api-gateway", "services/api-gateway/main.go"},
 	}
 
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/yourusername/atprogo/pkg/lexicon"
+)
+
+// Import paths of the packages generated code uses
+const (
+	lexiconImport = "github.com/yourusername/atprogo/pkg/lexicon"
+	xrpcImport    = "github.com/yourusername/atprogo/pkg/xrpc"
+)
+
+// generator generates Go code for a set of lexicon schemas
+type generator struct {
+	pkg     string
+	schemas map[string]*lexicon.Schema
+}
+
+// newGenerator creates a generator for schemas. Type names are checked up
+// front, so that two definitions mapping to the same Go name are reported
+// instead of generating code that does not compile.
+func newGenerator(pkg string, schemas []*lexicon.Schema) (*generator, error) {
+	g := &generator{
+		pkg:     pkg,
+		schemas: make(map[string]*lexicon.Schema, len(schemas)),
+	}
+	names := make(map[string]string)
+	for _, schema := range schemas {
+		if _, ok := g.schemas[schema.ID]; ok {
+			return nil, fmt.Errorf("duplicate schema %s", schema.ID)
+		}
+		g.schemas[schema.ID] = schema
+
+		for _, defName := range defNames(schema) {
+			name := typeName(schema.ID, defName)
+			ref := lexicon.NormalizeRef("#"+defName, schema.ID)
+			if other, ok := names[name]; ok {
+				return nil, fmt.Errorf("%s and %s both generate %s", other, ref, name)
+			}
+			names[name] = ref
+		}
+	}
+	return g, nil
+}
+
+// generate returns the generated Go files by file name
+func (g *generator) generate() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+
+	ids := make([]string, 0, len(g.schemas))
+	for id := range g.schemas {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		src, err := g.generateSchema(g.schemas[id])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", id, err)
+		}
+		files[strings.ToLower(typeName(id, "main"))+".go"] = src
+	}
+
+	client := &file{g: g, imports: map[string]bool{xrpcImport: true}}
+	client.printf("// Client calls the XRPC methods generated from lexicons\n")
+	client.printf("type Client struct {\n\t*xrpc.Client\n}\n\n")
+	client.printf("// NewClient creates a client for the XRPC methods on a host\n")
+	client.printf("func NewClient(host string) *Client {\n\treturn &Client{Client: xrpc.NewClient(host)}\n}\n")
+	src, err := client.source("lexicons")
+	if err != nil {
+		return nil, err
+	}
+	files["client.go"] = src
+
+	return files, nil
+}
+
+// generateSchema generates the Go file for one schema
+func (g *generator) generateSchema(schema *lexicon.Schema) ([]byte, error) {
+	f := &file{g: g, schemaID: schema.ID, imports: make(map[string]bool)}
+
+	for _, defName := range defNames(schema) {
+		def := schema.Defs[defName]
+		name := typeName(schema.ID, defName)
+		ref := lexicon.NormalizeRef("#"+defName, schema.ID)
+
+		switch def.Type {
+		case lexicon.TypeRecord:
+			f.printf("// %sNSID is the type of %s records\n", name, ref)
+			f.printf("const %sNSID = %q\n\n", name, ref)
+			if def.Record == nil {
+				return nil, fmt.Errorf("record %s has no record schema", ref)
+			}
+			f.genStruct(name, "is the "+ref+" record", def.Record, schema.ID, def.Description, true)
+		case lexicon.TypeObject:
+			f.genStruct(name, "is the "+ref+" object", def, schema.ID, def.Description, true)
+		case lexicon.TypeToken:
+			f.docComment(name, "is the "+ref+" token", def.Description)
+			f.printf("const %s = %q\n\n", name, ref)
+		case lexicon.TypeQuery, lexicon.TypeProcedure:
+			f.genMethod(name, ref, def, schema.ID)
+		case lexicon.TypeSubscription:
+			f.printf("// %sNSID is the ID of the %s subscription\n", name, ref)
+			f.printf("const %sNSID = %q\n\n", name, ref)
+			log.Printf("%s: only the ID of subscriptions is generated", ref)
+		}
+		// Other definitions are inlined where they are referenced
+
+		for len(f.pending) > 0 {
+			next := f.pending[0]
+			f.pending = f.pending[1:]
+			next()
+		}
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.source(schema.ID)
+}
+
+// file is a generated Go file being written
+type file struct {
+	g        *generator
+	schemaID string
+	imports  map[string]bool
+	buf      bytes.Buffer
+
+	// pending generates inline types after the definition that uses them
+	pending []func()
+	// err is the first error generating the file
+	err error
+}
+
+func (f *file) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&f.buf, format, args...)
+}
+
+func (f *file) use(path string) {
+	f.imports[path] = true
+}
+
+func (f *file) fail(err error) {
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// source adds the header and imports to the generated code and formats it
+func (f *file) source(from string) ([]byte, error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by lexgen from %s. DO NOT EDIT.\n\n", from)
+	fmt.Fprintf(&out, "package %s\n\n", f.g.pkg)
+
+	if len(f.imports) > 0 {
+		paths := make([]string, 0, len(f.imports))
+		for path := range f.imports {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		// Standard library imports go first, then module imports
+		out.WriteString("import (\n")
+		for _, path := range paths {
+			if !isModuleImport(path) {
+				fmt.Fprintf(&out, "\t%q\n", path)
+			}
+		}
+		out.WriteString("\n")
+		for _, path := range paths {
+			if isModuleImport(path) {
+				fmt.Fprintf(&out, "\t%q\n", path)
+			}
+		}
+		out.WriteString(")\n\n")
+	}
+	out.Write(f.buf.Bytes())
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated code: %w", err)
+	}
+	return src, nil
+}
+
+// isModuleImport reports whether an import path is outside the standard library
+func isModuleImport(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return strings.Contains(first, ".")
+}
+
+// docComment writes the doc comment of a generated type, saying what it is
+// and adding the schema's description
+func (f *file) docComment(name, what, description string) {
+	f.printf("// %s %s\n", name, what)
+	if description != "" {
+		f.printf("//\n// %s\n", oneLine(description))
+	}
+}
+
+// genStruct generates a struct for an object definition. Objects that can be
+// union members or records carry their $type.
+func (f *file) genStruct(name, what string, def *lexicon.Def, schemaID, description string, withType bool) {
+	f.docComment(name, what, description)
+	f.printf("type %s struct {\n", name)
+	if withType {
+		f.printf("\tLexiconTypeID string `json:\"$type,omitempty\"`\n")
+	}
+
+	props := make([]string, 0, len(def.Properties))
+	for prop := range def.Properties {
+		props = append(props, prop)
+	}
+	sort.Strings(props)
+	for _, prop := range props {
+		propDef := def.Properties[prop]
+		required := def.IsRequired(prop)
+		goType := f.goType(name, prop, propDef, schemaID, required && !def.IsNullable(prop))
+
+		tag := prop
+		if !required {
+			tag += ",omitempty"
+		}
+		if propDef.Description != "" {
+			f.printf("\t// %s\n", oneLine(propDef.Description))
+		}
+		f.printf("\t%s %s `json:\"%s\"`\n", exportName(prop), goType, tag)
+	}
+	f.printf("}\n\n")
+}
+
+// goType returns the Go type of a value. Values that are not required are
+// pointers, so that they can be left out. Inline objects and unions get types
+// named after the field they are in.
+func (f *file) goType(parent, field string, def *lexicon.Def, schemaID string, required bool) string {
+	optional := func(t string) string {
+		if required {
+			return t
+		}
+		return "*" + t
+	}
+	inlineName := parent
+	if field != "" {
+		inlineName += "_" + exportName(field)
+	}
+
+	switch def.Type {
+	case lexicon.TypeString:
+		return optional("string")
+	case lexicon.TypeInteger:
+		return optional("int64")
+	case lexicon.TypeBoolean:
+		return optional("bool")
+	case lexicon.TypeBytes:
+		f.use(lexiconImport)
+		return "lexicon.Bytes"
+	case lexicon.TypeCIDLink:
+		f.use(lexiconImport)
+		return optional("lexicon.CIDLink")
+	case lexicon.TypeBlob:
+		f.use(lexiconImport)
+		return "*lexicon.Blob"
+	case lexicon.TypeArray:
+		if def.Items == nil {
+			f.use("encoding/json")
+			return "[]json.RawMessage"
+		}
+		return "[]" + f.goType(inlineName+"Elem", "", def.Items, schemaID, true)
+	case lexicon.TypeRef:
+		target, targetSchemaID, err := f.g.resolve(def.Ref, schemaID)
+		if err != nil {
+			f.fail(err)
+			return "interface{}"
+		}
+		switch target.Type {
+		case lexicon.TypeObject, lexicon.TypeRecord:
+			return "*" + typeName(lexicon.SplitRef(def.Ref, schemaID))
+		case lexicon.TypeToken:
+			return optional("string")
+		}
+		return f.goType(parent, field, target, targetSchemaID, required)
+	case lexicon.TypeUnion:
+		f.pending = append(f.pending, func() { f.genUnion(inlineName, def, schemaID) })
+		return "*" + inlineName
+	case lexicon.TypeObject:
+		what := "is the " + field + " object of " + parent
+		if field == "" {
+			what = "is an object"
+		}
+		f.pending = append(f.pending, func() { f.genStruct(inlineName, what, def, schemaID, def.Description, false) })
+		return "*" + inlineName
+	}
+
+	f.use("encoding/json")
+	return "json.RawMessage"
+}
+
+// genUnion generates a union type with a field for each member type. Its JSON
+// is that of the member that is set, with $type naming the member. Open
+// unions keep values of other types in Unknown.
+func (f *file) genUnion(name string, def *lexicon.Def, schemaID string) {
+	type member struct {
+		typeName string
+		ref      string
+	}
+	var members []member
+	for _, ref := range def.Refs {
+		target, _, err := f.g.resolve(ref, schemaID)
+		if err != nil {
+			f.fail(err)
+			return
+		}
+		if target.Type != lexicon.TypeObject && target.Type != lexicon.TypeRecord {
+			f.fail(fmt.Errorf("union member %s is a %s, not an object", ref, target.Type))
+			return
+		}
+		members = append(members, member{
+			typeName: typeName(lexicon.SplitRef(ref, schemaID)),
+			ref:      lexicon.NormalizeRef(ref, schemaID),
+		})
+	}
+	f.use("encoding/json")
+	f.use("fmt")
+	f.use("strings")
+
+	kind := "an open"
+	if def.Closed {
+		kind = "a closed"
+	}
+	f.printf("// %s is %s union. Exactly one of its fields is set.\n", name, kind)
+	f.printf("type %s struct {\n", name)
+	for _, m := range members {
+		f.printf("\t%s *%s\n", m.typeName, m.typeName)
+	}
+	if !def.Closed {
+		f.printf("\t// Unknown holds a value whose type is not one of the above\n")
+		f.printf("\tUnknown json.RawMessage\n")
+	}
+	f.printf("}\n\n")
+
+	f.printf("// MarshalJSON implements json.Marshaler\n")
+	f.printf("func (u %s) MarshalJSON() ([]byte, error) {\n\tswitch {\n", name)
+	for _, m := range members {
+		f.printf("\tcase u.%s != nil:\n", m.typeName)
+		f.printf("\t\tv := *u.%s\n", m.typeName)
+		f.printf("\t\tv.LexiconTypeID = %q\n", m.ref)
+		f.printf("\t\treturn json.Marshal(&v)\n")
+	}
+	if !def.Closed {
+		f.printf("\tcase u.Unknown != nil:\n\t\treturn u.Unknown, nil\n")
+	}
+	f.printf("\t}\n\treturn nil, fmt.Errorf(\"%s has no value\")\n}\n\n", name)
+
+	f.printf("// UnmarshalJSON implements json.Unmarshaler, choosing the member by $type\n")
+	f.printf("func (u *%s) UnmarshalJSON(data []byte) error {\n", name)
+	f.printf("\tvar v struct {\n\t\tType string `json:\"$type\"`\n\t}\n")
+	f.printf("\tif err := json.Unmarshal(data, &v); err != nil {\n\t\treturn err\n\t}\n")
+	f.printf("\tswitch strings.TrimSuffix(v.Type, \"#main\") {\n")
+	for _, m := range members {
+		f.printf("\tcase %q:\n", m.ref)
+		f.printf("\t\tu.%s = new(%s)\n", m.typeName, m.typeName)
+		f.printf("\t\treturn json.Unmarshal(data, u.%s)\n", m.typeName)
+	}
+	f.printf("\tcase \"\":\n\t\treturn fmt.Errorf(\"%s value has no $type\")\n\t}\n", name)
+	if def.Closed {
+		f.printf("\treturn fmt.Errorf(\"unknown type %%q in %s\", v.Type)\n}\n\n", name)
+	} else {
+		f.printf("\tu.Unknown = append(json.RawMessage(nil), data...)\n\treturn nil\n}\n\n")
+	}
+}
+
+// body is the Go form of an XRPC input or output
+type body struct {
+	// goType is the type of a JSON body, or empty for other encodings
+	goType   string
+	encoding string
+}
+
+func (f *file) body(name, what string, b *lexicon.Body, schemaID string) *body {
+	if b == nil {
+		return nil
+	}
+	if b.Encoding != "application/json" {
+		return &body{encoding: b.Encoding}
+	}
+	if b.Schema == nil {
+		f.use("encoding/json")
+		return &body{goType: "json.RawMessage", encoding: b.Encoding}
+	}
+	if b.Schema.Type == lexicon.TypeObject {
+		f.pending = append(f.pending, func() { f.genStruct(name, what, b.Schema, schemaID, b.Description, false) })
+		return &body{goType: "*" + name, encoding: b.Encoding}
+	}
+	return &body{goType: f.goType(name, "", b.Schema, schemaID, true), encoding: b.Encoding}
+}
+
+// genMethod generates the types of a query or procedure, a client method
+// calling it, and a handler interface with a function registering it
+func (f *file) genMethod(name, nsid string, def *lexicon.Def, schemaID string) {
+	kind := "query"
+	if def.Type == lexicon.TypeProcedure {
+		kind = "procedure"
+	}
+	f.printf("// %sNSID is the ID of the %s %s\n", name, nsid, kind)
+	f.printf("const %sNSID = %q\n\n", name, nsid)
+
+	var paramsType string
+	if def.Parameters != nil && len(def.Parameters.Properties) > 0 {
+		paramsType = name + "_Params"
+		f.genStruct(paramsType, "holds the parameters of "+nsid, def.Parameters, schemaID, "", false)
+	}
+	input := f.body(name+"_Input", "is the input of "+nsid, def.Input, schemaID)
+	output := f.body(name+"_Output", "is the output of "+nsid, def.Output, schemaID)
+	f.use("context")
+
+	// Arguments and results shared by the client method and the handler
+	args := []string{"ctx context.Context"}
+	callArgs := []string{"ctx"}
+	if paramsType != "" {
+		args = append(args, "params *"+paramsType)
+		callArgs = append(callArgs, "&p")
+	}
+	rawInput := input != nil && input.goType == ""
+	if input != nil && !rawInput {
+		args = append(args, "input "+input.goType)
+		callArgs = append(callArgs, "input")
+	}
+	results := "error"
+	if output != nil {
+		outType := output.goType
+		if outType == "" {
+			outType = "[]byte"
+		}
+		results = "(" + outType + ", error)"
+	}
+
+	// Client method
+	clientArgs := args
+	contentType := ""
+	if rawInput {
+		f.use("io")
+		clientArgs = append(clientArgs, "input io.Reader")
+		contentType = fmt.Sprintf("%q", input.encoding)
+		if strings.ContainsAny(input.encoding, "*,") {
+			clientArgs = append(clientArgs, "contentType string")
+			contentType = "contentType"
+		}
+	}
+	f.docComment(name, "calls "+nsid, def.Description)
+	f.printf("func (c *Client) %s(%s) %s {\n", name, strings.Join(clientArgs, ", "), results)
+
+	params := "nil"
+	if paramsType != "" {
+		params = "xrpc.EncodeParams(params)"
+		f.use(xrpcImport)
+	}
+	out := "nil"
+	if output != nil {
+		out = "&out"
+		switch {
+		case output.goType == "":
+			f.printf("\tvar out []byte\n")
+		case strings.HasPrefix(output.goType, "*"):
+			f.printf("\tvar out %s\n", strings.TrimPrefix(output.goType, "*"))
+		default:
+			f.printf("\tvar out %s\n", output.goType)
+		}
+	}
+	var call string
+	switch {
+	case def.Type == lexicon.TypeQuery:
+		call = fmt.Sprintf("c.Query(ctx, %sNSID, %s, %s)", name, params, out)
+	case rawInput:
+		f.use("net/http")
+		call = fmt.Sprintf("c.Do(ctx, http.MethodPost, %sNSID, %s, %s, input, %s)", name, params, contentType, out)
+	case input != nil:
+		call = fmt.Sprintf("c.Procedure(ctx, %sNSID, %s, input, %s)", name, params, out)
+	default:
+		call = fmt.Sprintf("c.Procedure(ctx, %sNSID, %s, nil, %s)", name, params, out)
+	}
+	switch {
+	case output == nil:
+		f.printf("\treturn %s\n}\n\n", call)
+	case strings.HasPrefix(output.goType, "*"):
+		f.printf("\tif err := %s; err != nil {\n\t\treturn nil, err\n\t}\n", call)
+		f.printf("\treturn &out, nil\n}\n\n")
+	default:
+		f.printf("\tif err := %s; err != nil {\n\t\treturn nil, err\n\t}\n", call)
+		f.printf("\treturn out, nil\n}\n\n")
+	}
+
+	// The server side decodes JSON bodies only
+	if rawInput || (output != nil && output.goType == "") {
+		log.Printf("%s: handlers are only generated for JSON bodies", nsid)
+		return
+	}
+
+	f.use("net/http")
+	f.use(xrpcImport)
+	f.printf("// %sHandler serves the %s %s\n", name, nsid, kind)
+	f.printf("type %sHandler interface {\n", name)
+	f.printf("\t%s(%s) %s\n}\n\n", name, strings.Join(args, ", "), results)
+
+	f.printf("// Register%s registers a handler for %s\n", name, nsid)
+	f.printf("func Register%s(mux *http.ServeMux, h %sHandler) {\n", name, name)
+	f.printf("\txrpc.Register(mux, %sNSID, func(ctx context.Context, params map[string]interface{}) (interface{}, error) {\n", name)
+	if paramsType != "" {
+		f.printf("\t\tvar p %s\n", paramsType)
+		f.printf("\t\tif err := xrpc.DecodeParams(params, &p); err != nil {\n\t\t\treturn nil, err\n\t\t}\n")
+	}
+	if input != nil {
+		inputArg := "input"
+		if strings.HasPrefix(input.goType, "*") {
+			f.printf("\t\tinput := new(%s)\n", strings.TrimPrefix(input.goType, "*"))
+		} else {
+			f.printf("\t\tvar input %s\n", input.goType)
+			inputArg = "&input"
+		}
+		f.printf("\t\tif err := xrpc.DecodeInput(params, %s); err != nil {\n\t\t\treturn nil, err\n\t\t}\n", inputArg)
+	}
+	if output == nil {
+		f.printf("\t\treturn nil, h.%s(%s)\n", name, strings.Join(callArgs, ", "))
+	} else {
+		f.printf("\t\treturn h.%s(%s)\n", name, strings.Join(callArgs, ", "))
+	}
+	f.printf("\t})\n}\n\n")
+}
+
+// resolve finds the definition a reference points at among the loaded schemas
+func (g *generator) resolve(ref, schemaID string) (*lexicon.Def, string, error) {
+	id, name := lexicon.SplitRef(ref, schemaID)
+	schema, ok := g.schemas[id]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown schema %s in ref %s", id, ref)
+	}
+	def, ok := schema.Defs[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown definition %s#%s", id, name)
+	}
+	return def, id, nil
+}
+
+// defNames returns the definition names of a schema, main first
+func defNames(schema *lexicon.Schema) []string {
+	names := make([]string, 0, len(schema.Defs))
+	for name := range schema.Defs {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if names[i] == "main" || names[j] == "main" {
+			return names[i] == "main"
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// typeName returns the Go type name of a definition, built from the last two
+// segments of the schema ID: app.bsky.feed.post is FeedPost, and its #replyRef
+// definition is FeedPost_ReplyRef
+func typeName(schemaID, defName string) string {
+	segments := strings.Split(schemaID, ".")
+	name := exportName(segments[len(segments)-1])
+	if len(segments) > 1 {
+		name = exportName(segments[len(segments)-2]) + name
+	}
+	if defName != "main" {
+		name += "_" + exportName(defName)
+	}
+	return name
+}
+
+// initialisms are words that Go names spell in upper case
+var initialisms = map[string]bool{
+	"cid":  true,
+	"did":  true,
+	"id":   true,
+	"ip":   true,
+	"nsid": true,
+	"uri":  true,
+	"url":  true,
+}
+
+// exportName turns a lexicon name like parentUri or cid-link into an exported
+// Go name like ParentURI or CIDLink
+func exportName(s string) string {
+	var words []string
+	var word []rune
+	var prev rune
+	for _, r := range s {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+		case unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)):
+			words = append(words, string(word))
+			word = []rune{r}
+		default:
+			word = append(word, r)
+		}
+		prev = r
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	var b strings.Builder
+	for _, w := range words {
+		if initialisms[strings.ToLower(w)] {
+			b.WriteString(strings.ToUpper(w))
+			continue
+		}
+		runes := []rune(w)
+		b.WriteRune(unicode.ToUpper(runes[0]))
+		b.WriteString(string(runes[1:]))
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// oneLine collapses a description to a single line for a comment
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/yourusername/atprogo/pkg/lexicon"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// TestGenerateGolden generates code for the schemas in testdata/lexicons and
+// compares it to testdata/golden. Run with -update after changing the generator.
+func TestGenerateGolden(t *testing.T) {
+	schemas, err := lexicon.LoadSchemas(filepath.Join("testdata", "lexicons"))
+	if err != nil {
+		t.Fatalf("LoadSchemas: %v", err)
+	}
+	g, err := newGenerator("api", schemas)
+	if err != nil {
+		t.Fatalf("newGenerator: %v", err)
+	}
+	files, err := g.generate()
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+
+	goldenDir := filepath.Join("testdata", "golden")
+	if *update {
+		if err := os.RemoveAll(goldenDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(goldenDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, src := range files {
+			if err := os.WriteFile(filepath.Join(goldenDir, name+".golden"), src, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Every golden file must be generated, and every generated file must have a golden file
+	goldens, err := filepath.Glob(filepath.Join(goldenDir, "*.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for _, path := range goldens {
+		want = append(want, filepath.Base(path[:len(path)-len(".golden")]))
+	}
+	var got []string
+	for name := range files {
+		got = append(got, name)
+	}
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("generated files %v, want %v", got, want)
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Fatalf("generated files %v, want %v", got, want)
+		}
+	}
+
+	for name, src := range files {
+		golden, err := os.ReadFile(filepath.Join(goldenDir, name+".golden"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(src) != string(golden) {
+			t.Errorf("%s differs from its golden file, run go test ./cmd/lexgen -update and review the diff:\n%s", name, src)
+		}
+	}
+}
+
+func TestNewGeneratorNameClash(t *testing.T) {
+	// Both schemas end in feed.post, so both generate FeedPost
+	a := &lexicon.Schema{Lexicon: 1, ID: "com.example.feed.post", Defs: map[string]*lexicon.Def{
+		"main": {Type: lexicon.TypeObject},
+	}}
+	b := &lexicon.Schema{Lexicon: 1, ID: "org.other.feed.post", Defs: map[string]*lexicon.Def{
+		"main": {Type: lexicon.TypeObject},
+	}}
+	if _, err := newGenerator("api", []*lexicon.Schema{a, b}); err == nil {
+		t.Fatal("newGenerator() = nil error, want an error for clashing type names")
+	}
+}
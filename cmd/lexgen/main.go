@@ -0,0 +1,106 @@
+// Command lexgen generates Go code from Lexicon schemas: structs for records
+// and objects, union types dispatching on $type, typed XRPC client methods and
+// server handler interfaces.
+//
+//	go run ./cmd/lexgen -lexicons lexicons -out pkg/api
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/atprogo/pkg/lexicon"
+)
+
+// generatedHeader starts every file lexgen writes
+const generatedHeader = "// Code generated by lexgen"
+
+func main() {
+	lexiconDir := flag.String("lexicons", "lexicons", "directory of Lexicon JSON files")
+	outDir := flag.String("out", "pkg/api", "directory to write the generated Go files to")
+	pkg := flag.String("package", "", "package name of the generated files (default: the name of the output directory)")
+	flag.Parse()
+
+	if *pkg == "" {
+		absOut, err := filepath.Abs(*outDir)
+		if err != nil {
+			log.Fatalf("Invalid output directory: %v", err)
+		}
+		*pkg = filepath.Base(absOut)
+	}
+
+	// Load schemas
+	schemas, err := lexicon.LoadSchemas(*lexiconDir)
+	if err != nil {
+		log.Fatalf("Failed to load lexicons: %v", err)
+	}
+	if len(schemas) == 0 {
+		log.Fatalf("No lexicons found in %s", *lexiconDir)
+	}
+
+	// Generate code
+	g, err := newGenerator(*pkg, schemas)
+	if err != nil {
+		log.Fatalf("Failed to generate code: %v", err)
+	}
+	files, err := g.generate()
+	if err != nil {
+		log.Fatalf("Failed to generate code: %v", err)
+	}
+
+	// Write files, removing files generated earlier for schemas that are gone
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		log.Fatalf("Failed to create output directory: %v", err)
+	}
+	if err := removeStale(*outDir, files); err != nil {
+		log.Fatalf("Failed to remove old generated files: %v", err)
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(*outDir, name), src, 0644); err != nil {
+			log.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	log.Printf("Generated %d files from %d lexicons in %s", len(files), len(schemas), *outDir)
+}
+
+// removeStale removes files lexgen generated in dir that are not in files
+func removeStale(dir string, files map[string][]byte) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if _, ok := files[filepath.Base(path)]; ok {
+			continue
+		}
+		generated, err := isGenerated(path)
+		if err != nil {
+			return err
+		}
+		if generated {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// isGenerated reports whether a Go file was written by lexgen
+func isGenerated(path string) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return false, scanner.Err()
+	}
+	return strings.HasPrefix(scanner.Text(), generatedHeader), nil
+}
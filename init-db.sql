@@ -2,6 +2,7 @@
 CREATE DATABASE auth;
 CREATE DATABASE pds;
 CREATE DATABASE bgs;
+CREATE DATABASE plc;
 
 -- Connect to auth database
 \c auth
@@ -12,7 +13,10 @@ CREATE TABLE users (
     did TEXT NOT NULL UNIQUE,
     username TEXT NOT NULL UNIQUE,
     email TEXT NOT NULL UNIQUE,
+    email_confirmed_at TIMESTAMP WITH TIME ZONE,
     password_hash TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    status_changed_at TIMESTAMP WITH TIME ZONE,
     created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
@@ -20,14 +24,172 @@ CREATE TABLE users (
 -- Create index on username
 CREATE INDEX idx_users_username ON users(username);
 CREATE INDEX idx_users_did ON users(did);
+CREATE INDEX idx_users_status_changed_at ON users(status_changed_at);
+
+-- Create account keys table
+CREATE TABLE account_keys (
+    did TEXT NOT NULL,
+    purpose TEXT NOT NULL,
+    key_type TEXT NOT NULL,
+    public_key TEXT NOT NULL,
+    encrypted_key BYTEA NOT NULL,
+    master_key_id TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (did, purpose)
+);
+
+-- Create app passwords table
+CREATE TABLE app_passwords (
+    id SERIAL PRIMARY KEY,
+    did TEXT NOT NULL,
+    name TEXT NOT NULL,
+    password_hash TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (did, name)
+);
+
+-- Create invite codes table
+CREATE TABLE invite_codes (
+    code TEXT PRIMARY KEY,
+    available_uses INTEGER NOT NULL,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    for_account TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create index on invite code account
+CREATE INDEX idx_invite_codes_for_account ON invite_codes(for_account);
+
+-- Create invite code uses table
+CREATE TABLE invite_code_uses (
+    code TEXT NOT NULL REFERENCES invite_codes(code),
+    used_by TEXT NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (code, used_by)
+);
+
+-- Create email tokens table
+CREATE TABLE email_tokens (
+    did TEXT NOT NULL,
+    purpose TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (did, purpose)
+);
+
+-- Create index on token hash
+CREATE UNIQUE INDEX idx_email_tokens_token_hash ON email_tokens(purpose, token_hash);
+
+-- Create TOTP factors table
+CREATE TABLE totp_factors (
+    did TEXT PRIMARY KEY,
+    encrypted_secret BYTEA NOT NULL,
+    master_key_id TEXT NOT NULL,
+    last_counter BIGINT NOT NULL DEFAULT 0,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create recovery codes table
+CREATE TABLE recovery_codes (
+    id SERIAL PRIMARY KEY,
+    did TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create index on recovery codes did
+CREATE INDEX idx_recovery_codes_did ON recovery_codes(did);
+
+-- Create login failures table for throttling
+CREATE TABLE login_failures (
+    key TEXT NOT NULL,
+    failed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create index on login failure key
+CREATE INDEX idx_login_failures_key ON login_failures(key, failed_at);
+
+-- Create failed logins audit table
+CREATE TABLE failed_logins (
+    id BIGSERIAL PRIMARY KEY,
+    identifier TEXT NOT NULL,
+    did TEXT,
+    ip TEXT NOT NULL,
+    user_agent TEXT,
+    reason TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create indexes on failed logins
+CREATE INDEX idx_failed_logins_did ON failed_logins(did);
+CREATE INDEX idx_failed_logins_ip ON failed_logins(ip);
+
+-- Create identity events table
+CREATE TABLE identity_events (
+    seq BIGSERIAL PRIMARY KEY,
+    did TEXT NOT NULL,
+    handle TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create sessions table
+CREATE TABLE sessions (
+    id TEXT PRIMARY KEY,
+    did TEXT NOT NULL,
+    scope TEXT NOT NULL DEFAULT 'com.atproto.access',
+    app_password_name TEXT,
+    client_id TEXT,
+    dpop_jkt TEXT,
+    refresh_jti TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    refreshed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Create indexes on sessions
+CREATE INDEX idx_sessions_did ON sessions(did);
+CREATE INDEX idx_sessions_revoked_at ON sessions(revoked_at);
+
+-- Create OAuth authorization requests table
+CREATE TABLE oauth_requests (
+    id TEXT PRIMARY KEY,
+    client_id TEXT NOT NULL,
+    redirect_uri TEXT NOT NULL,
+    scope TEXT NOT NULL,
+    state TEXT NOT NULL,
+    code_challenge TEXT NOT NULL,
+    code_challenge_method TEXT NOT NULL,
+    login_hint TEXT,
+    dpop_jkt TEXT NOT NULL,
+    did TEXT,
+    code TEXT UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
 
 -- Connect to pds database
 \c pds
 
+-- Create accounts table
+CREATE TABLE accounts (
+    did TEXT PRIMARY KEY,
+    handle TEXT NOT NULL UNIQUE,
+    signing_key TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
 -- Create repositories table
 CREATE TABLE repositories (
     did TEXT PRIMARY KEY,
     head TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
     created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
 );
@@ -59,6 +221,16 @@ CREATE TABLE documents (
 -- Create index on repository_did and type
 CREATE INDEX idx_documents_repository_did_type ON documents(repository_did, type);
 
+-- Create blobs table
+CREATE TABLE blobs (
+    cid TEXT NOT NULL,
+    repository_did TEXT NOT NULL REFERENCES repositories(did),
+    mime_type TEXT NOT NULL,
+    data BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (repository_did, cid)
+);
+
 -- Connect to bgs database
 \c bgs
 
@@ -73,3 +245,30 @@ CREATE TABLE follows (
 -- Create index on follower and following
 CREATE INDEX idx_follows_follower ON follows(follower);
 CREATE INDEX idx_follows_following ON follows(following);
+
+-- Create account statuses table for accounts that are not active
+CREATE TABLE account_statuses (
+    did TEXT PRIMARY KEY,
+    status TEXT NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Connect to plc database
+\c plc
+
+-- Create operations table
+CREATE TABLE operations (
+    id BIGSERIAL PRIMARY KEY,
+    cid TEXT NOT NULL UNIQUE,
+    did TEXT NOT NULL,
+    prev TEXT,
+    operation JSONB NOT NULL,
+    nullified BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Create index on did
+CREATE INDEX idx_operations_did ON operations(did);
+
+-- Only one active operation can follow each operation; recoveries nullify the ones they replace
+CREATE UNIQUE INDEX idx_operations_did_prev ON operations(did, prev) WHERE NOT nullified;
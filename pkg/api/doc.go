@@ -0,0 +1,6 @@
+// Package api holds the Go types, XRPC client methods and handler interfaces
+// generated from the Lexicon schemas in /lexicons. Add or change a schema
+// there and run go generate instead of editing the generated files.
+package api
+
+//go:generate go run ../../cmd/lexgen -lexicons ../../lexicons -out .
@@ -0,0 +1,17 @@
+// Code generated by lexgen from com.atproto.server.defs. DO NOT EDIT.
+
+package api
+
+// ServerDefs_Session is the com.atproto.server.defs#session object
+//
+// A session as returned by the session endpoints. Tokens are only included when a session is created or refreshed.
+type ServerDefs_Session struct {
+	LexiconTypeID  string  `json:"$type,omitempty"`
+	AccessJwt      *string `json:"accessJwt,omitempty"`
+	DID            string  `json:"did"`
+	Email          *string `json:"email,omitempty"`
+	EmailConfirmed bool    `json:"emailConfirmed"`
+	Handle         string  `json:"handle"`
+	RefreshJwt     *string `json:"refreshJwt,omitempty"`
+	Status         *string `json:"status,omitempty"`
+}
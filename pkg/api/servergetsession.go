@@ -0,0 +1,36 @@
+// Code generated by lexgen from com.atproto.server.getSession. DO NOT EDIT.
+
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/yourusername/atprogo/pkg/xrpc"
+)
+
+// ServerGetSessionNSID is the ID of the com.atproto.server.getSession query
+const ServerGetSessionNSID = "com.atproto.server.getSession"
+
+// ServerGetSession calls com.atproto.server.getSession
+//
+// Gets the session of the authenticated account.
+func (c *Client) ServerGetSession(ctx context.Context) (*ServerDefs_Session, error) {
+	var out ServerDefs_Session
+	if err := c.Query(ctx, ServerGetSessionNSID, nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// ServerGetSessionHandler serves the com.atproto.server.getSession query
+type ServerGetSessionHandler interface {
+	ServerGetSession(ctx context.Context) (*ServerDefs_Session, error)
+}
+
+// RegisterServerGetSession registers a handler for com.atproto.server.getSession
+func RegisterServerGetSession(mux *http.ServeMux, h ServerGetSessionHandler) {
+	xrpc.Register(mux, ServerGetSessionNSID, func(ctx context.Context, params map[string]interface{}) (interface{}, error) {
+		return h.ServerGetSession(ctx)
+	})
+}
@@ -0,0 +1,280 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// Errors of account lifecycle operations
+var (
+	ErrAccountNotFound  = errors.New("account not found")
+	ErrAccountNotActive = errors.New("account is not active")
+	// ErrUpstream wraps failures of the PLC directory or the PDS
+	ErrUpstream = errors.New("upstream service failed")
+)
+
+// AccountStore stores the users of accounts
+type AccountStore interface {
+	CreateUser(ctx context.Context, user *User, password string) error
+	GetUserByDID(ctx context.Context, did string) (*User, error)
+	RemoveUser(ctx context.Context, did string) error
+	DeleteUser(ctx context.Context, did string) error
+	VerifyPassword(user *User, password string) bool
+	VerifyAppPassword(ctx context.Context, user *User, password string) (*AppPassword, bool)
+	UseInviteCode(ctx context.Context, code, did string) error
+	ReleaseInviteCode(ctx context.Context, code, did string) error
+}
+
+// AccountKeys stores the private keys of accounts
+type AccountKeys interface {
+	SaveKey(ctx context.Context, did, purpose string, privateKey identity.PrivateKey) error
+	DeleteKeys(ctx context.Context, did string) error
+}
+
+// DIDRegistry registers the DIDs of new accounts, and tombstones them if the
+// account cannot be created
+type DIDRegistry interface {
+	CreateDID(ctx context.Context, rotationKey identity.PrivateKey, signingKey identity.PublicKey, handle, pdsEndpoint string, recoveryKeys ...identity.PublicKey) (string, error)
+	Tombstone(ctx context.Context, did string, rotationKey identity.PrivateKey) error
+}
+
+// RepositoryHost creates the repositories of new accounts. A handle another
+// account has is reported as ErrDuplicateHandle.
+type RepositoryHost interface {
+	CreateRepository(ctx context.Context, did, handle string, signingKey identity.PrivateKey) error
+}
+
+// SecondFactor checks the TOTP or recovery code of a main password login
+type SecondFactor interface {
+	Check(ctx context.Context, did, token string) error
+}
+
+// Accounts creates, logs in and deletes the accounts of the auth service
+type Accounts struct {
+	users       AccountStore
+	keys        AccountKeys
+	dids        DIDRegistry
+	repos       RepositoryHost
+	sessions    *SessionManager
+	twoFactor   SecondFactor
+	pdsEndpoint string
+	recoveryKey identity.PublicKey
+
+	// InviteRequired makes new accounts claim an invite code
+	InviteRequired bool
+}
+
+// NewAccounts creates the account lifecycle of a PDS. New DIDs point at
+// pdsEndpoint and list recoveryKey after the account's own rotation key.
+func NewAccounts(users AccountStore, keys AccountKeys, dids DIDRegistry, repos RepositoryHost, sessions *SessionManager, twoFactor SecondFactor, pdsEndpoint string, recoveryKey identity.PublicKey) *Accounts {
+	return &Accounts{
+		users:       users,
+		keys:        keys,
+		dids:        dids,
+		repos:       repos,
+		sessions:    sessions,
+		twoFactor:   twoFactor,
+		pdsEndpoint: pdsEndpoint,
+		recoveryKey: recoveryKey,
+	}
+}
+
+// Register creates an account with a new did:plc whose handle is the user's
+// username. It registers the DID, stores its keys, claims the invite code,
+// creates the user and its first session, and has the PDS create the repository.
+// A failed step undoes the ones before it, tombstoning the DID.
+func (a *Accounts) Register(ctx context.Context, user *User, password, inviteCode string) (*SessionTokens, error) {
+	// Generate signing and rotation keys
+	signingDID, rotationDID, err := newAccountKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	// Register DID with the PLC directory, keeping the server key for recovery
+	did, err := a.dids.CreateDID(ctx, rotationDID.PrivateKey, signingDID.PublicKey, user.Username, a.pdsEndpoint, a.recoveryKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to register DID: %w", ErrUpstream, err)
+	}
+	user.DID = did
+
+	// From here on, a failed step undoes the ones before it
+	var undo rollback
+	undo.add(func(ctx context.Context) error {
+		return a.dids.Tombstone(ctx, did, rotationDID.PrivateKey)
+	})
+
+	if err := a.createAccount(ctx, &undo, user, password, inviteCode, signingDID, rotationDID); err != nil {
+		undo.run(did)
+		return nil, err
+	}
+
+	// Create session before the repository, which cannot be undone here
+	tokens, err := a.sessions.CreateSession(ctx, did)
+	if err != nil {
+		undo.run(did)
+		return nil, err
+	}
+	undo.add(func(ctx context.Context) error {
+		return a.sessions.RevokeAllSessions(ctx, did)
+	})
+
+	// Create repository with a signed genesis commit
+	if err := a.repos.CreateRepository(ctx, did, user.Username, signingDID.PrivateKey); err != nil {
+		undo.run(did)
+		if errors.Is(err, ErrDuplicateHandle) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: failed to create repository: %w", ErrUpstream, err)
+	}
+
+	return tokens, nil
+}
+
+// CreateMigratedAccount creates the account of a DID migrating in from another
+// PDS. It returns the keys the DID must move to; the DID itself is updated by
+// the old PDS. A failed step undoes the ones before it.
+func (a *Accounts) CreateMigratedAccount(ctx context.Context, user *User, password, inviteCode string) (signingDID, rotationDID *identity.DID, err error) {
+	// Generate the keys the DID will move to
+	signingDID, rotationDID, err = newAccountKeys()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var undo rollback
+	if err := a.createAccount(ctx, &undo, user, password, inviteCode, signingDID, rotationDID); err != nil {
+		undo.run(user.DID)
+		return nil, nil, err
+	}
+	return signingDID, rotationDID, nil
+}
+
+// newAccountKeys generates the signing and rotation keys of a new account
+func newAccountKeys() (signingDID, rotationDID *identity.DID, err error) {
+	signingDID, err = identity.NewKeyDID()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	rotationDID, err = identity.NewKeyDID()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate rotation key: %w", err)
+	}
+	return signingDID, rotationDID, nil
+}
+
+// createAccount stores the keys, claims the invite code and creates the user of
+// a new account, recording how to undo each step in undo
+func (a *Accounts) createAccount(ctx context.Context, undo *rollback, user *User, password, inviteCode string, signingDID, rotationDID *identity.DID) error {
+	did := user.DID
+
+	// Store keys
+	undo.add(func(ctx context.Context) error {
+		return a.keys.DeleteKeys(ctx, did)
+	})
+	if err := a.keys.SaveKey(ctx, did, identity.KeyPurposeSigning, signingDID.PrivateKey); err != nil {
+		return fmt.Errorf("failed to store signing key: %w", err)
+	}
+	if err := a.keys.SaveKey(ctx, did, identity.KeyPurposeRotation, rotationDID.PrivateKey); err != nil {
+		return fmt.Errorf("failed to store rotation key: %w", err)
+	}
+
+	// Use invite code
+	if a.InviteRequired {
+		if err := a.users.UseInviteCode(ctx, inviteCode, did); err != nil {
+			return err
+		}
+		undo.add(func(ctx context.Context) error {
+			return a.users.ReleaseInviteCode(ctx, inviteCode, did)
+		})
+	}
+
+	// Create user
+	if err := a.users.CreateUser(ctx, user, password); err != nil {
+		return err
+	}
+	undo.add(func(ctx context.Context) error {
+		return a.users.RemoveUser(ctx, did)
+	})
+	return nil
+}
+
+// Login verifies a password against the user's main password and app passwords
+// and starts a session. App password logins get a restricted session. Main
+// password logins of accounts with TOTP also need authFactorToken. Suspended and
+// taken down accounts cannot log in. The boolean reports whether the password
+// was valid.
+func (a *Accounts) Login(ctx context.Context, user *User, password, authFactorToken string) (*SessionTokens, bool, error) {
+	if a.users.VerifyPassword(user, password) {
+		if err := user.CheckLogin(); err != nil {
+			return nil, true, err
+		}
+		if err := a.twoFactor.Check(ctx, user.DID, authFactorToken); err != nil {
+			return nil, true, err
+		}
+		tokens, err := a.sessions.CreateSession(ctx, user.DID)
+		return tokens, true, err
+	}
+
+	if appPassword, ok := a.users.VerifyAppPassword(ctx, user, password); ok {
+		if err := user.CheckLogin(); err != nil {
+			return nil, true, err
+		}
+		tokens, err := a.sessions.CreateAppPasswordSession(ctx, user.DID, appPassword.Name)
+		return tokens, true, err
+	}
+
+	return nil, false, nil
+}
+
+// MigratingUser returns the user of an account that can migrate away: one that
+// exists, can log in and is active
+func (a *Accounts) MigratingUser(ctx context.Context, did string) (*User, error) {
+	user, err := a.users.GetUserByDID(ctx, did)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAccountNotFound, err)
+	}
+	if err := user.CheckLogin(); err != nil {
+		return nil, err
+	}
+	if user.Status != StatusActive {
+		return nil, ErrAccountNotActive
+	}
+	return user, nil
+}
+
+// Delete ends every session of an account and deletes its credentials and keys.
+// The PDS and BGS delete the account's data when they sync its status.
+func (a *Accounts) Delete(ctx context.Context, did string) error {
+	if err := a.sessions.RevokeAllSessions(ctx, did); err != nil {
+		return err
+	}
+	if err := a.users.DeleteUser(ctx, did); err != nil {
+		return err
+	}
+	return a.keys.DeleteKeys(ctx, did)
+}
+
+// rollback undoes the completed steps of an operation that failed part-way
+type rollback []func(ctx context.Context) error
+
+// add records how to undo a completed step
+func (r *rollback) add(undo func(ctx context.Context) error) {
+	*r = append(*r, undo)
+}
+
+// run undoes the completed steps, last step first. Steps that fail to undo are
+// logged, since the request that failed has no one left to report them to.
+func (r rollback) run(did string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for i := len(r) - 1; i >= 0; i-- {
+		if err := r[i](ctx); err != nil {
+			log.Printf("Failed to roll back account creation of %s: %v", did, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// AccountStatus is a change of an account's status published by the auth service
+type AccountStatus struct {
+	DID       string    `json:"did"`
+	Status    string    `json:"status"`
+	ChangedAt time.Time `json:"changedAt"`
+}
+
+// FetchAccountStatuses fetches the account status changes the auth service
+// published at or after since
+func FetchAccountStatuses(ctx context.Context, client *http.Client, statusURL string, since time.Time) ([]AccountStatus, error) {
+	u, err := url.Parse(statusURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid account status URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("since", since.UTC().Format(time.RFC3339Nano))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch account statuses: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch account statuses: unexpected status: %s", resp.Status)
+	}
+
+	var body struct {
+		Accounts []AccountStatus `json:"accounts"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid account status list: %w", err)
+	}
+	return body.Accounts, nil
+}
+
+// SyncAccountStatuses polls the auth service's account status changes until ctx
+// is done and passes each change to apply, oldest first. When apply fails, the
+// change and the ones after it are fetched again on the next poll, so apply must
+// be idempotent.
+func SyncAccountStatuses(ctx context.Context, statusURL string, interval time.Duration, apply func(context.Context, AccountStatus) error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var since time.Time
+	for {
+		changes, err := FetchAccountStatuses(ctx, client, statusURL, since)
+		if err != nil {
+			log.Printf("Failed to sync account statuses: %v", err)
+		}
+		for _, change := range changes {
+			if err := apply(ctx, change); err != nil {
+				log.Printf("Failed to apply status %s of %s: %v", change.Status, change.DID, err)
+				break
+			}
+			since = change.ChangedAt
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
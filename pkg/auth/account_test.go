@@ -0,0 +1,608 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// fakeAccountStore keeps users, passwords and invite code uses in memory
+type fakeAccountStore struct {
+	mu           sync.Mutex
+	users        map[string]*User
+	passwords    map[string]string
+	appPasswords map[string]map[string]string
+	invites      map[string]int
+	inviteUses   map[string][]string
+	failCreate   error
+}
+
+func newFakeAccountStore() *fakeAccountStore {
+	return &fakeAccountStore{
+		users:        make(map[string]*User),
+		passwords:    make(map[string]string),
+		appPasswords: make(map[string]map[string]string),
+		invites:      make(map[string]int),
+		inviteUses:   make(map[string][]string),
+	}
+}
+
+func (s *fakeAccountStore) CreateUser(ctx context.Context, user *User, password string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failCreate != nil {
+		return s.failCreate
+	}
+	if _, ok := s.users[user.DID]; ok {
+		return fmt.Errorf("duplicate user %s", user.DID)
+	}
+	user.Status = StatusActive
+	stored := *user
+	s.users[user.DID] = &stored
+	s.passwords[user.DID] = password
+	return nil
+}
+
+func (s *fakeAccountStore) GetUserByDID(ctx context.Context, did string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[did]
+	if !ok {
+		return nil, errors.New("no rows in result set")
+	}
+	found := *user
+	return &found, nil
+}
+
+func (s *fakeAccountStore) RemoveUser(ctx context.Context, did string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.users, did)
+	delete(s.passwords, did)
+	return nil
+}
+
+func (s *fakeAccountStore) DeleteUser(ctx context.Context, did string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if user, ok := s.users[did]; ok {
+		user.Status = StatusDeleted
+	}
+	delete(s.passwords, did)
+	delete(s.appPasswords, did)
+	return nil
+}
+
+func (s *fakeAccountStore) VerifyPassword(user *User, password string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, ok := s.passwords[user.DID]
+	return ok && stored == password
+}
+
+func (s *fakeAccountStore) VerifyAppPassword(ctx context.Context, user *User, password string) (*AppPassword, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, stored := range s.appPasswords[user.DID] {
+		if stored == password {
+			return &AppPassword{DID: user.DID, Name: name}, true
+		}
+	}
+	return nil, false
+}
+
+func (s *fakeAccountStore) UseInviteCode(ctx context.Context, code, did string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	available, ok := s.invites[code]
+	if !ok || len(s.inviteUses[code]) >= available {
+		return ErrInvalidInviteCode
+	}
+	s.inviteUses[code] = append(s.inviteUses[code], did)
+	return nil
+}
+
+func (s *fakeAccountStore) ReleaseInviteCode(ctx context.Context, code, did string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uses := s.inviteUses[code]
+	for i, usedBy := range uses {
+		if usedBy == did {
+			s.inviteUses[code] = append(uses[:i:i], uses[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// fakeAccountKeys keeps account keys in memory
+type fakeAccountKeys struct {
+	mu       sync.Mutex
+	keys     map[string]map[string]identity.PrivateKey
+	failSave string
+}
+
+func newFakeAccountKeys() *fakeAccountKeys {
+	return &fakeAccountKeys{keys: make(map[string]map[string]identity.PrivateKey)}
+}
+
+func (k *fakeAccountKeys) SaveKey(ctx context.Context, did, purpose string, privateKey identity.PrivateKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if purpose == k.failSave {
+		return errors.New("keystore unavailable")
+	}
+	if k.keys[did] == nil {
+		k.keys[did] = make(map[string]identity.PrivateKey)
+	}
+	k.keys[did][purpose] = privateKey
+	return nil
+}
+
+func (k *fakeAccountKeys) DeleteKeys(ctx context.Context, did string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.keys, did)
+	return nil
+}
+
+// fakeDIDRegistry registers DIDs in memory
+type fakeDIDRegistry struct {
+	mu          sync.Mutex
+	created     int
+	handles     map[string]string
+	tombstoned  map[string]bool
+	failCreate  error
+	recoveryKey identity.PublicKey
+}
+
+func newFakeDIDRegistry() *fakeDIDRegistry {
+	return &fakeDIDRegistry{handles: make(map[string]string), tombstoned: make(map[string]bool)}
+}
+
+func (r *fakeDIDRegistry) CreateDID(ctx context.Context, rotationKey identity.PrivateKey, signingKey identity.PublicKey, handle, pdsEndpoint string, recoveryKeys ...identity.PublicKey) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failCreate != nil {
+		return "", r.failCreate
+	}
+	r.created++
+	did := fmt.Sprintf("did:plc:account%d", r.created)
+	r.handles[did] = handle
+	if len(recoveryKeys) > 0 {
+		r.recoveryKey = recoveryKeys[0]
+	}
+	return did, nil
+}
+
+func (r *fakeDIDRegistry) Tombstone(ctx context.Context, did string, rotationKey identity.PrivateKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tombstoned[did] = true
+	return nil
+}
+
+// fakeRepositoryHost records the repositories it is asked to create
+type fakeRepositoryHost struct {
+	mu         sync.Mutex
+	repos      map[string]string
+	failCreate error
+}
+
+func (h *fakeRepositoryHost) CreateRepository(ctx context.Context, did, handle string, signingKey identity.PrivateKey) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.failCreate != nil {
+		return h.failCreate
+	}
+	if h.repos == nil {
+		h.repos = make(map[string]string)
+	}
+	h.repos[did] = handle
+	return nil
+}
+
+// fakeSecondFactor accepts code for accounts in enrolled
+type fakeSecondFactor struct {
+	enrolled map[string]string
+}
+
+func (f fakeSecondFactor) Check(ctx context.Context, did, token string) error {
+	code, ok := f.enrolled[did]
+	switch {
+	case !ok:
+		return nil
+	case token == "":
+		return ErrAuthFactorRequired
+	case token != code:
+		return ErrInvalidAuthFactor
+	}
+	return nil
+}
+
+// testAccounts is an Accounts with fake dependencies
+type testAccounts struct {
+	*Accounts
+	users     *fakeAccountStore
+	keys      *fakeAccountKeys
+	dids      *fakeDIDRegistry
+	repos     *fakeRepositoryHost
+	twoFactor fakeSecondFactor
+	sessions  *SessionManager
+	store     *fakeSessionStore
+}
+
+const testPDSEndpoint = "https://pds.example.com"
+
+func newTestAccounts(t *testing.T) *testAccounts {
+	t.Helper()
+	sessions, store := newTestSessionManager(t)
+	a := &testAccounts{
+		users:     newFakeAccountStore(),
+		keys:      newFakeAccountKeys(),
+		dids:      newFakeDIDRegistry(),
+		repos:     &fakeRepositoryHost{},
+		twoFactor: fakeSecondFactor{enrolled: make(map[string]string)},
+		sessions:  sessions,
+		store:     store,
+	}
+	recoveryKey := generateTestKey(t, identity.KeyTypeSecp256k1)
+	a.Accounts = NewAccounts(a.users, a.keys, a.dids, a.repos, sessions, a.twoFactor, testPDSEndpoint, recoveryKey.Public())
+	return a
+}
+
+func TestAccountsRegister(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAccounts(t)
+	a.InviteRequired = true
+	a.users.invites["invite-1"] = 1
+
+	user := &User{Username: "alice.example.com", Email: "alice@example.com"}
+	tokens, err := a.Register(ctx, user, "password", "invite-1")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	did := user.DID
+
+	if a.dids.handles[did] != "alice.example.com" {
+		t.Errorf("DID registered with handle %q", a.dids.handles[did])
+	}
+	if a.dids.recoveryKey == nil {
+		t.Error("DID registered without the recovery key")
+	}
+	for _, purpose := range []string{identity.KeyPurposeSigning, identity.KeyPurposeRotation} {
+		if a.keys.keys[did][purpose] == nil {
+			t.Errorf("%s key not stored", purpose)
+		}
+	}
+	if uses := a.users.inviteUses["invite-1"]; len(uses) != 1 || uses[0] != did {
+		t.Errorf("invite code uses = %v, want [%s]", uses, did)
+	}
+	if _, ok := a.users.users[did]; !ok {
+		t.Error("user not created")
+	}
+	if a.repos.repos[did] != "alice.example.com" {
+		t.Errorf("repository created with handle %q", a.repos.repos[did])
+	}
+	claims, err := a.sessions.VerifyAccessToken(tokens.AccessJwt)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken: %v", err)
+	}
+	if claims.Subject != did || claims.Scope != ScopeAccess {
+		t.Errorf("session of %s with scope %s, want %s with %s", claims.Subject, claims.Scope, did, ScopeAccess)
+	}
+}
+
+func TestAccountsCreateMigratedAccount(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAccounts(t)
+
+	user := &User{DID: "did:plc:migrating", Username: "bob.example.com", Email: "bob@example.com"}
+	signingDID, rotationDID, err := a.CreateMigratedAccount(ctx, user, "password", "")
+	if err != nil {
+		t.Fatalf("CreateMigratedAccount: %v", err)
+	}
+
+	// The DID keeps its identifier; only the keys it moves to are new
+	if a.dids.created != 0 {
+		t.Errorf("%d DIDs registered for a migrating account", a.dids.created)
+	}
+	for purpose, want := range map[string]*identity.DID{identity.KeyPurposeSigning: signingDID, identity.KeyPurposeRotation: rotationDID} {
+		stored := a.keys.keys[user.DID][purpose]
+		if stored == nil || identity.FormatKeyDID(stored.Public()) != want.String() {
+			t.Errorf("stored %s key is not the returned one", purpose)
+		}
+	}
+	if _, ok := a.users.users[user.DID]; !ok {
+		t.Error("user not created")
+	}
+
+	// A failed step removes the keys again
+	a.users.failCreate = errors.New("database unavailable")
+	other := &User{DID: "did:plc:other", Username: "carol.example.com", Email: "carol@example.com"}
+	if _, _, err := a.CreateMigratedAccount(ctx, other, "password", ""); err == nil {
+		t.Fatal("CreateMigratedAccount() = nil error with a failing store")
+	}
+	if _, ok := a.keys.keys[other.DID]; ok {
+		t.Error("keys of the failed account were not deleted")
+	}
+}
+
+func TestAccountsMigratingUser(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAccounts(t)
+	for did, status := range map[string]string{
+		"did:plc:active":      StatusActive,
+		"did:plc:deactivated": StatusDeactivated,
+		"did:plc:suspended":   StatusSuspended,
+	} {
+		a.users.users[did] = &User{DID: did, Status: status}
+	}
+
+	tests := []struct {
+		did     string
+		wantErr error
+	}{
+		{"did:plc:active", nil},
+		{"did:plc:deactivated", ErrAccountNotActive},
+		{"did:plc:suspended", ErrAccountSuspended},
+		{"did:plc:unknown", ErrAccountNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.did, func(t *testing.T) {
+			user, err := a.MigratingUser(ctx, tt.did)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("MigratingUser() = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && user.DID != tt.did {
+				t.Errorf("MigratingUser() returned %s", user.DID)
+			}
+		})
+	}
+}
+
+func TestAccountsDelete(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAccounts(t)
+
+	user := &User{Username: "alice.example.com", Email: "alice@example.com"}
+	tokens, err := a.Register(ctx, user, "password", "")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if err := a.Delete(ctx, user.DID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := a.sessions.VerifyAccessToken(tokens.AccessJwt); err == nil {
+		t.Error("session still valid after deletion")
+	}
+	if a.users.users[user.DID].Status != StatusDeleted {
+		t.Errorf("status = %s, want %s", a.users.users[user.DID].Status, StatusDeleted)
+	}
+	if _, ok := a.keys.keys[user.DID]; ok {
+		t.Error("keys not deleted")
+	}
+	if _, ok, _ := a.Login(ctx, a.users.users[user.DID], "password", ""); ok {
+		t.Error("password still valid after deletion")
+	}
+}
+
+func TestAccountsRegisterRollback(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		fail    func(a *testAccounts)
+		wantErr error
+		// Whether the DID was registered and the invite code claimed before the failure
+		registered, inviteClaimed bool
+	}{
+		{
+			name:    "DID registration",
+			fail:    func(a *testAccounts) { a.dids.failCreate = errors.New("directory unavailable") },
+			wantErr: ErrUpstream,
+		},
+		{
+			name:       "signing key",
+			fail:       func(a *testAccounts) { a.keys.failSave = identity.KeyPurposeSigning },
+			registered: true,
+		},
+		{
+			name:       "rotation key",
+			fail:       func(a *testAccounts) { a.keys.failSave = identity.KeyPurposeRotation },
+			registered: true,
+		},
+		{
+			name:       "exhausted invite code",
+			fail:       func(a *testAccounts) { a.users.inviteUses["invite-1"] = []string{"did:plc:earlier"} },
+			wantErr:    ErrInvalidInviteCode,
+			registered: true,
+		},
+		{
+			name:       "user",
+			fail:       func(a *testAccounts) { a.users.failCreate = errors.New("database unavailable") },
+			registered: true, inviteClaimed: true,
+		},
+		{
+			name:       "repository",
+			fail:       func(a *testAccounts) { a.repos.failCreate = errors.New("PDS unavailable") },
+			wantErr:    ErrUpstream,
+			registered: true, inviteClaimed: true,
+		},
+		{
+			name:       "repository handle taken",
+			fail:       func(a *testAccounts) { a.repos.failCreate = ErrDuplicateHandle },
+			wantErr:    ErrDuplicateHandle,
+			registered: true, inviteClaimed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestAccounts(t)
+			a.InviteRequired = true
+			a.users.invites["invite-1"] = 1
+			tt.fail(a)
+
+			user := &User{Username: "alice.example.com", Email: "alice@example.com"}
+			_, err := a.Register(ctx, user, "password", "invite-1")
+			if err == nil {
+				t.Fatal("Register() = nil error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register() = %v, want %v", err, tt.wantErr)
+			}
+			if !tt.registered {
+				if a.dids.created != 0 {
+					t.Errorf("%d DIDs registered", a.dids.created)
+				}
+				return
+			}
+			did := user.DID
+
+			if !a.dids.tombstoned[did] {
+				t.Error("DID not tombstoned")
+			}
+			if _, ok := a.keys.keys[did]; ok {
+				t.Error("keys not deleted")
+			}
+			for _, usedBy := range a.users.inviteUses["invite-1"] {
+				if usedBy == did {
+					t.Error("invite code not released")
+				}
+			}
+			if _, ok := a.users.users[did]; ok {
+				t.Error("user not removed")
+			}
+			if sessions, _ := a.store.ListSessions(ctx, did); len(sessions) != 0 {
+				t.Errorf("%d sessions left", len(sessions))
+			}
+
+			// The released code can register the account again
+			if tt.inviteClaimed {
+				a.users.failCreate = nil
+				a.repos.failCreate = nil
+				if _, err := a.Register(ctx, &User{Username: "alice.example.com", Email: "alice@example.com"}, "password", "invite-1"); err != nil {
+					t.Errorf("Register() again = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAccountsLogin(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAccounts(t)
+
+	user := &User{Username: "alice.example.com", Email: "alice@example.com"}
+	if _, err := a.Register(ctx, user, "password", ""); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	a.users.appPasswords[user.DID] = map[string]string{"bot": "app-password"}
+	a.twoFactor.enrolled[user.DID] = "123456"
+
+	tests := []struct {
+		name            string
+		password        string
+		authFactorToken string
+		wantValid       bool
+		wantErr         error
+		wantScope       string
+	}{
+		{"main password", "password", "123456", true, nil, ScopeAccess},
+		{"main password without TOTP", "password", "", true, ErrAuthFactorRequired, ""},
+		{"main password with wrong TOTP", "password", "654321", true, ErrInvalidAuthFactor, ""},
+		{"app password", "app-password", "", true, nil, ScopeAppPass},
+		{"wrong password", "wrong", "", false, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, valid, err := a.Login(ctx, user, tt.password, tt.authFactorToken)
+			if valid != tt.wantValid || !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Login() = %v, %v, want %v, %v", valid, err, tt.wantValid, tt.wantErr)
+			}
+			if tt.wantScope == "" {
+				return
+			}
+			claims, err := a.sessions.VerifyAccessToken(tokens.AccessJwt)
+			if err != nil {
+				t.Fatalf("VerifyAccessToken: %v", err)
+			}
+			if claims.Scope != tt.wantScope {
+				t.Errorf("scope = %s, want %s", claims.Scope, tt.wantScope)
+			}
+		})
+	}
+
+	// Suspended accounts cannot log in with either password
+	suspended := *user
+	suspended.Status = StatusSuspended
+	for _, password := range []string{"password", "app-password"} {
+		if _, _, err := a.Login(ctx, &suspended, password, "123456"); !errors.Is(err, ErrAccountSuspended) {
+			t.Errorf("Login(suspended account) = %v, want ErrAccountSuspended", err)
+		}
+	}
+}
+
+func TestAppPasswordSessions(t *testing.T) {
+	ctx := context.Background()
+	a := newTestAccounts(t)
+
+	user := &User{Username: "alice.example.com", Email: "alice@example.com"}
+	if _, err := a.Register(ctx, user, "password", ""); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	a.users.appPasswords[user.DID] = map[string]string{"bot": "bot-password", "client": "client-password"}
+
+	login := func(password string) *SessionTokens {
+		t.Helper()
+		tokens, _, err := a.Login(ctx, user, password, "")
+		if err != nil {
+			t.Fatalf("Login: %v", err)
+		}
+		return tokens
+	}
+	full := login("password")
+	bot := login("bot-password")
+	client := login("client-password")
+
+	// App password sessions cannot manage the account
+	handler := RequireFullAccess(a.sessions, func(w http.ResponseWriter, r *http.Request) {})
+	for name, tt := range map[string]struct {
+		token  string
+		status int
+	}{
+		"full access":  {full.AccessJwt, http.StatusOK},
+		"app password": {bot.AccessJwt, http.StatusForbidden},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/account/delete", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if rec.Code != tt.status {
+			t.Errorf("RequireFullAccess(%s) = %d, want %d", name, rec.Code, tt.status)
+		}
+	}
+
+	// Revoking an app password ends its sessions and only its sessions
+	if err := a.sessions.RevokeAppPasswordSessions(ctx, user.DID, "bot"); err != nil {
+		t.Fatalf("RevokeAppPasswordSessions: %v", err)
+	}
+	if _, err := a.sessions.VerifyAccessToken(bot.AccessJwt); err == nil {
+		t.Error("access token of the revoked app password still valid")
+	}
+	if _, _, err := a.sessions.RefreshSession(ctx, bot.RefreshJwt); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("RefreshSession(revoked app password) = %v, want ErrSessionRevoked", err)
+	}
+	for name, tokens := range map[string]*SessionTokens{"full access": full, "other app password": client} {
+		if _, err := a.sessions.VerifyAccessToken(tokens.AccessJwt); err != nil {
+			t.Errorf("VerifyAccessToken(%s) = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrDuplicateAppPassword is returned when an account already has an app password with the same name
+var ErrDuplicateAppPassword = errors.New("app password name already in use")
+
+// appPasswordAlphabet is the alphabet app passwords are generated from
+const appPasswordAlphabet = "abcdefghijklmnopqrstuvwxyz234567"
+
+// AppPassword represents a named password for a bot or third-party client
+type AppPassword struct {
+	ID        int       `json:"-"`
+	DID       string    `json:"-"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// CreateAppPassword creates a named app password for an account and returns it with its
+// generated password. The password is only stored as a hash.
+func (r *UserRepository) CreateAppPassword(ctx context.Context, did, name string) (*AppPassword, string, error) {
+	password, err := generateAppPassword()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate app password: %w", err)
+	}
+
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	query := `
+		INSERT INTO app_passwords (did, name, password_hash, created_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	appPassword := &AppPassword{
+		DID:       did,
+		Name:      name,
+		CreatedAt: time.Now(),
+	}
+	err = r.db.QueryRow(ctx, query, did, name, string(hashedPassword), appPassword.CreatedAt).Scan(&appPassword.ID)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, "", ErrDuplicateAppPassword
+		}
+		return nil, "", fmt.Errorf("failed to create app password: %w", err)
+	}
+
+	return appPassword, password, nil
+}
+
+// ListAppPasswords lists the app passwords of an account
+func (r *UserRepository) ListAppPasswords(ctx context.Context, did string) ([]*AppPassword, error) {
+	query := `
+		SELECT id, did, name, created_at
+		FROM app_passwords
+		WHERE did = $1
+		ORDER BY created_at
+	`
+	rows, err := r.db.Query(ctx, query, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list app passwords: %w", err)
+	}
+	defer rows.Close()
+
+	appPasswords := []*AppPassword{}
+	for rows.Next() {
+		var appPassword AppPassword
+		if err := rows.Scan(&appPassword.ID, &appPassword.DID, &appPassword.Name, &appPassword.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan app password: %w", err)
+		}
+		appPasswords = append(appPasswords, &appPassword)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list app passwords: %w", err)
+	}
+	return appPasswords, nil
+}
+
+// RevokeAppPassword deletes a named app password. It reports whether the app password existed.
+func (r *UserRepository) RevokeAppPassword(ctx context.Context, did, name string) (bool, error) {
+	query := `
+		DELETE FROM app_passwords
+		WHERE did = $1 AND name = $2
+	`
+	tag, err := r.db.Exec(ctx, query, did, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke app password: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// VerifyAppPassword checks a password against a user's app passwords and returns the one it matches
+func (r *UserRepository) VerifyAppPassword(ctx context.Context, user *User, password string) (*AppPassword, bool) {
+	query := `
+		SELECT id, did, name, password_hash, created_at
+		FROM app_passwords
+		WHERE did = $1
+	`
+	rows, err := r.db.Query(ctx, query, user.DID)
+	if err != nil {
+		return nil, false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var appPassword AppPassword
+		var passwordHash string
+		if err := rows.Scan(&appPassword.ID, &appPassword.DID, &appPassword.Name, &passwordHash, &appPassword.CreatedAt); err != nil {
+			return nil, false
+		}
+		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil {
+			return &appPassword, true
+		}
+	}
+	return nil, false
+}
+
+// generateAppPassword generates a random password of the form xxxx-xxxx-xxxx-xxxx
+func generateAppPassword() (string, error) {
+	groups := make([]string, 4)
+	max := big.NewInt(int64(len(appPasswordAlphabet)))
+	for i := range groups {
+		var group [4]byte
+		for j := range group {
+			n, err := rand.Int(rand.Reader, max)
+			if err != nil {
+				return "", err
+			}
+			group[j] = appPasswordAlphabet[n.Int64()]
+		}
+		groups[i] = string(group[:])
+	}
+	return strings.Join(groups, "-"), nil
+}
@@ -0,0 +1,218 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// ErrUseDPoPNonce is returned when a DPoP proof lacks the server's current nonce
+var ErrUseDPoPNonce = errors.New("use_dpop_nonce")
+
+// DPoP proof limits
+const (
+	dpopMaxAge        = 5 * time.Minute
+	dpopNonceRotation = 3 * time.Minute
+)
+
+// DPoPProof holds the claims of a DPoP proof JWT
+type DPoPProof struct {
+	JWTID    string `json:"jti"`
+	Method   string `json:"htm"`
+	URL      string `json:"htu"`
+	IssuedAt int64  `json:"iat"`
+	ATH      string `json:"ath,omitempty"`
+	Nonce    string `json:"nonce,omitempty"`
+}
+
+// DPoPVerifier verifies DPoP proofs (RFC 9449), rejecting replayed proofs and,
+// if RequireNonce is set, proofs without a current server nonce
+type DPoPVerifier struct {
+	RequireNonce bool
+
+	mu            sync.Mutex
+	seen          map[string]time.Time
+	nonce         string
+	previousNonce string
+	rotatedAt     time.Time
+}
+
+// NewDPoPVerifier creates a new DPoP verifier
+func NewDPoPVerifier(requireNonce bool) *DPoPVerifier {
+	return &DPoPVerifier{
+		RequireNonce: requireNonce,
+		seen:         make(map[string]time.Time),
+	}
+}
+
+// Nonce returns the current server nonce, rotating it periodically
+func (v *DPoPVerifier) Nonce() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rotateNonce()
+	return v.nonce
+}
+
+// rotateNonce replaces the nonce once it is old. The previous nonce stays valid
+// for one more rotation. Callers must hold v.mu.
+func (v *DPoPVerifier) rotateNonce() {
+	if v.nonce != "" && time.Since(v.rotatedAt) < dpopNonceRotation {
+		return
+	}
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	v.previousNonce = v.nonce
+	v.nonce = base64.RawURLEncoding.EncodeToString(buf)
+	v.rotatedAt = time.Now()
+}
+
+// Verify verifies a DPoP proof for a request to method and url and returns the
+// thumbprint of the proof key. If accessToken is not empty, the proof must be
+// bound to it.
+func (v *DPoPVerifier) Verify(proof, method, url, accessToken string) (string, error) {
+	parts := strings.Split(proof, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("invalid DPoP proof format")
+	}
+
+	// Decode header
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid DPoP header encoding: %v", err)
+	}
+	var header struct {
+		Typ string          `json:"typ"`
+		Alg string          `json:"alg"`
+		JWK json.RawMessage `json:"jwk"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return "", fmt.Errorf("invalid DPoP header: %v", err)
+	}
+	if header.Typ != "dpop+jwt" {
+		return "", fmt.Errorf("invalid DPoP proof type: %s", header.Typ)
+	}
+
+	// The header must carry a public key only
+	var jwkFields map[string]interface{}
+	if err := json.Unmarshal(header.JWK, &jwkFields); err != nil {
+		return "", fmt.Errorf("invalid DPoP key: %v", err)
+	}
+	if _, ok := jwkFields["d"]; ok {
+		return "", fmt.Errorf("DPoP key must not contain a private key")
+	}
+	var jwk identity.JWK
+	if err := json.Unmarshal(header.JWK, &jwk); err != nil {
+		return "", fmt.Errorf("invalid DPoP key: %v", err)
+	}
+	publicKey, err := jwk.PublicKey()
+	if err != nil {
+		return "", err
+	}
+	if header.Alg != publicKey.Type().JWTAlg() {
+		return "", fmt.Errorf("algorithm %s does not match %s key", header.Alg, publicKey.Type())
+	}
+
+	// Verify signature
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid DPoP signature encoding: %v", err)
+	}
+	if !identity.VerifyJWS(publicKey, []byte(parts[0]+"."+parts[1]), signature) {
+		return "", fmt.Errorf("invalid DPoP signature")
+	}
+
+	// Decode claims
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid DPoP claims encoding: %v", err)
+	}
+	var claims DPoPProof
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return "", fmt.Errorf("invalid DPoP claims: %v", err)
+	}
+
+	// Check request binding
+	if claims.Method != method {
+		return "", fmt.Errorf("DPoP proof is for %s, not %s", claims.Method, method)
+	}
+	if stripQuery(claims.URL) != stripQuery(url) {
+		return "", fmt.Errorf("DPoP proof is for %s, not %s", claims.URL, url)
+	}
+	if accessToken != "" {
+		hash := sha256.Sum256([]byte(accessToken))
+		if claims.ATH != base64.RawURLEncoding.EncodeToString(hash[:]) {
+			return "", fmt.Errorf("DPoP proof is not bound to the access token")
+		}
+	}
+
+	// Check age
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	if time.Since(issuedAt) > dpopMaxAge || time.Until(issuedAt) > DefaultLeeway {
+		return "", fmt.Errorf("DPoP proof is expired or issued in the future")
+	}
+	if claims.JWTID == "" {
+		return "", fmt.Errorf("DPoP proof has no jti")
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Check nonce
+	if v.RequireNonce {
+		v.rotateNonce()
+		if claims.Nonce == "" || (claims.Nonce != v.nonce && claims.Nonce != v.previousNonce) {
+			return "", ErrUseDPoPNonce
+		}
+	}
+
+	// Reject replayed proofs
+	now := time.Now()
+	for jti, expiresAt := range v.seen {
+		if expiresAt.Before(now) {
+			delete(v.seen, jti)
+		}
+	}
+	if _, ok := v.seen[claims.JWTID]; ok {
+		return "", fmt.Errorf("DPoP proof replayed")
+	}
+	v.seen[claims.JWTID] = issuedAt.Add(dpopMaxAge + DefaultLeeway)
+
+	return jwk.Thumbprint(), nil
+}
+
+// stripQuery removes the query and fragment from a URL
+func stripQuery(url string) string {
+	if i := strings.IndexAny(url, "?#"); i >= 0 {
+		return url[:i]
+	}
+	return url
+}
+
+// RequestURL reconstructs the public URL of a request, honouring the
+// X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix headers set by
+// the API gateway
+func RequestURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	host := r.Host
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+		host = forwardedHost
+	}
+
+	return scheme + "://" + host + r.Header.Get("X-Forwarded-Prefix") + r.URL.Path
+}
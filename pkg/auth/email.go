@@ -0,0 +1,177 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Email token purposes
+const (
+	EmailTokenConfirmEmail  = "confirm_email"
+	EmailTokenResetPassword = "reset_password"
+	EmailTokenUpdateEmail   = "update_email"
+	EmailTokenDeleteAccount = "delete_account"
+	EmailTokenPlcOperation  = "plc_operation"
+)
+
+// Email token errors
+var (
+	ErrInvalidEmailToken = errors.New("invalid email token")
+	ErrExpiredEmailToken = errors.New("email token expired")
+	ErrDuplicateEmail    = errors.New("email already in use")
+)
+
+// emailTokenAlphabet is the alphabet email tokens are generated from
+const emailTokenAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+
+// CreateEmailToken creates a one-time token for a purpose and returns it. It
+// replaces any earlier token for the same account and purpose. The token is only
+// stored as a hash.
+func (r *UserRepository) CreateEmailToken(ctx context.Context, did, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateEmailToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate email token: %w", err)
+	}
+
+	query := `
+		INSERT INTO email_tokens (did, purpose, token_hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (did, purpose) DO UPDATE
+		SET token_hash = EXCLUDED.token_hash, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+	`
+	now := time.Now()
+	if _, err := r.db.Exec(ctx, query, did, purpose, hashEmailToken(token), now, now.Add(ttl)); err != nil {
+		return "", fmt.Errorf("failed to create email token: %w", err)
+	}
+	return token, nil
+}
+
+// ConsumeEmailToken deletes a token for a purpose and returns the DID it was
+// issued for. If did is not empty, the token must have been issued for it.
+func (r *UserRepository) ConsumeEmailToken(ctx context.Context, purpose, token, did string) (string, error) {
+	query := `
+		DELETE FROM email_tokens
+		WHERE purpose = $1 AND token_hash = $2 AND ($3 = '' OR did = $3)
+		RETURNING did, expires_at
+	`
+	var expiresAt time.Time
+	err := r.db.QueryRow(ctx, query, purpose, hashEmailToken(token), did).Scan(&did, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrInvalidEmailToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to consume email token: %w", err)
+	}
+
+	if expiresAt.Before(time.Now()) {
+		return "", ErrExpiredEmailToken
+	}
+	return did, nil
+}
+
+// CheckEmailToken checks a token for a purpose issued for did without consuming it
+func (r *UserRepository) CheckEmailToken(ctx context.Context, purpose, token, did string) error {
+	query := `
+		SELECT expires_at
+		FROM email_tokens
+		WHERE purpose = $1 AND token_hash = $2 AND did = $3
+	`
+	var expiresAt time.Time
+	err := r.db.QueryRow(ctx, query, purpose, hashEmailToken(token), did).Scan(&expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidEmailToken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check email token: %w", err)
+	}
+
+	if expiresAt.Before(time.Now()) {
+		return ErrExpiredEmailToken
+	}
+	return nil
+}
+
+// ConfirmEmail marks a user's email as confirmed
+func (r *UserRepository) ConfirmEmail(ctx context.Context, did string) error {
+	query := `
+		UPDATE users
+		SET email_confirmed_at = $1, updated_at = $1
+		WHERE did = $2
+	`
+	if _, err := r.db.Exec(ctx, query, time.Now(), did); err != nil {
+		return fmt.Errorf("failed to confirm email: %w", err)
+	}
+	return nil
+}
+
+// UpdateEmail changes a user's email. The new email is unconfirmed.
+func (r *UserRepository) UpdateEmail(ctx context.Context, did, email string) error {
+	query := `
+		UPDATE users
+		SET email = $1, email_confirmed_at = NULL, updated_at = $2
+		WHERE did = $3
+	`
+	_, err := r.db.Exec(ctx, query, email, time.Now(), did)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrDuplicateEmail
+		}
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+	return nil
+}
+
+// UpdatePassword changes a user's password
+func (r *UserRepository) UpdatePassword(ctx context.Context, did, password string) error {
+	// Hash password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	query := `
+		UPDATE users
+		SET password_hash = $1, updated_at = $2
+		WHERE did = $3
+	`
+	if _, err := r.db.Exec(ctx, query, string(hashedPassword), time.Now(), did); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	return nil
+}
+
+// generateEmailToken generates a random token of the form XXXXX-XXXXX
+func generateEmailToken() (string, error) {
+	var token [11]byte
+	max := big.NewInt(int64(len(emailTokenAlphabet)))
+	for i := range token {
+		if i == 5 {
+			token[i] = '-'
+			continue
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		token[i] = emailTokenAlphabet[n.Int64()]
+	}
+	return string(token[:]), nil
+}
+
+// hashEmailToken hashes a token as typed by the user, ignoring case and surrounding whitespace
+func hashEmailToken(token string) string {
+	hash := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(token))))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrDuplicateHandle is returned when a handle is already taken by another account
+var ErrDuplicateHandle = errors.New("handle already taken")
+
+// IdentityEvent is a change of an account's handle published by the auth service
+type IdentityEvent struct {
+	Seq    int64     `json:"seq"`
+	DID    string    `json:"did"`
+	Handle string    `json:"handle"`
+	Time   time.Time `json:"time"`
+}
+
+// UpdateHandle changes a user's handle and records an identity event for it
+func (r *UserRepository) UpdateHandle(ctx context.Context, did, handle string) (*IdentityEvent, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	event := &IdentityEvent{
+		DID:    did,
+		Handle: handle,
+		Time:   time.Now(),
+	}
+	query := `
+		UPDATE users
+		SET username = $1, updated_at = $2
+		WHERE did = $3
+	`
+	if _, err := tx.Exec(ctx, query, handle, event.Time, did); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return nil, ErrDuplicateHandle
+		}
+		return nil, fmt.Errorf("failed to update handle: %w", err)
+	}
+
+	query = `
+		INSERT INTO identity_events (did, handle, created_at)
+		VALUES ($1, $2, $3)
+		RETURNING seq
+	`
+	if err := tx.QueryRow(ctx, query, did, handle, event.Time).Scan(&event.Seq); err != nil {
+		return nil, fmt.Errorf("failed to record identity event: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit handle update: %w", err)
+	}
+	return event, nil
+}
+
+// ListIdentityEvents lists up to limit identity events after the cursor, oldest first
+func (r *UserRepository) ListIdentityEvents(ctx context.Context, cursor int64, limit int) ([]IdentityEvent, error) {
+	query := `
+		SELECT seq, did, handle, created_at
+		FROM identity_events
+		WHERE seq > $1
+		ORDER BY seq
+		LIMIT $2
+	`
+	rows, err := r.db.Query(ctx, query, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list identity events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []IdentityEvent{}
+	for rows.Next() {
+		var event IdentityEvent
+		if err := rows.Scan(&event.Seq, &event.DID, &event.Handle, &event.Time); err != nil {
+			return nil, fmt.Errorf("failed to scan identity event: %w", err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating identity events: %w", err)
+	}
+	return events, nil
+}
+
+// FetchIdentityEvents fetches the identity events the auth service published
+// after the cursor
+func FetchIdentityEvents(ctx context.Context, client *http.Client, eventsURL string, cursor int64) ([]IdentityEvent, error) {
+	u, err := url.Parse(eventsURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity events URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("cursor", strconv.FormatInt(cursor, 10))
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch identity events: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch identity events: unexpected status: %s", resp.Status)
+	}
+
+	var body struct {
+		Events []IdentityEvent `json:"events"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid identity event list: %w", err)
+	}
+	return body.Events, nil
+}
+
+// SyncIdentityEvents polls the auth service's identity events until ctx is done
+// and passes each event to apply, oldest first. When apply fails, the event and
+// the ones after it are fetched again on the next poll, so apply must be
+// idempotent.
+func SyncIdentityEvents(ctx context.Context, eventsURL string, interval time.Duration, apply func(context.Context, IdentityEvent) error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var cursor int64
+	for {
+		events, err := FetchIdentityEvents(ctx, client, eventsURL, cursor)
+		if err != nil {
+			log.Printf("Failed to sync identity events: %v", err)
+		}
+		for _, event := range events {
+			if err := apply(ctx, event); err != nil {
+				log.Printf("Failed to apply handle %s of %s: %v", event.Handle, event.DID, err)
+				break
+			}
+			cursor = event.Seq
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
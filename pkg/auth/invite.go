@@ -0,0 +1,288 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// InviteCodeAdmin is the account of invite codes created by admins for nobody in particular
+const InviteCodeAdmin = "admin"
+
+// ErrInvalidInviteCode is returned for unknown, disabled and used up invite codes
+var ErrInvalidInviteCode = errors.New("invalid invite code")
+
+// InviteCode is a code that lets new accounts register
+type InviteCode struct {
+	Code       string          `json:"code"`
+	Available  int             `json:"available"`
+	Disabled   bool            `json:"disabled"`
+	ForAccount string          `json:"forAccount"`
+	CreatedBy  string          `json:"createdBy"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Uses       []InviteCodeUse `json:"uses"`
+}
+
+// InviteCodeUse records an account that registered with an invite code
+type InviteCodeUse struct {
+	UsedBy string    `json:"usedBy"`
+	UsedAt time.Time `json:"usedAt"`
+}
+
+// CreateInviteCodes creates count invite codes that can each be used uses times
+func (r *UserRepository) CreateInviteCodes(ctx context.Context, forAccount, createdBy string, count, uses int) ([]*InviteCode, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	codes, err := createInviteCodes(ctx, tx, forAccount, createdBy, count, uses)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit invite codes: %w", err)
+	}
+	return codes, nil
+}
+
+// EnsureInviteCodes creates single-use invite codes for an account until it has
+// been given allowance codes in total, and returns the new codes
+func (r *UserRepository) EnsureInviteCodes(ctx context.Context, did string, allowance int) ([]*InviteCode, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the user so that concurrent requests do not exceed the allowance
+	if _, err := tx.Exec(ctx, `SELECT 1 FROM users WHERE did = $1 FOR UPDATE`, did); err != nil {
+		return nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	var given int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM invite_codes WHERE for_account = $1`, did).Scan(&given); err != nil {
+		return nil, fmt.Errorf("failed to count invite codes: %w", err)
+	}
+	if given >= allowance {
+		return nil, nil
+	}
+
+	codes, err := createInviteCodes(ctx, tx, did, did, allowance-given, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit invite codes: %w", err)
+	}
+	return codes, nil
+}
+
+// ListInviteCodes lists the invite codes of an account with their uses, newest
+// first. If forAccount is empty, all codes are listed.
+func (r *UserRepository) ListInviteCodes(ctx context.Context, forAccount string) ([]*InviteCode, error) {
+	query := `
+		SELECT code, available_uses, disabled, for_account, created_by, created_at
+		FROM invite_codes
+		WHERE $1 = '' OR for_account = $1
+		ORDER BY created_at DESC
+	`
+	rows, err := r.db.Query(ctx, query, forAccount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invite codes: %w", err)
+	}
+	defer rows.Close()
+
+	codes := []*InviteCode{}
+	byCode := make(map[string]*InviteCode)
+	for rows.Next() {
+		code := &InviteCode{Uses: []InviteCodeUse{}}
+		err := rows.Scan(
+			&code.Code,
+			&code.Available,
+			&code.Disabled,
+			&code.ForAccount,
+			&code.CreatedBy,
+			&code.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invite code: %w", err)
+		}
+		codes = append(codes, code)
+		byCode[code.Code] = code
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invite codes: %w", err)
+	}
+	if len(codes) == 0 {
+		return codes, nil
+	}
+
+	// Get uses
+	names := make([]string, 0, len(codes))
+	for _, code := range codes {
+		names = append(names, code.Code)
+	}
+	query = `
+		SELECT code, used_by, used_at
+		FROM invite_code_uses
+		WHERE code = ANY($1)
+		ORDER BY used_at
+	`
+	rows, err = r.db.Query(ctx, query, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invite code uses: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var use InviteCodeUse
+		if err := rows.Scan(&name, &use.UsedBy, &use.UsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan invite code use: %w", err)
+		}
+		byCode[name].Uses = append(byCode[name].Uses, use)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invite code uses: %w", err)
+	}
+	return codes, nil
+}
+
+// CheckInviteCode checks that an invite code exists, is not disabled and has uses left
+func (r *UserRepository) CheckInviteCode(ctx context.Context, code string) error {
+	query := `
+		SELECT c.available_uses > COUNT(u.used_by) AND NOT c.disabled
+		FROM invite_codes c
+		LEFT JOIN invite_code_uses u ON u.code = c.code
+		WHERE c.code = $1
+		GROUP BY c.code
+	`
+	var usable bool
+	err := r.db.QueryRow(ctx, query, normalizeInviteCode(code)).Scan(&usable)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidInviteCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check invite code: %w", err)
+	}
+	if !usable {
+		return ErrInvalidInviteCode
+	}
+	return nil
+}
+
+// UseInviteCode records that did registered with an invite code, if the code
+// still has uses left
+func (r *UserRepository) UseInviteCode(ctx context.Context, code, did string) error {
+	code = normalizeInviteCode(code)
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the code so that concurrent registrations cannot exceed its uses
+	var available int
+	var disabled bool
+	err = tx.QueryRow(ctx, `SELECT available_uses, disabled FROM invite_codes WHERE code = $1 FOR UPDATE`, code).Scan(&available, &disabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrInvalidInviteCode
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get invite code: %w", err)
+	}
+
+	var used int
+	if err := tx.QueryRow(ctx, `SELECT COUNT(*) FROM invite_code_uses WHERE code = $1`, code).Scan(&used); err != nil {
+		return fmt.Errorf("failed to count invite code uses: %w", err)
+	}
+	if disabled || used >= available {
+		return ErrInvalidInviteCode
+	}
+
+	query := `
+		INSERT INTO invite_code_uses (code, used_by, used_at)
+		VALUES ($1, $2, $3)
+	`
+	if _, err := tx.Exec(ctx, query, code, did, time.Now()); err != nil {
+		return fmt.Errorf("failed to use invite code: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit invite code use: %w", err)
+	}
+	return nil
+}
+
+// ReleaseInviteCode undoes a use of an invite code by a registration that failed
+func (r *UserRepository) ReleaseInviteCode(ctx context.Context, code, did string) error {
+	_, err := r.db.Exec(ctx, `DELETE FROM invite_code_uses WHERE code = $1 AND used_by = $2`, normalizeInviteCode(code), did)
+	if err != nil {
+		return fmt.Errorf("failed to release invite code: %w", err)
+	}
+	return nil
+}
+
+// DisableInviteCodes disables the given codes and all codes of the given
+// accounts, and returns how many codes were disabled
+func (r *UserRepository) DisableInviteCodes(ctx context.Context, codes, accounts []string) (int64, error) {
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = normalizeInviteCode(code)
+	}
+
+	query := `
+		UPDATE invite_codes
+		SET disabled = TRUE
+		WHERE NOT disabled AND (code = ANY($1) OR for_account = ANY($2))
+	`
+	tag, err := r.db.Exec(ctx, query, normalized, accounts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to disable invite codes: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// createInviteCodes inserts count new invite codes in tx
+func createInviteCodes(ctx context.Context, tx pgx.Tx, forAccount, createdBy string, count, uses int) ([]*InviteCode, error) {
+	query := `
+		INSERT INTO invite_codes (code, available_uses, disabled, for_account, created_by, created_at)
+		VALUES ($1, $2, FALSE, $3, $4, $5)
+	`
+	now := time.Now()
+	codes := make([]*InviteCode, 0, count)
+	for i := 0; i < count; i++ {
+		token, err := generateEmailToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate invite code: %w", err)
+		}
+
+		code := &InviteCode{
+			Code:       strings.ToLower(token),
+			Available:  uses,
+			ForAccount: forAccount,
+			CreatedBy:  createdBy,
+			CreatedAt:  now,
+			Uses:       []InviteCodeUse{},
+		}
+		if _, err := tx.Exec(ctx, query, code.Code, code.Available, code.ForAccount, code.CreatedBy, code.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to create invite code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// normalizeInviteCode normalizes an invite code as typed by the user
+func normalizeInviteCode(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// inviteSchema is the part of init-db.sql the invite code queries use
+const inviteSchema = `
+CREATE TABLE invite_codes (
+    code TEXT PRIMARY KEY,
+    available_uses INTEGER NOT NULL,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    for_account TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE TABLE invite_code_uses (
+    code TEXT NOT NULL REFERENCES invite_codes(code),
+    used_by TEXT NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (code, used_by)
+);
+`
+
+// newInviteTestRepository returns a user repository on a schema of its own in
+// the Postgres database at TEST_DATABASE_URL, and skips the test without one
+func newInviteTestRepository(t *testing.T) *UserRepository {
+	t.Helper()
+	databaseURL := os.Getenv("TEST_DATABASE_URL")
+	if databaseURL == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	ctx := context.Background()
+
+	schema := fmt.Sprintf("test_invite_%d", time.Now().UnixNano())
+	conn, err := pgx.Connect(ctx, databaseURL)
+	if err != nil {
+		t.Fatalf("failed to connect to database: %v", err)
+	}
+	defer conn.Close(ctx)
+	if _, err := conn.Exec(ctx, `CREATE SCHEMA `+schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		conn, err := pgx.Connect(context.Background(), databaseURL)
+		if err != nil {
+			t.Errorf("failed to connect to database: %v", err)
+			return
+		}
+		defer conn.Close(context.Background())
+		if _, err := conn.Exec(context.Background(), `DROP SCHEMA `+schema+` CASCADE`); err != nil {
+			t.Errorf("failed to drop schema: %v", err)
+		}
+	})
+
+	config, err := pgxpool.ParseConfig(databaseURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("failed to open pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	if _, err := pool.Exec(ctx, inviteSchema); err != nil {
+		t.Fatalf("failed to create tables: %v", err)
+	}
+	return NewUserRepository(pool)
+}
+
+func createTestInviteCode(t *testing.T, repo *UserRepository, uses int) string {
+	t.Helper()
+	codes, err := repo.CreateInviteCodes(context.Background(), "admin", "admin", 1, uses)
+	if err != nil {
+		t.Fatalf("CreateInviteCodes: %v", err)
+	}
+	return codes[0].Code
+}
+
+func TestUseInviteCodeExhaustion(t *testing.T) {
+	ctx := context.Background()
+	repo := newInviteTestRepository(t)
+	code := createTestInviteCode(t, repo, 2)
+
+	// Codes are matched as typed by users
+	if err := repo.UseInviteCode(ctx, " "+strings.ToUpper(code)+" ", "did:plc:alice"); err != nil {
+		t.Fatalf("UseInviteCode: %v", err)
+	}
+	if err := repo.CheckInviteCode(ctx, code); err != nil {
+		t.Errorf("CheckInviteCode() with a use left = %v", err)
+	}
+	if err := repo.UseInviteCode(ctx, code, "did:plc:bob"); err != nil {
+		t.Fatalf("UseInviteCode: %v", err)
+	}
+
+	if err := repo.CheckInviteCode(ctx, code); !errors.Is(err, ErrInvalidInviteCode) {
+		t.Errorf("CheckInviteCode(exhausted code) = %v, want ErrInvalidInviteCode", err)
+	}
+	if err := repo.UseInviteCode(ctx, code, "did:plc:carol"); !errors.Is(err, ErrInvalidInviteCode) {
+		t.Errorf("UseInviteCode(exhausted code) = %v, want ErrInvalidInviteCode", err)
+	}
+	if err := repo.UseInviteCode(ctx, "unknown", "did:plc:carol"); !errors.Is(err, ErrInvalidInviteCode) {
+		t.Errorf("UseInviteCode(unknown code) = %v, want ErrInvalidInviteCode", err)
+	}
+
+	// Disabled codes cannot be used
+	other := createTestInviteCode(t, repo, 1)
+	if _, err := repo.DisableInviteCodes(ctx, []string{other}, nil); err != nil {
+		t.Fatalf("DisableInviteCodes: %v", err)
+	}
+	if err := repo.UseInviteCode(ctx, other, "did:plc:carol"); !errors.Is(err, ErrInvalidInviteCode) {
+		t.Errorf("UseInviteCode(disabled code) = %v, want ErrInvalidInviteCode", err)
+	}
+}
+
+func TestUseInviteCodeConcurrently(t *testing.T) {
+	ctx := context.Background()
+	repo := newInviteTestRepository(t)
+	code := createTestInviteCode(t, repo, 3)
+
+	// Many registrations race for the code; only as many as it has uses win
+	const attempts = 20
+	var wg sync.WaitGroup
+	errs := make([]error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = repo.UseInviteCode(ctx, code, fmt.Sprintf("did:plc:account%d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	used := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			used++
+		case !errors.Is(err, ErrInvalidInviteCode):
+			t.Errorf("UseInviteCode() = %v", err)
+		}
+	}
+	if used != 3 {
+		t.Errorf("%d registrations used a code with 3 uses", used)
+	}
+}
+
+func TestReleaseInviteCode(t *testing.T) {
+	ctx := context.Background()
+	repo := newInviteTestRepository(t)
+	code := createTestInviteCode(t, repo, 1)
+
+	if err := repo.UseInviteCode(ctx, code, "did:plc:alice"); err != nil {
+		t.Fatalf("UseInviteCode: %v", err)
+	}
+
+	// Releasing another account's use changes nothing
+	if err := repo.ReleaseInviteCode(ctx, code, "did:plc:bob"); err != nil {
+		t.Fatalf("ReleaseInviteCode: %v", err)
+	}
+	if err := repo.CheckInviteCode(ctx, code); !errors.Is(err, ErrInvalidInviteCode) {
+		t.Errorf("CheckInviteCode() = %v, want ErrInvalidInviteCode", err)
+	}
+
+	// The registration that used the code is rolled back
+	if err := repo.ReleaseInviteCode(ctx, code, "did:plc:alice"); err != nil {
+		t.Fatalf("ReleaseInviteCode: %v", err)
+	}
+	if err := repo.UseInviteCode(ctx, code, "did:plc:bob"); err != nil {
+		t.Errorf("UseInviteCode(released code) = %v", err)
+	}
+}
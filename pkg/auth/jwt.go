@@ -1,23 +1,74 @@
 package auth
 
 import (
-	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
 )
 
 // Claims represents the claims in a JWT
 type Claims struct {
-	Issuer    string `json:"iss"`
-	Subject   string `json:"sub"`
-	Audience  string `json:"aud,omitempty"`
-	ExpiresAt int64  `json:"exp"`
-	NotBefore int64  `json:"nbf,omitempty"`
-	IssuedAt int64  `json:"iat"`
-	JWTID     string `json:"jti,omitempty"`
+	Issuer        string        `json:"iss"`
+	Subject       string        `json:"sub"`
+	Audience      string        `json:"aud,omitempty"`
+	ExpiresAt     int64         `json:"exp"`
+	NotBefore     int64         `json:"nbf,omitempty"`
+	IssuedAt      int64         `json:"iat"`
+	JWTID         string        `json:"jti,omitempty"`
+	SessionID     string        `json:"sid,omitempty"`
+	LexiconMethod string        `json:"lxm,omitempty"`
+	Scope         string        `json:"scope,omitempty"`
+	ClientID      string        `json:"client_id,omitempty"`
+	Confirmation  *Confirmation `json:"cnf,omitempty"`
+}
+
+// Confirmation binds a token to a key (RFC 7800)
+type Confirmation struct {
+	// JKT is the thumbprint of the DPoP key the token is bound to
+	JKT string `json:"jkt"`
+}
+
+// DefaultLeeway is the clock skew VerifyJWT allows when checking exp, nbf and iat
+const DefaultLeeway = 30 * time.Second
+
+// VerifyOptions are the checks VerifyJWT performs in addition to the signature and
+// the token's validity period. Empty fields are not checked.
+type VerifyOptions struct {
+	// Audience is the required "aud" claim
+	Audience string
+	// Issuers are the allowed "iss" claims
+	Issuers []string
+	// LexiconMethod is the required "lxm" claim
+	LexiconMethod string
+	// Leeway is the allowed clock skew, DefaultLeeway if zero
+	Leeway time.Duration
+}
+
+// VerifyOption configures VerifyJWT
+type VerifyOption func(*VerifyOptions)
+
+// WithAudience requires the token to be addressed to audience
+func WithAudience(audience string) VerifyOption {
+	return func(o *VerifyOptions) { o.Audience = audience }
+}
+
+// WithIssuers requires the token to be issued by one of issuers
+func WithIssuers(issuers ...string) VerifyOption {
+	return func(o *VerifyOptions) { o.Issuers = append(o.Issuers, issuers...) }
+}
+
+// WithLexiconMethod requires the token to be scoped to an XRPC method
+func WithLexiconMethod(lexiconMethod string) VerifyOption {
+	return func(o *VerifyOptions) { o.LexiconMethod = lexiconMethod }
+}
+
+// WithLeeway sets the allowed clock skew
+func WithLeeway(leeway time.Duration) VerifyOption {
+	return func(o *VerifyOptions) { o.Leeway = leeway }
 }
 
 // JWT represents a JSON Web Token
@@ -27,7 +78,7 @@ type JWT struct {
 	Signature []byte
 }
 
-// NewJWT creates a new JWT
+// NewJWT creates a new JWT. The "alg" header is set from the key type when the token is signed.
 func NewJWT(issuer, subject string, expiresIn time.Duration) *JWT {
 	now := time.Now()
 	return &JWT{
@@ -45,7 +96,14 @@ func NewJWT(issuer, subject string, expiresIn time.Duration) *JWT {
 }
 
 // Sign signs the JWT with a private key
-func (j *JWT) Sign(privateKey ed25519.PrivateKey) (string, error) {
+func (j *JWT) Sign(privateKey identity.PrivateKey) (string, error) {
+	// Set algorithm from key type
+	alg := privateKey.Type().JWTAlg()
+	if alg == "" {
+		return "", fmt.Errorf("unsupported key type: %s", privateKey.Type())
+	}
+	j.Header["alg"] = alg
+
 	// Encode header
 	headerJSON, err := json.Marshal(j.Header)
 	if err != nil {
@@ -64,15 +122,27 @@ func (j *JWT) Sign(privateKey ed25519.PrivateKey) (string, error) {
 	signatureInput := headerEncoded + "." + claimsEncoded
 
 	// Sign
-	signature := ed25519.Sign(privateKey, []byte(signatureInput))
+	signature, err := privateKey.Sign([]byte(signatureInput))
+	if err != nil {
+		return "", err
+	}
 	signatureEncoded := base64.RawURLEncoding.EncodeToString(signature)
 
 	// Create JWT
 	return headerEncoded + "." + claimsEncoded + "." + signatureEncoded, nil
 }
 
-// Verify verifies the JWT with a public key
-func VerifyJWT(tokenString string, publicKey ed25519.PublicKey) (*Claims, error) {
+// VerifyJWT verifies the JWT with a public key. The token's "alg" must match the key
+// type, and exp, nbf and iat are checked with a small leeway for clock skew.
+func VerifyJWT(tokenString string, publicKey identity.PublicKey, opts ...VerifyOption) (*Claims, error) {
+	var options VerifyOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.Leeway == 0 {
+		options.Leeway = DefaultLeeway
+	}
+
 	// Split token
 	parts := strings.Split(tokenString, ".")
 	if len(parts) != 3 {
@@ -92,8 +162,15 @@ func VerifyJWT(tokenString string, publicKey ed25519.PublicKey) (*Claims, error)
 
 	// Check algorithm
 	alg, ok := header["alg"].(string)
-	if !ok || alg != "EdDSA" {
-		return nil, fmt.Errorf("unsupported algorithm: %v", alg)
+	if !ok {
+		return nil, fmt.Errorf("missing algorithm")
+	}
+	keyType, err := identity.KeyTypeForJWTAlg(alg)
+	if err != nil {
+		return nil, err
+	}
+	if keyType != publicKey.Type() {
+		return nil, fmt.Errorf("algorithm %s does not match %s key", alg, publicKey.Type())
 	}
 
 	// Decode claims
@@ -115,15 +192,94 @@ func VerifyJWT(tokenString string, publicKey ed25519.PublicKey) (*Claims, error)
 
 	// Verify signature
 	signatureInput := parts[0] + "." + parts[1]
-	if !ed25519.Verify(publicKey, []byte(signatureInput), signature) {
+	if !publicKey.Verify([]byte(signatureInput), signature) {
 		return nil, fmt.Errorf("invalid signature")
 	}
 
-	// Verify expiration
-	now := time.Now().Unix()
-	if claims.ExpiresAt < now {
-		return nil, fmt.Errorf("token expired")
+	if err := claims.validate(options); err != nil {
+		return nil, err
 	}
 
 	return &claims, nil
 }
+
+// validate checks the claims against the verify options
+func (c *Claims) validate(options VerifyOptions) error {
+	now := time.Now()
+	leeway := int64(options.Leeway / time.Second)
+
+	// Check validity period
+	if c.ExpiresAt == 0 || c.ExpiresAt+leeway < now.Unix() {
+		return fmt.Errorf("token expired")
+	}
+	if c.NotBefore != 0 && c.NotBefore-leeway > now.Unix() {
+		return fmt.Errorf("token not valid yet")
+	}
+	if c.IssuedAt-leeway > now.Unix() {
+		return fmt.Errorf("token issued in the future")
+	}
+
+	// Check audience
+	if options.Audience != "" && c.Audience != options.Audience {
+		return fmt.Errorf("token audience %q does not match %q", c.Audience, options.Audience)
+	}
+
+	// Check issuer
+	if len(options.Issuers) > 0 {
+		allowed := false
+		for _, issuer := range options.Issuers {
+			if c.Issuer == issuer {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("token issuer %q is not allowed", c.Issuer)
+		}
+	}
+
+	// Check lexicon method
+	if options.LexiconMethod != "" && c.LexiconMethod != options.LexiconMethod {
+		return fmt.Errorf("token is not valid for %s", options.LexiconMethod)
+	}
+
+	return nil
+}
+
+// ParseJWT decodes a JWT without verifying its signature. Callers must verify
+// the token with VerifyJWT before trusting its claims.
+func ParseJWT(tokenString string) (*JWT, error) {
+	parts := strings.Split(tokenString, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("invalid token format")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid header encoding: %v", err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %v", err)
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid claims encoding: %v", err)
+	}
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	return &JWT{
+		Header:    header,
+		Claims:    claims,
+		Signature: signature,
+	}, nil
+}
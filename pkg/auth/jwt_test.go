@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"crypto/elliptic"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+func generateTestKey(t *testing.T, keyType identity.KeyType) identity.PrivateKey {
+	t.Helper()
+	key, err := identity.GenerateKey(keyType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signTestJWT signs a token from the test issuer to the test audience after
+// change has modified its claims
+func signTestJWT(t *testing.T, key identity.PrivateKey, change func(*Claims)) string {
+	t.Helper()
+	token := NewJWT("did:plc:issuer", "did:plc:issuer", time.Minute)
+	token.Claims.Audience = "did:web:service.example.com"
+	token.Claims.LexiconMethod = LxmCreateRecord
+	if change != nil {
+		change(&token.Claims)
+	}
+	signed, err := token.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerifyJWTOptions(t *testing.T) {
+	key := generateTestKey(t, identity.KeyTypeEd25519)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		change  func(*Claims)
+		opts    []VerifyOption
+		wantErr string
+	}{
+		{
+			name: "no options",
+		},
+		{
+			name: "all options match",
+			opts: []VerifyOption{WithAudience("did:web:service.example.com"), WithIssuers("did:plc:other", "did:plc:issuer"), WithLexiconMethod(LxmCreateRecord)},
+		},
+		{
+			name:    "wrong aud",
+			opts:    []VerifyOption{WithAudience("did:web:other.example.com")},
+			wantErr: "audience",
+		},
+		{
+			name:    "missing aud",
+			change:  func(c *Claims) { c.Audience = "" },
+			opts:    []VerifyOption{WithAudience("did:web:service.example.com")},
+			wantErr: "audience",
+		},
+		{
+			name:    "wrong iss",
+			opts:    []VerifyOption{WithIssuers("did:plc:other")},
+			wantErr: "issuer",
+		},
+		{
+			name:   "nbf in the future inside the leeway",
+			change: func(c *Claims) { c.NotBefore = now.Add(20 * time.Second).Unix() },
+		},
+		{
+			name:    "nbf in the future outside the leeway",
+			change:  func(c *Claims) { c.NotBefore = now.Add(2 * time.Minute).Unix() },
+			wantErr: "not valid yet",
+		},
+		{
+			name:   "nbf in the future inside a longer leeway",
+			change: func(c *Claims) { c.NotBefore = now.Add(2 * time.Minute).Unix() },
+			opts:   []VerifyOption{WithLeeway(5 * time.Minute)},
+		},
+		{
+			name:   "expired inside the leeway",
+			change: func(c *Claims) { c.ExpiresAt = now.Add(-20 * time.Second).Unix() },
+		},
+		{
+			name:    "expired outside the leeway",
+			change:  func(c *Claims) { c.ExpiresAt = now.Add(-2 * time.Minute).Unix() },
+			wantErr: "expired",
+		},
+		{
+			name:    "no exp",
+			change:  func(c *Claims) { c.ExpiresAt = 0 },
+			wantErr: "expired",
+		},
+		{
+			name:    "issued in the future",
+			change:  func(c *Claims) { c.IssuedAt = now.Add(2 * time.Minute).Unix() },
+			wantErr: "issued in the future",
+		},
+		{
+			name:    "missing lxm",
+			change:  func(c *Claims) { c.LexiconMethod = "" },
+			opts:    []VerifyOption{WithLexiconMethod(LxmCreateRecord)},
+			wantErr: "not valid for",
+		},
+		{
+			name:    "mismatched lxm",
+			change:  func(c *Claims) { c.LexiconMethod = LxmDeleteRecord },
+			opts:    []VerifyOption{WithLexiconMethod(LxmCreateRecord)},
+			wantErr: "not valid for",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := signTestJWT(t, key, tt.change)
+			claims, err := VerifyJWT(token, key.Public(), tt.opts...)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyJWT() = %v, want nil", err)
+				}
+				if claims.Issuer != "did:plc:issuer" {
+					t.Errorf("Issuer = %q, want did:plc:issuer", claims.Issuer)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyJWT() = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// withHeader replaces the header of a signed token, keeping its claims and signature
+func withHeader(t *testing.T, token string, header map[string]interface{}) string {
+	t.Helper()
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	return base64.RawURLEncoding.EncodeToString(headerJSON) + "." + parts[1] + "." + parts[2]
+}
+
+func TestVerifyJWTAlgorithmMustMatchKey(t *testing.T) {
+	ed25519Key := generateTestKey(t, identity.KeyTypeEd25519)
+	p256Key := generateTestKey(t, identity.KeyTypeP256)
+	k256Key := generateTestKey(t, identity.KeyTypeSecp256k1)
+
+	tests := []struct {
+		name  string
+		token string
+		key   identity.PublicKey
+	}{
+		{"ES256 token, ed25519 key", signTestJWT(t, p256Key, nil), ed25519Key.Public()},
+		{"ES256K token, P-256 key", signTestJWT(t, k256Key, nil), p256Key.Public()},
+		{"EdDSA token, secp256k1 key", signTestJWT(t, ed25519Key, nil), k256Key.Public()},
+		{"EdDSA token relabelled ES256", withHeader(t, signTestJWT(t, ed25519Key, nil), map[string]interface{}{"alg": "ES256", "typ": "JWT"}), ed25519Key.Public()},
+		{"alg none", withHeader(t, signTestJWT(t, ed25519Key, nil), map[string]interface{}{"alg": "none", "typ": "JWT"}), ed25519Key.Public()},
+		{"no alg", withHeader(t, signTestJWT(t, ed25519Key, nil), map[string]interface{}{"typ": "JWT"}), ed25519Key.Public()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := VerifyJWT(tt.token, tt.key); err == nil {
+				t.Error("VerifyJWT() = nil error, want an error")
+			}
+		})
+	}
+}
+
+func TestSignVerifyJWT(t *testing.T) {
+	tests := []struct {
+		keyType identity.KeyType
+		alg     string
+	}{
+		{identity.KeyTypeEd25519, "EdDSA"},
+		{identity.KeyTypeP256, "ES256"},
+		{identity.KeyTypeSecp256k1, "ES256K"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			key := generateTestKey(t, tt.keyType)
+			token := signTestJWT(t, key, nil)
+
+			parsed, err := ParseJWT(token)
+			if err != nil {
+				t.Fatalf("ParseJWT: %v", err)
+			}
+			if parsed.Header["alg"] != tt.alg {
+				t.Errorf("alg = %v, want %s", parsed.Header["alg"], tt.alg)
+			}
+
+			claims, err := VerifyJWT(token, key.Public(), WithAudience("did:web:service.example.com"))
+			if err != nil {
+				t.Fatalf("VerifyJWT: %v", err)
+			}
+			if claims.Issuer != "did:plc:issuer" {
+				t.Errorf("iss = %s, want did:plc:issuer", claims.Issuer)
+			}
+
+			// A token signed by another key of the same type is rejected
+			if _, err := VerifyJWT(token, generateTestKey(t, tt.keyType).Public()); err == nil {
+				t.Error("VerifyJWT() with another key = nil error")
+			}
+		})
+	}
+}
+
+func TestVerifyJWTRejectsHighS(t *testing.T) {
+	tests := []struct {
+		keyType identity.KeyType
+		order   *big.Int
+	}{
+		{identity.KeyTypeP256, elliptic.P256().Params().N},
+		{identity.KeyTypeSecp256k1, secp256k1.S256().Params().N},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.keyType), func(t *testing.T) {
+			key := generateTestKey(t, tt.keyType)
+			token := signTestJWT(t, key, nil)
+
+			// Replace S with N-S, the other valid ECDSA encoding of the signature
+			parts := strings.Split(token, ".")
+			signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := new(big.Int).SetBytes(signature[32:])
+			s.Sub(tt.order, s)
+			s.FillBytes(signature[32:])
+			malleated := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+			if _, err := VerifyJWT(token, key.Public()); err != nil {
+				t.Fatalf("VerifyJWT(low-S token): %v", err)
+			}
+			if _, err := VerifyJWT(malleated, key.Public()); err == nil {
+				t.Error("VerifyJWT(high-S token) = nil error")
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package auth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// SigningKey is a key the auth service signs session tokens with
+type SigningKey struct {
+	ID         string
+	PrivateKey identity.PrivateKey
+	CreatedAt  time.Time
+
+	// path is the file the key is stored in, if any
+	path string
+}
+
+// KeyManager holds the keys the auth service signs session tokens with. The
+// newest key signs new tokens; older keys are kept for Retention after a newer
+// key replaced them, so that the tokens they signed verify until they expire.
+type KeyManager struct {
+	mu   sync.RWMutex
+	dir  string
+	keys []*SigningKey // oldest first
+
+	// Retention is how long a replaced key keeps verifying tokens. It must be
+	// at least the lifetime of the longest-lived token.
+	Retention time.Duration
+}
+
+// NewKeyManager creates a key manager that signs with a single key kept in
+// memory. It can rotate, but keys it rotates to are lost on restart.
+func NewKeyManager(key identity.PrivateKey) *KeyManager {
+	return &KeyManager{
+		keys: []*SigningKey{{
+			ID:         KeyID(key.Public()),
+			PrivateKey: key,
+			CreatedAt:  time.Now(),
+		}},
+		Retention: DefaultRefreshTokenTTL,
+	}
+}
+
+// LoadKeyManager creates a key manager for the ed25519 keys in dir, stored as
+// hex-encoded seeds in *.key files. The most recently modified file holds the
+// current key. If dir has no keys yet, one is generated.
+func LoadKeyManager(dir string) (*KeyManager, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	m := &KeyManager{
+		dir:       dir,
+		Retention: DefaultRefreshTokenTTL,
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	if len(m.keys) == 0 {
+		if err := m.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Reload reloads the keys from the key directory, picking up keys that were
+// added or rotated to by other auth service instances sharing it
+func (m *KeyManager) Reload() error {
+	if m.dir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(m.dir, "*.key"))
+	if err != nil {
+		return fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	keys := make([]*SigningKey, 0, len(paths))
+	for _, path := range paths {
+		key, err := loadSigningKey(path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+	})
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(keys) == 0 && len(m.keys) > 0 {
+		return fmt.Errorf("no keys left in %s", m.dir)
+	}
+	m.keys = keys
+	m.prune(time.Now())
+	return nil
+}
+
+// loadSigningKey loads an ed25519 key from a file holding its hex-encoded seed
+func loadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", path, err)
+	}
+	privateKey, err := identity.ParsePrivateKey(identity.KeyTypeEd25519, seed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %s: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat key %s: %w", path, err)
+	}
+
+	return &SigningKey{
+		ID:         KeyID(privateKey.Public()),
+		PrivateKey: privateKey,
+		CreatedAt:  info.ModTime(),
+		path:       path,
+	}, nil
+}
+
+// Current returns the key new tokens are signed with
+func (m *KeyManager) Current() *SigningKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.keys[len(m.keys)-1]
+}
+
+// PublicKey returns the public key with key ID kid
+func (m *KeyManager) PublicKey(kid string) (identity.PublicKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, key := range m.keys {
+		if key.ID == kid {
+			return key.PrivateKey.Public(), nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key ID: %s", kid)
+}
+
+// JWKS returns the public keys that tokens are verified with, newest first
+func (m *KeyManager) JWKS() JWKS {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	jwks := JWKS{Keys: make([]identity.JWK, 0, len(m.keys))}
+	for i := len(m.keys) - 1; i >= 0; i-- {
+		jwk := identity.NewJWK(m.keys[i].PrivateKey.Public())
+		jwk.Kid = m.keys[i].ID
+		jwk.Use = "sig"
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks
+}
+
+// Rotate generates a new ed25519 key and makes it the current key. With a key
+// directory, the key is written there first so that it survives restarts.
+func (m *KeyManager) Rotate() error {
+	privateKey, err := identity.GenerateKey(identity.KeyTypeEd25519)
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	key := &SigningKey{
+		ID:         KeyID(privateKey.Public()),
+		PrivateKey: privateKey,
+		CreatedAt:  time.Now(),
+	}
+
+	if m.dir != "" {
+		key.path = filepath.Join(m.dir, key.CreatedAt.UTC().Format("20060102T150405Z")+".key")
+		data := []byte(hex.EncodeToString(privateKey.Bytes()) + "\n")
+		if err := os.WriteFile(key.path, data, 0600); err != nil {
+			return fmt.Errorf("failed to write signing key: %w", err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.keys = append(m.keys, key)
+	m.prune(key.CreatedAt)
+	return nil
+}
+
+// RotateIfOlder rotates when the current key is older than maxAge
+func (m *KeyManager) RotateIfOlder(maxAge time.Duration) (bool, error) {
+	if time.Since(m.Current().CreatedAt) < maxAge {
+		return false, nil
+	}
+	return true, m.Rotate()
+}
+
+// prune forgets keys that were replaced more than Retention ago and deletes
+// their files. The current key is always kept. The caller must hold m.mu.
+func (m *KeyManager) prune(now time.Time) {
+	kept := m.keys[:0]
+	for i, key := range m.keys {
+		if i < len(m.keys)-1 && now.Sub(m.keys[i+1].CreatedAt) > m.Retention {
+			if key.path != "" {
+				os.Remove(key.path)
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	m.keys = kept
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// KeySet looks up the public keys session tokens are verified with by their key ID
+type KeySet interface {
+	PublicKey(kid string) (identity.PublicKey, error)
+}
+
+// JWKS is a JSON Web Key Set of the keys the auth service signs session tokens with
+type JWKS struct {
+	Keys []identity.JWK `json:"keys"`
+}
+
+// KeyID returns the key ID of a public key, its RFC 7638 JWK thumbprint
+func KeyID(publicKey identity.PublicKey) string {
+	return identity.NewJWK(publicKey).Thumbprint()
+}
+
+// TokenKeyID returns the key ID in a JWT's "kid" header without verifying the token
+func TokenKeyID(tokenString string) (string, error) {
+	token, err := ParseJWT(tokenString)
+	if err != nil {
+		return "", err
+	}
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return "", fmt.Errorf("token has no key ID")
+	}
+	return kid, nil
+}
+
+// StaticKeySet is a fixed set of public keys by key ID
+type StaticKeySet map[string]identity.PublicKey
+
+// NewStaticKeySet creates a key set of the given keys
+func NewStaticKeySet(keys ...identity.PublicKey) StaticKeySet {
+	set := make(StaticKeySet, len(keys))
+	for _, key := range keys {
+		set[KeyID(key)] = key
+	}
+	return set
+}
+
+// PublicKey returns the public key with key ID kid
+func (s StaticKeySet) PublicKey(kid string) (identity.PublicKey, error) {
+	key, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID: %s", kid)
+	}
+	return key, nil
+}
+
+// RemoteKeySet fetches the key set the auth service publishes and caches it.
+// An unknown key ID fetches the set again, so that keys the auth service has
+// just rotated to verify right away.
+type RemoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      StaticKeySet
+	fetchedAt time.Time
+
+	// TTL is how long a fetched key set is used before it is fetched again
+	TTL time.Duration
+	// MinRefresh limits how often unknown key IDs fetch the key set
+	MinRefresh time.Duration
+}
+
+// NewRemoteKeySet creates a key set fetched from a JWKS URL
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		url:        url,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		keys:       StaticKeySet{},
+		TTL:        5 * time.Minute,
+		MinRefresh: 30 * time.Second,
+	}
+}
+
+// PublicKey returns the public key with key ID kid
+func (s *RemoteKeySet) PublicKey(kid string) (identity.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, known := s.keys[kid]
+	age := time.Since(s.fetchedAt)
+	if (known && age < s.TTL) || (!known && age < s.MinRefresh) {
+		return s.keys.PublicKey(kid)
+	}
+
+	// Fetch the key set again, keeping the cached one if that fails
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	keys, err := FetchJWKS(ctx, s.client, s.url)
+	s.fetchedAt = time.Now()
+	if err != nil {
+		log.Printf("Failed to refresh key set: %v", err)
+		if known {
+			return key, nil
+		}
+		return nil, err
+	}
+	s.keys = keys
+	return s.keys.PublicKey(kid)
+}
+
+// FetchJWKS fetches a JSON Web Key Set and indexes its keys by key ID
+func FetchJWKS(ctx context.Context, client *http.Client, url string) (StaticKeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch key set: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch key set: unexpected status: %s", resp.Status)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	keys := make(StaticKeySet, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %s: %w", jwk.Kid, err)
+		}
+		// Key IDs are thumbprints, so a key cannot be published under another key's ID
+		if jwk.Kid != KeyID(key) {
+			return nil, fmt.Errorf("key ID %s does not match its key", jwk.Kid)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
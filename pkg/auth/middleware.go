@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// contextKey is the type of context keys set by this package
+type contextKey int
+
+const claimsContextKey contextKey = iota
+
+// AccessTokenVerifier verifies access tokens
+type AccessTokenVerifier interface {
+	VerifyAccessToken(tokenString string) (*Claims, error)
+}
+
+// resourceDPoP verifies the DPoP proofs sent with DPoP-bound access tokens
+var resourceDPoP = NewDPoPVerifier(false)
+
+// RequireAuth wraps a handler so that it only runs for requests with a valid
+// access token. DPoP-bound tokens must be sent with the DPoP scheme and a proof
+// from the bound key. The token's claims are available from the request context.
+func RequireAuth(verifier AccessTokenVerifier, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, scheme := AccessToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := verifyRequest(verifier, r, token, scheme)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", scheme+` error="invalid_token"`)
+			http.Error(w, "Invalid access token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+	}
+}
+
+// VerifyRequest verifies the access token of a request, checking the DPoP
+// proof of DPoP-bound tokens
+func VerifyRequest(verifier AccessTokenVerifier, r *http.Request) (*Claims, error) {
+	token, scheme := AccessToken(r)
+	if token == "" {
+		return nil, fmt.Errorf("missing access token")
+	}
+	return verifyRequest(verifier, r, token, scheme)
+}
+
+// verifyRequest verifies the access token of a request and, for DPoP-bound
+// tokens, the request's DPoP proof
+func verifyRequest(verifier AccessTokenVerifier, r *http.Request, token, scheme string) (*Claims, error) {
+	claims, err := verifier.VerifyAccessToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.Confirmation == nil {
+		if scheme != "Bearer" {
+			return nil, fmt.Errorf("token is not DPoP-bound")
+		}
+		return claims, nil
+	}
+
+	if scheme != "DPoP" {
+		return nil, fmt.Errorf("DPoP-bound token sent as a bearer token")
+	}
+	jkt, err := resourceDPoP.Verify(r.Header.Get("DPoP"), r.Method, RequestURL(r), token)
+	if err != nil {
+		return nil, err
+	}
+	if jkt != claims.Confirmation.JKT {
+		return nil, fmt.Errorf("DPoP proof key does not match the token")
+	}
+	return claims, nil
+}
+
+// RequireFullAccess wraps a handler so that it only runs for requests with a valid
+// full-access token. Tokens of app password sessions are rejected with 403, which
+// keeps account management out of reach of bots and third-party clients.
+func RequireFullAccess(verifier AccessTokenVerifier, next http.HandlerFunc) http.HandlerFunc {
+	return RequireAuth(verifier, func(w http.ResponseWriter, r *http.Request) {
+		claims, _ := ClaimsFromContext(r.Context())
+		if claims.Scope != ScopeAccess {
+			http.Error(w, "App passwords cannot be used for this endpoint", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	})
+}
+
+// ContextWithClaims returns a copy of ctx carrying the authenticated token claims
+func ContextWithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, claimsContextKey, claims)
+}
+
+// ClaimsFromContext returns the authenticated token claims from ctx
+func ClaimsFromContext(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsContextKey).(*Claims)
+	return claims, ok
+}
+
+// DIDFromContext returns the authenticated DID from ctx
+func DIDFromContext(ctx context.Context) (string, bool) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.Subject == "" {
+		return "", false
+	}
+	return claims.Subject, true
+}
+
+// BearerToken returns the bearer token from the request's Authorization header
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+}
+
+// AccessToken returns the token and scheme ("Bearer" or "DPoP") from the
+// request's Authorization header
+func AccessToken(r *http.Request) (string, string) {
+	header := r.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer", "DPoP"} {
+		if strings.HasPrefix(header, scheme+" ") {
+			return strings.TrimSpace(strings.TrimPrefix(header, scheme+" ")), scheme
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RevokedToken is an entry in a revocation list. ID is a session ID or a token's jti.
+type RevokedToken struct {
+	ID    string    `json:"id"`
+	Until time.Time `json:"until"`
+}
+
+// RevocationList is an in-memory set of revoked session and token IDs. Entries
+// are kept until the access tokens they revoke would have expired anyway.
+type RevocationList struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewRevocationList creates an empty revocation list
+func NewRevocationList() *RevocationList {
+	return &RevocationList{
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// Revoke adds an ID to the list until the given time
+func (l *RevocationList) Revoke(id string, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(l.revoked[id]) {
+		l.revoked[id] = until
+	}
+}
+
+// Add adds entries to the list
+func (l *RevocationList) Add(entries []RevokedToken) {
+	for _, entry := range entries {
+		l.Revoke(entry.ID, entry.Until)
+	}
+}
+
+// IsRevoked reports whether a token's session or jti has been revoked
+func (l *RevocationList) IsRevoked(claims *Claims) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if claims.SessionID != "" {
+		if _, ok := l.revoked[claims.SessionID]; ok {
+			return true
+		}
+	}
+	if claims.JWTID != "" {
+		if _, ok := l.revoked[claims.JWTID]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries returns the entries in the list
+func (l *RevocationList) Entries() []RevokedToken {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entries := make([]RevokedToken, 0, len(l.revoked))
+	for id, until := range l.revoked {
+		entries = append(entries, RevokedToken{ID: id, Until: until})
+	}
+	return entries
+}
+
+// Prune removes entries whose tokens have expired
+func (l *RevocationList) Prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	for id, until := range l.revoked {
+		if until.Before(now) {
+			delete(l.revoked, id)
+		}
+	}
+}
+
+// FetchRevocations fetches the revocation list published by the auth service
+func FetchRevocations(ctx context.Context, client *http.Client, url string) ([]RevokedToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch revocations: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch revocations: unexpected status: %s", resp.Status)
+	}
+
+	var body struct {
+		Revoked []RevokedToken `json:"revoked"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid revocation list: %w", err)
+	}
+	return body.Revoked, nil
+}
+
+// SyncRevocations polls the auth service's revocation list into list until ctx is done
+func SyncRevocations(ctx context.Context, list *RevocationList, url string, interval time.Duration) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		entries, err := FetchRevocations(ctx, client, url)
+		if err != nil {
+			log.Printf("Failed to sync revocation list: %v", err)
+		} else {
+			list.Add(entries)
+		}
+		list.Prune()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// DefaultServiceTokenTTL is the lifetime of service-auth tokens
+const DefaultServiceTokenTTL = time.Minute
+
+// Lexicon methods that service tokens are scoped to. The services that mint
+// and check the tokens share these so that the two cannot drift apart.
+const (
+	LxmCreateAccount = "com.atproto.server.createAccount"
+	LxmImportRepo    = "com.atproto.repo.importRepo"
+	LxmGetRepo       = "com.atproto.sync.getRepo"
+	LxmCreateRecord  = "com.atproto.repo.createRecord"
+	LxmDeleteRecord  = "com.atproto.repo.deleteRecord"
+)
+
+// NewServiceToken creates a short-lived token, signed with the account's DID
+// key, that authorizes the audience to act on the account's behalf
+func NewServiceToken(did, audience, lexiconMethod string, signingKey identity.PrivateKey, expiresIn time.Duration) (string, error) {
+	token := NewJWT(did, did, expiresIn)
+	token.Claims.Audience = audience
+	token.Claims.LexiconMethod = lexiconMethod
+	token.Claims.JWTID = uuid.New().String()
+	return token.Sign(signingKey)
+}
+
+// VerifyServiceToken verifies a service token against the issuer's DID document.
+// Callers should require an audience with WithAudience. If the signature does not
+// match a cached key, the DID is resolved again in case the key was rotated.
+func VerifyServiceToken(ctx context.Context, tokenString string, resolver identity.Resolver, opts ...VerifyOption) (*Claims, error) {
+	token, err := ParseJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// Resolve issuer's signing key
+	issuer, err := resolver.Resolve(ctx, token.Claims.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve token issuer: %w", err)
+	}
+
+	claims, err := VerifyJWT(tokenString, issuer.PublicKey, opts...)
+	if err != nil {
+		// Retry once with a fresh DID document
+		cache, ok := resolver.(interface{ Invalidate(did string) })
+		if !ok {
+			return nil, err
+		}
+		cache.Invalidate(token.Claims.Issuer)
+
+		fresh, resolveErr := resolver.Resolve(ctx, token.Claims.Issuer)
+		if resolveErr != nil || fresh.PublicKey.Equal(issuer.PublicKey) {
+			return nil, err
+		}
+		if claims, err = VerifyJWT(tokenString, fresh.PublicKey, opts...); err != nil {
+			return nil, err
+		}
+	}
+
+	// Service tokens act for their issuer
+	if claims.Subject == "" {
+		claims.Subject = claims.Issuer
+	}
+
+	return claims, nil
+}
+
+// RequireServiceAuth wraps a handler so that it accepts service-auth tokens
+// addressed to audience and scoped to lexiconMethod, in addition to session
+// access tokens. Service tokens are verified through DID resolution.
+func RequireServiceAuth(verifier AccessTokenVerifier, resolver identity.Resolver, audience, lexiconMethod string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, scheme := AccessToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		// Try a session token first, then a service token
+		claims, err := verifyRequest(verifier, r, token, scheme)
+		if err != nil && scheme == "Bearer" {
+			claims, err = VerifyServiceToken(r.Context(), token, resolver, WithAudience(audience), WithLexiconMethod(lexiconMethod))
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, "Invalid access token", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r.WithContext(ContextWithClaims(r.Context(), claims)))
+	}
+}
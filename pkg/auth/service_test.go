@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+const (
+	testServiceDID = "did:plc:alice"
+	testAudience   = "did:web:service.example.com"
+)
+
+// fakeResolver resolves DIDs to documents with the signing keys in keys
+type fakeResolver struct {
+	mu       sync.Mutex
+	keys     map[string]identity.PublicKey
+	resolved int
+}
+
+func newFakeResolver(did string, key identity.PublicKey) *fakeResolver {
+	return &fakeResolver{keys: map[string]identity.PublicKey{did: key}}
+}
+
+func (r *fakeResolver) setKey(did string, key identity.PublicKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[did] = key
+}
+
+func (r *fakeResolver) ResolveDocument(ctx context.Context, did string) (*identity.Document, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolved++
+	key, ok := r.keys[did]
+	if !ok {
+		return nil, fmt.Errorf("DID not found")
+	}
+	return identity.NewDocument(did, key, nil, ""), nil
+}
+
+func (r *fakeResolver) Resolve(ctx context.Context, did string) (*identity.DID, error) {
+	doc, err := r.ResolveDocument(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	return doc.DID()
+}
+
+func TestVerifyServiceToken(t *testing.T) {
+	ctx := context.Background()
+	key := generateTestKey(t, identity.KeyTypeSecp256k1)
+	other := generateTestKey(t, identity.KeyTypeSecp256k1)
+	resolver := newFakeResolver(testServiceDID, key.Public())
+
+	sign := func(t *testing.T, key identity.PrivateKey, audience, lxm string) string {
+		token, err := NewServiceToken(testServiceDID, audience, lxm, key, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr bool
+	}{
+		{"valid", sign(t, key, testAudience, LxmCreateRecord), false},
+		{"wrong aud", sign(t, key, "did:web:other.example.com", LxmCreateRecord), true},
+		{"missing lxm", sign(t, key, testAudience, ""), true},
+		{"mismatched lxm", sign(t, key, testAudience, LxmCreateAccount), true},
+		{"signed by another key", sign(t, other, testAudience, LxmCreateRecord), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyServiceToken(ctx, tt.token, resolver, WithAudience(testAudience), WithLexiconMethod(LxmCreateRecord))
+			if tt.wantErr {
+				if err == nil {
+					t.Error("VerifyServiceToken() = nil error, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("VerifyServiceToken() = %v", err)
+			}
+			if claims.Subject != testServiceDID {
+				t.Errorf("Subject = %q, want the issuer %q", claims.Subject, testServiceDID)
+			}
+		})
+	}
+}
+
+func TestVerifyServiceTokenAfterKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	oldKey := generateTestKey(t, identity.KeyTypeP256)
+	newKey := generateTestKey(t, identity.KeyTypeP256)
+	upstream := newFakeResolver(testServiceDID, oldKey.Public())
+	cache := identity.NewCachingResolver(upstream, time.Hour, time.Minute, time.Hour)
+
+	// Cache the document with the old key
+	if _, err := cache.Resolve(ctx, testServiceDID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The account rotates its signing key and signs with the new one
+	upstream.setKey(testServiceDID, newKey.Public())
+	token, err := NewServiceToken(testServiceDID, testAudience, LxmCreateRecord, newKey, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a cache to invalidate, the stale key is all there is
+	stale := newFakeResolver(testServiceDID, oldKey.Public())
+	if _, err := VerifyServiceToken(ctx, token, stale, WithAudience(testAudience)); err == nil {
+		t.Error("VerifyServiceToken() with a stale resolver = nil error")
+	}
+
+	// The caching resolver is invalidated and the DID resolved again
+	if _, err := VerifyServiceToken(ctx, token, cache, WithAudience(testAudience)); err != nil {
+		t.Fatalf("VerifyServiceToken() after rotation = %v", err)
+	}
+	if upstream.resolved != 2 {
+		t.Errorf("DID resolved %d times, want 2", upstream.resolved)
+	}
+
+	// Tokens signed by the old key no longer verify
+	oldToken, err := NewServiceToken(testServiceDID, testAudience, LxmCreateRecord, oldKey, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyServiceToken(ctx, oldToken, cache, WithAudience(testAudience)); err == nil {
+		t.Error("VerifyServiceToken() with the rotated out key = nil error")
+	}
+}
+
+func TestRequireServiceAuth(t *testing.T) {
+	sessionKey := generateTestKey(t, identity.KeyTypeEd25519)
+	accountKey := generateTestKey(t, identity.KeyTypeSecp256k1)
+	verifier := NewSessionVerifier(testIssuer, NewStaticKeySet(sessionKey.Public()))
+	resolver := newFakeResolver(testServiceDID, accountKey.Public())
+
+	handler := RequireServiceAuth(verifier, resolver, testAudience, LxmCreateRecord, func(w http.ResponseWriter, r *http.Request) {
+		did, _ := DIDFromContext(r.Context())
+		fmt.Fprint(w, did)
+	})
+
+	serviceToken := func(audience, lxm string) string {
+		token, err := NewServiceToken(testServiceDID, audience, lxm, accountKey, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	session := NewJWT(testIssuer, "did:plc:bob", time.Minute)
+	session.Claims.Audience = testIssuer
+	session.Claims.Scope = ScopeAccess
+	session.Header["kid"] = KeyID(sessionKey.Public())
+	sessionToken, err := session.Sign(sessionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		status  int
+		wantDID string
+	}{
+		{"session token", sessionToken, http.StatusOK, "did:plc:bob"},
+		{"service token", serviceToken(testAudience, LxmCreateRecord), http.StatusOK, testServiceDID},
+		{"service token for another audience", serviceToken("did:web:other.example.com", LxmCreateRecord), http.StatusUnauthorized, ""},
+		{"service token for another method", serviceToken(testAudience, LxmDeleteRecord), http.StatusUnauthorized, ""},
+		{"no token", "", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/follow", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if tt.wantDID != "" && rec.Body.String() != tt.wantDID {
+				t.Errorf("authenticated DID = %q, want %q", rec.Body.String(), tt.wantDID)
+			}
+		})
+	}
+}
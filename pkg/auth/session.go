@@ -0,0 +1,430 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// Token scopes
+const (
+	ScopeAccess  = "com.atproto.access"
+	ScopeAppPass = "com.atproto.appPass"
+	ScopeRefresh = "com.atproto.refresh"
+)
+
+// ScopeATProto is the OAuth scope every atproto client must request. OAuth
+// access tokens carry the granted scope string instead of a session scope.
+const ScopeATProto = "atproto"
+
+// Default session token lifetimes
+const (
+	DefaultAccessTokenTTL  = 2 * time.Hour
+	DefaultRefreshTokenTTL = 90 * 24 * time.Hour
+)
+
+// SessionTokens is a pair of access and refresh tokens
+type SessionTokens struct {
+	AccessJwt  string `json:"accessJwt"`
+	RefreshJwt string `json:"refreshJwt"`
+}
+
+// issuedToken is a signed token with the claims it was signed with
+type issuedToken struct {
+	token  string
+	claims Claims
+}
+
+// SessionVerifier verifies session tokens issued by a SessionManager
+type SessionVerifier struct {
+	issuer      string
+	keys        KeySet
+	revocations *RevocationList
+}
+
+// NewSessionVerifier creates a verifier for session tokens from issuer signed
+// with the keys in a key set
+func NewSessionVerifier(issuer string, keys KeySet) *SessionVerifier {
+	return &SessionVerifier{
+		issuer: issuer,
+		keys:   keys,
+	}
+}
+
+// LoadSessionVerifier creates a session verifier from JWT_ISSUER and either the
+// key set published at JWT_JWKS_URL or the single did:key in JWT_PUBLIC_KEY
+func LoadSessionVerifier() (*SessionVerifier, error) {
+	issuer := os.Getenv("JWT_ISSUER")
+	if issuer == "" {
+		return nil, fmt.Errorf("JWT_ISSUER environment variable is not set")
+	}
+
+	if jwksURL := os.Getenv("JWT_JWKS_URL"); jwksURL != "" {
+		return NewSessionVerifier(issuer, NewRemoteKeySet(jwksURL)), nil
+	}
+
+	keyDID, err := identity.ParseKeyDID(os.Getenv("JWT_PUBLIC_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWT_PUBLIC_KEY: %w", err)
+	}
+
+	return NewSessionVerifier(issuer, NewStaticKeySet(keyDID.PublicKey)), nil
+}
+
+// SetRevocationList makes access token checks reject tokens on the list
+func (v *SessionVerifier) SetRevocationList(list *RevocationList) {
+	v.revocations = list
+}
+
+// VerifyAccessToken verifies an access token and returns its claims. Tokens of
+// app password sessions are accepted with the restricted ScopeAppPass scope and
+// OAuth tokens with the atproto scope. OAuth tokens are always DPoP-bound; the
+// caller must check the proof.
+func (v *SessionVerifier) VerifyAccessToken(tokenString string) (*Claims, error) {
+	claims, err := v.verify(tokenString, isAccessToken)
+	if err != nil {
+		return nil, err
+	}
+	if v.revocations != nil && v.revocations.IsRevoked(claims) {
+		return nil, fmt.Errorf("token revoked")
+	}
+	return claims, nil
+}
+
+// VerifyRefreshToken verifies a refresh token and returns its claims
+func (v *SessionVerifier) VerifyRefreshToken(tokenString string) (*Claims, error) {
+	return v.verify(tokenString, func(claims *Claims) bool {
+		return claims.Scope == ScopeRefresh
+	})
+}
+
+// verify verifies a session token and checks its scope with validScope
+func (v *SessionVerifier) verify(tokenString string, validScope func(*Claims) bool) (*Claims, error) {
+	claims, err := v.verifyJWT(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !validScope(claims) {
+		return nil, fmt.Errorf("invalid token scope: %s", claims.Scope)
+	}
+	return claims, nil
+}
+
+// verifyJWT verifies a token from the issuer with the key named by its "kid" header
+func (v *SessionVerifier) verifyJWT(tokenString string) (*Claims, error) {
+	kid, err := TokenKeyID(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := v.keys.PublicKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	return VerifyJWT(tokenString, publicKey, WithIssuers(v.issuer), WithAudience(v.issuer))
+}
+
+// isAccessToken reports whether claims have an access token scope
+func isAccessToken(claims *Claims) bool {
+	switch claims.Scope {
+	case ScopeAccess, ScopeAppPass:
+		return claims.ClientID == ""
+	case ScopeRefresh:
+		return false
+	}
+	return claims.ClientID != "" && claims.Confirmation != nil && HasScope(claims.Scope, ScopeATProto)
+}
+
+// HasScope reports whether a space-separated scope string contains scope
+func HasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// SessionManager issues session tokens signed with the current server key and
+// tracks their sessions in a store
+type SessionManager struct {
+	*SessionVerifier
+	signingKeys *KeyManager
+	store       SessionStore
+	AccessTTL   time.Duration
+	RefreshTTL  time.Duration
+}
+
+// NewSessionManager creates a new session manager
+func NewSessionManager(issuer string, signingKeys *KeyManager, store SessionStore) *SessionManager {
+	verifier := NewSessionVerifier(issuer, signingKeys)
+	verifier.SetRevocationList(NewRevocationList())
+	return &SessionManager{
+		SessionVerifier: verifier,
+		signingKeys:     signingKeys,
+		store:           store,
+		AccessTTL:       DefaultAccessTokenTTL,
+		RefreshTTL:      DefaultRefreshTokenTTL,
+	}
+}
+
+// JWKS returns the key set session tokens are verified with
+func (m *SessionManager) JWKS() JWKS {
+	return m.signingKeys.JWKS()
+}
+
+// CreateSession starts a new full-access session for a DID and issues its first token pair
+func (m *SessionManager) CreateSession(ctx context.Context, did string) (*SessionTokens, error) {
+	return m.createSession(ctx, &Session{
+		DID:   did,
+		Scope: ScopeAccess,
+	})
+}
+
+// CreateAppPasswordSession starts a session for a DID that logged in with an app password.
+// Its access tokens carry the restricted ScopeAppPass scope.
+func (m *SessionManager) CreateAppPasswordSession(ctx context.Context, did, appPasswordName string) (*SessionTokens, error) {
+	return m.createSession(ctx, &Session{
+		DID:             did,
+		Scope:           ScopeAppPass,
+		AppPasswordName: appPasswordName,
+	})
+}
+
+// CreateOAuthSession starts a session for an OAuth client. Its tokens carry the
+// granted scope and are bound to the client's DPoP key.
+func (m *SessionManager) CreateOAuthSession(ctx context.Context, did, clientID, scope, dpopJKT string) (*SessionTokens, error) {
+	return m.createSession(ctx, &Session{
+		DID:      did,
+		Scope:    scope,
+		ClientID: clientID,
+		DPoPJKT:  dpopJKT,
+	})
+}
+
+// createSession stores a new session and issues its first token pair
+func (m *SessionManager) createSession(ctx context.Context, session *Session) (*SessionTokens, error) {
+	session.ID = uuid.New().String()
+	session.RefreshJTI = uuid.New().String()
+	access, refresh, err := m.issueTokens(session, session.RefreshJTI)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store session
+	session.ExpiresAt = time.Unix(refresh.claims.ExpiresAt, 0)
+	if err := m.store.CreateSession(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return &SessionTokens{
+		AccessJwt:  access.token,
+		RefreshJwt: refresh.token,
+	}, nil
+}
+
+// RefreshSession exchanges a refresh token for a new token pair. Each refresh token
+// can only be used once; reusing one revokes its session and returns the token's
+// claims with ErrRefreshTokenReused.
+func (m *SessionManager) RefreshSession(ctx context.Context, refreshToken string) (*Claims, *SessionTokens, error) {
+	claims, err := m.VerifyRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.ClientID != "" {
+		return nil, nil, fmt.Errorf("OAuth refresh tokens must be used at the token endpoint")
+	}
+
+	_, tokens, err := m.rotate(ctx, claims)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		return claims, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return claims, tokens, nil
+}
+
+// RefreshOAuthSession exchanges an OAuth refresh token for a new token pair. The
+// token must have been issued to clientID and bound to the DPoP key dpopJKT.
+func (m *SessionManager) RefreshOAuthSession(ctx context.Context, refreshToken, clientID, dpopJKT string) (*Session, *SessionTokens, error) {
+	claims, err := m.VerifyRefreshToken(refreshToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.ClientID == "" || claims.ClientID != clientID {
+		return nil, nil, fmt.Errorf("refresh token was not issued to %s", clientID)
+	}
+	if claims.Confirmation == nil || claims.Confirmation.JKT != dpopJKT {
+		return nil, nil, fmt.Errorf("refresh token is bound to a different DPoP key")
+	}
+
+	return m.rotate(ctx, claims)
+}
+
+// rotate rotates the refresh token of a verified refresh token's session and
+// issues a new token pair
+func (m *SessionManager) rotate(ctx context.Context, claims *Claims) (*Session, *SessionTokens, error) {
+	if claims.SessionID == "" || claims.JWTID == "" {
+		return nil, nil, ErrSessionNotFound
+	}
+
+	// Rotate refresh token
+	refreshJTI := uuid.New().String()
+	session, err := m.store.RotateRefreshToken(ctx, claims.SessionID, claims.JWTID, refreshJTI, time.Now().Add(m.RefreshTTL))
+	if errors.Is(err, ErrRefreshTokenReused) {
+		m.revoke(claims.SessionID, time.Now())
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := m.issueTokens(session, refreshJTI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return session, &SessionTokens{
+		AccessJwt:  access.token,
+		RefreshJwt: refresh.token,
+	}, nil
+}
+
+// DeleteSession revokes the session of a refresh token
+func (m *SessionManager) DeleteSession(ctx context.Context, refreshToken string) error {
+	claims, err := m.VerifyRefreshToken(refreshToken)
+	if err != nil {
+		return err
+	}
+	if claims.SessionID == "" {
+		return ErrSessionNotFound
+	}
+
+	if err := m.store.RevokeSession(ctx, claims.SessionID); err != nil {
+		return err
+	}
+	m.revoke(claims.SessionID, time.Now())
+	return nil
+}
+
+// RevokeToken revokes the session of an access or refresh token issued to an
+// OAuth client (RFC 7009)
+func (m *SessionManager) RevokeToken(ctx context.Context, token, clientID string) error {
+	claims, err := m.verifyJWT(token)
+	if err != nil {
+		return err
+	}
+	if claims.ClientID == "" || claims.ClientID != clientID {
+		return fmt.Errorf("token was not issued to %s", clientID)
+	}
+	if claims.SessionID == "" {
+		return ErrSessionNotFound
+	}
+
+	if err := m.store.RevokeSession(ctx, claims.SessionID); err != nil {
+		return err
+	}
+	m.revoke(claims.SessionID, time.Now())
+	return nil
+}
+
+// RevokeAllSessions revokes every session of an account
+func (m *SessionManager) RevokeAllSessions(ctx context.Context, did string) error {
+	ids, err := m.store.RevokeAllSessions(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		m.revoke(id, now)
+	}
+	return nil
+}
+
+// RevokeAppPasswordSessions revokes the sessions created with an app password
+func (m *SessionManager) RevokeAppPasswordSessions(ctx context.Context, did, appPasswordName string) error {
+	ids, err := m.store.RevokeAppPasswordSessions(ctx, did, appPasswordName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		m.revoke(id, now)
+	}
+	return nil
+}
+
+// RevokedTokens returns the sessions revoked recently enough that their access tokens may not have expired
+func (m *SessionManager) RevokedTokens(ctx context.Context) ([]RevokedToken, error) {
+	revoked, err := m.store.ListRevokedSince(ctx, time.Now().Add(-m.AccessTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]RevokedToken, 0, len(revoked))
+	for id, revokedAt := range revoked {
+		entries = append(entries, RevokedToken{ID: id, Until: revokedAt.Add(m.AccessTTL)})
+	}
+	return entries, nil
+}
+
+// LoadRevocations adds recently revoked sessions from the store to the local revocation list
+func (m *SessionManager) LoadRevocations(ctx context.Context) error {
+	entries, err := m.RevokedTokens(ctx)
+	if err != nil {
+		return err
+	}
+	m.revocations.Add(entries)
+	m.revocations.Prune()
+	return nil
+}
+
+// revoke adds a session revoked at the given time to the local revocation list
+func (m *SessionManager) revoke(sessionID string, revokedAt time.Time) {
+	m.revocations.Revoke(sessionID, revokedAt.Add(m.AccessTTL))
+}
+
+// issueTokens signs an access token with the session's scope and a refresh token with refreshJTI
+func (m *SessionManager) issueTokens(session *Session, refreshJTI string) (*issuedToken, *issuedToken, error) {
+	access, err := m.newToken(session, session.Scope, uuid.New().String(), m.AccessTTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	refresh, err := m.newToken(session, ScopeRefresh, refreshJTI, m.RefreshTTL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign refresh token: %w", err)
+	}
+
+	return access, refresh, nil
+}
+
+// newToken signs a token for a session with the given scope. Tokens of OAuth
+// sessions carry the client ID and are bound to the client's DPoP key.
+func (m *SessionManager) newToken(session *Session, scope, jti string, expiresIn time.Duration) (*issuedToken, error) {
+	token := NewJWT(m.issuer, session.DID, expiresIn)
+	token.Claims.Audience = m.issuer
+	token.Claims.JWTID = jti
+	token.Claims.SessionID = session.ID
+	token.Claims.Scope = scope
+	token.Claims.ClientID = session.ClientID
+	if session.DPoPJKT != "" {
+		token.Claims.Confirmation = &Confirmation{JKT: session.DPoPJKT}
+	}
+
+	signingKey := m.signingKeys.Current()
+	token.Header["kid"] = signingKey.ID
+	signed, err := token.Sign(signingKey.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &issuedToken{token: signed, claims: token.Claims}, nil
+}
@@ -0,0 +1,320 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Session store errors
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrSessionRevoked      = errors.New("session revoked")
+	ErrRefreshTokenReused  = errors.New("refresh token reused")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+)
+
+// Session represents a login session, identified by the refresh token family it issues
+type Session struct {
+	ID              string     `json:"id"`
+	DID             string     `json:"did"`
+	Scope           string     `json:"scope"`
+	AppPasswordName string     `json:"appPasswordName,omitempty"`
+	ClientID        string     `json:"clientId,omitempty"`
+	DPoPJKT         string     `json:"-"`
+	RefreshJTI      string     `json:"-"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	RefreshedAt     time.Time  `json:"refreshedAt"`
+	ExpiresAt       time.Time  `json:"expiresAt"`
+	RevokedAt       *time.Time `json:"revokedAt,omitempty"`
+}
+
+// checkRefresh returns why the session's refresh token jti cannot be rotated
+// at now, or nil if it can. ErrRefreshTokenReused means that jti was already
+// rotated out, so it was stolen or replayed and the session must be revoked.
+func (s *Session) checkRefresh(jti string, now time.Time) error {
+	if s.RevokedAt != nil {
+		return ErrSessionRevoked
+	}
+	if s.RefreshJTI != jti {
+		return ErrRefreshTokenReused
+	}
+	if s.ExpiresAt.Before(now) {
+		return ErrRefreshTokenExpired
+	}
+	return nil
+}
+
+// SessionStore tracks sessions and their refresh tokens
+type SessionStore interface {
+	// CreateSession stores a new session
+	CreateSession(ctx context.Context, session *Session) error
+	// RotateRefreshToken replaces a session's current refresh token oldJTI with
+	// newJTI. Presenting a refresh token that was already rotated out revokes the
+	// whole session and returns ErrRefreshTokenReused.
+	RotateRefreshToken(ctx context.Context, sessionID, oldJTI, newJTI string, expiresAt time.Time) (*Session, error)
+	// RevokeSession revokes a session
+	RevokeSession(ctx context.Context, sessionID string) error
+	// RevokeAppPasswordSessions revokes the active sessions created with an app password and returns their IDs
+	RevokeAppPasswordSessions(ctx context.Context, did, appPasswordName string) ([]string, error)
+	// RevokeAllSessions revokes every active session of an account and returns their IDs
+	RevokeAllSessions(ctx context.Context, did string) ([]string, error)
+	// ListSessions lists the active sessions of an account
+	ListSessions(ctx context.Context, did string) ([]*Session, error)
+	// ListRevokedSince lists the IDs and revocation times of sessions revoked after since
+	ListRevokedSince(ctx context.Context, since time.Time) (map[string]time.Time, error)
+	// DeleteExpiredSessions deletes sessions whose refresh tokens expired before cutoff
+	DeleteExpiredSessions(ctx context.Context, cutoff time.Time) (int64, error)
+}
+
+// PostgresSessionStore keeps sessions in the sessions table
+type PostgresSessionStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresSessionStore creates a new Postgres session store
+func NewPostgresSessionStore(db *pgxpool.Pool) *PostgresSessionStore {
+	return &PostgresSessionStore{
+		db: db,
+	}
+}
+
+// CreateSession stores a new session
+func (s *PostgresSessionStore) CreateSession(ctx context.Context, session *Session) error {
+	query := `
+		INSERT INTO sessions (id, did, scope, app_password_name, client_id, dpop_jkt, refresh_jti, created_at, refreshed_at, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7, $8, $9, $10)
+	`
+	now := time.Now()
+	_, err := s.db.Exec(ctx, query,
+		session.ID,
+		session.DID,
+		session.Scope,
+		session.AppPasswordName,
+		session.ClientID,
+		session.DPoPJKT,
+		session.RefreshJTI,
+		now,
+		now,
+		session.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	session.CreatedAt = now
+	session.RefreshedAt = now
+	return nil
+}
+
+// RotateRefreshToken replaces a session's current refresh token with a new one. Presenting a
+// refresh token that was already rotated out revokes the whole session and returns
+// ErrRefreshTokenReused.
+func (s *PostgresSessionStore) RotateRefreshToken(ctx context.Context, sessionID, oldJTI, newJTI string, expiresAt time.Time) (*Session, error) {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock session
+	query := `
+		SELECT id, did, scope, COALESCE(app_password_name, ''), COALESCE(client_id, ''), COALESCE(dpop_jkt, ''), refresh_jti, created_at, refreshed_at, expires_at, revoked_at
+		FROM sessions
+		WHERE id = $1
+		FOR UPDATE
+	`
+	var session Session
+	err = tx.QueryRow(ctx, query, sessionID).Scan(
+		&session.ID,
+		&session.DID,
+		&session.Scope,
+		&session.AppPasswordName,
+		&session.ClientID,
+		&session.DPoPJKT,
+		&session.RefreshJTI,
+		&session.CreatedAt,
+		&session.RefreshedAt,
+		&session.ExpiresAt,
+		&session.RevokedAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+
+	now := time.Now()
+	err = session.checkRefresh(oldJTI, now)
+
+	// An old refresh token means it was stolen or replayed, so revoke the session family
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if _, err := tx.Exec(ctx, `UPDATE sessions SET revoked_at = $1 WHERE id = $2`, now, sessionID); err != nil {
+			return nil, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("failed to revoke session: %w", err)
+		}
+		return nil, ErrRefreshTokenReused
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Rotate token
+	query = `
+		UPDATE sessions
+		SET refresh_jti = $1, refreshed_at = $2, expires_at = $3
+		WHERE id = $4
+	`
+	if _, err := tx.Exec(ctx, query, newJTI, now, expiresAt, sessionID); err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	session.RefreshJTI = newJTI
+	session.RefreshedAt = now
+	session.ExpiresAt = expiresAt
+	return &session, nil
+}
+
+// RevokeSession revokes a session
+func (s *PostgresSessionStore) RevokeSession(ctx context.Context, sessionID string) error {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $1
+		WHERE id = $2 AND revoked_at IS NULL
+	`
+	if _, err := s.db.Exec(ctx, query, time.Now(), sessionID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return nil
+}
+
+// RevokeAppPasswordSessions revokes the active sessions created with an app password and returns their IDs
+func (s *PostgresSessionStore) RevokeAppPasswordSessions(ctx context.Context, did, appPasswordName string) ([]string, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $1
+		WHERE did = $2 AND app_password_name = $3 AND revoked_at IS NULL
+		RETURNING id
+	`
+	return s.revokeSessions(ctx, query, time.Now(), did, appPasswordName)
+}
+
+// RevokeAllSessions revokes every active session of an account and returns their IDs
+func (s *PostgresSessionStore) RevokeAllSessions(ctx context.Context, did string) ([]string, error) {
+	query := `
+		UPDATE sessions
+		SET revoked_at = $1
+		WHERE did = $2 AND revoked_at IS NULL
+		RETURNING id
+	`
+	return s.revokeSessions(ctx, query, time.Now(), did)
+}
+
+// revokeSessions runs a revoking update that returns the IDs of the revoked sessions
+func (s *PostgresSessionStore) revokeSessions(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	return ids, nil
+}
+
+// ListSessions lists the active sessions of an account
+func (s *PostgresSessionStore) ListSessions(ctx context.Context, did string) ([]*Session, error) {
+	query := `
+		SELECT id, did, scope, COALESCE(app_password_name, ''), COALESCE(client_id, ''), COALESCE(dpop_jkt, ''), refresh_jti, created_at, refreshed_at, expires_at, revoked_at
+		FROM sessions
+		WHERE did = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY created_at DESC
+	`
+	rows, err := s.db.Query(ctx, query, did, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(
+			&session.ID,
+			&session.DID,
+			&session.Scope,
+			&session.AppPasswordName,
+			&session.ClientID,
+			&session.DPoPJKT,
+			&session.RefreshJTI,
+			&session.CreatedAt,
+			&session.RefreshedAt,
+			&session.ExpiresAt,
+			&session.RevokedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		sessions = append(sessions, &session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// ListRevokedSince lists the IDs and revocation times of sessions revoked after since
+func (s *PostgresSessionStore) ListRevokedSince(ctx context.Context, since time.Time) (map[string]time.Time, error) {
+	query := `
+		SELECT id, revoked_at
+		FROM sessions
+		WHERE revoked_at > $1
+	`
+	rows, err := s.db.Query(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list revoked sessions: %w", err)
+	}
+	defer rows.Close()
+
+	revoked := make(map[string]time.Time)
+	for rows.Next() {
+		var id string
+		var revokedAt time.Time
+		if err := rows.Scan(&id, &revokedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan session: %w", err)
+		}
+		revoked[id] = revokedAt
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list revoked sessions: %w", err)
+	}
+	return revoked, nil
+}
+
+// DeleteExpiredSessions deletes sessions whose refresh tokens expired before cutoff
+func (s *PostgresSessionStore) DeleteExpiredSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE expires_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
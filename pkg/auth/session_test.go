@@ -0,0 +1,318 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+const testIssuer = "https://auth.example.com"
+
+// fakeSessionStore keeps sessions in memory and rotates refresh tokens with
+// the same checks as the Postgres store
+type fakeSessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func newFakeSessionStore() *fakeSessionStore {
+	return &fakeSessionStore{sessions: make(map[string]*Session)}
+}
+
+func (s *fakeSessionStore) CreateSession(ctx context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *session
+	stored.CreatedAt = time.Now()
+	stored.RefreshedAt = stored.CreatedAt
+	s.sessions[session.ID] = &stored
+	return nil
+}
+
+func (s *fakeSessionStore) RotateRefreshToken(ctx context.Context, sessionID, oldJTI, newJTI string, expiresAt time.Time) (*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[sessionID]
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	now := time.Now()
+	err := session.checkRefresh(oldJTI, now)
+	if errors.Is(err, ErrRefreshTokenReused) {
+		session.RevokedAt = &now
+	}
+	if err != nil {
+		return nil, err
+	}
+	session.RefreshJTI = newJTI
+	session.RefreshedAt = now
+	session.ExpiresAt = expiresAt
+	rotated := *session
+	return &rotated, nil
+}
+
+func (s *fakeSessionStore) RevokeSession(ctx context.Context, sessionID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if session, ok := s.sessions[sessionID]; ok && session.RevokedAt == nil {
+		now := time.Now()
+		session.RevokedAt = &now
+	}
+	return nil
+}
+
+func (s *fakeSessionStore) revokeWhere(match func(*Session) bool) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var ids []string
+	for id, session := range s.sessions {
+		if session.RevokedAt == nil && match(session) {
+			session.RevokedAt = &now
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (s *fakeSessionStore) RevokeAppPasswordSessions(ctx context.Context, did, appPasswordName string) ([]string, error) {
+	return s.revokeWhere(func(session *Session) bool {
+		return session.DID == did && session.AppPasswordName == appPasswordName
+	}), nil
+}
+
+func (s *fakeSessionStore) RevokeAllSessions(ctx context.Context, did string) ([]string, error) {
+	return s.revokeWhere(func(session *Session) bool {
+		return session.DID == did
+	}), nil
+}
+
+func (s *fakeSessionStore) ListSessions(ctx context.Context, did string) ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var sessions []*Session
+	for _, session := range s.sessions {
+		if session.DID == did && session.RevokedAt == nil && session.ExpiresAt.After(time.Now()) {
+			listed := *session
+			sessions = append(sessions, &listed)
+		}
+	}
+	return sessions, nil
+}
+
+func (s *fakeSessionStore) ListRevokedSince(ctx context.Context, since time.Time) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revoked := make(map[string]time.Time)
+	for id, session := range s.sessions {
+		if session.RevokedAt != nil && session.RevokedAt.After(since) {
+			revoked[id] = *session.RevokedAt
+		}
+	}
+	return revoked, nil
+}
+
+func (s *fakeSessionStore) DeleteExpiredSessions(ctx context.Context, cutoff time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted int64
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(cutoff) {
+			delete(s.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func newTestSessionManager(t *testing.T) (*SessionManager, *fakeSessionStore) {
+	t.Helper()
+	key, err := identity.GenerateKey(identity.KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newFakeSessionStore()
+	return NewSessionManager(testIssuer, NewKeyManager(key), store), store
+}
+
+func TestRefreshSessionRotatesRefreshToken(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestSessionManager(t)
+
+	first, err := m.CreateSession(ctx, "did:plc:alice")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	_, second, err := m.RefreshSession(ctx, first.RefreshJwt)
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+	if second.RefreshJwt == first.RefreshJwt {
+		t.Fatal("refresh token was not rotated")
+	}
+
+	// The new pair works
+	if _, err := m.VerifyAccessToken(second.AccessJwt); err != nil {
+		t.Errorf("VerifyAccessToken(new access token): %v", err)
+	}
+	if _, _, err := m.RefreshSession(ctx, second.RefreshJwt); err != nil {
+		t.Errorf("RefreshSession(new refresh token): %v", err)
+	}
+}
+
+func TestRefreshSessionReuseRevokesSession(t *testing.T) {
+	ctx := context.Background()
+	m, store := newTestSessionManager(t)
+
+	first, err := m.CreateSession(ctx, "did:plc:alice")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	claims, second, err := m.RefreshSession(ctx, first.RefreshJwt)
+	if err != nil {
+		t.Fatalf("RefreshSession: %v", err)
+	}
+
+	// Rotating with the same refresh token again revokes the session
+	if _, _, err := m.RefreshSession(ctx, first.RefreshJwt); !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("RefreshSession(reused token) = %v, want ErrRefreshTokenReused", err)
+	}
+	if store.sessions[claims.SessionID].RevokedAt == nil {
+		t.Error("session was not revoked in the store")
+	}
+
+	// The whole family is revoked, including the pair issued by the rotation
+	if _, _, err := m.RefreshSession(ctx, second.RefreshJwt); !errors.Is(err, ErrSessionRevoked) {
+		t.Errorf("RefreshSession(latest token) = %v, want ErrSessionRevoked", err)
+	}
+	for name, token := range map[string]string{"first": first.AccessJwt, "second": second.AccessJwt} {
+		if _, err := m.VerifyAccessToken(token); err == nil {
+			t.Errorf("VerifyAccessToken(%s access token) = nil error after reuse", name)
+		}
+	}
+}
+
+func TestRefreshSessionExpired(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("expired token", func(t *testing.T) {
+		m, _ := newTestSessionManager(t)
+		m.RefreshTTL = -time.Hour
+
+		tokens, err := m.CreateSession(ctx, "did:plc:alice")
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		if _, _, err := m.RefreshSession(ctx, tokens.RefreshJwt); err == nil {
+			t.Error("RefreshSession(expired token) = nil error")
+		}
+	})
+
+	t.Run("expired session", func(t *testing.T) {
+		m, store := newTestSessionManager(t)
+
+		tokens, err := m.CreateSession(ctx, "did:plc:alice")
+		if err != nil {
+			t.Fatalf("CreateSession: %v", err)
+		}
+		for _, session := range store.sessions {
+			session.ExpiresAt = time.Now().Add(-time.Minute)
+		}
+		if _, _, err := m.RefreshSession(ctx, tokens.RefreshJwt); !errors.Is(err, ErrRefreshTokenExpired) {
+			t.Errorf("RefreshSession(token of expired session) = %v, want ErrRefreshTokenExpired", err)
+		}
+	})
+}
+
+func TestSessionVerifierRejectsRevokedJTI(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestSessionManager(t)
+
+	revoked, err := m.CreateSession(ctx, "did:plc:alice")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	other, err := m.CreateSession(ctx, "did:plc:alice")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	claims, err := m.VerifyAccessToken(revoked.AccessJwt)
+	if err != nil {
+		t.Fatalf("VerifyAccessToken: %v", err)
+	}
+
+	m.revocations.Revoke(claims.JWTID, time.Now().Add(time.Hour))
+
+	if _, err := m.VerifyAccessToken(revoked.AccessJwt); err == nil {
+		t.Error("VerifyAccessToken(revoked jti) = nil error")
+	}
+	if _, err := m.VerifyAccessToken(other.AccessJwt); err != nil {
+		t.Errorf("VerifyAccessToken(other token) = %v, want nil", err)
+	}
+}
+
+func TestRevocationListSync(t *testing.T) {
+	ctx := context.Background()
+	m, _ := newTestSessionManager(t)
+
+	tokens, err := m.CreateSession(ctx, "did:plc:alice")
+	if err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+
+	// A service verifying tokens with its own revocation list
+	verifier := NewSessionVerifier(testIssuer, m.signingKeys)
+	list := NewRevocationList()
+	verifier.SetRevocationList(list)
+	if _, err := verifier.VerifyAccessToken(tokens.AccessJwt); err != nil {
+		t.Fatalf("VerifyAccessToken: %v", err)
+	}
+
+	if err := m.RevokeAllSessions(ctx, "did:plc:alice"); err != nil {
+		t.Fatalf("RevokeAllSessions: %v", err)
+	}
+
+	// The auth service publishes the revoked sessions, as RevokedSessionsHandler does
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		revoked, err := m.RevokedTokens(r.Context())
+		if err != nil {
+			t.Error(err)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"revoked": revoked})
+	}))
+	defer ts.Close()
+
+	entries, err := FetchRevocations(ctx, ts.Client(), ts.URL)
+	if err != nil {
+		t.Fatalf("FetchRevocations: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("FetchRevocations() returned %d entries, want 1", len(entries))
+	}
+	list.Add(entries)
+
+	if _, err := verifier.VerifyAccessToken(tokens.AccessJwt); err == nil {
+		t.Error("VerifyAccessToken(token of revoked session) = nil error after sync")
+	}
+}
+
+func TestRevocationListPrune(t *testing.T) {
+	list := NewRevocationList()
+	list.Revoke("expired", time.Now().Add(-time.Second))
+	list.Revoke("current", time.Now().Add(time.Hour))
+	list.Prune()
+
+	if list.IsRevoked(&Claims{SessionID: "expired"}) {
+		t.Error("entry whose tokens expired was not pruned")
+	}
+	if !list.IsRevoked(&Claims{SessionID: "current"}) {
+		t.Error("current entry was pruned")
+	}
+}
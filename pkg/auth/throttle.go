@@ -0,0 +1,313 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Reasons logins fail for in the audit log
+const (
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureInvalidAuthFactor  = "invalid_auth_factor"
+	LoginFailureThrottled          = "throttled"
+)
+
+// ThrottledError is returned for a login attempt refused because the account
+// or client is locked out
+type ThrottledError struct {
+	// Wait is how long until the next attempt is allowed
+	Wait time.Duration
+}
+
+// Error implements error
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.Wait.Round(time.Second))
+}
+
+// ThrottlePolicy limits failed logins for one kind of key. Once a key has
+// MaxFailures failures within the sliding Window, each further failure locks it
+// out, for Lockout after the first and twice as long after each one after that,
+// up to MaxLockout.
+type ThrottlePolicy struct {
+	MaxFailures int
+	Window      time.Duration
+	Lockout     time.Duration
+	MaxLockout  time.Duration
+}
+
+// Default throttle policies for accounts and client IPs
+var (
+	DefaultAccountThrottle = ThrottlePolicy{MaxFailures: 5, Window: time.Hour, Lockout: time.Minute, MaxLockout: time.Hour}
+	DefaultIPThrottle      = ThrottlePolicy{MaxFailures: 50, Window: time.Hour, Lockout: time.Minute, MaxLockout: time.Hour}
+)
+
+// lockout returns how long a key with failures failures in the window is locked
+// out after its latest failure
+func (p ThrottlePolicy) lockout(failures int) time.Duration {
+	if failures < p.MaxFailures {
+		return 0
+	}
+	lockout := p.Lockout
+	for i := p.MaxFailures; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > p.MaxLockout {
+		lockout = p.MaxLockout
+	}
+	return lockout
+}
+
+// ThrottleStore stores the failed login attempts of throttle keys
+type ThrottleStore interface {
+	// AddFailure records a failed attempt of key
+	AddFailure(ctx context.Context, key string, at time.Time) error
+	// Failures returns the number of failures of key at or after since, and the time of the latest one
+	Failures(ctx context.Context, key string, since time.Time) (int, time.Time, error)
+	// Reset forgets the failures of key
+	Reset(ctx context.Context, key string) error
+	// DeleteFailuresBefore forgets failures that happened before cutoff
+	DeleteFailuresBefore(ctx context.Context, cutoff time.Time) error
+}
+
+// LoginThrottle limits failed logins per account and per client IP
+type LoginThrottle struct {
+	Store   ThrottleStore
+	Account ThrottlePolicy
+	IP      ThrottlePolicy
+	// TrustProxy makes ClientIP use the address the API gateway appends to X-Forwarded-For
+	TrustProxy bool
+}
+
+// NewLoginThrottle creates a login throttle with the default policies
+func NewLoginThrottle(store ThrottleStore) *LoginThrottle {
+	return &LoginThrottle{
+		Store:   store,
+		Account: DefaultAccountThrottle,
+		IP:      DefaultIPThrottle,
+	}
+}
+
+// Check returns how long the account and client IP must wait before the next
+// login attempt, or zero if they may try now
+func (t *LoginThrottle) Check(ctx context.Context, account, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range []struct {
+		name   string
+		policy ThrottlePolicy
+	}{
+		{"account:" + account, t.Account},
+		{"ip:" + ip, t.IP},
+	} {
+		failures, last, err := t.Store.Failures(ctx, key.name, now.Add(-key.policy.Window))
+		if err != nil {
+			return 0, err
+		}
+		if until := last.Add(key.policy.lockout(failures)); until.Sub(now) > wait {
+			wait = until.Sub(now)
+		}
+	}
+	return wait, nil
+}
+
+// Fail records a failed login attempt of the account from the client IP
+func (t *LoginThrottle) Fail(ctx context.Context, account, ip string) error {
+	now := time.Now()
+	if err := t.Store.AddFailure(ctx, "account:"+account, now); err != nil {
+		return err
+	}
+	return t.Store.AddFailure(ctx, "ip:"+ip, now)
+}
+
+// Succeed forgets the failed attempts of an account after a successful login.
+// The client IP keeps its failures so that one valid account cannot be used to
+// keep guessing at others.
+func (t *LoginThrottle) Succeed(ctx context.Context, account string) error {
+	return t.Store.Reset(ctx, "account:"+account)
+}
+
+// DeleteExpired forgets failures that no policy looks at anymore
+func (t *LoginThrottle) DeleteExpired(ctx context.Context) error {
+	window := t.Account.Window
+	if t.IP.Window > window {
+		window = t.IP.Window
+	}
+	return t.Store.DeleteFailuresBefore(ctx, time.Now().Add(-window))
+}
+
+// ClientIP returns the IP address a request came from
+func (t *LoginThrottle) ClientIP(r *http.Request) string {
+	if t.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			hops := strings.Split(forwarded, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// MemoryThrottleStore keeps failed login attempts in memory, for a single auth
+// service instance
+type MemoryThrottleStore struct {
+	mu       sync.Mutex
+	failures map[string][]time.Time
+}
+
+// NewMemoryThrottleStore creates a new in-memory throttle store
+func NewMemoryThrottleStore() *MemoryThrottleStore {
+	return &MemoryThrottleStore{
+		failures: make(map[string][]time.Time),
+	}
+}
+
+// AddFailure records a failed attempt of key
+func (s *MemoryThrottleStore) AddFailure(ctx context.Context, key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.failures[key] = append(s.failures[key], at)
+	return nil
+}
+
+// Failures returns the number of failures of key at or after since, and the time of the latest one
+func (s *MemoryThrottleStore) Failures(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int
+	var last time.Time
+	for _, at := range s.failures[key] {
+		if at.Before(since) {
+			continue
+		}
+		count++
+		if at.After(last) {
+			last = at
+		}
+	}
+	return count, last, nil
+}
+
+// Reset forgets the failures of key
+func (s *MemoryThrottleStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, key)
+	return nil
+}
+
+// DeleteFailuresBefore forgets failures that happened before cutoff
+func (s *MemoryThrottleStore) DeleteFailuresBefore(ctx context.Context, cutoff time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, failures := range s.failures {
+		kept := failures[:0]
+		for _, at := range failures {
+			if !at.Before(cutoff) {
+				kept = append(kept, at)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.failures, key)
+		} else {
+			s.failures[key] = kept
+		}
+	}
+	return nil
+}
+
+// PostgresThrottleStore keeps failed login attempts in the login_failures
+// table, so that all auth service instances share them
+type PostgresThrottleStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresThrottleStore creates a new Postgres throttle store
+func NewPostgresThrottleStore(db *pgxpool.Pool) *PostgresThrottleStore {
+	return &PostgresThrottleStore{
+		db: db,
+	}
+}
+
+// AddFailure records a failed attempt of key
+func (s *PostgresThrottleStore) AddFailure(ctx context.Context, key string, at time.Time) error {
+	if _, err := s.db.Exec(ctx, `INSERT INTO login_failures (key, failed_at) VALUES ($1, $2)`, key, at); err != nil {
+		return fmt.Errorf("failed to record login failure: %w", err)
+	}
+	return nil
+}
+
+// Failures returns the number of failures of key at or after since, and the time of the latest one
+func (s *PostgresThrottleStore) Failures(ctx context.Context, key string, since time.Time) (int, time.Time, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(MAX(failed_at), 'epoch')
+		FROM login_failures
+		WHERE key = $1 AND failed_at >= $2
+	`
+	var count int
+	var last time.Time
+	if err := s.db.QueryRow(ctx, query, key, since).Scan(&count, &last); err != nil {
+		return 0, time.Time{}, fmt.Errorf("failed to count login failures: %w", err)
+	}
+	return count, last, nil
+}
+
+// Reset forgets the failures of key
+func (s *PostgresThrottleStore) Reset(ctx context.Context, key string) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM login_failures WHERE key = $1`, key); err != nil {
+		return fmt.Errorf("failed to reset login failures: %w", err)
+	}
+	return nil
+}
+
+// DeleteFailuresBefore forgets failures that happened before cutoff
+func (s *PostgresThrottleStore) DeleteFailuresBefore(ctx context.Context, cutoff time.Time) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM login_failures WHERE failed_at < $1`, cutoff); err != nil {
+		return fmt.Errorf("failed to delete login failures: %w", err)
+	}
+	return nil
+}
+
+// FailedLogin is an audit record of a failed login attempt
+type FailedLogin struct {
+	Identifier string    `json:"identifier"`
+	DID        string    `json:"did,omitempty"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"userAgent,omitempty"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// RecordFailedLogin writes an audit record of a failed login attempt
+func (r *UserRepository) RecordFailedLogin(ctx context.Context, attempt *FailedLogin) error {
+	query := `
+		INSERT INTO failed_logins (identifier, did, ip, user_agent, reason, created_at)
+		VALUES ($1, NULLIF($2, ''), $3, NULLIF($4, ''), $5, $6)
+	`
+	attempt.CreatedAt = time.Now()
+	_, err := r.db.Exec(ctx, query,
+		attempt.Identifier,
+		attempt.DID,
+		attempt.IP,
+		attempt.UserAgent,
+		attempt.Reason,
+		attempt.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record failed login: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestThrottlePolicyLockout(t *testing.T) {
+	policy := ThrottlePolicy{MaxFailures: 3, Window: time.Hour, Lockout: time.Minute, MaxLockout: 10 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Minute},
+		{4, 2 * time.Minute},
+		{5, 4 * time.Minute},
+		{6, 8 * time.Minute},
+		// Doubling stops at MaxLockout
+		{7, 10 * time.Minute},
+		{100, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := policy.lockout(tt.failures); got != tt.want {
+			t.Errorf("lockout(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func newTestThrottle() *LoginThrottle {
+	throttle := NewLoginThrottle(NewMemoryThrottleStore())
+	throttle.Account = ThrottlePolicy{MaxFailures: 3, Window: time.Hour, Lockout: time.Minute, MaxLockout: time.Hour}
+	throttle.IP = ThrottlePolicy{MaxFailures: 5, Window: time.Hour, Lockout: time.Minute, MaxLockout: time.Hour}
+	return throttle
+}
+
+func checkWait(t *testing.T, throttle *LoginThrottle, account, ip string, min, max time.Duration) {
+	t.Helper()
+	wait, err := throttle.Check(context.Background(), account, ip)
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if wait < min || wait > max {
+		t.Errorf("Check(%s, %s) = %s, want between %s and %s", account, ip, wait, min, max)
+	}
+}
+
+func TestLoginThrottleLocksOutAccount(t *testing.T) {
+	ctx := context.Background()
+	throttle := newTestThrottle()
+
+	for i := 0; i < 2; i++ {
+		if err := throttle.Fail(ctx, "did:plc:alice", "192.0.2.1"); err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+	}
+	checkWait(t, throttle, "did:plc:alice", "192.0.2.1", 0, 0)
+
+	// The third failure locks the account out, from any IP
+	if err := throttle.Fail(ctx, "did:plc:alice", "192.0.2.1"); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	checkWait(t, throttle, "did:plc:alice", "192.0.2.2", 59*time.Second, time.Minute)
+
+	// The fourth doubles the lockout
+	if err := throttle.Fail(ctx, "did:plc:alice", "192.0.2.1"); err != nil {
+		t.Fatalf("Fail: %v", err)
+	}
+	checkWait(t, throttle, "did:plc:alice", "192.0.2.2", 119*time.Second, 2*time.Minute)
+
+	// Other accounts are not locked out
+	checkWait(t, throttle, "did:plc:bob", "192.0.2.2", 0, 0)
+}
+
+func TestLoginThrottleLocksOutIP(t *testing.T) {
+	ctx := context.Background()
+	throttle := newTestThrottle()
+
+	// Guessing at many accounts from one IP locks the IP out
+	for _, account := range []string{"a", "b", "c", "d", "e"} {
+		if err := throttle.Fail(ctx, account, "192.0.2.1"); err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+	}
+	checkWait(t, throttle, "f", "192.0.2.1", 59*time.Second, time.Minute)
+	checkWait(t, throttle, "f", "192.0.2.2", 0, 0)
+}
+
+func TestLoginThrottleSucceed(t *testing.T) {
+	ctx := context.Background()
+	throttle := newTestThrottle()
+
+	for i := 0; i < 5; i++ {
+		if err := throttle.Fail(ctx, "did:plc:alice", "192.0.2.1"); err != nil {
+			t.Fatalf("Fail: %v", err)
+		}
+	}
+	if err := throttle.Succeed(ctx, "did:plc:alice"); err != nil {
+		t.Fatalf("Succeed: %v", err)
+	}
+
+	// The account's failures are forgotten, but the IP keeps its own
+	checkWait(t, throttle, "did:plc:alice", "192.0.2.2", 0, 0)
+	checkWait(t, throttle, "did:plc:alice", "192.0.2.1", 59*time.Second, time.Minute)
+}
+
+func TestLoginThrottleWindow(t *testing.T) {
+	ctx := context.Background()
+	throttle := newTestThrottle()
+
+	// Failures older than the window do not count
+	old := time.Now().Add(-2 * time.Hour)
+	for i := 0; i < 3; i++ {
+		if err := throttle.Store.AddFailure(ctx, "account:did:plc:alice", old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkWait(t, throttle, "did:plc:alice", "192.0.2.1", 0, 0)
+
+	if err := throttle.DeleteExpired(ctx); err != nil {
+		t.Fatalf("DeleteExpired: %v", err)
+	}
+	count, _, err := throttle.Store.Failures(ctx, "account:did:plc:alice", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("%d failures left after DeleteExpired, want 0", count)
+	}
+}
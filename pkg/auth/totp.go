@@ -0,0 +1,367 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yourusername/atprogo/pkg/identity"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by authenticator apps)
+const (
+	totpDigits     = 6
+	totpPeriod     = 30
+	totpSecretSize = 20
+	totpSkew       = 1
+)
+
+// recoveryCodeCount is the number of recovery codes issued when TOTP is confirmed
+const recoveryCodeCount = 10
+
+// Two-factor errors
+var (
+	ErrAuthFactorRequired = errors.New("auth factor token required")
+	ErrInvalidAuthFactor  = errors.New("invalid auth factor token")
+	ErrTOTPEnabled        = errors.New("TOTP is already enabled")
+	ErrTOTPNotEnrolled    = errors.New("TOTP is not enrolled")
+)
+
+// totpEncoding encodes TOTP secrets for authenticator apps
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates a random base32-encoded TOTP secret
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPCode computes the code of a base32-encoded secret for a time step (RFC 4226)
+func TOTPCode(secret string, counter int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000), nil
+}
+
+// TOTPCounter returns the TOTP time step of t
+func TOTPCounter(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// TOTPProvisioningURI returns the otpauth:// URI authenticator apps enroll from,
+// usually shown as a QR code
+func TOTPProvisioningURI(issuer, account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// matchTOTP returns the time step within the allowed skew that code is valid
+// for, if it is after lastCounter
+func matchTOTP(secret, code string, now time.Time, lastCounter int64) (int64, bool) {
+	current := TOTPCounter(now)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		expected, err := TOTPCode(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+	return 0, false
+}
+
+// TwoFactorStore stores TOTP secrets, encrypted with the keystore master key,
+// and hashed recovery codes
+type TwoFactorStore struct {
+	db       *pgxpool.Pool
+	keystore *identity.Keystore
+}
+
+// NewTwoFactorStore creates a new two-factor store
+func NewTwoFactorStore(db *pgxpool.Pool, keystore *identity.Keystore) *TwoFactorStore {
+	return &TwoFactorStore{
+		db:       db,
+		keystore: keystore,
+	}
+}
+
+// Enroll generates a new TOTP secret for an account. The secret is not used for
+// login until it is confirmed with a code.
+func (s *TwoFactorStore) Enroll(ctx context.Context, did string) (string, error) {
+	enabled, err := s.Enabled(ctx, did)
+	if err != nil {
+		return "", err
+	}
+	if enabled {
+		return "", ErrTOTPEnabled
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret: %w", err)
+	}
+	keyID, encrypted, err := s.keystore.Seal([]byte(secret), totpAAD(did))
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt TOTP secret: %w", err)
+	}
+
+	query := `
+		INSERT INTO totp_factors (did, encrypted_secret, master_key_id, last_counter, created_at)
+		VALUES ($1, $2, $3, 0, $4)
+		ON CONFLICT (did) DO UPDATE
+		SET encrypted_secret = EXCLUDED.encrypted_secret, master_key_id = EXCLUDED.master_key_id,
+			last_counter = 0, confirmed_at = NULL, created_at = EXCLUDED.created_at
+	`
+	if _, err := s.db.Exec(ctx, query, did, encrypted, keyID, time.Now()); err != nil {
+		return "", fmt.Errorf("failed to enroll TOTP: %w", err)
+	}
+	return secret, nil
+}
+
+// Confirm enables an enrolled TOTP secret once the user proves they can generate
+// codes for it, and returns a fresh set of recovery codes
+func (s *TwoFactorStore) Confirm(ctx context.Context, did, code string) ([]string, error) {
+	ok, err := s.verifyTOTP(ctx, did, code, false)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidAuthFactor
+	}
+
+	query := `
+		UPDATE totp_factors
+		SET confirmed_at = $1
+		WHERE did = $2
+	`
+	if _, err := s.db.Exec(ctx, query, time.Now(), did); err != nil {
+		return nil, fmt.Errorf("failed to confirm TOTP: %w", err)
+	}
+
+	return s.RegenerateRecoveryCodes(ctx, did)
+}
+
+// Enabled reports whether an account has a confirmed TOTP secret
+func (s *TwoFactorStore) Enabled(ctx context.Context, did string) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM totp_factors WHERE did = $1 AND confirmed_at IS NOT NULL)`, did).Scan(&enabled)
+	if err != nil {
+		return false, fmt.Errorf("failed to check TOTP: %w", err)
+	}
+	return enabled, nil
+}
+
+// Check verifies the second factor of a login. It succeeds for accounts without
+// TOTP and returns ErrAuthFactorRequired if the account has TOTP and no token
+// was given.
+func (s *TwoFactorStore) Check(ctx context.Context, did, token string) error {
+	enabled, err := s.Enabled(ctx, did)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return nil
+	}
+	if token == "" {
+		return ErrAuthFactorRequired
+	}
+
+	ok, err := s.Verify(ctx, did, token)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidAuthFactor
+	}
+	return nil
+}
+
+// Verify checks a TOTP code or an unused recovery code for an account with TOTP
+// enabled. Each TOTP code and recovery code can only be used once.
+func (s *TwoFactorStore) Verify(ctx context.Context, did, token string) (bool, error) {
+	token = strings.TrimSpace(token)
+	if len(token) == totpDigits {
+		return s.verifyTOTP(ctx, did, token, true)
+	}
+	return s.useRecoveryCode(ctx, did, token)
+}
+
+// Disable removes an account's TOTP secret and recovery codes
+func (s *TwoFactorStore) Disable(ctx context.Context, did string) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM totp_factors WHERE did = $1`, did); err != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", err)
+	}
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE did = $1`, did); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to disable TOTP: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces an account's recovery codes and returns the
+// new ones. The codes are only stored as hashes.
+func (s *TwoFactorStore) RegenerateRecoveryCodes(ctx context.Context, did string) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateAppPassword()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash recovery code: %w", err)
+		}
+		codes[i] = code
+		hashes[i] = string(hash)
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE did = $1`, did); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	now := time.Now()
+	for _, hash := range hashes {
+		_, err := tx.Exec(ctx, `INSERT INTO recovery_codes (did, code_hash, created_at) VALUES ($1, $2, $3)`, did, hash, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// verifyTOTP checks a TOTP code against an account's secret, confirmed or not,
+// and records its time step so that it cannot be replayed
+func (s *TwoFactorStore) verifyTOTP(ctx context.Context, did, code string, confirmed bool) (bool, error) {
+	query := `
+		SELECT encrypted_secret, master_key_id, last_counter, confirmed_at IS NOT NULL
+		FROM totp_factors
+		WHERE did = $1
+	`
+	var encrypted []byte
+	var keyID string
+	var lastCounter int64
+	var isConfirmed bool
+	err := s.db.QueryRow(ctx, query, did).Scan(&encrypted, &keyID, &lastCounter, &isConfirmed)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, ErrTOTPNotEnrolled
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get TOTP secret: %w", err)
+	}
+	if isConfirmed != confirmed {
+		if confirmed {
+			return false, ErrTOTPNotEnrolled
+		}
+		return false, ErrTOTPEnabled
+	}
+
+	secret, err := s.keystore.Open(keyID, encrypted, totpAAD(did))
+	if err != nil {
+		return false, err
+	}
+
+	counter, ok := matchTOTP(string(secret), code, time.Now(), lastCounter)
+	if !ok {
+		return false, nil
+	}
+
+	// Record the time step unless a concurrent login already used it
+	tag, err := s.db.Exec(ctx, `UPDATE totp_factors SET last_counter = $1 WHERE did = $2 AND last_counter < $1`, counter, did)
+	if err != nil {
+		return false, fmt.Errorf("failed to update TOTP counter: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// useRecoveryCode checks a recovery code against an account's unused codes and marks it used
+func (s *TwoFactorStore) useRecoveryCode(ctx context.Context, did, code string) (bool, error) {
+	rows, err := s.db.Query(ctx, `SELECT id, code_hash FROM recovery_codes WHERE did = $1 AND used_at IS NULL`, did)
+	if err != nil {
+		return false, fmt.Errorf("failed to get recovery codes: %w", err)
+	}
+	defer rows.Close()
+
+	matched := 0
+	for rows.Next() {
+		var id int
+		var hash string
+		if err := rows.Scan(&id, &hash); err != nil {
+			return false, fmt.Errorf("failed to scan recovery code: %w", err)
+		}
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(strings.ToLower(code))) == nil {
+			matched = id
+			break
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to get recovery codes: %w", err)
+	}
+	if matched == 0 {
+		return false, nil
+	}
+
+	tag, err := s.db.Exec(ctx, `UPDATE recovery_codes SET used_at = $1 WHERE id = $2 AND used_at IS NULL`, time.Now(), matched)
+	if err != nil {
+		return false, fmt.Errorf("failed to use recovery code: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// totpAAD binds an encrypted TOTP secret to its account
+func totpAAD(did string) string {
+	return did + "|totp"
+}
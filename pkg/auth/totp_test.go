@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 4226 and RFC 6238 test vectors, "12345678901234567890"
+var rfcSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC4226(t *testing.T) {
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range want {
+		got, err := TOTPCode(rfcSecret, int64(counter))
+		if err != nil {
+			t.Fatalf("TOTPCode(%d): %v", counter, err)
+		}
+		if got != code {
+			t.Errorf("TOTPCode(%d) = %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; 6-digit codes are their last 6 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := TOTPCode(rfcSecret, TOTPCounter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.code)
+		}
+	}
+}
+
+func TestTOTPCodeLowercaseSecret(t *testing.T) {
+	upper, err := TOTPCode("GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lower, err := TOTPCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upper != lower || upper != "287082" {
+		t.Errorf("TOTPCode = %s and %s, want 287082 for both", upper, lower)
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := TOTPCode("not base32!", 0); err == nil {
+		t.Error("TOTPCode() = nil error, want an error for an invalid secret")
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPCounter(now)
+	code := func(counter int64) string {
+		c, err := TOTPCode(rfcSecret, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		lastCounter int64
+		want        int64
+		ok          bool
+	}{
+		{"current step", code(current), 0, current, true},
+		{"previous step", code(current - 1), 0, current - 1, true},
+		{"next step", code(current + 1), 0, current + 1, true},
+		{"outside skew", code(current - 2), 0, 0, false},
+		{"wrong code", "000000", 0, 0, false},
+		{"replayed", code(current), current, 0, false},
+		{"older than last used", code(current - 1), current, 0, false},
+		{"after last used", code(current + 1), current, current + 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := matchTOTP(rfcSecret, tt.code, now, tt.lastCounter)
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("matchTOTP() = %d, %v, want %d, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	got := TOTPProvisioningURI("atprogo", "alice.test", "SECRET")
+	want := "otpauth://totp/atprogo:alice.test?algorithm=SHA1&digits=6&issuer=atprogo&period=30&secret=SECRET"
+	if got != want {
+		t.Errorf("TOTPProvisioningURI() = %s, want %s", got, want)
+	}
+}
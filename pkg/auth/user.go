@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,15 +10,33 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Account statuses
+const (
+	StatusActive      = "active"
+	StatusDeactivated = "deactivated"
+	StatusSuspended   = "suspended"
+	StatusTakendown   = "takendown"
+	StatusDeleted     = "deleted"
+)
+
+// Errors for accounts that cannot log in
+var (
+	ErrAccountSuspended = errors.New("account is suspended")
+	ErrAccountTakendown = errors.New("account has been taken down")
+	ErrAccountDeleted   = errors.New("account has been deleted")
+)
+
 // User represents a user in the system
 type User struct {
-	ID           int       `json:"id"`
-	DID          string    `json:"did"`
-	Username     string    `json:"username"`
-	Email        string    `json:"email"`
-	PasswordHash string    `json:"-"`
-	CreatedAt    time.Time `json:"createdAt"`
-	UpdatedAt    time.Time `json:"updatedAt"`
+	ID               int        `json:"id"`
+	DID              string     `json:"did"`
+	Username         string     `json:"username"`
+	Email            string     `json:"email"`
+	EmailConfirmedAt *time.Time `json:"emailConfirmedAt,omitempty"`
+	PasswordHash     string     `json:"-"`
+	Status           string     `json:"status"`
+	CreatedAt        time.Time  `json:"createdAt"`
+	UpdatedAt        time.Time  `json:"updatedAt"`
 }
 
 // UserRepository handles user data access
@@ -40,8 +59,8 @@ func (r *UserRepository) CreateUser(ctx context.Context, user *User, password st
 
 	// Insert user into database
 	query := `
-		INSERT INTO users (did, username, email, password_hash, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (did, username, email, password_hash, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING id
 	`
 	now := time.Now()
@@ -50,6 +69,7 @@ func (r *UserRepository) CreateUser(ctx context.Context, user *User, password st
 		user.Username,
 		user.Email,
 		string(hashedPassword),
+		StatusActive,
 		now,
 		now,
 	).Scan(&user.ID)
@@ -58,6 +78,7 @@ func (r *UserRepository) CreateUser(ctx context.Context, user *User, password st
 		return fmt.Errorf("failed to create user: %w", err)
 	}
 
+	user.Status = StatusActive
 	user.CreatedAt = now
 	user.UpdatedAt = now
 	user.PasswordHash = string(hashedPassword)
@@ -68,7 +89,7 @@ func (r *UserRepository) CreateUser(ctx context.Context, user *User, password st
 // GetUserByUsername gets a user by username
 func (r *UserRepository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
 	query := `
-		SELECT id, did, username, email, password_hash, created_at, updated_at
+		SELECT id, did, username, email, email_confirmed_at, password_hash, status, created_at, updated_at
 		FROM users
 		WHERE username = $1
 	`
@@ -78,7 +99,9 @@ func (r *UserRepository) GetUserByUsername(ctx context.Context, username string)
 		&user.DID,
 		&user.Username,
 		&user.Email,
+		&user.EmailConfirmedAt,
 		&user.PasswordHash,
+		&user.Status,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -91,7 +114,7 @@ func (r *UserRepository) GetUserByUsername(ctx context.Context, username string)
 // GetUserByDID gets a user by DID
 func (r *UserRepository) GetUserByDID(ctx context.Context, did string) (*User, error) {
 	query := `
-		SELECT id, did, username, email, password_hash, created_at, updated_at
+		SELECT id, did, username, email, email_confirmed_at, password_hash, status, created_at, updated_at
 		FROM users
 		WHERE did = $1
 	`
@@ -101,7 +124,9 @@ func (r *UserRepository) GetUserByDID(ctx context.Context, did string) (*User, e
 		&user.DID,
 		&user.Username,
 		&user.Email,
+		&user.EmailConfirmedAt,
 		&user.PasswordHash,
+		&user.Status,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -111,6 +136,132 @@ func (r *UserRepository) GetUserByDID(ctx context.Context, did string) (*User, e
 	return &user, nil
 }
 
+// GetUserByEmail gets a user by email
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	query := `
+		SELECT id, did, username, email, email_confirmed_at, password_hash, status, created_at, updated_at
+		FROM users
+		WHERE lower(email) = lower($1)
+	`
+	var user User
+	err := r.db.QueryRow(ctx, query, email).Scan(
+		&user.ID,
+		&user.DID,
+		&user.Username,
+		&user.Email,
+		&user.EmailConfirmedAt,
+		&user.PasswordHash,
+		&user.Status,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	return &user, nil
+}
+
+// CheckLogin returns the error that keeps the user from logging in, if any.
+// Deactivated accounts can log in so that they can reactivate.
+func (u *User) CheckLogin() error {
+	switch u.Status {
+	case StatusSuspended:
+		return ErrAccountSuspended
+	case StatusTakendown:
+		return ErrAccountTakendown
+	case StatusDeleted:
+		return ErrAccountDeleted
+	}
+	return nil
+}
+
+// UpdateStatus updates a user's account status
+func (r *UserRepository) UpdateStatus(ctx context.Context, did, status string) error {
+	query := `
+		UPDATE users
+		SET status = $1, status_changed_at = $2, updated_at = $2
+		WHERE did = $3
+	`
+	_, err := r.db.Exec(ctx, query, status, time.Now(), did)
+	if err != nil {
+		return fmt.Errorf("failed to update user status: %w", err)
+	}
+	return nil
+}
+
+// ListStatusChanges lists the accounts whose status changed at or after since,
+// oldest change first
+func (r *UserRepository) ListStatusChanges(ctx context.Context, since time.Time) ([]AccountStatus, error) {
+	query := `
+		SELECT did, status, status_changed_at
+		FROM users
+		WHERE status_changed_at >= $1
+		ORDER BY status_changed_at
+	`
+	rows, err := r.db.Query(ctx, query, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list status changes: %w", err)
+	}
+	defer rows.Close()
+
+	changes := []AccountStatus{}
+	for rows.Next() {
+		var change AccountStatus
+		if err := rows.Scan(&change.DID, &change.Status, &change.ChangedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan status change: %w", err)
+		}
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating status changes: %w", err)
+	}
+	return changes, nil
+}
+
+// DeleteUser deletes a user's credentials, app passwords, pending email tokens
+// and second factors, disables their invite codes, and leaves a tombstone with the deleted status. The DID and
+// username stay reserved so that they cannot be taken over.
+func (r *UserRepository) DeleteUser(ctx context.Context, did string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	for _, table := range []string{"app_passwords", "email_tokens", "totp_factors", "recovery_codes"} {
+		if _, err := tx.Exec(ctx, `DELETE FROM `+table+` WHERE did = $1`, did); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", table, err)
+		}
+	}
+	if _, err := tx.Exec(ctx, `UPDATE invite_codes SET disabled = TRUE WHERE for_account = $1`, did); err != nil {
+		return fmt.Errorf("failed to disable invite codes: %w", err)
+	}
+
+	// The email is replaced by the DID to free the address while keeping it unique
+	query := `
+		UPDATE users
+		SET email = did, email_confirmed_at = NULL, password_hash = '', status = $1, status_changed_at = $2, updated_at = $2
+		WHERE did = $3
+	`
+	if _, err := tx.Exec(ctx, query, StatusDeleted, time.Now(), did); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit user deletion: %w", err)
+	}
+	return nil
+}
+
+// RemoveUser removes a user that failed to finish registering. Unlike
+// DeleteUser, it leaves no tombstone.
+func (r *UserRepository) RemoveUser(ctx context.Context, did string) error {
+	if _, err := r.db.Exec(ctx, `DELETE FROM users WHERE did = $1`, did); err != nil {
+		return fmt.Errorf("failed to remove user: %w", err)
+	}
+	return nil
+}
+
 // VerifyPassword verifies a user's password
 func (r *UserRepository) VerifyPassword(user *User, password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password))
@@ -0,0 +1,99 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// AccountStatusActive is the status of accounts whose follows are shown. Only
+// accounts with another status are stored.
+const AccountStatusActive = "active"
+
+// AccountStatusDeleted is the status of deleted accounts
+const AccountStatusDeleted = "deleted"
+
+// DocumentResolver resolves DIDs to their documents
+type DocumentResolver interface {
+	ResolveDocument(ctx context.Context, did string) (*identity.Document, error)
+}
+
+// MigratedAway reports whether an account's DID document names a PDS other than
+// pdsEndpoint. A PDS deactivates the accounts that migrate away from it, but
+// their follows now come from their new PDS and must stay visible.
+func MigratedAway(ctx context.Context, resolver DocumentResolver, did, pdsEndpoint string) (bool, error) {
+	doc, err := resolver.ResolveDocument(ctx, did)
+	if err != nil {
+		return false, fmt.Errorf("failed to resolve DID: %w", err)
+	}
+	current := strings.TrimSuffix(doc.PDSEndpoint(), "/")
+	return current != "" && current != strings.TrimSuffix(pdsEndpoint, "/"), nil
+}
+
+// GetAccountStatus gets the status of an account
+func (r *FollowRepository) GetAccountStatus(ctx context.Context, did string) (string, error) {
+	var status string
+	err := r.db.QueryRow(ctx, `SELECT status FROM account_statuses WHERE did = $1`, did).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return AccountStatusActive, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get account status: %w", err)
+	}
+	return status, nil
+}
+
+// SetAccountStatus records the status of an account. Follows from and to
+// accounts that are not active are hidden.
+func (r *FollowRepository) SetAccountStatus(ctx context.Context, did, status string) error {
+	if status == AccountStatusActive {
+		if _, err := r.db.Exec(ctx, `DELETE FROM account_statuses WHERE did = $1`, did); err != nil {
+			return fmt.Errorf("failed to set account status: %w", err)
+		}
+		return nil
+	}
+
+	query := `
+		INSERT INTO account_statuses (did, status, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (did) DO UPDATE
+		SET status = EXCLUDED.status, updated_at = EXCLUDED.updated_at
+	`
+	if _, err := r.db.Exec(ctx, query, did, status, time.Now()); err != nil {
+		return fmt.Errorf("failed to set account status: %w", err)
+	}
+	return nil
+}
+
+// DeleteAccount deletes the follows from and to an account and records it as deleted
+func (r *FollowRepository) DeleteAccount(ctx context.Context, did string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `DELETE FROM follows WHERE follower = $1 OR following = $1`, did); err != nil {
+		return fmt.Errorf("failed to delete follows: %w", err)
+	}
+
+	query := `
+		INSERT INTO account_statuses (did, status, updated_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (did) DO UPDATE
+		SET status = EXCLUDED.status, updated_at = EXCLUDED.updated_at
+	`
+	if _, err := tx.Exec(ctx, query, did, AccountStatusDeleted, time.Now()); err != nil {
+		return fmt.Errorf("failed to set account status: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit account deletion: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package bgs
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// fakeResolver resolves DIDs to documents naming the PDS in pds
+type fakeResolver struct {
+	key identity.PublicKey
+	pds map[string]string
+}
+
+func (r fakeResolver) ResolveDocument(ctx context.Context, did string) (*identity.Document, error) {
+	endpoint, ok := r.pds[did]
+	if !ok {
+		return nil, errors.New("DID not found")
+	}
+	return identity.NewDocument(did, r.key, nil, endpoint), nil
+}
+
+func TestMigratedAway(t *testing.T) {
+	key, err := identity.GenerateKey(identity.KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := fakeResolver{key: key.Public(), pds: map[string]string{
+		"did:plc:stayed":   "https://pds.example.com",
+		"did:plc:slash":    "https://pds.example.com/",
+		"did:plc:migrated": "https://other.example.com",
+		"did:plc:nopds":    "",
+	}}
+
+	tests := []struct {
+		did     string
+		want    bool
+		wantErr bool
+	}{
+		{"did:plc:stayed", false, false},
+		{"did:plc:slash", false, false},
+		{"did:plc:migrated", true, false},
+		{"did:plc:nopds", false, false},
+		{"did:plc:unknown", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.did, func(t *testing.T) {
+			got, err := MigratedAway(context.Background(), resolver, tt.did, "https://pds.example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("MigratedAway() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("MigratedAway() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -60,12 +60,13 @@ func (r *FollowRepository) DeleteFollow(ctx context.Context, follower, following
 	return nil
 }
 
-// GetFollowers gets the followers of a DID
+// GetFollowers gets the followers of a DID, leaving out accounts that are not active
 func (r *FollowRepository) GetFollowers(ctx context.Context, did string, limit, offset int) ([]*Follow, error) {
 	query := `
 		SELECT follower, following, created_at
 		FROM follows
 		WHERE following = $1
+		AND NOT EXISTS (SELECT 1 FROM account_statuses WHERE did = follows.follower)
 		ORDER BY created_at DESC
 		LIMIT $2 OFFSET $3
 	`
@@ -94,12 +95,13 @@ func (r *FollowRepository) GetFollowers(ctx context.Context, did string, limit,
 	return follows, nil
 }
 
-// GetFollowing gets the DIDs a DID is following
+// GetFollowing gets the DIDs a DID is following, leaving out accounts that are not active
 func (r *FollowRepository) GetFollowing(ctx context.Context, did string, limit, offset int) ([]*Follow, error) {
 	query := `
 		SELECT follower, following, created_at
 		FROM follows
 		WHERE follower = $1
+		AND NOT EXISTS (SELECT 1 FROM account_statuses WHERE did = follows.following)
 		ORDER BY created_at DESC
 		LIMIT $2 OFFSET $3
 	`
@@ -145,12 +147,13 @@ func (r *FollowRepository) IsFollowing(ctx context.Context, follower, following
 	return exists, nil
 }
 
-// GetFollowersCount gets the number of followers of a DID
+// GetFollowersCount gets the number of active followers of a DID
 func (r *FollowRepository) GetFollowersCount(ctx context.Context, did string) (int, error) {
 	query := `
 		SELECT COUNT(*)
 		FROM follows
 		WHERE following = $1
+		AND NOT EXISTS (SELECT 1 FROM account_statuses WHERE did = follows.follower)
 	`
 	var count int
 	err := r.db.QueryRow(ctx, query, did).Scan(&count)
@@ -160,12 +163,13 @@ func (r *FollowRepository) GetFollowersCount(ctx context.Context, did string) (i
 	return count, nil
 }
 
-// GetFollowingCount gets the number of DIDs a DID is following
+// GetFollowingCount gets the number of active DIDs a DID is following
 func (r *FollowRepository) GetFollowingCount(ctx context.Context, did string) (int, error) {
 	query := `
 		SELECT COUNT(*)
 		FROM follows
 		WHERE follower = $1
+		AND NOT EXISTS (SELECT 1 FROM account_statuses WHERE did = follows.following)
 	`
 	var count int
 	err := r.db.QueryRow(ctx, query, did).Scan(&count)
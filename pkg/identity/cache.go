@@ -0,0 +1,176 @@
+package identity
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// resolveTimeout bounds lookups made on behalf of the cache, which are not tied to any one request
+const resolveTimeout = 15 * time.Second
+
+// purgeInterval is how often the cache removes expired entries as it is written to
+const purgeInterval = 10 * time.Minute
+
+// CachingResolver wraps a Resolver with a TTL cache. Concurrent lookups of the
+// same DID are coalesced, and expired documents are served while they refresh.
+// Expired entries are purged as new ones are stored, so the cache does not grow
+// without bound.
+type CachingResolver struct {
+	resolver    Resolver
+	ttl         time.Duration
+	negativeTTL time.Duration
+	maxStale    time.Duration
+
+	mu          sync.Mutex
+	entries     map[string]*cacheEntry
+	invalidated map[string]time.Time
+	lastPurge   time.Time
+	group       singleflight.Group
+}
+
+// cacheEntry is a cached resolution result
+type cacheEntry struct {
+	doc       *Document
+	err       error
+	fetchedAt time.Time
+}
+
+// NewCachingResolver creates a caching resolver. Documents are fresh for ttl
+// and may be served stale for up to maxStale more while a refresh runs.
+// Failed lookups are cached for negativeTTL.
+func NewCachingResolver(resolver Resolver, ttl, negativeTTL, maxStale time.Duration) *CachingResolver {
+	return &CachingResolver{
+		resolver:    resolver,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		maxStale:    maxStale,
+		entries:     make(map[string]*cacheEntry),
+		invalidated: make(map[string]time.Time),
+		lastPurge:   time.Now(),
+	}
+}
+
+// Resolve resolves a DID to a DID with its public key populated
+func (c *CachingResolver) Resolve(ctx context.Context, did string) (*DID, error) {
+	doc, err := c.ResolveDocument(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	return doc.DID()
+}
+
+// ResolveDocument returns the cached DID document for a DID, fetching it if needed
+func (c *CachingResolver) ResolveDocument(ctx context.Context, did string) (*Document, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[did]
+	c.mu.Unlock()
+
+	if ok {
+		age := now.Sub(entry.fetchedAt)
+		switch {
+		case entry.err != nil && age < c.negativeTTL:
+			return nil, entry.err
+		case entry.err == nil && age < c.ttl:
+			return entry.doc, nil
+		case entry.err == nil && age < c.ttl+c.maxStale:
+			// Serve stale while refreshing in the background
+			c.group.DoChan(did, func() (interface{}, error) {
+				return c.fetch(did)
+			})
+			return entry.doc, nil
+		}
+	}
+
+	// Fetch, sharing the lookup with concurrent callers
+	ch := c.group.DoChan(did, func() (interface{}, error) {
+		return c.fetch(did)
+	})
+	select {
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return res.Val.(*Document), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetch resolves a DID with the underlying resolver and stores the result
+func (c *CachingResolver) fetch(did string) (*Document, error) {
+	started := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	doc, err := c.resolver.ResolveDocument(ctx, did)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maybePurge(now)
+
+	// Don't store results of lookups that started before an invalidation
+	if invalidatedAt, ok := c.invalidated[did]; ok && !started.After(invalidatedAt) {
+		return doc, err
+	}
+
+	// Keep serving a stale document if a refresh fails
+	if err != nil {
+		if old, ok := c.entries[did]; ok && old.err == nil && now.Sub(old.fetchedAt) < c.ttl+c.maxStale {
+			return old.doc, nil
+		}
+	}
+
+	c.entries[did] = &cacheEntry{doc: doc, err: err, fetchedAt: now}
+	return doc, err
+}
+
+// Invalidate removes a DID from the cache so the next lookup fetches it again
+func (c *CachingResolver) Invalidate(did string) {
+	now := time.Now()
+
+	c.mu.Lock()
+	delete(c.entries, did)
+	c.invalidated[did] = now
+	c.maybePurge(now)
+	c.mu.Unlock()
+	c.group.Forget(did)
+}
+
+// Purge removes expired entries from the cache
+func (c *CachingResolver) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.purge(time.Now())
+}
+
+// maybePurge purges the cache if it has not been purged for purgeInterval.
+// The caller must hold c.mu.
+func (c *CachingResolver) maybePurge(now time.Time) {
+	if now.Sub(c.lastPurge) >= purgeInterval {
+		c.purge(now)
+	}
+}
+
+// purge removes expired entries and invalidations. The caller must hold c.mu.
+func (c *CachingResolver) purge(now time.Time) {
+	c.lastPurge = now
+	for did, entry := range c.entries {
+		age := now.Sub(entry.fetchedAt)
+		if (entry.err != nil && age >= c.negativeTTL) || (entry.err == nil && age >= c.ttl+c.maxStale) {
+			delete(c.entries, did)
+		}
+	}
+	for did, invalidatedAt := range c.invalidated {
+		// No lookup started before the invalidation can still be running
+		if now.Sub(invalidatedAt) > resolveTimeout {
+			delete(c.invalidated, did)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package identity
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingResolver counts lookups of the resolver it wraps
+type countingResolver struct {
+	Resolver
+	lookups atomic.Int32
+}
+
+func (r *countingResolver) ResolveDocument(ctx context.Context, did string) (*Document, error) {
+	r.lookups.Add(1)
+	return r.Resolver.ResolveDocument(ctx, did)
+}
+
+func TestCachingResolver(t *testing.T) {
+	const did = "did:plc:alice234567abcdefghijklm"
+	inner := &countingResolver{Resolver: fakeResolver{did: {ID: did}}}
+	cache := NewCachingResolver(inner, time.Hour, time.Minute, time.Hour)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		doc, err := cache.ResolveDocument(ctx, did)
+		if err != nil || doc.ID != did {
+			t.Fatalf("ResolveDocument() = %v, %v", doc, err)
+		}
+	}
+	if n := inner.lookups.Load(); n != 1 {
+		t.Fatalf("%d lookups, want 1", n)
+	}
+
+	// Failures are cached too
+	if _, err := cache.ResolveDocument(ctx, "did:plc:unknown"); err == nil {
+		t.Fatal("ResolveDocument() = nil error for an unknown DID")
+	}
+	if _, err := cache.ResolveDocument(ctx, "did:plc:unknown"); err == nil {
+		t.Fatal("ResolveDocument() = nil error for an unknown DID")
+	}
+	if n := inner.lookups.Load(); n != 2 {
+		t.Fatalf("%d lookups, want 2", n)
+	}
+
+	// Invalidation forces a new lookup
+	cache.Invalidate(did)
+	if _, err := cache.ResolveDocument(ctx, did); err != nil {
+		t.Fatal(err)
+	}
+	if n := inner.lookups.Load(); n != 3 {
+		t.Fatalf("%d lookups, want 3", n)
+	}
+}
+
+func TestCachingResolverPurgesItself(t *testing.T) {
+	cache := NewCachingResolver(fakeResolver{}, time.Hour, time.Minute, time.Hour)
+
+	// Invalidations and failed lookups from long ago
+	old := time.Now().Add(-2 * time.Hour)
+	cache.mu.Lock()
+	for _, did := range []string{"did:plc:a", "did:plc:b", "did:plc:c"} {
+		cache.invalidated[did] = old
+		cache.entries[did] = &cacheEntry{err: ErrHandleNotFound, fetchedAt: old}
+	}
+	cache.lastPurge = old
+	cache.mu.Unlock()
+
+	// Writing to the cache purges them without anyone calling Purge
+	cache.Invalidate("did:plc:d")
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if len(cache.entries) != 0 {
+		t.Errorf("%d expired entries left, want 0", len(cache.entries))
+	}
+	if len(cache.invalidated) != 1 {
+		t.Errorf("%d invalidations left, want only the new one", len(cache.invalidated))
+	}
+}
@@ -1,39 +1,73 @@
 package identity
 
 import (
-	"crypto/ed25519"
-	"crypto/rand"
-	"encoding/base64"
+	"context"
 	"fmt"
+	"strings"
 )
 
 // DID represents a decentralized identifier
 type DID struct {
 	Method     string
 	Identifier string
-	PublicKey  ed25519.PublicKey
-	PrivateKey ed25519.PrivateKey
+	PublicKey  PublicKey
+	PrivateKey PrivateKey
 }
 
-// NewDID creates a new DID with a generated key pair
+// NewDID creates a new DID with a generated ed25519 key pair
 func NewDID(method string) (*DID, error) {
+	return NewDIDWithKeyType(method, KeyTypeEd25519)
+}
+
+// NewDIDWithKeyType creates a new DID with a generated key pair of the given type
+func NewDIDWithKeyType(method string, keyType KeyType) (*DID, error) {
 	// Generate key pair
-	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	privateKey, err := GenerateKey(keyType)
 	if err != nil {
 		return nil, err
 	}
-
-	// Encode public key as base64
-	pubKeyEncoded := base64.StdEncoding.EncodeToString(publicKey)
+	publicKey := privateKey.Public()
 
 	return &DID{
 		Method:     method,
-		Identifier: pubKeyEncoded[:16], // Use first 16 chars of public key as identifier
+		Identifier: EncodeMultikey(publicKey), // Multibase multikey, as used by did:key
 		PublicKey:  publicKey,
 		PrivateKey: privateKey,
 	}, nil
 }
 
+// NewKeyDID creates a new did:key with a generated ed25519 key pair
+func NewKeyDID() (*DID, error) {
+	return NewDID("key")
+}
+
+// FormatKeyDID returns the did:key string for a public key
+func FormatKeyDID(publicKey PublicKey) string {
+	return "did:key:" + EncodeMultikey(publicKey)
+}
+
+// ParseKeyDID parses a did:key string into a DID with its public key populated
+func ParseKeyDID(didStr string) (*DID, error) {
+	method, identifier, err := SplitDID(didStr)
+	if err != nil {
+		return nil, err
+	}
+	if method != "key" {
+		return nil, fmt.Errorf("not a did:key: %s", didStr)
+	}
+
+	publicKey, err := DecodeMultikey(identifier)
+	if err != nil {
+		return nil, fmt.Errorf("invalid did:key: %w", err)
+	}
+
+	return &DID{
+		Method:     method,
+		Identifier: identifier,
+		PublicKey:  publicKey,
+	}, nil
+}
+
 // String returns the string representation of the DID
 func (d *DID) String() string {
 	return fmt.Sprintf("did:%s:%s", d.Method, d.Identifier)
@@ -44,17 +78,23 @@ func (d *DID) Sign(data []byte) ([]byte, error) {
 	if d.PrivateKey == nil {
 		return nil, fmt.Errorf("private key not available")
 	}
-	return ed25519.Sign(d.PrivateKey, data), nil
+	return d.PrivateKey.Sign(data)
 }
 
 // Verify verifies a signature with the DID's public key
 func (d *DID) Verify(data, signature []byte) bool {
-	return ed25519.Verify(d.PublicKey, data, signature)
+	if d.PublicKey == nil {
+		return false
+	}
+	return d.PublicKey.Verify(data, signature)
 }
 
-// ParseDID parses a DID string
-func ParseDID(didStr string) (*DID, error) {
-	// In a real implementation, this would parse the DID string
-	// and resolve the DID document to get the public key
-	return nil, fmt.Errorf("not implemented")
+// ParseDID parses a DID string and resolves its public key. did:key DIDs are
+// decoded directly; other methods are resolved with resolver.
+// The returned DID has no private key.
+func ParseDID(ctx context.Context, resolver Resolver, didStr string) (*DID, error) {
+	if strings.HasPrefix(didStr, "did:key:") {
+		return ParseKeyDID(didStr)
+	}
+	return resolver.Resolve(ctx, didStr)
 }
@@ -0,0 +1,145 @@
+package identity
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Document represents a DID document
+type Document struct {
+	Context            []string             `json:"@context,omitempty"`
+	ID                 string               `json:"id"`
+	AlsoKnownAs        []string             `json:"alsoKnownAs,omitempty"`
+	VerificationMethod []VerificationMethod `json:"verificationMethod,omitempty"`
+	Service            []Service            `json:"service,omitempty"`
+}
+
+// VerificationMethod represents a public key listed in a DID document
+type VerificationMethod struct {
+	ID                 string `json:"id"`
+	Type               string `json:"type"`
+	Controller         string `json:"controller"`
+	PublicKeyMultibase string `json:"publicKeyMultibase"`
+}
+
+// Service represents a service endpoint listed in a DID document
+type Service struct {
+	ID              string `json:"id"`
+	Type            string `json:"type"`
+	ServiceEndpoint string `json:"serviceEndpoint"`
+}
+
+// DocumentContext is the JSON-LD context of DID documents produced by this package
+var DocumentContext = []string{
+	"https://www.w3.org/ns/did/v1",
+	"https://w3id.org/security/multikey/v1",
+}
+
+// NewDocument creates a DID document with an atproto signing key, handles and an optional PDS endpoint
+func NewDocument(did string, signingKey PublicKey, handles []string, pdsEndpoint string) *Document {
+	doc := &Document{
+		Context:     DocumentContext,
+		ID:          did,
+		AlsoKnownAs: make([]string, 0, len(handles)),
+		VerificationMethod: []VerificationMethod{
+			{
+				ID:                 did + "#atproto",
+				Type:               "Multikey",
+				Controller:         did,
+				PublicKeyMultibase: EncodeMultikey(signingKey),
+			},
+		},
+	}
+
+	for _, handle := range handles {
+		doc.AlsoKnownAs = append(doc.AlsoKnownAs, "at://"+handle)
+	}
+
+	if pdsEndpoint != "" {
+		doc.Service = append(doc.Service, Service{
+			ID:              "#atproto_pds",
+			Type:            "AtprotoPersonalDataServer",
+			ServiceEndpoint: pdsEndpoint,
+		})
+	}
+
+	return doc
+}
+
+// Document creates a DID document for the DID's public key
+func (d *DID) Document(handles []string, pdsEndpoint string) *Document {
+	return NewDocument(d.String(), d.PublicKey, handles, pdsEndpoint)
+}
+
+// Handles returns the handles listed in the document's alsoKnownAs
+func (d *Document) Handles() []string {
+	var handles []string
+	for _, aka := range d.AlsoKnownAs {
+		if strings.HasPrefix(aka, "at://") {
+			handles = append(handles, strings.TrimPrefix(aka, "at://"))
+		}
+	}
+	return handles
+}
+
+// PDSEndpoint returns the PDS service endpoint from the document
+func (d *Document) PDSEndpoint() string {
+	for _, service := range d.Service {
+		if service.ID == "#atproto_pds" || service.ID == d.ID+"#atproto_pds" {
+			return service.ServiceEndpoint
+		}
+	}
+	return ""
+}
+
+// SigningKey returns the atproto signing key from the document
+func (d *Document) SigningKey() (PublicKey, error) {
+	for _, vm := range d.VerificationMethod {
+		if vm.ID != "#atproto" && vm.ID != d.ID+"#atproto" {
+			continue
+		}
+		return DecodeMultikey(vm.PublicKeyMultibase)
+	}
+	return nil, fmt.Errorf("no atproto verification method in document for %s", d.ID)
+}
+
+// DID returns the DID described by the document, with only the public key populated
+func (d *Document) DID() (*DID, error) {
+	method, identifier, err := SplitDID(d.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, err := d.SigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &DID{
+		Method:     method,
+		Identifier: identifier,
+		PublicKey:  publicKey,
+	}, nil
+}
+
+// WebDID returns the did:web DID for a host, percent-encoding any port
+func WebDID(host string) string {
+	return "did:web:" + strings.ReplaceAll(host, ":", "%3A")
+}
+
+// SplitDID splits a DID string into its method and method-specific identifier
+func SplitDID(didStr string) (method, identifier string, err error) {
+	parts := strings.SplitN(didStr, ":", 3)
+	if len(parts) != 3 || parts[0] != "did" {
+		return "", "", fmt.Errorf("invalid DID: %q", didStr)
+	}
+	if parts[1] == "" || parts[2] == "" {
+		return "", "", fmt.Errorf("invalid DID: %q", didStr)
+	}
+	for _, c := range parts[1] {
+		if c < 'a' || c > 'z' {
+			return "", "", fmt.Errorf("invalid DID method: %q", parts[1])
+		}
+	}
+	return parts[1], parts[2], nil
+}
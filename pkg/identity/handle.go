@@ -0,0 +1,223 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrHandleNotFound is returned when a handle does not resolve to a DID
+var ErrHandleNotFound = errors.New("handle not found")
+
+// TXTResolver looks up DNS TXT records. *net.Resolver implements it.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// HTTPDoer performs HTTP requests. *http.Client implements it.
+type HTTPDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// HandleResolver resolves handles to DIDs via DNS TXT records and /.well-known/atproto-did
+type HandleResolver struct {
+	DNS         TXTResolver
+	HTTP        HTTPDoer
+	DIDResolver Resolver
+}
+
+// NewHandleResolver creates a new handle resolver that verifies handles with the DID resolver
+func NewHandleResolver(didResolver Resolver) *HandleResolver {
+	return &HandleResolver{
+		DNS:         net.DefaultResolver,
+		HTTP:        &http.Client{Timeout: 10 * time.Second},
+		DIDResolver: didResolver,
+	}
+}
+
+// NormalizeHandle lowercases a handle and checks its syntax
+func NormalizeHandle(handle string) (string, error) {
+	handle = strings.ToLower(strings.TrimPrefix(handle, "@"))
+	if err := ValidateHandle(handle); err != nil {
+		return "", err
+	}
+	return handle, nil
+}
+
+// ValidateHandle checks that a handle is a syntactically valid domain name
+func ValidateHandle(handle string) error {
+	if len(handle) == 0 || len(handle) > 253 {
+		return fmt.Errorf("invalid handle length: %q", handle)
+	}
+
+	labels := strings.Split(handle, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("handle must contain at least two labels: %q", handle)
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("invalid handle label length: %q", handle)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("handle labels cannot start or end with a hyphen: %q", handle)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("invalid character in handle: %q", handle)
+			}
+		}
+	}
+
+	// The top-level domain cannot start with a digit
+	if tld := labels[len(labels)-1]; tld[0] >= '0' && tld[0] <= '9' {
+		return fmt.Errorf("invalid top-level domain in handle: %q", handle)
+	}
+
+	return nil
+}
+
+// reservedHandleLabels are names that accounts cannot take under a service's
+// handle domain, since they look like the service itself
+var reservedHandleLabels = map[string]bool{
+	"abuse":         true,
+	"admin":         true,
+	"administrator": true,
+	"api":           true,
+	"atproto":       true,
+	"auth":          true,
+	"bgs":           true,
+	"help":          true,
+	"hostmaster":    true,
+	"mail":          true,
+	"moderator":     true,
+	"oauth":         true,
+	"pds":           true,
+	"plc":           true,
+	"postmaster":    true,
+	"root":          true,
+	"security":      true,
+	"staff":         true,
+	"support":       true,
+	"system":        true,
+	"webmaster":     true,
+	"www":           true,
+	"xrpc":          true,
+}
+
+// IsReservedHandle reports whether the first label of a handle is reserved
+func IsReservedHandle(handle string) bool {
+	label, _, _ := strings.Cut(strings.ToLower(handle), ".")
+	return reservedHandleLabels[label]
+}
+
+// ResolveHandle resolves a handle to a DID and checks that the DID document claims the handle
+func (r *HandleResolver) ResolveHandle(ctx context.Context, handle string) (string, error) {
+	handle, err := NormalizeHandle(handle)
+	if err != nil {
+		return "", err
+	}
+
+	did, err := r.LookupHandle(ctx, handle)
+	if err != nil {
+		return "", err
+	}
+
+	// Verify that the DID document points back at the handle
+	doc, err := r.DIDResolver.ResolveDocument(ctx, did)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve DID for handle %s: %w", handle, err)
+	}
+	for _, h := range doc.Handles() {
+		if strings.EqualFold(h, handle) {
+			return did, nil
+		}
+	}
+	return "", fmt.Errorf("DID document for %s does not list handle %s", did, handle)
+}
+
+// LookupHandle finds the DID claimed by a handle without verifying the DID document
+func (r *HandleResolver) LookupHandle(ctx context.Context, handle string) (string, error) {
+	// Try DNS first
+	did, dnsErr := r.lookupDNS(ctx, handle)
+	if dnsErr == nil {
+		return did, nil
+	}
+
+	// Fall back to HTTPS well-known
+	did, httpErr := r.lookupWellKnown(ctx, handle)
+	if httpErr == nil {
+		return did, nil
+	}
+
+	if errors.Is(dnsErr, ErrHandleNotFound) && errors.Is(httpErr, ErrHandleNotFound) {
+		return "", fmt.Errorf("%w: %s", ErrHandleNotFound, handle)
+	}
+	return "", fmt.Errorf("failed to resolve handle %s: dns: %v; https: %v", handle, dnsErr, httpErr)
+}
+
+// lookupDNS resolves a handle from the _atproto TXT record
+func (r *HandleResolver) lookupDNS(ctx context.Context, handle string) (string, error) {
+	records, err := r.DNS.LookupTXT(ctx, "_atproto."+handle)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", ErrHandleNotFound
+		}
+		return "", err
+	}
+
+	var did string
+	for _, record := range records {
+		value, ok := strings.CutPrefix(record, "did=")
+		if !ok {
+			continue
+		}
+		if did != "" && did != value {
+			return "", fmt.Errorf("conflicting _atproto TXT records")
+		}
+		did = value
+	}
+	if did == "" {
+		return "", ErrHandleNotFound
+	}
+	if _, _, err := SplitDID(did); err != nil {
+		return "", err
+	}
+	return did, nil
+}
+
+// lookupWellKnown resolves a handle from https://<handle>/.well-known/atproto-did
+func (r *HandleResolver) lookupWellKnown(ctx context.Context, handle string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+handle+"/.well-known/atproto-did", nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := r.HTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", ErrHandleNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 2048))
+	if err != nil {
+		return "", err
+	}
+	did := strings.TrimSpace(string(body))
+	if _, _, err := SplitDID(did); err != nil {
+		return "", err
+	}
+	return did, nil
+}
@@ -0,0 +1,209 @@
+package identity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// fakeTXT answers TXT lookups from a map; missing names are NXDOMAIN
+type fakeTXT map[string][]string
+
+func (f fakeTXT) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	records, ok := f[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+// failingTXT fails every TXT lookup like an unreachable DNS server
+type failingTXT struct{}
+
+func (failingTXT) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+}
+
+// fakeHTTP serves /.well-known/atproto-did bodies by host; missing hosts are 404
+type fakeHTTP map[string]string
+
+func (f fakeHTTP) Do(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || req.URL.Path != "/.well-known/atproto-did" {
+		return nil, fmt.Errorf("unexpected request to %s", req.URL)
+	}
+	body, ok := f[req.URL.Host]
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusNotFound
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+// fakeResolver serves DID documents from a map
+type fakeResolver map[string]*Document
+
+func (f fakeResolver) ResolveDocument(ctx context.Context, did string) (*Document, error) {
+	doc, ok := f[did]
+	if !ok {
+		return nil, fmt.Errorf("DID not found: %s", did)
+	}
+	return doc, nil
+}
+
+func (f fakeResolver) Resolve(ctx context.Context, did string) (*DID, error) {
+	doc, err := f.ResolveDocument(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	return doc.DID()
+}
+
+func TestResolveHandle(t *testing.T) {
+	const (
+		alice = "did:plc:alice234567abcdefghijklm"
+		bob   = "did:plc:bob34567abcdefghijklmnop"
+	)
+	dids := fakeResolver{
+		alice: {ID: alice, AlsoKnownAs: []string{"at://alice.example.com"}},
+		bob:   {ID: bob, AlsoKnownAs: []string{"at://bob.example.org"}},
+	}
+
+	tests := []struct {
+		name    string
+		dns     TXTResolver
+		http    fakeHTTP
+		handle  string
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "dns",
+			dns:    fakeTXT{"_atproto.alice.example.com": {"did=" + alice}},
+			handle: "alice.example.com",
+			want:   alice,
+		},
+		{
+			name:   "dns ignores other records",
+			dns:    fakeTXT{"_atproto.alice.example.com": {"v=spf1 -all", "did=" + alice}},
+			handle: "alice.example.com",
+			want:   alice,
+		},
+		{
+			name:   "handle is normalized",
+			dns:    fakeTXT{"_atproto.alice.example.com": {"did=" + alice}},
+			handle: "@Alice.Example.COM",
+			want:   alice,
+		},
+		{
+			name:   "well-known fallback",
+			dns:    fakeTXT{},
+			http:   fakeHTTP{"bob.example.org": bob + "\n"},
+			handle: "bob.example.org",
+			want:   bob,
+		},
+		{
+			name:   "well-known after dns failure",
+			dns:    failingTXT{},
+			http:   fakeHTTP{"bob.example.org": bob},
+			handle: "bob.example.org",
+			want:   bob,
+		},
+		{
+			name:    "not found",
+			dns:     fakeTXT{},
+			handle:  "nobody.example.com",
+			wantErr: ErrHandleNotFound,
+		},
+		{
+			name:   "dns failure is not not found",
+			dns:    failingTXT{},
+			handle: "nobody.example.com",
+		},
+		{
+			name:   "conflicting txt records",
+			dns:    fakeTXT{"_atproto.alice.example.com": {"did=" + alice, "did=" + bob}},
+			handle: "alice.example.com",
+		},
+		{
+			name:   "invalid did in txt record",
+			dns:    fakeTXT{"_atproto.alice.example.com": {"did=alice"}},
+			handle: "alice.example.com",
+		},
+		{
+			name:   "document does not claim handle",
+			dns:    fakeTXT{"_atproto.mallory.example.com": {"did=" + alice}},
+			handle: "mallory.example.com",
+		},
+		{
+			name:   "unknown did",
+			dns:    fakeTXT{"_atproto.carol.example.com": {"did=did:plc:carol4567abcdefghijklmno"}},
+			handle: "carol.example.com",
+		},
+		{
+			name:   "invalid handle",
+			dns:    fakeTXT{},
+			handle: "not a handle",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &HandleResolver{DNS: tt.dns, HTTP: tt.http, DIDResolver: dids}
+			got, err := r.ResolveHandle(context.Background(), tt.handle)
+			if tt.want != "" {
+				if err != nil || got != tt.want {
+					t.Fatalf("ResolveHandle(%q) = %q, %v, want %q", tt.handle, got, err, tt.want)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("ResolveHandle(%q) = %q, want an error", tt.handle, got)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("ResolveHandle(%q) error = %v, want %v", tt.handle, err, tt.wantErr)
+			}
+			if tt.wantErr == nil && errors.Is(err, ErrHandleNotFound) {
+				t.Errorf("ResolveHandle(%q) error = %v, want an error other than %v", tt.handle, err, ErrHandleNotFound)
+			}
+		})
+	}
+}
+
+func TestNormalizeHandle(t *testing.T) {
+	tests := []struct {
+		handle string
+		want   string
+	}{
+		{"alice.example.com", "alice.example.com"},
+		{"@Alice.Example.com", "alice.example.com"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"a.b", "a.b"},
+		{"alice", ""},
+		{"alice..example.com", ""},
+		{"-alice.example.com", ""},
+		{"alice-.example.com", ""},
+		{"alice_1.example.com", ""},
+		{"alice.example.123", ""},
+		{strings.Repeat("a", 64) + ".com", ""},
+	}
+	for _, tt := range tests {
+		got, err := NormalizeHandle(tt.handle)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("NormalizeHandle(%q) = %q, want an error", tt.handle, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("NormalizeHandle(%q) = %q, %v, want %q", tt.handle, got, err, tt.want)
+		}
+	}
+}
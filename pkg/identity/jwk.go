@@ -0,0 +1,138 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+}
+
+// jwkCurves maps key types to their JWK curve names
+var jwkCurves = map[KeyType]string{
+	KeyTypeEd25519:   "Ed25519",
+	KeyTypeP256:      "P-256",
+	KeyTypeSecp256k1: "secp256k1",
+}
+
+// NewJWK encodes a public key as a JWK
+func NewJWK(publicKey PublicKey) JWK {
+	jwk := JWK{
+		Crv: jwkCurves[publicKey.Type()],
+		Alg: publicKey.Type().JWTAlg(),
+	}
+
+	switch k := publicKey.(type) {
+	case ed25519Public:
+		jwk.Kty = "OKP"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k)
+	case p256Public:
+		jwk.Kty = "EC"
+		jwk.X = base64.RawURLEncoding.EncodeToString(k.key.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(k.key.Y.FillBytes(make([]byte, 32)))
+	case k256Public:
+		uncompressed := k.key.SerializeUncompressed()
+		jwk.Kty = "EC"
+		jwk.X = base64.RawURLEncoding.EncodeToString(uncompressed[1:33])
+		jwk.Y = base64.RawURLEncoding.EncodeToString(uncompressed[33:])
+	}
+
+	return jwk
+}
+
+// PublicKey decodes the JWK into a public key
+func (j JWK) PublicKey() (PublicKey, error) {
+	x, err := base64.RawURLEncoding.DecodeString(j.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWK x coordinate: %w", err)
+	}
+
+	switch {
+	case j.Kty == "OKP" && j.Crv == "Ed25519":
+		return ParsePublicKey(KeyTypeEd25519, x)
+	case j.Kty == "EC" && j.Crv == "P-256":
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid P-256 JWK")
+		}
+		curve := elliptic.P256()
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid P-256 JWK: point is not on the curve")
+		}
+		return p256Public{key}, nil
+	case j.Kty == "EC" && j.Crv == "secp256k1":
+		y, err := base64.RawURLEncoding.DecodeString(j.Y)
+		if err != nil || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid secp256k1 JWK")
+		}
+		key, err := secp256k1.ParsePubKey(append(append([]byte{0x04}, x...), y...))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secp256k1 JWK: %w", err)
+		}
+		return k256Public{key}, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWK: kty=%s crv=%s", j.Kty, j.Crv)
+	}
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the JWK
+func (j JWK) Thumbprint() string {
+	// Members in lexicographic order, required members only
+	var members interface{}
+	if j.Kty == "OKP" {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{j.Crv, j.Kty, j.X}
+	} else {
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{j.Crv, j.Kty, j.X, j.Y}
+	}
+
+	data, _ := json.Marshal(members)
+	hash := sha256.Sum256(data)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// VerifyJWS verifies a JWS signature from another implementation. Unlike
+// PublicKey.Verify, high-S ECDSA signatures are accepted.
+func VerifyJWS(publicKey PublicKey, data, signature []byte) bool {
+	if len(signature) == 64 {
+		var n *big.Int
+		switch publicKey.Type() {
+		case KeyTypeP256:
+			n = elliptic.P256().Params().N
+		case KeyTypeSecp256k1:
+			n = secp256k1.S256().N
+		}
+		if n != nil {
+			s := new(big.Int).SetBytes(signature[32:])
+			if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+				normalized := append([]byte{}, signature[:32]...)
+				signature = append(normalized, new(big.Int).Sub(n, s).FillBytes(make([]byte, 32))...)
+			}
+		}
+	}
+	return publicKey.Verify(data, signature)
+}
@@ -0,0 +1,299 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	k256ecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// KeyType identifies the curve of a key
+type KeyType string
+
+// Supported key types
+const (
+	KeyTypeEd25519   KeyType = "ed25519"
+	KeyTypeP256      KeyType = "p256"
+	KeyTypeSecp256k1 KeyType = "secp256k1"
+)
+
+// JWTAlg returns the JWT "alg" value for signatures made with this key type
+func (t KeyType) JWTAlg() string {
+	switch t {
+	case KeyTypeEd25519:
+		return "EdDSA"
+	case KeyTypeP256:
+		return "ES256"
+	case KeyTypeSecp256k1:
+		return "ES256K"
+	default:
+		return ""
+	}
+}
+
+// KeyTypeForJWTAlg returns the key type that produces signatures with a JWT "alg" value
+func KeyTypeForJWTAlg(alg string) (KeyType, error) {
+	switch alg {
+	case "EdDSA":
+		return KeyTypeEd25519, nil
+	case "ES256":
+		return KeyTypeP256, nil
+	case "ES256K":
+		return KeyTypeSecp256k1, nil
+	default:
+		return "", fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+}
+
+// PublicKey is a public key of any supported type
+type PublicKey interface {
+	Type() KeyType
+	// Bytes returns the raw key, compressed for elliptic curve keys
+	Bytes() []byte
+	// Verify checks a signature over data. ECDSA signatures are 64-byte r||s with low S.
+	Verify(data, signature []byte) bool
+	Equal(other PublicKey) bool
+}
+
+// PrivateKey is a private key of any supported type
+type PrivateKey interface {
+	Type() KeyType
+	// Bytes returns the raw private key: the seed for ed25519, the scalar for ECDSA
+	Bytes() []byte
+	Public() PublicKey
+	Sign(data []byte) ([]byte, error)
+}
+
+// GenerateKey generates a new private key of the given type
+func GenerateKey(keyType KeyType) (PrivateKey, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519Private{privateKey}, nil
+	case KeyTypeP256:
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return p256Private{privateKey}, nil
+	case KeyTypeSecp256k1:
+		privateKey, err := secp256k1.GeneratePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		return k256Private{privateKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// ParsePrivateKey parses a raw private key of the given type
+func ParsePrivateKey(keyType KeyType, data []byte) (PrivateKey, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		if len(data) != ed25519.SeedSize {
+			return nil, fmt.Errorf("invalid ed25519 seed length: %d", len(data))
+		}
+		return ed25519Private{ed25519.NewKeyFromSeed(data)}, nil
+	case KeyTypeP256:
+		if len(data) != 32 {
+			return nil, fmt.Errorf("invalid P-256 private key length: %d", len(data))
+		}
+		curve := elliptic.P256()
+		d := new(big.Int).SetBytes(data)
+		if d.Sign() == 0 || d.Cmp(curve.Params().N) >= 0 {
+			return nil, fmt.Errorf("invalid P-256 private key")
+		}
+		privateKey := &ecdsa.PrivateKey{D: d}
+		privateKey.PublicKey.Curve = curve
+		privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(data)
+		return p256Private{privateKey}, nil
+	case KeyTypeSecp256k1:
+		if len(data) != 32 {
+			return nil, fmt.Errorf("invalid secp256k1 private key length: %d", len(data))
+		}
+		return k256Private{secp256k1.PrivKeyFromBytes(data)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// ParsePublicKey parses a raw public key of the given type
+func ParsePublicKey(keyType KeyType, data []byte) (PublicKey, error) {
+	switch keyType {
+	case KeyTypeEd25519:
+		if len(data) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 public key length: %d", len(data))
+		}
+		return ed25519Public(append([]byte{}, data...)), nil
+	case KeyTypeP256:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), data)
+		if x == nil {
+			return nil, fmt.Errorf("invalid P-256 public key")
+		}
+		return p256Public{&ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}}, nil
+	case KeyTypeSecp256k1:
+		publicKey, err := secp256k1.ParsePubKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secp256k1 public key: %w", err)
+		}
+		return k256Public{publicKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// NewEd25519PrivateKey wraps an ed25519 private key
+func NewEd25519PrivateKey(privateKey ed25519.PrivateKey) PrivateKey {
+	return ed25519Private{privateKey}
+}
+
+// NewEd25519PublicKey wraps an ed25519 public key
+func NewEd25519PublicKey(publicKey ed25519.PublicKey) PublicKey {
+	return ed25519Public(publicKey)
+}
+
+// ed25519Public is an ed25519 public key
+type ed25519Public ed25519.PublicKey
+
+func (k ed25519Public) Type() KeyType { return KeyTypeEd25519 }
+func (k ed25519Public) Bytes() []byte { return []byte(k) }
+
+func (k ed25519Public) Verify(data, signature []byte) bool {
+	if len(k) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(k), data, signature)
+}
+
+func (k ed25519Public) Equal(other PublicKey) bool {
+	return other != nil && other.Type() == KeyTypeEd25519 && ed25519.PublicKey(k).Equal(ed25519.PublicKey(other.Bytes()))
+}
+
+// ed25519Private is an ed25519 private key
+type ed25519Private struct {
+	key ed25519.PrivateKey
+}
+
+func (k ed25519Private) Type() KeyType     { return KeyTypeEd25519 }
+func (k ed25519Private) Bytes() []byte     { return k.key.Seed() }
+func (k ed25519Private) Public() PublicKey { return ed25519Public(k.key.Public().(ed25519.PublicKey)) }
+
+func (k ed25519Private) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(k.key, data), nil
+}
+
+// p256Public is a NIST P-256 public key
+type p256Public struct {
+	key *ecdsa.PublicKey
+}
+
+func (k p256Public) Type() KeyType { return KeyTypeP256 }
+func (k p256Public) Bytes() []byte { return elliptic.MarshalCompressed(k.key.Curve, k.key.X, k.key.Y) }
+
+func (k p256Public) Verify(data, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	r := new(big.Int).SetBytes(signature[:32])
+	s := new(big.Int).SetBytes(signature[32:])
+
+	// Only accept low-S signatures
+	halfOrder := new(big.Int).Rsh(k.key.Curve.Params().N, 1)
+	if s.Cmp(halfOrder) > 0 {
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+	return ecdsa.Verify(k.key, hash[:], r, s)
+}
+
+func (k p256Public) Equal(other PublicKey) bool {
+	o, ok := other.(p256Public)
+	return ok && k.key.Equal(o.key)
+}
+
+// p256Private is a NIST P-256 private key
+type p256Private struct {
+	key *ecdsa.PrivateKey
+}
+
+func (k p256Private) Type() KeyType     { return KeyTypeP256 }
+func (k p256Private) Bytes() []byte     { return k.key.D.FillBytes(make([]byte, 32)) }
+func (k p256Private) Public() PublicKey { return p256Public{&k.key.PublicKey} }
+
+func (k p256Private) Sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, k.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// Normalize to low S
+	n := k.key.Curve.Params().N
+	if s.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		s.Sub(n, s)
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return signature, nil
+}
+
+// k256Public is a secp256k1 public key
+type k256Public struct {
+	key *secp256k1.PublicKey
+}
+
+func (k k256Public) Type() KeyType { return KeyTypeSecp256k1 }
+func (k k256Public) Bytes() []byte { return k.key.SerializeCompressed() }
+
+func (k k256Public) Verify(data, signature []byte) bool {
+	if len(signature) != 64 {
+		return false
+	}
+	var r, s secp256k1.ModNScalar
+	if r.SetByteSlice(signature[:32]) || s.SetByteSlice(signature[32:]) {
+		return false
+	}
+
+	// Only accept low-S signatures
+	if s.IsOverHalfOrder() {
+		return false
+	}
+
+	hash := sha256.Sum256(data)
+	return k256ecdsa.NewSignature(&r, &s).Verify(hash[:], k.key)
+}
+
+func (k k256Public) Equal(other PublicKey) bool {
+	o, ok := other.(k256Public)
+	return ok && k.key.IsEqual(o.key)
+}
+
+// k256Private is a secp256k1 private key
+type k256Private struct {
+	key *secp256k1.PrivateKey
+}
+
+func (k k256Private) Type() KeyType     { return KeyTypeSecp256k1 }
+func (k k256Private) Bytes() []byte     { return k.key.Serialize() }
+func (k k256Private) Public() PublicKey { return k256Public{k.key.PubKey()} }
+
+func (k k256Private) Sign(data []byte) ([]byte, error) {
+	hash := sha256.Sum256(data)
+
+	// The compact signature is a recovery byte followed by r||s, already low-S
+	compact := k256ecdsa.SignCompact(k.key, hash[:], true)
+	return compact[1:], nil
+}
@@ -0,0 +1,146 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"math/big"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+)
+
+// curveOrder returns the order of the curve of an ECDSA key type
+func curveOrder(keyType KeyType) *big.Int {
+	if keyType == KeyTypeP256 {
+		return elliptic.P256().Params().N
+	}
+	return secp256k1.S256().Params().N
+}
+
+// highS returns the other valid encoding of an ECDSA signature, with S replaced
+// by N-S
+func highS(keyType KeyType, signature []byte) []byte {
+	s := new(big.Int).SetBytes(signature[32:])
+	s.Sub(curveOrder(keyType), s)
+
+	out := append([]byte{}, signature[:32]...)
+	return append(out, s.FillBytes(make([]byte, 32))...)
+}
+
+func TestSignVerify(t *testing.T) {
+	data := []byte("commit bytes")
+	for _, keyType := range []KeyType{KeyTypeEd25519, KeyTypeP256, KeyTypeSecp256k1} {
+		t.Run(string(keyType), func(t *testing.T) {
+			key, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			other, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signature, err := key.Sign(data)
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if len(signature) != 64 {
+				t.Errorf("signature is %d bytes, want 64", len(signature))
+			}
+			if !key.Public().Verify(data, signature) {
+				t.Error("Verify() = false for a valid signature")
+			}
+
+			if key.Public().Verify([]byte("other bytes"), signature) {
+				t.Error("Verify() = true for other data")
+			}
+			if other.Public().Verify(data, signature) {
+				t.Error("Verify() = true for another key")
+			}
+			if key.Public().Verify(data, signature[:63]) {
+				t.Error("Verify() = true for a truncated signature")
+			}
+		})
+	}
+}
+
+func TestVerifyRequiresLowS(t *testing.T) {
+	data := []byte("commit bytes")
+	for _, keyType := range []KeyType{KeyTypeP256, KeyTypeSecp256k1} {
+		t.Run(string(keyType), func(t *testing.T) {
+			key, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			halfOrder := new(big.Int).Rsh(curveOrder(keyType), 1)
+
+			// Sign normalizes every signature to low S
+			for i := 0; i < 20; i++ {
+				signature, err := key.Sign(data)
+				if err != nil {
+					t.Fatalf("Sign: %v", err)
+				}
+				if new(big.Int).SetBytes(signature[32:]).Cmp(halfOrder) > 0 {
+					t.Fatalf("Sign() returned a high-S signature %x", signature)
+				}
+
+				// The high-S form is valid ECDSA but must be rejected
+				if key.Public().Verify(data, highS(keyType, signature)) {
+					t.Fatal("Verify() = true for a high-S signature")
+				}
+			}
+		})
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeEd25519, KeyTypeP256, KeyTypeSecp256k1} {
+		t.Run(string(keyType), func(t *testing.T) {
+			key, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			parsed, err := ParsePrivateKey(keyType, key.Bytes())
+			if err != nil {
+				t.Fatalf("ParsePrivateKey: %v", err)
+			}
+			if !bytes.Equal(parsed.Bytes(), key.Bytes()) {
+				t.Error("parsed private key differs")
+			}
+			public, err := ParsePublicKey(keyType, key.Public().Bytes())
+			if err != nil {
+				t.Fatalf("ParsePublicKey: %v", err)
+			}
+			if !public.Equal(key.Public()) {
+				t.Error("parsed public key differs")
+			}
+
+			// Signatures of the parsed key verify with the original
+			signature, err := parsed.Sign([]byte("data"))
+			if err != nil {
+				t.Fatalf("Sign: %v", err)
+			}
+			if !key.Public().Verify([]byte("data"), signature) {
+				t.Error("Verify() = false for a signature of the parsed key")
+			}
+		})
+	}
+}
+
+func TestKeyTypeForJWTAlg(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeEd25519, KeyTypeP256, KeyTypeSecp256k1} {
+		got, err := KeyTypeForJWTAlg(keyType.JWTAlg())
+		if err != nil {
+			t.Fatalf("KeyTypeForJWTAlg(%s): %v", keyType.JWTAlg(), err)
+		}
+		if got != keyType {
+			t.Errorf("KeyTypeForJWTAlg(%s) = %s, want %s", keyType.JWTAlg(), got, keyType)
+		}
+	}
+	for _, alg := range []string{"", "none", "HS256", "RS256", "es256"} {
+		if _, err := KeyTypeForJWTAlg(alg); err == nil {
+			t.Errorf("KeyTypeForJWTAlg(%q) = nil error", alg)
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package identity
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Key purposes
+const (
+	KeyPurposeSigning  = "signing"
+	KeyPurposeRotation = "rotation"
+)
+
+// MasterKeySize is the size of a keystore master key (AES-256)
+const MasterKeySize = 32
+
+// KeyPublisher publishes a new signing key to an account's DID document
+type KeyPublisher interface {
+	PublishSigningKey(ctx context.Context, did string, signingKey PublicKey, rotationKey PrivateKey) error
+}
+
+// Keystore stores account private keys in Postgres, encrypted with a master key
+type Keystore struct {
+	db        *pgxpool.Pool
+	currentID string
+	ciphers   map[string]cipher.AEAD
+}
+
+// NewKeystore creates a new keystore. Keys are encrypted with masterKey;
+// previousKeys are only used to decrypt keys stored before a master key rotation.
+func NewKeystore(db *pgxpool.Pool, masterKey []byte, previousKeys ...[]byte) (*Keystore, error) {
+	ks := &Keystore{
+		db:      db,
+		ciphers: make(map[string]cipher.AEAD),
+	}
+
+	for i, key := range append([][]byte{masterKey}, previousKeys...) {
+		id, aead, err := newMasterCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			ks.currentID = id
+		}
+		ks.ciphers[id] = aead
+	}
+
+	return ks, nil
+}
+
+// newMasterCipher creates an AES-GCM cipher and key ID for a master key
+func newMasterCipher(masterKey []byte) (string, cipher.AEAD, error) {
+	if len(masterKey) != MasterKeySize {
+		return "", nil, fmt.Errorf("master key must be %d bytes", MasterKeySize)
+	}
+	block, err := aes.NewCipher(masterKey)
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", nil, err
+	}
+	sum := sha256.Sum256(masterKey)
+	return hex.EncodeToString(sum[:8]), aead, nil
+}
+
+// LoadMasterKey loads a hex-encoded master key from the named environment
+// variable, or from the file named by the same variable with a _FILE suffix
+func LoadMasterKey(envVar string) ([]byte, error) {
+	value := os.Getenv(envVar)
+	if value == "" {
+		path := os.Getenv(envVar + "_FILE")
+		if path == "" {
+			return nil, fmt.Errorf("%s or %s_FILE must be set", envVar, envVar)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master key file: %w", err)
+		}
+		value = string(data)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key encoding: %w", err)
+	}
+	if len(key) != MasterKeySize {
+		return nil, fmt.Errorf("master key must be %d bytes", MasterKeySize)
+	}
+	return key, nil
+}
+
+// encrypt encrypts a private key with the current master key, bound to the DID, purpose and key type
+func (k *Keystore) encrypt(did, purpose string, privateKey PrivateKey) ([]byte, error) {
+	_, data, err := k.Seal(privateKey.Bytes(), did+"|"+purpose+"|"+string(privateKey.Type()))
+	return data, err
+}
+
+// decrypt decrypts a private key with the master key it was stored under
+func (k *Keystore) decrypt(did, purpose string, keyType KeyType, keyID string, data []byte) (PrivateKey, error) {
+	raw, err := k.Open(keyID, data, did+"|"+purpose+"|"+string(keyType))
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(keyType, raw)
+}
+
+// Seal encrypts a secret with the current master key, bound to aad. It returns
+// the ID of the master key, which must be stored with the secret to open it.
+func (k *Keystore) Seal(secret []byte, aad string) (string, []byte, error) {
+	aead := k.ciphers[k.currentID]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", nil, err
+	}
+	return k.currentID, aead.Seal(nonce, nonce, secret, []byte(aad)), nil
+}
+
+// Open decrypts a secret sealed with Seal under the master key keyID
+func (k *Keystore) Open(keyID string, data []byte, aad string) ([]byte, error) {
+	aead, ok := k.ciphers[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key: %s", keyID)
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted key too short")
+	}
+	raw, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(aad))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key: %w", err)
+	}
+	return raw, nil
+}
+
+// SaveKey stores a private key for an account, replacing any existing key with the same purpose
+func (k *Keystore) SaveKey(ctx context.Context, did, purpose string, privateKey PrivateKey) error {
+	encrypted, err := k.encrypt(did, purpose, privateKey)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt key: %w", err)
+	}
+
+	query := `
+		INSERT INTO account_keys (did, purpose, key_type, public_key, encrypted_key, master_key_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (did, purpose) DO UPDATE
+		SET key_type = EXCLUDED.key_type,
+			public_key = EXCLUDED.public_key,
+			encrypted_key = EXCLUDED.encrypted_key,
+			master_key_id = EXCLUDED.master_key_id,
+			updated_at = EXCLUDED.updated_at
+	`
+	now := time.Now()
+	_, err = k.db.Exec(ctx, query,
+		did,
+		purpose,
+		privateKey.Type(),
+		EncodeMultikey(privateKey.Public()),
+		encrypted,
+		k.currentID,
+		now,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	return nil
+}
+
+// GetKey loads and decrypts a private key for an account
+func (k *Keystore) GetKey(ctx context.Context, did, purpose string) (PrivateKey, error) {
+	query := `
+		SELECT key_type, encrypted_key, master_key_id
+		FROM account_keys
+		WHERE did = $1 AND purpose = $2
+	`
+	var keyType KeyType
+	var encrypted []byte
+	var keyID string
+	if err := k.db.QueryRow(ctx, query, did, purpose).Scan(&keyType, &encrypted, &keyID); err != nil {
+		return nil, fmt.Errorf("failed to get key: %w", err)
+	}
+	return k.decrypt(did, purpose, keyType, keyID, encrypted)
+}
+
+// GetSigningDID loads an account's signing key as a DID that can sign
+func (k *Keystore) GetSigningDID(ctx context.Context, did string) (*DID, error) {
+	privateKey, err := k.GetKey(ctx, did, KeyPurposeSigning)
+	if err != nil {
+		return nil, err
+	}
+	method, identifier, err := SplitDID(did)
+	if err != nil {
+		return nil, err
+	}
+	return &DID{
+		Method:     method,
+		Identifier: identifier,
+		PublicKey:  privateKey.Public(),
+		PrivateKey: privateKey,
+	}, nil
+}
+
+// DeleteKeys deletes all keys for an account
+func (k *Keystore) DeleteKeys(ctx context.Context, did string) error {
+	query := `
+		DELETE FROM account_keys
+		WHERE did = $1
+	`
+	if _, err := k.db.Exec(ctx, query, did); err != nil {
+		return fmt.Errorf("failed to delete keys: %w", err)
+	}
+	return nil
+}
+
+// RotateSigningKey generates a new signing key of the given type for an account, stores it
+// and then publishes it with the account's rotation key. The old key is restored if
+// publishing fails, so a published key is never one the keystore failed to store
+func (k *Keystore) RotateSigningKey(ctx context.Context, did string, keyType KeyType, publisher KeyPublisher) (PublicKey, error) {
+	rotationKey, err := k.GetKey(ctx, did, KeyPurposeRotation)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate new signing key
+	privateKey, err := GenerateKey(keyType)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := privateKey.Public()
+	encrypted, err := k.encrypt(did, KeyPurposeSigning, privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt key: %w", err)
+	}
+
+	// Replace the stored key, remembering the old one
+	tx, err := k.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var oldType, oldPublic, oldMasterKeyID string
+	var oldEncrypted []byte
+	err = tx.QueryRow(ctx, `
+		SELECT key_type, public_key, encrypted_key, master_key_id
+		FROM account_keys
+		WHERE did = $1 AND purpose = $2
+		FOR UPDATE
+	`, did, KeyPurposeSigning).Scan(&oldType, &oldPublic, &oldEncrypted, &oldMasterKeyID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing key: %w", err)
+	}
+
+	query := `
+		UPDATE account_keys
+		SET key_type = $1, public_key = $2, encrypted_key = $3, master_key_id = $4, updated_at = $5
+		WHERE did = $6 AND purpose = $7
+	`
+	_, err = tx.Exec(ctx, query,
+		keyType,
+		EncodeMultikey(publicKey),
+		encrypted,
+		k.currentID,
+		time.Now(),
+		did,
+		KeyPurposeSigning,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save key: %w", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit signing key: %w", err)
+	}
+
+	// Publish new key to the DID document, restoring the old key if that fails
+	if err := publisher.PublishSigningKey(ctx, did, publicKey, rotationKey); err != nil {
+		restore := `
+			UPDATE account_keys
+			SET key_type = $1, public_key = $2, encrypted_key = $3, master_key_id = $4, updated_at = $5
+			WHERE did = $6 AND purpose = $7 AND public_key = $8
+		`
+		if _, restoreErr := k.db.Exec(ctx, restore, oldType, oldPublic, oldEncrypted, oldMasterKeyID, time.Now(), did, KeyPurposeSigning, EncodeMultikey(publicKey)); restoreErr != nil {
+			return nil, fmt.Errorf("failed to publish signing key: %w (and failed to restore the old key: %v)", err, restoreErr)
+		}
+		return nil, fmt.Errorf("failed to publish signing key: %w", err)
+	}
+	return publicKey, nil
+}
+
+// Reencrypt re-encrypts every key that is not stored under the current master key
+func (k *Keystore) Reencrypt(ctx context.Context) (int, error) {
+	tx, err := k.db.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		SELECT did, purpose, key_type, encrypted_key, master_key_id
+		FROM account_keys
+		WHERE master_key_id <> $1
+		FOR UPDATE
+	`
+	rows, err := tx.Query(ctx, query, k.currentID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get keys: %w", err)
+	}
+
+	type storedKey struct {
+		did, purpose string
+		privateKey   PrivateKey
+	}
+	var keys []storedKey
+	for rows.Next() {
+		var did, purpose, keyID string
+		var keyType KeyType
+		var encrypted []byte
+		if err := rows.Scan(&did, &purpose, &keyType, &encrypted, &keyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan key: %w", err)
+		}
+		privateKey, err := k.decrypt(did, purpose, keyType, keyID, encrypted)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to decrypt key for %s: %w", did, err)
+		}
+		keys = append(keys, storedKey{did: did, purpose: purpose, privateKey: privateKey})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating keys: %w", err)
+	}
+
+	for _, key := range keys {
+		encrypted, err := k.encrypt(key.did, key.purpose, key.privateKey)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encrypt key: %w", err)
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE account_keys
+			SET encrypted_key = $1, master_key_id = $2, updated_at = $3
+			WHERE did = $4 AND purpose = $5
+		`, encrypted, k.currentID, time.Now(), key.did, key.purpose)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update key: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("failed to commit re-encrypted keys: %w", err)
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,189 @@
+package identity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testMasterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, MasterKeySize)
+}
+
+func TestKeystoreSealOpen(t *testing.T) {
+	ks, err := NewKeystore(nil, testMasterKey(1))
+	if err != nil {
+		t.Fatalf("NewKeystore: %v", err)
+	}
+
+	secret := []byte("totp secret")
+	keyID, sealed, err := ks.Seal(secret, "did:plc:alice|totp")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if bytes.Contains(sealed, secret) {
+		t.Fatal("sealed data contains the secret")
+	}
+
+	opened, err := ks.Open(keyID, sealed, "did:plc:alice|totp")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(opened, secret) {
+		t.Errorf("Open() = %q, want %q", opened, secret)
+	}
+
+	// Sealing twice uses a fresh nonce
+	_, again, err := ks.Seal(secret, "did:plc:alice|totp")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing the same secret twice gave the same ciphertext")
+	}
+}
+
+func TestKeystoreOpenRejects(t *testing.T) {
+	ks, err := NewKeystore(nil, testMasterKey(1))
+	if err != nil {
+		t.Fatalf("NewKeystore: %v", err)
+	}
+	keyID, sealed, err := ks.Seal([]byte("secret"), "did:plc:alice|totp")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+
+	tests := []struct {
+		name  string
+		keyID string
+		data  []byte
+		aad   string
+	}{
+		{"other account", keyID, sealed, "did:plc:bob|totp"},
+		{"other purpose", keyID, sealed, "did:plc:alice|signing|ed25519"},
+		{"tampered", keyID, tampered, "did:plc:alice|totp"},
+		{"truncated", keyID, sealed[:4], "did:plc:alice|totp"},
+		{"unknown master key", "0000000000000000", sealed, "did:plc:alice|totp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ks.Open(tt.keyID, tt.data, tt.aad); err == nil {
+				t.Error("Open() = nil error, want an error")
+			}
+		})
+	}
+}
+
+func TestKeystorePreviousMasterKey(t *testing.T) {
+	old, err := NewKeystore(nil, testMasterKey(1))
+	if err != nil {
+		t.Fatalf("NewKeystore: %v", err)
+	}
+	oldID, sealed, err := old.Seal([]byte("secret"), "aad")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	// After a master key rotation, secrets sealed under the old key still open
+	rotated, err := NewKeystore(nil, testMasterKey(2), testMasterKey(1))
+	if err != nil {
+		t.Fatalf("NewKeystore: %v", err)
+	}
+	opened, err := rotated.Open(oldID, sealed, "aad")
+	if err != nil || string(opened) != "secret" {
+		t.Fatalf("Open() = %q, %v, want secret", opened, err)
+	}
+	newID, _, err := rotated.Seal([]byte("secret"), "aad")
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if newID == oldID {
+		t.Error("rotated keystore seals with the previous master key")
+	}
+
+	// Without the old key they do not
+	fresh, err := NewKeystore(nil, testMasterKey(2))
+	if err != nil {
+		t.Fatalf("NewKeystore: %v", err)
+	}
+	if _, err := fresh.Open(oldID, sealed, "aad"); err == nil {
+		t.Error("Open() = nil error without the previous master key")
+	}
+}
+
+func TestKeystoreEncryptPrivateKey(t *testing.T) {
+	ks, err := NewKeystore(nil, testMasterKey(1))
+	if err != nil {
+		t.Fatalf("NewKeystore: %v", err)
+	}
+	for _, keyType := range []KeyType{KeyTypeEd25519, KeyTypeP256, KeyTypeSecp256k1} {
+		t.Run(string(keyType), func(t *testing.T) {
+			key, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatalf("GenerateKey: %v", err)
+			}
+			encrypted, err := ks.encrypt("did:plc:alice", KeyPurposeSigning, key)
+			if err != nil {
+				t.Fatalf("encrypt: %v", err)
+			}
+			decrypted, err := ks.decrypt("did:plc:alice", KeyPurposeSigning, keyType, ks.currentID, encrypted)
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(decrypted.Bytes(), key.Bytes()) {
+				t.Error("decrypted key differs from the original")
+			}
+			if _, err := ks.decrypt("did:plc:alice", KeyPurposeRotation, keyType, ks.currentID, encrypted); err == nil {
+				t.Error("decrypt() = nil error for the wrong purpose")
+			}
+		})
+	}
+}
+
+func TestNewKeystoreKeySize(t *testing.T) {
+	if _, err := NewKeystore(nil, make([]byte, 16)); err == nil {
+		t.Error("NewKeystore() = nil error for a 16-byte master key")
+	}
+}
+
+func TestLoadMasterKey(t *testing.T) {
+	key := testMasterKey(7)
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("TEST_MASTER_KEY", hex.EncodeToString(key))
+		got, err := LoadMasterKey("TEST_MASTER_KEY")
+		if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("LoadMasterKey() = %x, %v, want %x", got, err, key)
+		}
+	})
+	t.Run("file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.key")
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("TEST_MASTER_KEY", "")
+		t.Setenv("TEST_MASTER_KEY_FILE", path)
+		got, err := LoadMasterKey("TEST_MASTER_KEY")
+		if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("LoadMasterKey() = %x, %v, want %x", got, err, key)
+		}
+	})
+	t.Run("unset", func(t *testing.T) {
+		t.Setenv("TEST_MASTER_KEY", "")
+		t.Setenv("TEST_MASTER_KEY_FILE", "")
+		if _, err := LoadMasterKey("TEST_MASTER_KEY"); err == nil {
+			t.Fatal("LoadMasterKey() = nil error when unset")
+		}
+	})
+	t.Run("short", func(t *testing.T) {
+		t.Setenv("TEST_MASTER_KEY", "abcd")
+		if _, err := LoadMasterKey("TEST_MASTER_KEY"); err == nil {
+			t.Fatal("LoadMasterKey() = nil error for a short key")
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package identity
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+)
+
+// base58Alphabet is the bitcoin base58 alphabet used by multibase "z"
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// multicodecPrefixes are the varint-encoded multicodec prefixes of each key type
+var multicodecPrefixes = map[KeyType][]byte{
+	KeyTypeEd25519:   {0xed, 0x01},
+	KeyTypeP256:      {0x80, 0x24},
+	KeyTypeSecp256k1: {0xe7, 0x01},
+}
+
+// EncodeMultikey encodes a public key as a base58btc multibase multikey
+func EncodeMultikey(publicKey PublicKey) string {
+	data := append(append([]byte{}, multicodecPrefixes[publicKey.Type()]...), publicKey.Bytes()...)
+	return "z" + base58Encode(data)
+}
+
+// DecodeMultikey decodes a base58btc multibase multikey into a public key
+func DecodeMultikey(multikey string) (PublicKey, error) {
+	if len(multikey) < 2 || multikey[0] != 'z' {
+		return nil, fmt.Errorf("unsupported multibase encoding: %q", multikey)
+	}
+
+	data, err := base58Decode(multikey[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multibase value: %w", err)
+	}
+
+	// Find key type from multicodec prefix
+	for keyType, prefix := range multicodecPrefixes {
+		if bytes.HasPrefix(data, prefix) {
+			return ParsePublicKey(keyType, data[len(prefix):])
+		}
+	}
+	return nil, fmt.Errorf("unsupported key type")
+}
+
+// base58Encode encodes data with the base58btc alphabet
+func base58Encode(data []byte) string {
+	num := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var out []byte
+	for num.Sign() > 0 {
+		num.DivMod(num, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+
+	// Leading zero bytes are encoded as leading '1' characters
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+
+	// Reverse
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	return string(out)
+}
+
+// base58Decode decodes a base58btc string
+func base58Decode(s string) ([]byte, error) {
+	num := new(big.Int)
+	radix := big.NewInt(58)
+
+	for _, c := range s {
+		idx := -1
+		for i, a := range base58Alphabet {
+			if a == c {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("invalid base58 character: %q", c)
+		}
+		num.Mul(num, radix)
+		num.Add(num, big.NewInt(int64(idx)))
+	}
+
+	decoded := num.Bytes()
+
+	// Restore leading zero bytes
+	zeros := 0
+	for zeros < len(s) && s[zeros] == base58Alphabet[0] {
+		zeros++
+	}
+
+	return append(make([]byte, zeros), decoded...), nil
+}
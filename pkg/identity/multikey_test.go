@@ -0,0 +1,122 @@
+package identity
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestBase58(t *testing.T) {
+	// Vectors of the base58 encoding draft (draft-msporny-base58)
+	tests := []struct {
+		data    string
+		encoded string
+	}{
+		{"", ""},
+		{"Hello World!", "2NEpo7TZRRrLZSi2U"},
+		{"The quick brown fox jumps over the lazy dog.", "USm3fpXnKG5EUBx2ndxBDMPVciP5hGey2Jh4NDv6gmeo1LkMeiKrLJUUBk6Z"},
+		{"\x00\x00\x28\x7f\xb4\xcd", "11233QC4"},
+		{"\x00", "1"},
+	}
+	for _, tt := range tests {
+		if got := base58Encode([]byte(tt.data)); got != tt.encoded {
+			t.Errorf("base58Encode(%x) = %q, want %q", tt.data, got, tt.encoded)
+		}
+		decoded, err := base58Decode(tt.encoded)
+		if err != nil {
+			t.Fatalf("base58Decode(%q): %v", tt.encoded, err)
+		}
+		if !bytes.Equal(decoded, []byte(tt.data)) {
+			t.Errorf("base58Decode(%q) = %x, want %x", tt.encoded, decoded, tt.data)
+		}
+	}
+
+	// 0, O, I and l are not in the alphabet
+	for _, s := range []string{"0", "O", "I", "l", "2NEpo7TZ0RrLZSi2U"} {
+		if _, err := base58Decode(s); err == nil {
+			t.Errorf("base58Decode(%q) = nil error", s)
+		}
+	}
+}
+
+func TestKeyDIDVectors(t *testing.T) {
+	// Vectors of the did:key spec and the atproto interop tests
+	tests := []struct {
+		keyType    KeyType
+		privateKey string
+		did        string
+	}{
+		{KeyTypeEd25519, "0000000000000000000000000000000000000000000000000000000000000000", "did:key:z6MkiTBz1ymuepAQ4HEHYSF1H8quG5GLVVQR3djdX3mDooWp"},
+		{KeyTypeP256, "82ebbd63ebbd9ff60141a69bd4c9be282f2415e8eafa9d42c0ed396daccca979", "did:key:zDnaeTiq1PdzvZXUaMdezchcMJQpBdH2VN4pgrrEhMCCbmwSb"},
+		{KeyTypeSecp256k1, "9085d2bef69286a6cbb51623c8fa258629945cd55ca705cc4e66700396894e0c", "did:key:zQ3shokFTS3brHcDQrn82RUDfCZESWL1ZdCEJwekUDPQiYBme"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.keyType), func(t *testing.T) {
+			data, err := hex.DecodeString(tt.privateKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			privateKey, err := ParsePrivateKey(tt.keyType, data)
+			if err != nil {
+				t.Fatalf("ParsePrivateKey: %v", err)
+			}
+			if got := FormatKeyDID(privateKey.Public()); got != tt.did {
+				t.Errorf("FormatKeyDID() = %s, want %s", got, tt.did)
+			}
+
+			parsed, err := ParseKeyDID(tt.did)
+			if err != nil {
+				t.Fatalf("ParseKeyDID: %v", err)
+			}
+			if parsed.PublicKey.Type() != tt.keyType {
+				t.Errorf("key type = %s, want %s", parsed.PublicKey.Type(), tt.keyType)
+			}
+			if !parsed.PublicKey.Equal(privateKey.Public()) {
+				t.Error("parsed key does not match the private key")
+			}
+		})
+	}
+}
+
+func TestMultikeyRoundTrip(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeEd25519, KeyTypeP256, KeyTypeSecp256k1} {
+		t.Run(string(keyType), func(t *testing.T) {
+			privateKey, err := GenerateKey(keyType)
+			if err != nil {
+				t.Fatal(err)
+			}
+			multikey := EncodeMultikey(privateKey.Public())
+
+			decoded, err := DecodeMultikey(multikey)
+			if err != nil {
+				t.Fatalf("DecodeMultikey: %v", err)
+			}
+			if decoded.Type() != keyType {
+				t.Errorf("key type = %s, want %s", decoded.Type(), keyType)
+			}
+			if !bytes.Equal(decoded.Bytes(), privateKey.Public().Bytes()) {
+				t.Errorf("DecodeMultikey() = %x, want %x", decoded.Bytes(), privateKey.Public().Bytes())
+			}
+		})
+	}
+}
+
+func TestDecodeMultikeyInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		multikey string
+	}{
+		{"empty", ""},
+		{"base64 multibase", "mAAAA"},
+		{"invalid base58", "z0OIl"},
+		{"unknown multicodec", "z" + base58Encode([]byte{0x12, 0x00, 0x01, 0x02})},
+		{"truncated key", "z" + base58Encode([]byte{0xe7, 0x01, 0x02, 0x03})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecodeMultikey(tt.multikey); err == nil {
+				t.Errorf("DecodeMultikey(%q) = nil error", tt.multikey)
+			}
+		})
+	}
+}
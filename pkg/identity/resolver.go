@@ -0,0 +1,148 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultPLCURL is the PLC directory used when none is configured
+const DefaultPLCURL = "https://plc.directory"
+
+// maxDocumentSize limits the size of a fetched DID document
+const maxDocumentSize = 1 << 20
+
+// Resolver resolves DIDs to their DID documents and public keys
+type Resolver interface {
+	ResolveDocument(ctx context.Context, did string) (*Document, error)
+	Resolve(ctx context.Context, did string) (*DID, error)
+}
+
+// BasicResolver resolves did:plc and did:web DIDs over HTTP, and did:key DIDs locally
+type BasicResolver struct {
+	PLCURL     string
+	HTTPClient *http.Client
+}
+
+// NewResolver creates a new resolver using the given PLC directory URL
+func NewResolver(plcURL string) *BasicResolver {
+	if plcURL == "" {
+		plcURL = DefaultPLCURL
+	}
+	return &BasicResolver{
+		PLCURL:     strings.TrimSuffix(plcURL, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Resolve resolves a DID to a DID with its public key populated
+func (r *BasicResolver) Resolve(ctx context.Context, did string) (*DID, error) {
+	doc, err := r.ResolveDocument(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	return doc.DID()
+}
+
+// ResolveDocument fetches the DID document for a DID
+func (r *BasicResolver) ResolveDocument(ctx context.Context, did string) (*Document, error) {
+	method, identifier, err := SplitDID(did)
+	if err != nil {
+		return nil, err
+	}
+
+	// Build document URL
+	var docURL string
+	switch method {
+	case "key":
+		// did:key documents are derived from the key itself
+		keyDID, err := ParseKeyDID(did)
+		if err != nil {
+			return nil, err
+		}
+		return NewDocument(did, keyDID.PublicKey, nil, ""), nil
+	case "plc":
+		docURL = r.PLCURL + "/" + url.PathEscape(did)
+	case "web":
+		docURL, err = webDocumentURL(identifier)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported DID method: %s", method)
+	}
+
+	doc, err := r.fetchDocument(ctx, docURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", did, err)
+	}
+
+	// Make sure the document describes the requested DID
+	if doc.ID != did {
+		return nil, fmt.Errorf("DID document id %q does not match %q", doc.ID, did)
+	}
+
+	return doc, nil
+}
+
+// fetchDocument fetches and decodes a DID document
+func (r *BasicResolver) fetchDocument(ctx context.Context, docURL string) (*Document, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, docURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/did+ld+json, application/json")
+
+	client := r.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("DID not found")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	var doc Document
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxDocumentSize)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid DID document: %w", err)
+	}
+	return &doc, nil
+}
+
+// webDocumentURL returns the URL of the DID document for a did:web identifier
+func webDocumentURL(identifier string) (string, error) {
+	// Only hostname-level did:web identifiers are supported
+	if strings.Contains(identifier, ":") {
+		return "", fmt.Errorf("did:web with path is not supported: %s", identifier)
+	}
+
+	// Ports are percent-encoded in the identifier
+	host, err := url.PathUnescape(identifier)
+	if err != nil {
+		return "", fmt.Errorf("invalid did:web identifier: %w", err)
+	}
+	if host == "" || strings.ContainsAny(host, "/?#@") {
+		return "", fmt.Errorf("invalid did:web identifier: %s", identifier)
+	}
+
+	scheme := "https"
+	if hostname := strings.Split(host, ":")[0]; hostname == "localhost" {
+		scheme = "http"
+	}
+
+	return scheme + "://" + host + "/.well-known/did.json", nil
+}
@@ -0,0 +1,155 @@
+package identity
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestWebDocumentURL(t *testing.T) {
+	tests := []struct {
+		identifier string
+		want       string
+		wantErr    bool
+	}{
+		{"example.com", "https://example.com/.well-known/did.json", false},
+		{"example.com%3A8443", "https://example.com:8443/.well-known/did.json", false},
+		{"localhost%3A8082", "http://localhost:8082/.well-known/did.json", false},
+		{"example.com:user:alice", "", true},
+		{"example.com%2Fpath", "", true},
+		{"user%40example.com", "", true},
+		{"example.com%zz", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.identifier, func(t *testing.T) {
+			got, err := webDocumentURL(tt.identifier)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("webDocumentURL() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("webDocumentURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// serveDocuments serves DID documents by request path
+func serveDocuments(t *testing.T, docs map[string]*Document) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		doc, ok := docs[r.URL.EscapedPath()]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/did+ld+json")
+		if err := json.NewEncoder(w).Encode(doc); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestResolveWeb(t *testing.T) {
+	key, err := GenerateKey(KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs := make(map[string]*Document)
+	ts := httptest.NewTLSServer(serveDocuments(t, docs))
+	defer ts.Close()
+
+	// The test server's port is percent-encoded in the identifier
+	host := strings.TrimPrefix(ts.URL, "https://")
+	did := "did:web:" + strings.ReplaceAll(host, ":", "%3A")
+	docs["/.well-known/did.json"] = NewDocument(did, key.Public(), []string{"alice.example.com"}, "https://pds.example.com")
+
+	resolver := NewResolver("")
+	resolver.HTTPClient = ts.Client()
+
+	resolved, err := resolver.Resolve(context.Background(), did)
+	if err != nil {
+		t.Fatalf("Resolve: %v", err)
+	}
+	if FormatKeyDID(resolved.PublicKey) != FormatKeyDID(key.Public()) {
+		t.Error("resolved key does not match the document")
+	}
+	parsed, err := ParseDID(context.Background(), resolver, did)
+	if err != nil {
+		t.Fatalf("ParseDID: %v", err)
+	}
+	if FormatKeyDID(parsed.PublicKey) != FormatKeyDID(key.Public()) {
+		t.Error("ParseDID key does not match the document")
+	}
+
+	// A document for another DID is rejected
+	docs["/.well-known/did.json"] = NewDocument("did:web:other.example.com", key.Public(), nil, "")
+	if _, err := resolver.ResolveDocument(context.Background(), did); err == nil {
+		t.Error("ResolveDocument() = nil error for a document of another DID")
+	}
+}
+
+func TestResolvePLC(t *testing.T) {
+	const did = "did:plc:alice234567abcdefghijklm"
+	key, err := GenerateKey(KeyTypeP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(serveDocuments(t, map[string]*Document{
+		"/" + url.PathEscape(did): NewDocument(did, key.Public(), []string{"alice.example.com"}, "https://pds.example.com"),
+	}))
+	defer ts.Close()
+
+	// The directory URL may be configured with a trailing slash
+	resolver := NewResolver(ts.URL + "/")
+
+	doc, err := resolver.ResolveDocument(context.Background(), did)
+	if err != nil {
+		t.Fatalf("ResolveDocument: %v", err)
+	}
+	if doc.PDSEndpoint() != "https://pds.example.com" {
+		t.Errorf("PDSEndpoint() = %q", doc.PDSEndpoint())
+	}
+	resolved, err := ParseDID(context.Background(), resolver, did)
+	if err != nil {
+		t.Fatalf("ParseDID: %v", err)
+	}
+	if FormatKeyDID(resolved.PublicKey) != FormatKeyDID(key.Public()) {
+		t.Error("resolved key does not match the document")
+	}
+
+	if _, err := resolver.ResolveDocument(context.Background(), "did:plc:unknown234567abcdefghij"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("ResolveDocument(unknown DID) = %v, want a not found error", err)
+	}
+}
+
+func TestResolveKey(t *testing.T) {
+	key, err := GenerateKey(KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := FormatKeyDID(key.Public())
+
+	// did:key needs no resolver
+	parsed, err := ParseDID(context.Background(), nil, did)
+	if err != nil {
+		t.Fatalf("ParseDID: %v", err)
+	}
+	if FormatKeyDID(parsed.PublicKey) != did {
+		t.Errorf("ParseDID() key = %s, want %s", FormatKeyDID(parsed.PublicKey), did)
+	}
+
+	doc, err := NewResolver("").ResolveDocument(context.Background(), did)
+	if err != nil {
+		t.Fatalf("ResolveDocument: %v", err)
+	}
+	if doc.ID != did {
+		t.Errorf("document id = %s, want %s", doc.ID, did)
+	}
+	if _, err := NewResolver("").ResolveDocument(context.Background(), "did:example:123"); err == nil {
+		t.Error("ResolveDocument() = nil error for an unsupported method")
+	}
+}
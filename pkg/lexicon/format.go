@@ -0,0 +1,242 @@
+package lexicon
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// String formats
+const (
+	FormatDatetime     = "datetime"
+	FormatDID          = "did"
+	FormatHandle       = "handle"
+	FormatATIdentifier = "at-identifier"
+	FormatURI          = "uri"
+	FormatATURI        = "at-uri"
+	FormatNSID         = "nsid"
+	FormatCID          = "cid"
+	FormatLanguage     = "language"
+	FormatTID          = "tid"
+	FormatRecordKey    = "record-key"
+)
+
+var (
+	languagePattern  = regexp.MustCompile(`^(i|[a-zA-Z]{2,3})(-[a-zA-Z0-9]+)*$`)
+	tidPattern       = regexp.MustCompile(`^[234567abcdefghij][234567abcdefghijklmnopqrstuvwxyz]{12}$`)
+	recordKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9._:~-]{1,512}$`)
+	cidPattern       = regexp.MustCompile(`^[a-zA-Z0-9+/=]{8,256}$`)
+	nsidNamePattern  = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]{0,62}$`)
+)
+
+// ValidateFormat checks that a string is valid in a lexicon string format.
+// Unknown formats are not checked.
+func ValidateFormat(format, s string) error {
+	switch format {
+	case FormatDatetime:
+		// Datetimes must have a timezone and use an uppercase T
+		if _, err := time.Parse(time.RFC3339Nano, s); err != nil || !strings.Contains(s, "T") {
+			return fmt.Errorf("invalid datetime: %q", s)
+		}
+	case FormatDID:
+		if _, _, err := identity.SplitDID(s); err != nil {
+			return err
+		}
+	case FormatHandle:
+		return identity.ValidateHandle(s)
+	case FormatATIdentifier:
+		if strings.HasPrefix(s, "did:") {
+			return ValidateFormat(FormatDID, s)
+		}
+		return identity.ValidateHandle(s)
+	case FormatURI:
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" || strings.ContainsAny(s, " \t\n") {
+			return fmt.Errorf("invalid URI: %q", s)
+		}
+	case FormatATURI:
+		return ValidateATURI(s)
+	case FormatNSID:
+		return ValidateNSID(s)
+	case FormatCID:
+		if !cidPattern.MatchString(s) {
+			return fmt.Errorf("invalid CID: %q", s)
+		}
+	case FormatLanguage:
+		if !languagePattern.MatchString(s) {
+			return fmt.Errorf("invalid language tag: %q", s)
+		}
+	case FormatTID:
+		if !tidPattern.MatchString(s) {
+			return fmt.Errorf("invalid TID: %q", s)
+		}
+	case FormatRecordKey:
+		if !recordKeyPattern.MatchString(s) || s == "." || s == ".." {
+			return fmt.Errorf("invalid record key: %q", s)
+		}
+	}
+	return nil
+}
+
+// ValidateNSID checks that a string is a namespaced identifier, like app.bsky.feed.post
+func ValidateNSID(nsid string) error {
+	if len(nsid) > 317 {
+		return fmt.Errorf("NSID is too long: %q", nsid)
+	}
+	labels := strings.Split(nsid, ".")
+	if len(labels) < 3 {
+		return fmt.Errorf("NSID must have at least three segments: %q", nsid)
+	}
+
+	// All but the last segment are a reversed domain name
+	if err := identity.ValidateHandle(strings.Join(labels[:len(labels)-1], ".")); err != nil {
+		return fmt.Errorf("invalid NSID authority: %q", nsid)
+	}
+	if labels[0][0] >= '0' && labels[0][0] <= '9' {
+		return fmt.Errorf("invalid NSID authority: %q", nsid)
+	}
+	if !nsidNamePattern.MatchString(labels[len(labels)-1]) {
+		return fmt.Errorf("invalid NSID name: %q", nsid)
+	}
+	return nil
+}
+
+// ValidateATURI checks that a string is an at:// URI naming a repository,
+// optionally followed by a collection and record key
+func ValidateATURI(uri string) error {
+	rest, ok := strings.CutPrefix(uri, "at://")
+	if !ok {
+		return fmt.Errorf("invalid AT URI: %q", uri)
+	}
+	rest, _, _ = strings.Cut(rest, "#")
+	parts := strings.Split(rest, "/")
+	if len(parts) > 3 {
+		return fmt.Errorf("invalid AT URI: %q", uri)
+	}
+	if err := ValidateFormat(FormatATIdentifier, parts[0]); err != nil {
+		return fmt.Errorf("invalid AT URI authority: %q", uri)
+	}
+	if len(parts) > 1 {
+		if err := ValidateNSID(parts[1]); err != nil {
+			return fmt.Errorf("invalid AT URI collection: %q", uri)
+		}
+	}
+	if len(parts) > 2 {
+		if err := ValidateFormat(FormatRecordKey, parts[2]); err != nil {
+			return fmt.Errorf("invalid AT URI record key: %q", uri)
+		}
+	}
+	return nil
+}
+
+// GraphemeLen counts the user-perceived characters in a string. It follows the
+// Unicode extended grapheme cluster rules closely enough for length limits:
+// combining marks, variation selectors, emoji modifiers and tags extend the
+// character before them, zero-width joiners join emoji, regional indicators
+// pair up into flags, and conjoining Hangul jamo combine into syllables.
+func GraphemeLen(s string) int {
+	count := 0
+	prev := rune(-1)
+	joinNext := false
+	pairedIndicator := false
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+
+		extends := false
+		switch {
+		case prev < 0:
+		case joinNext:
+			extends = true
+		case r == '\n' && prev == '\r':
+			extends = true
+		case isGraphemeExtend(r):
+			extends = true
+		case isRegionalIndicator(r) && isRegionalIndicator(prev) && !pairedIndicator:
+			extends = true
+		case hangulJoins(prev, r):
+			extends = true
+		}
+
+		pairedIndicator = extends && isRegionalIndicator(r) && isRegionalIndicator(prev)
+		joinNext = r == '\u200d'
+		if !extends {
+			count++
+		}
+		prev = r
+	}
+	return count
+}
+
+func isGraphemeExtend(r rune) bool {
+	switch {
+	case r == '\u200d', r == '\u200c':
+		return true
+	case r >= 0xFE00 && r <= 0xFE0F, r >= 0xE0100 && r <= 0xE01EF:
+		// Variation selectors
+		return true
+	case r >= 0x1F3FB && r <= 0x1F3FF:
+		// Emoji skin tone modifiers
+		return true
+	case r >= 0xE0020 && r <= 0xE007F:
+		// Tags, as in subdivision flags
+		return true
+	}
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// Hangul syllable types (Unicode Hangul_Syllable_Type)
+const (
+	hangulNone = iota
+	hangulL
+	hangulV
+	hangulT
+	hangulLV
+	hangulLVT
+)
+
+// hangulType returns the Hangul syllable type of a rune
+func hangulType(r rune) int {
+	switch {
+	case r >= 0x1100 && r <= 0x115F, r >= 0xA960 && r <= 0xA97C:
+		return hangulL
+	case r >= 0x1160 && r <= 0x11A7, r >= 0xD7B0 && r <= 0xD7C6:
+		return hangulV
+	case r >= 0x11A8 && r <= 0x11FF, r >= 0xD7CB && r <= 0xD7FB:
+		return hangulT
+	case r >= 0xAC00 && r <= 0xD7A3:
+		// Precomposed syllables come in blocks of 28, the first without a trailing consonant
+		if (r-0xAC00)%28 == 0 {
+			return hangulLV
+		}
+		return hangulLVT
+	}
+	return hangulNone
+}
+
+// hangulJoins reports whether r continues the Hangul syllable ending in prev:
+// leading consonants join vowels, leading consonants and syllables; vowels
+// join vowels and trailing consonants; trailing consonants join trailing
+// consonants
+func hangulJoins(prev, r rune) bool {
+	next := hangulType(r)
+	switch hangulType(prev) {
+	case hangulL:
+		return next == hangulL || next == hangulV || next == hangulLV || next == hangulLVT
+	case hangulLV, hangulV:
+		return next == hangulV || next == hangulT
+	case hangulLVT, hangulT:
+		return next == hangulT
+	}
+	return false
+}
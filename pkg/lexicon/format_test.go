@@ -0,0 +1,44 @@
+package lexicon
+
+import "testing"
+
+func TestGraphemeLen(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want int
+	}{
+		{"empty", "", 0},
+		{"ascii", "hello", 5},
+		{"crlf", "a\r\nb", 3},
+		{"combining accent", "e\u0301", 1},
+		{"precomposed accent", "\u00e9", 1},
+		{"variation selector", "\u2764\ufe0f", 1},
+		{"skin tone", "\U0001F44B\U0001F3FD", 1},
+		{"zwj family", "\U0001F468\u200d\U0001F469\u200d\U0001F467\u200d\U0001F466", 1},
+		{"zwj with modifier", "\U0001F469\U0001F3FD\u200d\U0001F4BB", 1},
+		{"flag", "\U0001F1EB\U0001F1F7", 1},
+		{"two flags", "\U0001F1EB\U0001F1F7\U0001F1E9\U0001F1EA", 2},
+		{"odd regional indicators", "\U0001F1EB\U0001F1F7\U0001F1E9", 2},
+		{"subdivision flag", "\U0001F3F4\U000E0067\U000E0062\U000E0073\U000E0063\U000E0074\U000E007F", 1},
+		{"hangul syllables", "\ud55c\uae00", 2},
+		{"hangul L V", "\u1112\u1161", 1},
+		{"hangul L V T", "\u1112\u1161\u11ab", 1},
+		{"hangul jamo words", "\u1112\u1161\u11ab\u1100\u1173\u11af", 2},
+		{"hangul L L V", "\u1100\u1100\u1161", 1},
+		{"hangul LV syllable and T", "\ud558\u11ab", 1},
+		{"hangul LVT syllable and T", "\ud55c\u11ab", 1},
+		{"hangul LVT syllable and V", "\ud55c\u1161", 2},
+		{"hangul L and syllable", "\u1100\ud55c", 1},
+		{"hangul T and L", "\u11ab\u1100", 2},
+		{"hangul V and L", "\u1161\u1100", 2},
+		{"mixed", "hi \U0001F44B\U0001F3FD!", 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GraphemeLen(tt.s); got != tt.want {
+				t.Errorf("GraphemeLen(%q) = %d, want %d", tt.s, got, tt.want)
+			}
+		})
+	}
+}
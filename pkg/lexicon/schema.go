@@ -0,0 +1,207 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Lexicon definition types
+const (
+	TypeNull         = "null"
+	TypeBoolean      = "boolean"
+	TypeInteger      = "integer"
+	TypeString       = "string"
+	TypeBytes        = "bytes"
+	TypeCIDLink      = "cid-link"
+	TypeBlob         = "blob"
+	TypeArray        = "array"
+	TypeObject       = "object"
+	TypeParams       = "params"
+	TypeToken        = "token"
+	TypeRef          = "ref"
+	TypeUnion        = "union"
+	TypeUnknown      = "unknown"
+	TypeRecord       = "record"
+	TypeQuery        = "query"
+	TypeProcedure    = "procedure"
+	TypeSubscription = "subscription"
+)
+
+// Def is a single definition in a schema, or a field within one. Which fields
+// are set depends on Type.
+type Def struct {
+	Type        string `json:"type"`
+	Description string `json:"description,omitempty"`
+
+	// Record
+	Key    string `json:"key,omitempty"`
+	Record *Def   `json:"record,omitempty"`
+
+	// Object and params
+	Properties map[string]*Def `json:"properties,omitempty"`
+	Required   []string        `json:"required,omitempty"`
+	Nullable   []string        `json:"nullable,omitempty"`
+
+	// Array, and the byte lengths of strings and bytes
+	Items     *Def `json:"items,omitempty"`
+	MinLength *int `json:"minLength,omitempty"`
+	MaxLength *int `json:"maxLength,omitempty"`
+
+	// String
+	Format       string   `json:"format,omitempty"`
+	MinGraphemes *int     `json:"minGraphemes,omitempty"`
+	MaxGraphemes *int     `json:"maxGraphemes,omitempty"`
+	KnownValues  []string `json:"knownValues,omitempty"`
+
+	// Integer
+	Minimum *int64 `json:"minimum,omitempty"`
+	Maximum *int64 `json:"maximum,omitempty"`
+
+	// String, integer and boolean
+	Enum    []interface{} `json:"enum,omitempty"`
+	Const   interface{}   `json:"const,omitempty"`
+	Default interface{}   `json:"default,omitempty"`
+
+	// Blob
+	Accept  []string `json:"accept,omitempty"`
+	MaxSize *int64   `json:"maxSize,omitempty"`
+
+	// Ref and union
+	Ref    string   `json:"ref,omitempty"`
+	Refs   []string `json:"refs,omitempty"`
+	Closed bool     `json:"closed,omitempty"`
+
+	// Query, procedure and subscription
+	Parameters *Def       `json:"parameters,omitempty"`
+	Input      *Body      `json:"input,omitempty"`
+	Output     *Body      `json:"output,omitempty"`
+	Message    *Body      `json:"message,omitempty"`
+	Errors     []ErrorDef `json:"errors,omitempty"`
+}
+
+// Body describes the input or output of an XRPC method, or the messages of a subscription
+type Body struct {
+	Description string `json:"description,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	Schema      *Def   `json:"schema,omitempty"`
+}
+
+// ErrorDef names an error an XRPC method can return
+type ErrorDef struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+// IsRequired reports whether an object property is required
+func (d *Def) IsRequired(name string) bool {
+	return contains(d.Required, name)
+}
+
+// IsNullable reports whether an object property can be null
+func (d *Def) IsNullable(name string) bool {
+	return contains(d.Nullable, name)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSchema parses a lexicon schema from JSON
+func ParseSchema(data []byte) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	if schema.Lexicon != 1 {
+		return nil, fmt.Errorf("unsupported lexicon version %d in %s", schema.Lexicon, schema.ID)
+	}
+	if err := ValidateNSID(schema.ID); err != nil {
+		return nil, fmt.Errorf("invalid schema ID: %w", err)
+	}
+	for name, def := range schema.Defs {
+		if def == nil {
+			return nil, fmt.Errorf("empty definition %s#%s", schema.ID, name)
+		}
+		switch def.Type {
+		case TypeRecord, TypeQuery, TypeProcedure, TypeSubscription:
+			if name != "main" {
+				return nil, fmt.Errorf("%s definition %s#%s must be main", def.Type, schema.ID, name)
+			}
+		}
+	}
+	return &schema, nil
+}
+
+// LoadSchemas parses every *.json file under dir as a lexicon schema
+func LoadSchemas(dir string) ([]*Schema, error) {
+	var schemas []*Schema
+	err := filepath.WalkDir(dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read schema: %w", err)
+		}
+		schema, err := ParseSchema(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		schemas = append(schemas, schema)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schemas, nil
+}
+
+// SplitRef splits a reference into the schema ID and definition name it
+// points at. References starting with "#" are relative to schemaID, and
+// references without a fragment point at the main definition.
+func SplitRef(ref, schemaID string) (id, name string) {
+	id, name, ok := strings.Cut(ref, "#")
+	if !ok {
+		name = "main"
+	}
+	if id == "" {
+		id = schemaID
+	}
+	return id, name
+}
+
+// NormalizeRef returns the absolute form of a reference, as used in $type
+// fields: "nsid" for main definitions and "nsid#name" for others
+func NormalizeRef(ref, schemaID string) string {
+	id, name := SplitRef(ref, schemaID)
+	if name == "main" {
+		return id
+	}
+	return id + "#" + name
+}
+
+// resolveRef finds the definition a reference points at, along with the ID of
+// the schema it is in
+func (v *SchemaValidator) resolveRef(ref, schemaID string) (*Def, string, error) {
+	id, name := SplitRef(ref, schemaID)
+	schema, ok := v.schemas[id]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown schema: %s", id)
+	}
+	def, ok := schema.Defs[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown definition: %s#%s", id, name)
+	}
+	return def, id, nil
+}
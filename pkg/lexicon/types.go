@@ -17,9 +17,11 @@ type Document struct {
 
 // Schema defines the structure of a lexicon type
 type Schema struct {
-	LexiconID string                 `json:"lexicon"`
-	Revision  int                    `json:"revision"`
-	Defs      map[string]interface{} `json:"defs"`
+	Lexicon     int             `json:"lexicon"`
+	ID          string          `json:"id"`
+	Revision    int             `json:"revision,omitempty"`
+	Description string          `json:"description,omitempty"`
+	Defs        map[string]*Def `json:"defs"`
 }
 
 // Validator validates a document against its schema
@@ -41,19 +43,40 @@ func NewSchemaValidator() *SchemaValidator {
 
 // RegisterSchema registers a schema with the validator
 func (v *SchemaValidator) RegisterSchema(schema *Schema) {
-	v.schemas[schema.LexiconID] = schema
+	v.schemas[schema.ID] = schema
 }
 
-// Validate validates a document against its schema
+// Validate validates a document against the record definition of its type.
+// Errors are *ValidationError values naming the JSON path that failed.
 func (v *SchemaValidator) Validate(doc *Document) error {
 	schema, ok := v.schemas[doc.Type]
 	if !ok {
 		return fmt.Errorf("unknown document type: %s", doc.Type)
 	}
+	main, ok := schema.Defs["main"]
+	if !ok || main.Type != TypeRecord || main.Record == nil {
+		return fmt.Errorf("%s is not a record type", doc.Type)
+	}
+
+	// A record may name its own type, but not another one
+	if t, ok := doc.Value["$type"]; ok && t != doc.Type {
+		return &ValidationError{Path: "$.$type", Message: fmt.Sprintf("must be %s", doc.Type)}
+	}
 
-	// In a real implementation, this would validate the document structure
-	// against the schema definition
-	return nil
+	return v.validateDef("$", map[string]interface{}(doc.Value), main.Record, schema.ID)
+}
+
+// ValidateValue validates a value decoded from JSON against a definition,
+// named by a reference like "app.bsky.feed.post" or "app.bsky.feed.defs#postView"
+func (v *SchemaValidator) ValidateValue(ref string, value interface{}) error {
+	def, schemaID, err := v.resolveRef(ref, "")
+	if err != nil {
+		return err
+	}
+	if def.Type == TypeRecord && def.Record != nil {
+		def = def.Record
+	}
+	return v.validateDef("$", value, def, schemaID)
 }
 
 // MarshalDocument marshals a document to JSON
@@ -0,0 +1,370 @@
+package lexicon
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError is returned when a value does not match its schema
+type ValidationError struct {
+	// Path is the JSON path of the failing value, like $.embed.images[0].alt
+	Path    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Path + ": " + e.Message
+}
+
+func invalid(path, format string, args ...interface{}) error {
+	return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+}
+
+// validateDef validates a value decoded from JSON against a definition.
+// schemaID is the schema the definition is in, for resolving relative refs.
+func (v *SchemaValidator) validateDef(path string, value interface{}, def *Def, schemaID string) error {
+	switch def.Type {
+	case TypeNull:
+		if value != nil {
+			return invalid(path, "must be null")
+		}
+		return nil
+	case TypeBoolean:
+		return validateBoolean(path, value, def)
+	case TypeInteger:
+		return validateInteger(path, value, def)
+	case TypeString:
+		return validateString(path, value, def)
+	case TypeBytes:
+		return validateBytes(path, value, def)
+	case TypeCIDLink:
+		return validateCIDLink(path, value)
+	case TypeBlob:
+		return validateBlob(path, value, def)
+	case TypeArray:
+		return v.validateArray(path, value, def, schemaID)
+	case TypeObject, TypeParams:
+		return v.validateObject(path, value, def, schemaID)
+	case TypeRecord:
+		if def.Record == nil {
+			return invalid(path, "record definition has no record schema")
+		}
+		return v.validateObject(path, value, def.Record, schemaID)
+	case TypeRef:
+		target, targetSchemaID, err := v.resolveRef(def.Ref, schemaID)
+		if err != nil {
+			return invalid(path, "%v", err)
+		}
+		return v.validateDef(path, value, target, targetSchemaID)
+	case TypeUnion:
+		return v.validateUnion(path, value, def, schemaID)
+	case TypeToken:
+		// A token stands for its own name
+		if s, ok := value.(string); !ok || s == "" {
+			return invalid(path, "must be a token name")
+		}
+		return nil
+	case TypeUnknown:
+		if _, ok := value.(map[string]interface{}); !ok {
+			return invalid(path, "must be an object")
+		}
+		return nil
+	}
+	return invalid(path, "unsupported definition type %q", def.Type)
+}
+
+func validateBoolean(path string, value interface{}, def *Def) error {
+	b, ok := value.(bool)
+	if !ok {
+		return invalid(path, "must be a boolean")
+	}
+	if c, ok := def.Const.(bool); ok && b != c {
+		return invalid(path, "must be %t", c)
+	}
+	return nil
+}
+
+func validateInteger(path string, value interface{}, def *Def) error {
+	n, ok := asInteger(value)
+	if !ok {
+		return invalid(path, "must be an integer")
+	}
+	if def.Minimum != nil && n < *def.Minimum {
+		return invalid(path, "must be at least %d", *def.Minimum)
+	}
+	if def.Maximum != nil && n > *def.Maximum {
+		return invalid(path, "must be at most %d", *def.Maximum)
+	}
+	if len(def.Enum) > 0 && !inEnum(def.Enum, value) {
+		return invalid(path, "must be one of %v", def.Enum)
+	}
+	if def.Const != nil && !inEnum([]interface{}{def.Const}, value) {
+		return invalid(path, "must be %v", def.Const)
+	}
+	return nil
+}
+
+func validateString(path string, value interface{}, def *Def) error {
+	s, ok := value.(string)
+	if !ok {
+		return invalid(path, "must be a string")
+	}
+
+	// Lengths are in UTF-8 bytes, graphemes in user-perceived characters
+	if def.MinLength != nil && len(s) < *def.MinLength {
+		return invalid(path, "must be at least %d bytes long", *def.MinLength)
+	}
+	if def.MaxLength != nil && len(s) > *def.MaxLength {
+		return invalid(path, "must be at most %d bytes long", *def.MaxLength)
+	}
+	if def.MinGraphemes != nil || def.MaxGraphemes != nil {
+		n := GraphemeLen(s)
+		if def.MinGraphemes != nil && n < *def.MinGraphemes {
+			return invalid(path, "must be at least %d characters long", *def.MinGraphemes)
+		}
+		if def.MaxGraphemes != nil && n > *def.MaxGraphemes {
+			return invalid(path, "must be at most %d characters long", *def.MaxGraphemes)
+		}
+	}
+
+	if len(def.Enum) > 0 && !inEnum(def.Enum, s) {
+		return invalid(path, "must be one of %v", def.Enum)
+	}
+	if c, ok := def.Const.(string); ok && s != c {
+		return invalid(path, "must be %q", c)
+	}
+	// knownValues are suggestions, so any other string is accepted too
+
+	if def.Format != "" {
+		if err := ValidateFormat(def.Format, s); err != nil {
+			return invalid(path, "%v", err)
+		}
+	}
+	return nil
+}
+
+func validateBytes(path string, value interface{}, def *Def) error {
+	obj, ok := value.(map[string]interface{})
+	if !ok || len(obj) != 1 {
+		return invalid(path, `must be an object with only "$bytes"`)
+	}
+	encoded, ok := obj["$bytes"].(string)
+	if !ok {
+		return invalid(path, `must be an object with only "$bytes"`)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return invalid(path+".$bytes", "must be base64: %v", err)
+	}
+	if def.MinLength != nil && len(data) < *def.MinLength {
+		return invalid(path, "must be at least %d bytes long", *def.MinLength)
+	}
+	if def.MaxLength != nil && len(data) > *def.MaxLength {
+		return invalid(path, "must be at most %d bytes long", *def.MaxLength)
+	}
+	return nil
+}
+
+func validateCIDLink(path string, value interface{}) error {
+	obj, ok := value.(map[string]interface{})
+	if !ok || len(obj) != 1 {
+		return invalid(path, `must be an object with only "$link"`)
+	}
+	link, ok := obj["$link"].(string)
+	if !ok {
+		return invalid(path, `must be an object with only "$link"`)
+	}
+	if err := ValidateFormat(FormatCID, link); err != nil {
+		return invalid(path+".$link", "%v", err)
+	}
+	return nil
+}
+
+// validateBlob validates a blob reference. Blobs are either
+// {"$type": "blob", "ref": {"$link": cid}, "mimeType", "size"}, or the legacy
+// {"cid", "mimeType"} without a size.
+func validateBlob(path string, value interface{}, def *Def) error {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return invalid(path, "must be a blob")
+	}
+
+	var size int64 = -1
+	if obj["$type"] == "blob" {
+		if err := validateCIDLink(path+".ref", obj["ref"]); err != nil {
+			return err
+		}
+		n, ok := asInteger(obj["size"])
+		if !ok || n < 0 {
+			return invalid(path+".size", "must be a non-negative integer")
+		}
+		size = n
+	} else {
+		cid, ok := obj["cid"].(string)
+		if !ok {
+			return invalid(path, "must be a blob")
+		}
+		if err := ValidateFormat(FormatCID, cid); err != nil {
+			return invalid(path+".cid", "%v", err)
+		}
+	}
+
+	mimeType, ok := obj["mimeType"].(string)
+	if !ok || mimeType == "" {
+		return invalid(path+".mimeType", "must be a MIME type")
+	}
+	if len(def.Accept) > 0 && !acceptsMimeType(def.Accept, mimeType) {
+		return invalid(path+".mimeType", "must be one of %v", def.Accept)
+	}
+	if def.MaxSize != nil && size > *def.MaxSize {
+		return invalid(path+".size", "must be at most %d bytes", *def.MaxSize)
+	}
+	return nil
+}
+
+// acceptsMimeType matches a MIME type against patterns like image/png, image/* and */*
+func acceptsMimeType(accept []string, mimeType string) bool {
+	mimeType = strings.ToLower(mimeType)
+	for _, pattern := range accept {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*/*" || pattern == mimeType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(mimeType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *SchemaValidator) validateArray(path string, value interface{}, def *Def, schemaID string) error {
+	items, ok := value.([]interface{})
+	if !ok {
+		return invalid(path, "must be an array")
+	}
+	if def.MinLength != nil && len(items) < *def.MinLength {
+		return invalid(path, "must have at least %d items", *def.MinLength)
+	}
+	if def.MaxLength != nil && len(items) > *def.MaxLength {
+		return invalid(path, "must have at most %d items", *def.MaxLength)
+	}
+	if def.Items == nil {
+		return nil
+	}
+	for i, item := range items {
+		if err := v.validateDef(path+"["+strconv.Itoa(i)+"]", item, def.Items, schemaID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *SchemaValidator) validateObject(path string, value interface{}, def *Def, schemaID string) error {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return invalid(path, "must be an object")
+	}
+
+	// Check required properties first
+	for _, name := range def.Required {
+		if _, ok := obj[name]; !ok {
+			return invalid(path+"."+name, "is required")
+		}
+	}
+
+	// Check properties in a fixed order so that errors are stable.
+	// Properties that are not in the schema are allowed.
+	names := make([]string, 0, len(def.Properties))
+	for name := range def.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propValue, ok := obj[name]
+		if !ok {
+			continue
+		}
+		if propValue == nil {
+			if def.IsNullable(name) {
+				continue
+			}
+			return invalid(path+"."+name, "must not be null")
+		}
+		if err := v.validateDef(path+"."+name, propValue, def.Properties[name], schemaID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateUnion validates an object against the ref its $type names. Open
+// unions accept objects of other types without checking them.
+func (v *SchemaValidator) validateUnion(path string, value interface{}, def *Def, schemaID string) error {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return invalid(path, "must be an object")
+	}
+	typ, ok := obj["$type"].(string)
+	if !ok || typ == "" {
+		return invalid(path+".$type", "is required")
+	}
+	typ = NormalizeRef(typ, "")
+
+	for _, ref := range def.Refs {
+		if NormalizeRef(ref, schemaID) != typ {
+			continue
+		}
+		target, targetSchemaID, err := v.resolveRef(ref, schemaID)
+		if err != nil {
+			return invalid(path, "%v", err)
+		}
+		return v.validateDef(path, value, target, targetSchemaID)
+	}
+
+	if def.Closed {
+		return invalid(path+".$type", "must be one of %v", def.Refs)
+	}
+	return nil
+}
+
+// asInteger converts a decoded JSON number to an integer, if it is a whole number
+func asInteger(value interface{}) (int64, bool) {
+	switch n := value.(type) {
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(n), true
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+// inEnum reports whether value is one of the values in enum, comparing
+// numbers by value
+func inEnum(enum []interface{}, value interface{}) bool {
+	n, isInt := asInteger(value)
+	for _, e := range enum {
+		if isInt {
+			if m, ok := asInteger(e); ok && m == n {
+				return true
+			}
+			continue
+		}
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package lexicon
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const testPostSchema = `{
+	"lexicon": 1,
+	"id": "com.example.post",
+	"defs": {
+		"main": {
+			"type": "record",
+			"key": "tid",
+			"record": {
+				"type": "object",
+				"required": ["text", "createdAt"],
+				"nullable": ["reply"],
+				"properties": {
+					"text": {"type": "string", "maxLength": 300, "maxGraphemes": 3},
+					"createdAt": {"type": "string", "format": "datetime"},
+					"langs": {"type": "array", "maxLength": 2, "items": {"type": "string", "format": "language"}},
+					"reply": {"type": "ref", "ref": "#replyRef"},
+					"embed": {"type": "union", "refs": ["#images", "com.example.external"], "closed": true}
+				}
+			}
+		},
+		"replyRef": {
+			"type": "object",
+			"required": ["root"],
+			"properties": {
+				"root": {"type": "string", "format": "at-uri"}
+			}
+		},
+		"images": {
+			"type": "object",
+			"required": ["images"],
+			"properties": {
+				"images": {"type": "array", "items": {"type": "ref", "ref": "#image"}}
+			}
+		},
+		"image": {
+			"type": "object",
+			"required": ["alt"],
+			"properties": {
+				"alt": {"type": "string"},
+				"size": {"type": "integer", "minimum": 1}
+			}
+		}
+	}
+}`
+
+const testExternalSchema = `{
+	"lexicon": 1,
+	"id": "com.example.external",
+	"defs": {
+		"main": {
+			"type": "object",
+			"required": ["uri"],
+			"properties": {
+				"uri": {"type": "string", "format": "uri"}
+			}
+		}
+	}
+}`
+
+func newTestValidator(t *testing.T) *SchemaValidator {
+	t.Helper()
+	v := NewSchemaValidator()
+	for _, data := range []string{testPostSchema, testExternalSchema} {
+		schema, err := ParseSchema([]byte(data))
+		if err != nil {
+			t.Fatalf("ParseSchema: %v", err)
+		}
+		v.RegisterSchema(schema)
+	}
+	return v
+}
+
+func TestValidateErrorPaths(t *testing.T) {
+	v := newTestValidator(t)
+
+	tests := []struct {
+		name  string
+		value string
+		path  string
+	}{
+		{"valid", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z"}`, ""},
+		{"missing required", `{"text": "hi"}`, "$.createdAt"},
+		{"wrong type", `{"text": 1, "createdAt": "2024-01-01T00:00:00Z"}`, "$.text"},
+		{"too many graphemes", `{"text": "abcd", "createdAt": "2024-01-01T00:00:00Z"}`, "$.text"},
+		{"graphemes not bytes", `{"text": "👋🏽🇫🇷", "createdAt": "2024-01-01T00:00:00Z"}`, ""},
+		{"bad format", `{"text": "hi", "createdAt": "yesterday"}`, "$.createdAt"},
+		{"array item", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z", "langs": ["en", "-"]}`, "$.langs[1]"},
+		{"array too long", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z", "langs": ["en", "fr", "de"]}`, "$.langs"},
+		{"nullable", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z", "reply": null}`, ""},
+		{"ref", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z", "reply": {}}`, "$.reply.root"},
+		{"union without type", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z", "embed": {}}`, "$.embed.$type"},
+		{"closed union", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z", "embed": {"$type": "com.example.other"}}`, "$.embed.$type"},
+		{"union member", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z", "embed": {"$type": "com.example.post#images", "images": [{"alt": "a"}, {"alt": "b", "size": 0}]}}`, "$.embed.images[1].size"},
+		{"union member in other schema", `{"text": "hi", "createdAt": "2024-01-01T00:00:00Z", "embed": {"$type": "com.example.external", "uri": "https://example.com"}}`, ""},
+		{"own type", `{"$type": "com.example.post", "text": "hi", "createdAt": "2024-01-01T00:00:00Z"}`, ""},
+		{"other type", `{"$type": "com.example.external", "text": "hi", "createdAt": "2024-01-01T00:00:00Z"}`, "$.$type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatalf("invalid test value: %v", err)
+			}
+
+			err := v.Validate(&Document{Type: "com.example.post", Value: value})
+			if tt.path == "" {
+				if err != nil {
+					t.Fatalf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				t.Fatalf("Validate() = %v, want a *ValidationError", err)
+			}
+			if verr.Path != tt.path {
+				t.Errorf("error path = %q, want %q (%v)", verr.Path, tt.path, err)
+			}
+		})
+	}
+}
+
+func TestValidateUnknownType(t *testing.T) {
+	v := newTestValidator(t)
+
+	err := v.Validate(&Document{Type: "com.example.missing", Value: map[string]interface{}{}})
+	if err == nil {
+		t.Fatal("Validate() = nil, want an error for an unknown type")
+	}
+	if err := v.Validate(&Document{Type: "com.example.external", Value: map[string]interface{}{}}); err == nil {
+		t.Fatal("Validate() = nil, want an error for a type that is not a record")
+	}
+}
@@ -0,0 +1,98 @@
+package lexicon
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Bytes is a lexicon bytes value, encoded in JSON as {"$bytes": base64}
+type Bytes []byte
+
+// MarshalJSON implements json.Marshaler
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"$bytes": base64.RawStdEncoding.EncodeToString(b)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Bytes *string `json:"$bytes"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Bytes == nil {
+		return fmt.Errorf(`bytes must be an object with "$bytes"`)
+	}
+	decoded, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(*v.Bytes, "="))
+	if err != nil {
+		return fmt.Errorf("invalid bytes: %w", err)
+	}
+	*b = decoded
+	return nil
+}
+
+// CIDLink is a lexicon cid-link value, encoded in JSON as {"$link": cid}
+type CIDLink string
+
+// MarshalJSON implements json.Marshaler
+func (l CIDLink) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"$link": string(l)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (l *CIDLink) UnmarshalJSON(data []byte) error {
+	var v struct {
+		Link *string `json:"$link"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Link == nil {
+		return fmt.Errorf(`cid-link must be an object with "$link"`)
+	}
+	*l = CIDLink(*v.Link)
+	return nil
+}
+
+// Blob is a reference to a blob stored with a record
+type Blob struct {
+	Ref      CIDLink
+	MimeType string
+	// Size is -1 for blobs decoded from the legacy format, which has no size
+	Size int64
+}
+
+type blobJSON struct {
+	Type     string   `json:"$type"`
+	Ref      *CIDLink `json:"ref"`
+	MimeType string   `json:"mimeType"`
+	Size     int64    `json:"size"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (b Blob) MarshalJSON() ([]byte, error) {
+	return json.Marshal(blobJSON{Type: TypeBlob, Ref: &b.Ref, MimeType: b.MimeType, Size: b.Size})
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting the legacy {"cid", "mimeType"} format too
+func (b *Blob) UnmarshalJSON(data []byte) error {
+	var v struct {
+		blobJSON
+		CID string `json:"cid"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch {
+	case v.Type == TypeBlob && v.Ref != nil:
+		*b = Blob{Ref: *v.Ref, MimeType: v.MimeType, Size: v.Size}
+	case v.Type == "" && v.CID != "":
+		*b = Blob{Ref: CIDLink(v.CID), MimeType: v.MimeType, Size: -1}
+	default:
+		return fmt.Errorf("invalid blob")
+	}
+	return nil
+}
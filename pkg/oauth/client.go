@@ -0,0 +1,260 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/auth"
+)
+
+// maxMetadataSize limits the size of a fetched client metadata document
+const maxMetadataSize = 64 << 10
+
+// loopbackClientID is the client ID of native apps and development clients
+// without a metadata document
+const loopbackClientID = "http://localhost"
+
+// ClientMetadata is an OAuth client metadata document (RFC 7591). In atproto the
+// client ID is the URL the document is served from.
+type ClientMetadata struct {
+	ClientID                string   `json:"client_id"`
+	ClientName              string   `json:"client_name,omitempty"`
+	ClientURI               string   `json:"client_uri,omitempty"`
+	LogoURI                 string   `json:"logo_uri,omitempty"`
+	RedirectURIs            []string `json:"redirect_uris"`
+	GrantTypes              []string `json:"grant_types"`
+	ResponseTypes           []string `json:"response_types"`
+	Scope                   string   `json:"scope"`
+	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
+	ApplicationType         string   `json:"application_type,omitempty"`
+	DPoPBoundAccessTokens   bool     `json:"dpop_bound_access_tokens"`
+}
+
+// Validate checks that the metadata describes a client this server supports
+func (m *ClientMetadata) Validate() error {
+	if len(m.RedirectURIs) == 0 {
+		return fmt.Errorf("client has no redirect_uris")
+	}
+	if !contains(m.GrantTypes, "authorization_code") {
+		return fmt.Errorf("client does not support the authorization_code grant")
+	}
+	if !contains(m.ResponseTypes, "code") {
+		return fmt.Errorf("client does not support the code response type")
+	}
+	if !auth.HasScope(m.Scope, auth.ScopeATProto) {
+		return fmt.Errorf("client scope must include %s", auth.ScopeATProto)
+	}
+	if m.TokenEndpointAuthMethod != "none" {
+		return fmt.Errorf("unsupported token_endpoint_auth_method: %s", m.TokenEndpointAuthMethod)
+	}
+	if !m.DPoPBoundAccessTokens {
+		return fmt.Errorf("client must use DPoP-bound access tokens")
+	}
+	return nil
+}
+
+// AllowsRedirectURI reports whether uri is one of the client's redirect URIs.
+// Loopback redirect URIs match on any port (RFC 8252).
+func (m *ClientMetadata) AllowsRedirectURI(uri string) bool {
+	for _, allowed := range m.RedirectURIs {
+		if uri == allowed || sameLoopbackURI(uri, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsScope reports whether every scope in the space-separated scopes was
+// declared by the client
+func (m *ClientMetadata) AllowsScope(scopes string) bool {
+	for _, scope := range strings.Fields(scopes) {
+		if !auth.HasScope(m.Scope, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// DisplayName returns the client's name, or its client ID if it has none
+func (m *ClientMetadata) DisplayName() string {
+	if m.ClientName != "" {
+		return m.ClientName
+	}
+	return m.ClientID
+}
+
+// ClientResolver fetches client metadata documents
+type ClientResolver struct {
+	HTTPClient *http.Client
+}
+
+// NewClientResolver creates a new client resolver. Client IDs are URLs chosen
+// by whoever starts an authorization, so its HTTP client does not follow
+// redirects and refuses to connect to loopback, private and other internal
+// addresses.
+func NewClientResolver() *ClientResolver {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: checkPublicAddress,
+	}
+	return &ClientResolver{
+		HTTPClient: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: 5 * time.Second,
+			},
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return fmt.Errorf("client metadata must not redirect")
+			},
+		},
+	}
+}
+
+// checkPublicAddress refuses connections to addresses that are not publicly
+// routable. It runs after name resolution, so it also covers host names that
+// resolve to internal addresses.
+func checkPublicAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", address, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return fmt.Errorf("refusing to connect to non-public address %s", ip)
+	}
+	return nil
+}
+
+// Resolve returns the validated metadata of a client. Loopback clients
+// (http://localhost) get default metadata built from their client ID.
+func (r *ClientResolver) Resolve(ctx context.Context, clientID string) (*ClientMetadata, error) {
+	var metadata *ClientMetadata
+	var err error
+	if clientID == loopbackClientID || strings.HasPrefix(clientID, loopbackClientID+"?") {
+		metadata, err = loopbackMetadata(clientID)
+	} else {
+		metadata, err = r.fetch(ctx, clientID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := metadata.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid client metadata: %w", err)
+	}
+	return metadata, nil
+}
+
+// fetch downloads the metadata document of a client ID
+func (r *ClientResolver) fetch(ctx context.Context, clientID string) (*ClientMetadata, error) {
+	u, err := url.Parse(clientID)
+	if err != nil || u.Scheme != "https" || u.Host == "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid client ID: %s", clientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, clientID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch client metadata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch client metadata: status %d", resp.StatusCode)
+	}
+
+	var metadata ClientMetadata
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxMetadataSize)).Decode(&metadata); err != nil {
+		return nil, fmt.Errorf("failed to decode client metadata: %w", err)
+	}
+	if metadata.ClientID != clientID {
+		return nil, fmt.Errorf("client metadata is for %s, not %s", metadata.ClientID, clientID)
+	}
+	return &metadata, nil
+}
+
+// loopbackMetadata builds the metadata of a loopback client. The client ID may
+// carry redirect_uri and scope query parameters.
+func loopbackMetadata(clientID string) (*ClientMetadata, error) {
+	u, err := url.Parse(clientID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client ID: %s", clientID)
+	}
+	query := u.Query()
+
+	redirectURIs := query["redirect_uri"]
+	for _, redirectURI := range redirectURIs {
+		if !isLoopbackURI(redirectURI) {
+			return nil, fmt.Errorf("loopback client redirect URI must use a loopback IP: %s", redirectURI)
+		}
+	}
+	if len(redirectURIs) == 0 {
+		redirectURIs = []string{"http://127.0.0.1/", "http://[::1]/"}
+	}
+
+	scope := query.Get("scope")
+	if scope == "" {
+		scope = auth.ScopeATProto
+	}
+
+	return &ClientMetadata{
+		ClientID:                clientID,
+		ClientName:              "Loopback client",
+		RedirectURIs:            redirectURIs,
+		GrantTypes:              []string{"authorization_code", "refresh_token"},
+		ResponseTypes:           []string{"code"},
+		Scope:                   scope,
+		TokenEndpointAuthMethod: "none",
+		ApplicationType:         "native",
+		DPoPBoundAccessTokens:   true,
+	}, nil
+}
+
+// isLoopbackURI reports whether uri is an http URL on a loopback IP
+func isLoopbackURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "http" {
+		return false
+	}
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameLoopbackURI reports whether two loopback URIs differ only in their port
+func sameLoopbackURI(uri, allowed string) bool {
+	if !isLoopbackURI(uri) || !isLoopbackURI(allowed) {
+		return false
+	}
+	u, _ := url.Parse(uri)
+	a, _ := url.Parse(allowed)
+	return u.Hostname() == a.Hostname() && u.Path == a.Path && u.RawQuery == a.RawQuery
+}
+
+// contains reports whether values contains value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package oauth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		ok      bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:443", false},
+		{"[::1]:443", false},
+		{"10.0.0.1:443", false},
+		{"172.16.5.4:443", false},
+		{"192.168.1.1:443", false},
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:443", false},
+		{"[fd00::1]:443", false},
+		{"0.0.0.0:443", false},
+		{"[::]:443", false},
+		{"224.0.0.1:443", false},
+		{"[::ffff:127.0.0.1]:443", false},
+		{"localhost:443", false},
+	}
+	for _, tt := range tests {
+		err := checkPublicAddress("tcp", tt.address, nil)
+		if tt.ok && err != nil {
+			t.Errorf("checkPublicAddress(%s) = %v, want nil", tt.address, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("checkPublicAddress(%s) = nil, want an error", tt.address)
+		}
+	}
+}
+
+func TestClientResolverRefusesInternalAddresses(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("client metadata was fetched from a loopback address")
+	}))
+	defer ts.Close()
+
+	// Trust the test server's certificate, so that only the address check can refuse it
+	resolver := NewClientResolver()
+	resolver.HTTPClient.Transport.(*http.Transport).TLSClientConfig = ts.Client().Transport.(*http.Transport).TLSClientConfig
+	_, err := resolver.Resolve(context.Background(), ts.URL+"/client-metadata.json")
+	if err == nil || !strings.Contains(err.Error(), "non-public address") {
+		t.Fatalf("Resolve() = %v, want a non-public address error", err)
+	}
+}
+
+func TestClientResolverRefusesRedirects(t *testing.T) {
+	resolver := NewClientResolver()
+	req := httptest.NewRequest(http.MethodGet, "https://client.example.com/metadata.json", nil)
+	if err := resolver.HTTPClient.CheckRedirect(req, []*http.Request{req}); err == nil {
+		t.Fatal("CheckRedirect() = nil, want redirects to be refused")
+	}
+}
@@ -0,0 +1,28 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+// CodeChallengeS256 is the only PKCE code challenge method the server accepts
+const CodeChallengeS256 = "S256"
+
+// NewCodeChallenge derives the S256 code challenge of a PKCE code verifier
+func NewCodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// VerifyPKCE checks a code verifier against the code challenge of an
+// authorization request (RFC 7636)
+func VerifyPKCE(challenge, method, verifier string) bool {
+	if method != CodeChallengeS256 {
+		return false
+	}
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(NewCodeChallenge(verifier)), []byte(challenge)) == 1
+}
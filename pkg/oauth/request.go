@@ -0,0 +1,267 @@
+package oauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ErrRequestNotFound is returned for unknown, expired or already used
+// authorization requests and codes
+var ErrRequestNotFound = errors.New("authorization request not found")
+
+// AuthorizationRequest is a pushed authorization request. Once the user
+// approves it, it holds their DID and the authorization code issued for it.
+type AuthorizationRequest struct {
+	ID                  string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	LoginHint           string
+	DPoPJKT             string
+	DID                 string
+	Code                string
+	CreatedAt           time.Time
+	ExpiresAt           time.Time
+}
+
+// RequestStore stores authorization requests between the PAR, authorize and
+// token endpoints
+type RequestStore interface {
+	// CreateRequest stores a new authorization request
+	CreateRequest(ctx context.Context, req *AuthorizationRequest) error
+	// GetRequest returns an unexpired request that has not been approved yet
+	GetRequest(ctx context.Context, id string) (*AuthorizationRequest, error)
+	// ApproveRequest records the user's DID and the authorization code of a request
+	ApproveRequest(ctx context.Context, id, did, code string, expiresAt time.Time) error
+	// ConsumeCode deletes and returns the unexpired request an authorization code was issued for
+	ConsumeCode(ctx context.Context, code string) (*AuthorizationRequest, error)
+	// DeleteRequest deletes a request
+	DeleteRequest(ctx context.Context, id string) error
+}
+
+// MemoryRequestStore keeps authorization requests in memory
+type MemoryRequestStore struct {
+	mu       sync.Mutex
+	requests map[string]*AuthorizationRequest
+}
+
+// NewMemoryRequestStore creates a new in-memory request store
+func NewMemoryRequestStore() *MemoryRequestStore {
+	return &MemoryRequestStore{
+		requests: make(map[string]*AuthorizationRequest),
+	}
+}
+
+// CreateRequest stores a new authorization request
+func (s *MemoryRequestStore) CreateRequest(ctx context.Context, req *AuthorizationRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.prune()
+	req.CreatedAt = time.Now()
+	stored := *req
+	s.requests[req.ID] = &stored
+	return nil
+}
+
+// GetRequest returns an unexpired request that has not been approved yet
+func (s *MemoryRequestStore) GetRequest(ctx context.Context, id string) (*AuthorizationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[id]
+	if !ok || req.Code != "" || req.ExpiresAt.Before(time.Now()) {
+		return nil, ErrRequestNotFound
+	}
+	found := *req
+	return &found, nil
+}
+
+// ApproveRequest records the user's DID and the authorization code of a request
+func (s *MemoryRequestStore) ApproveRequest(ctx context.Context, id, did, code string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	req, ok := s.requests[id]
+	if !ok || req.Code != "" || req.ExpiresAt.Before(time.Now()) {
+		return ErrRequestNotFound
+	}
+	req.DID = did
+	req.Code = code
+	req.ExpiresAt = expiresAt
+	return nil
+}
+
+// ConsumeCode deletes and returns the unexpired request an authorization code was issued for
+func (s *MemoryRequestStore) ConsumeCode(ctx context.Context, code string) (*AuthorizationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, req := range s.requests {
+		if req.Code == "" || req.Code != code {
+			continue
+		}
+		delete(s.requests, id)
+		if req.ExpiresAt.Before(time.Now()) {
+			return nil, ErrRequestNotFound
+		}
+		return req, nil
+	}
+	return nil, ErrRequestNotFound
+}
+
+// DeleteRequest deletes a request
+func (s *MemoryRequestStore) DeleteRequest(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.requests, id)
+	return nil
+}
+
+// prune deletes expired requests. Callers must hold s.mu.
+func (s *MemoryRequestStore) prune() {
+	now := time.Now()
+	for id, req := range s.requests {
+		if req.ExpiresAt.Before(now) {
+			delete(s.requests, id)
+		}
+	}
+}
+
+// PostgresRequestStore keeps authorization requests in the oauth_requests table
+type PostgresRequestStore struct {
+	db *pgxpool.Pool
+}
+
+// NewPostgresRequestStore creates a new Postgres request store
+func NewPostgresRequestStore(db *pgxpool.Pool) *PostgresRequestStore {
+	return &PostgresRequestStore{
+		db: db,
+	}
+}
+
+// CreateRequest stores a new authorization request
+func (s *PostgresRequestStore) CreateRequest(ctx context.Context, req *AuthorizationRequest) error {
+	query := `
+		INSERT INTO oauth_requests (id, client_id, redirect_uri, scope, state, code_challenge, code_challenge_method, login_hint, dpop_jkt, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9, $10, $11)
+	`
+	now := time.Now()
+	_, err := s.db.Exec(ctx, query,
+		req.ID,
+		req.ClientID,
+		req.RedirectURI,
+		req.Scope,
+		req.State,
+		req.CodeChallenge,
+		req.CodeChallengeMethod,
+		req.LoginHint,
+		req.DPoPJKT,
+		now,
+		req.ExpiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create authorization request: %w", err)
+	}
+
+	req.CreatedAt = now
+	return nil
+}
+
+// GetRequest returns an unexpired request that has not been approved yet
+func (s *PostgresRequestStore) GetRequest(ctx context.Context, id string) (*AuthorizationRequest, error) {
+	query := `
+		SELECT id, client_id, redirect_uri, scope, state, code_challenge, code_challenge_method, COALESCE(login_hint, ''), dpop_jkt, COALESCE(did, ''), COALESCE(code, ''), created_at, expires_at
+		FROM oauth_requests
+		WHERE id = $1 AND code IS NULL AND expires_at > $2
+	`
+	return s.scanRequest(s.db.QueryRow(ctx, query, id, time.Now()))
+}
+
+// ApproveRequest records the user's DID and the authorization code of a request
+func (s *PostgresRequestStore) ApproveRequest(ctx context.Context, id, did, code string, expiresAt time.Time) error {
+	query := `
+		UPDATE oauth_requests
+		SET did = $1, code = $2, expires_at = $3
+		WHERE id = $4 AND code IS NULL AND expires_at > $5
+	`
+	tag, err := s.db.Exec(ctx, query, did, code, expiresAt, id, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to approve authorization request: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrRequestNotFound
+	}
+	return nil
+}
+
+// ConsumeCode deletes and returns the unexpired request an authorization code was issued for
+func (s *PostgresRequestStore) ConsumeCode(ctx context.Context, code string) (*AuthorizationRequest, error) {
+	query := `
+		DELETE FROM oauth_requests
+		WHERE code = $1
+		RETURNING id, client_id, redirect_uri, scope, state, code_challenge, code_challenge_method, COALESCE(login_hint, ''), dpop_jkt, COALESCE(did, ''), COALESCE(code, ''), created_at, expires_at
+	`
+	req, err := s.scanRequest(s.db.QueryRow(ctx, query, code))
+	if err != nil {
+		return nil, err
+	}
+	if req.ExpiresAt.Before(time.Now()) {
+		return nil, ErrRequestNotFound
+	}
+	return req, nil
+}
+
+// DeleteRequest deletes a request
+func (s *PostgresRequestStore) DeleteRequest(ctx context.Context, id string) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM oauth_requests WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete authorization request: %w", err)
+	}
+	return nil
+}
+
+// DeleteExpiredRequests deletes requests that expired before cutoff
+func (s *PostgresRequestStore) DeleteExpiredRequests(ctx context.Context, cutoff time.Time) (int64, error) {
+	tag, err := s.db.Exec(ctx, `DELETE FROM oauth_requests WHERE expires_at < $1`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired authorization requests: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// scanRequest scans an authorization request row
+func (s *PostgresRequestStore) scanRequest(row pgx.Row) (*AuthorizationRequest, error) {
+	var req AuthorizationRequest
+	err := row.Scan(
+		&req.ID,
+		&req.ClientID,
+		&req.RedirectURI,
+		&req.Scope,
+		&req.State,
+		&req.CodeChallenge,
+		&req.CodeChallengeMethod,
+		&req.LoginHint,
+		&req.DPoPJKT,
+		&req.DID,
+		&req.Code,
+		&req.CreatedAt,
+		&req.ExpiresAt,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrRequestNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authorization request: %w", err)
+	}
+	return &req, nil
+}
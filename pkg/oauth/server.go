@@ -0,0 +1,607 @@
+package oauth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/auth"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// Authorization request lifetimes
+const (
+	requestTTL = 5 * time.Minute
+	codeTTL    = time.Minute
+)
+
+// requestURIPrefix prefixes the IDs of pushed authorization requests
+const requestURIPrefix = "urn:ietf:params:oauth:request_uri:"
+
+// csrfCookie holds the token the consent form must echo back, so that other
+// sites cannot submit the form with credentials of their choosing
+const csrfCookie = "oauth_csrf"
+
+// Authenticator errors
+var (
+	// ErrInvalidCredentials is returned for a wrong identifier, password or second factor
+	ErrInvalidCredentials = errors.New("invalid identifier or password")
+	// ErrAuthFactorRequired is returned if the account needs a second factor and none was given
+	ErrAuthFactorRequired = errors.New("auth factor token required")
+)
+
+// Authenticator verifies the credentials a user enters on the consent screen
+type Authenticator interface {
+	// Authenticate returns the DID of the account identified by identifier.
+	// authFactorToken is the TOTP or recovery code, if the user entered one. r is
+	// the consent form submission, for throttling and auditing attempts by
+	// client; a locked out attempt returns *auth.ThrottledError.
+	Authenticate(r *http.Request, identifier, password, authFactorToken string) (string, error)
+}
+
+// TokenIssuer issues and revokes DPoP-bound tokens for OAuth clients
+type TokenIssuer interface {
+	CreateOAuthSession(ctx context.Context, did, clientID, scope, dpopJKT string) (*auth.SessionTokens, error)
+	RefreshOAuthSession(ctx context.Context, refreshToken, clientID, dpopJKT string) (*auth.Session, *auth.SessionTokens, error)
+	RevokeToken(ctx context.Context, token, clientID string) error
+}
+
+// ServerMetadata is the authorization server metadata document (RFC 8414)
+type ServerMetadata struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	PushedAuthorizationRequestEndpoint         string   `json:"pushed_authorization_request_endpoint"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint"`
+	ResponseTypesSupported                     []string `json:"response_types_supported"`
+	GrantTypesSupported                        []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported"`
+	TokenEndpointAuthMethodsSupported          []string `json:"token_endpoint_auth_methods_supported"`
+	ScopesSupported                            []string `json:"scopes_supported"`
+	DPoPSigningAlgValuesSupported              []string `json:"dpop_signing_alg_values_supported"`
+	RequirePushedAuthorizationRequests         bool     `json:"require_pushed_authorization_requests"`
+	AuthorizationResponseISSParameterSupported bool     `json:"authorization_response_iss_parameter_supported"`
+	ClientIDMetadataDocumentSupported          bool     `json:"client_id_metadata_document_supported"`
+}
+
+// TokenResponse is the response of the token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+	Subject      string `json:"sub"`
+}
+
+// ErrorResponse is an OAuth error response
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Server is an OAuth 2.1 authorization server for atproto clients. It requires
+// pushed authorization requests, PKCE and DPoP.
+type Server struct {
+	Issuer         string
+	Clients        *ClientResolver
+	Requests       RequestStore
+	Authenticator  Authenticator
+	Tokens         TokenIssuer
+	AccessTokenTTL time.Duration
+
+	dpop *auth.DPoPVerifier
+	mux  *http.ServeMux
+}
+
+// NewServer creates a new authorization server for issuer
+func NewServer(issuer string, clients *ClientResolver, requests RequestStore, authenticator Authenticator, tokens TokenIssuer) *Server {
+	s := &Server{
+		Issuer:         strings.TrimSuffix(issuer, "/"),
+		Clients:        clients,
+		Requests:       requests,
+		Authenticator:  authenticator,
+		Tokens:         tokens,
+		AccessTokenTTL: auth.DefaultAccessTokenTTL,
+		dpop:           auth.NewDPoPVerifier(true),
+		mux:            http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/.well-known/oauth-authorization-server", s.MetadataHandler)
+	s.mux.HandleFunc("/oauth/par", s.PARHandler)
+	s.mux.HandleFunc("/oauth/authorize", s.AuthorizeHandler)
+	s.mux.HandleFunc("/oauth/token", s.TokenHandler)
+	s.mux.HandleFunc("/oauth/revoke", s.RevokeHandler)
+	return s
+}
+
+// Register adds the server's endpoints to a mux
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.Handle("/.well-known/oauth-authorization-server", s)
+	mux.Handle("/oauth/", s)
+}
+
+// ServeHTTP serves the server's endpoints
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Metadata returns the server's metadata document
+func (s *Server) Metadata() *ServerMetadata {
+	return &ServerMetadata{
+		Issuer:                             s.Issuer,
+		AuthorizationEndpoint:              s.Issuer + "/oauth/authorize",
+		TokenEndpoint:                      s.Issuer + "/oauth/token",
+		PushedAuthorizationRequestEndpoint: s.Issuer + "/oauth/par",
+		RevocationEndpoint:                 s.Issuer + "/oauth/revoke",
+		ResponseTypesSupported:             []string{"code"},
+		GrantTypesSupported:                []string{"authorization_code", "refresh_token"},
+		CodeChallengeMethodsSupported:      []string{CodeChallengeS256},
+		TokenEndpointAuthMethodsSupported:  []string{"none"},
+		ScopesSupported:                    []string{auth.ScopeATProto, "transition:generic"},
+		DPoPSigningAlgValuesSupported: []string{
+			identity.KeyTypeEd25519.JWTAlg(),
+			identity.KeyTypeP256.JWTAlg(),
+			identity.KeyTypeSecp256k1.JWTAlg(),
+		},
+		RequirePushedAuthorizationRequests:         true,
+		AuthorizationResponseISSParameterSupported: true,
+		ClientIDMetadataDocumentSupported:          true,
+	}
+}
+
+// MetadataHandler serves the authorization server metadata
+func (s *Server) MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Metadata())
+}
+
+// PARHandler handles pushed authorization requests (RFC 9126)
+func (s *Server) PARHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Verify DPoP proof
+	jkt, ok := s.verifyDPoP(w, r, "/oauth/par")
+	if !ok {
+		return
+	}
+
+	// Resolve client
+	clientID := r.PostForm.Get("client_id")
+	client, err := s.Clients.Resolve(r.Context(), clientID)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_client", err.Error())
+		return
+	}
+
+	// Validate request
+	req := &AuthorizationRequest{
+		ID:                  randomToken(),
+		ClientID:            clientID,
+		RedirectURI:         r.PostForm.Get("redirect_uri"),
+		Scope:               r.PostForm.Get("scope"),
+		State:               r.PostForm.Get("state"),
+		CodeChallenge:       r.PostForm.Get("code_challenge"),
+		CodeChallengeMethod: r.PostForm.Get("code_challenge_method"),
+		LoginHint:           r.PostForm.Get("login_hint"),
+		DPoPJKT:             jkt,
+		ExpiresAt:           time.Now().Add(requestTTL),
+	}
+	if r.PostForm.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "unsupported_response_type", "response_type must be code")
+		return
+	}
+	if !client.AllowsRedirectURI(req.RedirectURI) {
+		writeError(w, http.StatusBadRequest, "invalid_request", "redirect_uri is not registered for the client")
+		return
+	}
+	if !auth.HasScope(req.Scope, auth.ScopeATProto) || !client.AllowsScope(req.Scope) {
+		writeError(w, http.StatusBadRequest, "invalid_scope", "scope must include atproto and only scopes declared by the client")
+		return
+	}
+	if req.State == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "state is required")
+		return
+	}
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != CodeChallengeS256 {
+		writeError(w, http.StatusBadRequest, "invalid_request", "an S256 code_challenge is required")
+		return
+	}
+
+	// Store request
+	if err := s.Requests.CreateRequest(r.Context(), req); err != nil {
+		log.Printf("Failed to store authorization request: %v", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Failed to store authorization request")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"request_uri": requestURIPrefix + req.ID,
+		"expires_in":  int64(requestTTL / time.Second),
+	})
+}
+
+// AuthorizeHandler shows the consent screen for a pushed authorization request
+// and redirects back to the client once the user approves or denies it
+func (s *Server) AuthorizeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Load request
+	requestURI := r.Form.Get("request_uri")
+	if !strings.HasPrefix(requestURI, requestURIPrefix) {
+		http.Error(w, "Invalid request_uri", http.StatusBadRequest)
+		return
+	}
+	req, err := s.Requests.GetRequest(r.Context(), strings.TrimPrefix(requestURI, requestURIPrefix))
+	if err != nil {
+		http.Error(w, "Authorization request not found or expired", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("client_id") != req.ClientID {
+		http.Error(w, "client_id does not match the authorization request", http.StatusBadRequest)
+		return
+	}
+
+	client, err := s.Clients.Resolve(r.Context(), req.ClientID)
+	if err != nil {
+		http.Error(w, "Invalid client", http.StatusBadRequest)
+		return
+	}
+
+	page := consentPage{
+		ClientName: client.DisplayName(),
+		ClientID:   req.ClientID,
+		RequestURI: requestURI,
+		Scopes:     strings.Fields(req.Scope),
+		Identifier: req.LoginHint,
+	}
+
+	if r.Method == http.MethodGet {
+		page.CSRFToken = s.setCSRFCookie(w, r)
+		renderConsent(w, http.StatusOK, page)
+		return
+	}
+
+	// Check CSRF token
+	cookie, err := r.Cookie(csrfCookie)
+	if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(r.PostForm.Get("csrf_token"))) != 1 {
+		http.Error(w, "Invalid or missing CSRF token, reload the page and try again", http.StatusForbidden)
+		return
+	}
+	page.CSRFToken = cookie.Value
+
+	// Handle denial
+	if r.PostForm.Get("decision") != "approve" {
+		s.Requests.DeleteRequest(r.Context(), req.ID)
+		s.redirect(w, r, req, url.Values{
+			"error":             {"access_denied"},
+			"error_description": {"The user denied the request"},
+		})
+		return
+	}
+
+	// Authenticate user
+	page.Identifier = r.PostForm.Get("identifier")
+	did, err := s.Authenticator.Authenticate(r, page.Identifier, r.PostForm.Get("password"), r.PostForm.Get("code"))
+	var throttled *auth.ThrottledError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", strconv.Itoa(int((throttled.Wait+time.Second-1)/time.Second)))
+		page.Error = "Too many failed attempts, please try again later"
+		renderConsent(w, http.StatusTooManyRequests, page)
+		return
+	}
+	if errors.Is(err, ErrInvalidCredentials) {
+		page.Error = "Invalid identifier or password"
+		renderConsent(w, http.StatusUnauthorized, page)
+		return
+	}
+	if errors.Is(err, ErrAuthFactorRequired) {
+		page.Error = "Enter the code from your authenticator app or a recovery code"
+		renderConsent(w, http.StatusUnauthorized, page)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to authenticate OAuth user: %v", err)
+		page.Error = "Sign in failed, please try again"
+		renderConsent(w, http.StatusInternalServerError, page)
+		return
+	}
+
+	// Issue authorization code
+	code := randomToken()
+	if err := s.Requests.ApproveRequest(r.Context(), req.ID, did, code, time.Now().Add(codeTTL)); err != nil {
+		http.Error(w, "Authorization request not found or expired", http.StatusBadRequest)
+		return
+	}
+
+	s.redirect(w, r, req, url.Values{"code": {code}})
+}
+
+// setCSRFCookie returns the CSRF token of the browser, setting a new one if it
+// has none yet. The cookie has no Path, so it is scoped to the directory the
+// browser sees the consent screen under, even behind a gateway.
+func (s *Server) setCSRFCookie(w http.ResponseWriter, r *http.Request) string {
+	if cookie, err := r.Cookie(csrfCookie); err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+	token := randomToken()
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    token,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.Issuer, "https://"),
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// redirect sends the user back to the client's redirect URI with params, the
+// request's state and the issuer
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest, params url.Values) {
+	redirectURL, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		http.Error(w, "Invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	query := redirectURL.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	query.Set("state", req.State)
+	query.Set("iss", s.Issuer)
+	redirectURL.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirectURL.String(), http.StatusSeeOther)
+}
+
+// TokenHandler exchanges authorization codes and refresh tokens for DPoP-bound tokens
+func (s *Server) TokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	// Verify DPoP proof
+	jkt, ok := s.verifyDPoP(w, r, "/oauth/token")
+	if !ok {
+		return
+	}
+
+	clientID := r.PostForm.Get("client_id")
+	if clientID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "client_id is required")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		s.exchangeCode(w, r, clientID, jkt)
+	case "refresh_token":
+		s.refreshToken(w, r, clientID, jkt)
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+	}
+}
+
+// exchangeCode handles the authorization_code grant
+func (s *Server) exchangeCode(w http.ResponseWriter, r *http.Request, clientID, jkt string) {
+	// Consume code
+	req, err := s.Requests.ConsumeCode(r.Context(), r.PostForm.Get("code"))
+	if errors.Is(err, ErrRequestNotFound) {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to consume authorization code: %v", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Failed to consume authorization code")
+		return
+	}
+
+	// Check the code was issued for this client, redirect URI, verifier and key
+	if req.ClientID != clientID {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "Authorization code was issued to another client")
+		return
+	}
+	if req.RedirectURI != r.PostForm.Get("redirect_uri") {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+		return
+	}
+	if !VerifyPKCE(req.CodeChallenge, req.CodeChallengeMethod, r.PostForm.Get("code_verifier")) {
+		writeError(w, http.StatusBadRequest, "invalid_grant", "Invalid code_verifier")
+		return
+	}
+	if req.DPoPJKT != jkt {
+		writeError(w, http.StatusBadRequest, "invalid_dpop_proof", "DPoP key does not match the authorization request")
+		return
+	}
+
+	// Issue tokens
+	tokens, err := s.Tokens.CreateOAuthSession(r.Context(), req.DID, clientID, req.Scope, jkt)
+	if err != nil {
+		log.Printf("Failed to create OAuth session: %v", err)
+		writeError(w, http.StatusInternalServerError, "server_error", "Failed to issue tokens")
+		return
+	}
+
+	s.writeTokens(w, tokens, req.DID, req.Scope)
+}
+
+// refreshToken handles the refresh_token grant
+func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request, clientID, jkt string) {
+	session, tokens, err := s.Tokens.RefreshOAuthSession(r.Context(), r.PostForm.Get("refresh_token"), clientID, jkt)
+	if err != nil {
+		if errors.Is(err, auth.ErrRefreshTokenReused) {
+			log.Printf("Refresh token reused by %s, session revoked", clientID)
+		}
+		writeError(w, http.StatusBadRequest, "invalid_grant", "Invalid refresh token")
+		return
+	}
+
+	s.writeTokens(w, tokens, session.DID, session.Scope)
+}
+
+// writeTokens writes a token response
+func (s *Server) writeTokens(w http.ResponseWriter, tokens *auth.SessionTokens, did, scope string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TokenResponse{
+		AccessToken:  tokens.AccessJwt,
+		TokenType:    "DPoP",
+		ExpiresIn:    int64(s.AccessTokenTTL / time.Second),
+		RefreshToken: tokens.RefreshJwt,
+		Scope:        scope,
+		Subject:      did,
+	})
+}
+
+// RevokeHandler revokes the session of an access or refresh token (RFC 7009)
+func (s *Server) RevokeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+	clientID := r.PostForm.Get("client_id")
+	if token == "" || clientID == "" {
+		writeError(w, http.StatusBadRequest, "invalid_request", "token and client_id are required")
+		return
+	}
+
+	// Invalid tokens are not an error, there is nothing left to revoke
+	if err := s.Tokens.RevokeToken(r.Context(), token, clientID); err != nil {
+		log.Printf("Failed to revoke token for %s: %v", clientID, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// verifyDPoP verifies the DPoP proof of a request to an endpoint and returns
+// its key thumbprint. Every response carries a fresh nonce; proofs without one
+// are rejected with use_dpop_nonce.
+func (s *Server) verifyDPoP(w http.ResponseWriter, r *http.Request, path string) (string, bool) {
+	w.Header().Set("DPoP-Nonce", s.dpop.Nonce())
+
+	proof := r.Header.Get("DPoP")
+	if proof == "" {
+		writeError(w, http.StatusBadRequest, "invalid_dpop_proof", "DPoP proof is required")
+		return "", false
+	}
+
+	jkt, err := s.dpop.Verify(proof, r.Method, s.Issuer+path, "")
+	if errors.Is(err, auth.ErrUseDPoPNonce) {
+		writeError(w, http.StatusBadRequest, "use_dpop_nonce", "Authorization server requires nonce in DPoP proof")
+		return "", false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_dpop_proof", err.Error())
+		return "", false
+	}
+	return jkt, true
+}
+
+// writeError writes an OAuth error response
+func writeError(w http.ResponseWriter, status int, code, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error:            code,
+		ErrorDescription: description,
+	})
+}
+
+// randomToken returns a random URL-safe token
+func randomToken() string {
+	buf := make([]byte, 32)
+	rand.Read(buf)
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// consentPage is the data of the consent screen
+type consentPage struct {
+	ClientName string
+	ClientID   string
+	RequestURI string
+	Scopes     []string
+	Identifier string
+	CSRFToken  string
+	Error      string
+}
+
+// consentTemplate renders the consent screen
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Authorize {{.ClientName}}</title>
+</head>
+<body>
+<h1>Authorize {{.ClientName}}</h1>
+<p>{{.ClientID}} is requesting access to your account:</p>
+<ul>
+{{range .Scopes}}<li>{{.}}</li>
+{{end}}</ul>
+{{if .Error}}<p role="alert">{{.Error}}</p>
+{{end}}<form method="post">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="request_uri" value="{{.RequestURI}}">
+<input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+<label>Handle or DID <input name="identifier" value="{{.Identifier}}" autocomplete="username"></label>
+<label>Password <input name="password" type="password" autocomplete="current-password"></label>
+<label>Two-factor code, if enabled <input name="code" autocomplete="one-time-code"></label>
+<button name="decision" value="approve">Allow</button>
+<button name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// renderConsent writes the consent screen
+func renderConsent(w http.ResponseWriter, status int, page consentPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := consentTemplate.Execute(w, page); err != nil {
+		log.Printf("Failed to render consent page: %v", err)
+	}
+}
@@ -0,0 +1,490 @@
+package oauth
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/auth"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+const (
+	testUserDID     = "did:plc:alice234567abcdefghijklm"
+	testRedirectURI = "http://127.0.0.1/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+var testClientID = "http://localhost?redirect_uri=" + url.QueryEscape(testRedirectURI)
+
+// fakeAuthenticator accepts alice.test with password hunter2 and locks out locked.test
+type fakeAuthenticator struct{}
+
+func (fakeAuthenticator) Authenticate(r *http.Request, identifier, password, authFactorToken string) (string, error) {
+	switch {
+	case identifier == "locked.test":
+		return "", &auth.ThrottledError{Wait: 30 * time.Second}
+	case identifier == "alice.test" && password == "hunter2":
+		return testUserDID, nil
+	}
+	return "", ErrInvalidCredentials
+}
+
+// fakeSession is a session of fakeTokens
+type fakeSession struct {
+	did, clientID, scope, jkt string
+	refresh                   string
+	revoked                   bool
+}
+
+// fakeTokens issues opaque tokens and, like the session store, revokes a
+// session when one of its old refresh tokens is used again
+type fakeTokens struct {
+	mu       sync.Mutex
+	sessions map[string]*fakeSession
+	refresh  map[string]string
+	n        int
+}
+
+func newFakeTokens() *fakeTokens {
+	return &fakeTokens{sessions: make(map[string]*fakeSession), refresh: make(map[string]string)}
+}
+
+func (f *fakeTokens) issue(id string, session *fakeSession) *auth.SessionTokens {
+	f.n++
+	session.refresh = fmt.Sprintf("refresh-%s-%d", id, f.n)
+	f.refresh[session.refresh] = id
+	return &auth.SessionTokens{AccessJwt: fmt.Sprintf("access-%s-%d", id, f.n), RefreshJwt: session.refresh}
+}
+
+func (f *fakeTokens) CreateOAuthSession(ctx context.Context, did, clientID, scope, dpopJKT string) (*auth.SessionTokens, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := fmt.Sprintf("s%d", len(f.sessions)+1)
+	session := &fakeSession{did: did, clientID: clientID, scope: scope, jkt: dpopJKT}
+	f.sessions[id] = session
+	return f.issue(id, session), nil
+}
+
+func (f *fakeTokens) RefreshOAuthSession(ctx context.Context, refreshToken, clientID, dpopJKT string) (*auth.Session, *auth.SessionTokens, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, ok := f.refresh[refreshToken]
+	if !ok {
+		return nil, nil, auth.ErrSessionNotFound
+	}
+	session := f.sessions[id]
+	if session.clientID != clientID || session.jkt != dpopJKT {
+		return nil, nil, fmt.Errorf("refresh token was issued to another client or key")
+	}
+	if session.revoked {
+		return nil, nil, auth.ErrSessionRevoked
+	}
+	if session.refresh != refreshToken {
+		session.revoked = true
+		return nil, nil, auth.ErrRefreshTokenReused
+	}
+	tokens := f.issue(id, session)
+	return &auth.Session{ID: id, DID: session.did, Scope: session.scope}, tokens, nil
+}
+
+func (f *fakeTokens) RevokeToken(ctx context.Context, token, clientID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, ok := f.refresh[token]
+	if !ok || f.sessions[id].clientID != clientID {
+		return auth.ErrSessionNotFound
+	}
+	f.sessions[id].revoked = true
+	return nil
+}
+
+// testClient is an OAuth client with a DPoP key and a browser cookie jar
+type testClient struct {
+	t      *testing.T
+	server *httptest.Server
+	http   *http.Client
+	key    identity.PrivateKey
+	nonce  string
+}
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server, *fakeTokens) {
+	t.Helper()
+	tokens := newFakeTokens()
+	srv := NewServer("http://placeholder", NewClientResolver(), NewMemoryRequestStore(), fakeAuthenticator{}, tokens)
+	ts := httptest.NewServer(srv)
+	t.Cleanup(ts.Close)
+	srv.Issuer = ts.URL
+	return srv, ts, tokens
+}
+
+func newTestClient(t *testing.T, ts *httptest.Server) *testClient {
+	t.Helper()
+	key, err := identity.GenerateKey(identity.KeyTypeP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{
+		t:      t,
+		server: ts,
+		key:    key,
+		http: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// proof creates a DPoP proof for a request
+func (c *testClient) proof(method, path string) string {
+	c.t.Helper()
+	header, _ := json.Marshal(map[string]interface{}{
+		"typ": "dpop+jwt",
+		"alg": c.key.Type().JWTAlg(),
+		"jwk": identity.NewJWK(c.key.Public()),
+	})
+	claims, _ := json.Marshal(auth.DPoPProof{
+		JWTID:    randomToken(),
+		Method:   method,
+		URL:      c.server.URL + path,
+		IssuedAt: time.Now().Unix(),
+		Nonce:    c.nonce,
+	})
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sig, err := c.key.Sign([]byte(input))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// post sends a form with a DPoP proof, remembering the server's nonce
+func (c *testClient) post(path string, form url.Values) (int, map[string]interface{}) {
+	c.t.Helper()
+	req, err := http.NewRequest(http.MethodPost, c.server.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("DPoP", c.proof(http.MethodPost, path))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if nonce := resp.Header.Get("DPoP-Nonce"); nonce != "" {
+		c.nonce = nonce
+	}
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+// par pushes an authorization request and returns its request_uri
+func (c *testClient) par() string {
+	c.t.Helper()
+	form := url.Values{
+		"client_id":             {testClientID},
+		"response_type":         {"code"},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {auth.ScopeATProto},
+		"state":                 {"xyz"},
+		"code_challenge":        {NewCodeChallenge(testVerifier)},
+		"code_challenge_method": {CodeChallengeS256},
+		"login_hint":            {"alice.test"},
+	}
+	status, body := c.post("/oauth/par", form)
+	if status == http.StatusBadRequest && body["error"] == "use_dpop_nonce" {
+		status, body = c.post("/oauth/par", form)
+	}
+	if status != http.StatusCreated {
+		c.t.Fatalf("PAR status = %d (%v), want %d", status, body, http.StatusCreated)
+	}
+	requestURI, _ := body["request_uri"].(string)
+	if !strings.HasPrefix(requestURI, requestURIPrefix) {
+		c.t.Fatalf("PAR request_uri = %q", requestURI)
+	}
+	return requestURI
+}
+
+var csrfFieldPattern = regexp.MustCompile(`name="csrf_token" value="([^"]+)"`)
+
+// consent loads the consent screen and returns its CSRF token
+func (c *testClient) consent(requestURI string) string {
+	c.t.Helper()
+	query := url.Values{"client_id": {testClientID}, "request_uri": {requestURI}}
+	resp, err := c.http.Get(c.server.URL + "/oauth/authorize?" + query.Encode())
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.t.Fatalf("consent screen status = %d", resp.StatusCode)
+	}
+	page, err := io.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	match := csrfFieldPattern.FindSubmatch(page)
+	if match == nil {
+		c.t.Fatal("consent screen has no CSRF token")
+	}
+	return string(match[1])
+}
+
+// submit posts the consent form and returns the response
+func (c *testClient) submit(requestURI string, fields url.Values) *http.Response {
+	c.t.Helper()
+	form := url.Values{"client_id": {testClientID}, "request_uri": {requestURI}}
+	for k, v := range fields {
+		form[k] = v
+	}
+	resp, err := c.http.PostForm(c.server.URL+"/oauth/authorize", form)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestAuthorizationFlow(t *testing.T) {
+	srv, ts, tokens := newTestServer(t)
+	client := newTestClient(t, ts)
+
+	// Push the authorization request
+	requestURI := client.par()
+
+	// Show the consent screen and approve it
+	csrf := client.consent(requestURI)
+	approve := url.Values{
+		"csrf_token": {csrf},
+		"identifier": {"alice.test"},
+		"password":   {"hunter2"},
+		"decision":   {"approve"},
+	}
+	resp := client.submit(requestURI, approve)
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("consent status = %d, want %d", resp.StatusCode, http.StatusSeeOther)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := location.Scheme + "://" + location.Host + location.Path; got != testRedirectURI {
+		t.Fatalf("redirected to %s, want %s", got, testRedirectURI)
+	}
+	if location.Query().Get("state") != "xyz" || location.Query().Get("iss") != srv.Issuer {
+		t.Fatalf("redirect query = %v, want state xyz and iss %s", location.Query(), srv.Issuer)
+	}
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatal("redirect has no code")
+	}
+
+	// Exchange the code
+	exchange := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {testClientID},
+		"code":          {code},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {testVerifier},
+	}
+	status, body := client.post("/oauth/token", exchange)
+	if status != http.StatusOK {
+		t.Fatalf("token status = %d (%v), want %d", status, body, http.StatusOK)
+	}
+	if body["token_type"] != "DPoP" || body["sub"] != testUserDID || body["scope"] != auth.ScopeATProto {
+		t.Fatalf("token response = %v", body)
+	}
+	refresh1, _ := body["refresh_token"].(string)
+
+	// Codes are single use
+	status, body = client.post("/oauth/token", exchange)
+	if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("second exchange = %d %v, want invalid_grant", status, body)
+	}
+
+	// Refresh the tokens
+	refresh := func(token string) (int, map[string]interface{}) {
+		return client.post("/oauth/token", url.Values{
+			"grant_type":    {"refresh_token"},
+			"client_id":     {testClientID},
+			"refresh_token": {token},
+		})
+	}
+	status, body = refresh(refresh1)
+	if status != http.StatusOK {
+		t.Fatalf("refresh status = %d (%v), want %d", status, body, http.StatusOK)
+	}
+	refresh2, _ := body["refresh_token"].(string)
+	if refresh2 == "" || refresh2 == refresh1 {
+		t.Fatalf("refresh token was not rotated: %q", refresh2)
+	}
+
+	// Another DPoP key cannot use the refresh token
+	thief := newTestClient(t, ts)
+	thief.nonce = client.nonce
+	status, body = thief.post("/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"client_id":     {testClientID},
+		"refresh_token": {refresh2},
+	})
+	if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("refresh with another key = %d %v, want invalid_grant", status, body)
+	}
+
+	// Reusing the old refresh token revokes the session, so the current one stops working too
+	status, body = refresh(refresh1)
+	if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("reused refresh = %d %v, want invalid_grant", status, body)
+	}
+	status, body = refresh(refresh2)
+	if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("refresh after reuse = %d %v, want invalid_grant", status, body)
+	}
+	if !tokens.sessions["s1"].revoked {
+		t.Fatal("session was not revoked after refresh token reuse")
+	}
+}
+
+func TestAuthorizeCSRF(t *testing.T) {
+	_, ts, _ := newTestServer(t)
+	client := newTestClient(t, ts)
+	requestURI := client.par()
+	csrf := client.consent(requestURI)
+
+	credentials := url.Values{
+		"identifier": {"alice.test"},
+		"password":   {"hunter2"},
+		"decision":   {"approve"},
+	}
+
+	// Without the token, or with another one, the form is refused
+	if resp := client.submit(requestURI, credentials); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("consent without CSRF token = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+	credentials.Set("csrf_token", "forged")
+	if resp := client.submit(requestURI, credentials); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("consent with a forged CSRF token = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	// A cross-site form has the token from its own page, but not the cookie
+	other := newTestClient(t, ts)
+	credentials.Set("csrf_token", csrf)
+	if resp := other.submit(requestURI, credentials); resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("consent without CSRF cookie = %d, want %d", resp.StatusCode, http.StatusForbidden)
+	}
+
+	// The real form goes through
+	if resp := client.submit(requestURI, credentials); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("consent with CSRF token = %d, want %d", resp.StatusCode, http.StatusSeeOther)
+	}
+}
+
+func TestAuthorizeErrors(t *testing.T) {
+	_, ts, _ := newTestServer(t)
+	client := newTestClient(t, ts)
+	requestURI := client.par()
+	csrf := client.consent(requestURI)
+
+	tests := []struct {
+		name       string
+		identifier string
+		password   string
+		status     int
+	}{
+		{"wrong password", "alice.test", "wrong", http.StatusUnauthorized},
+		{"locked out", "locked.test", "hunter2", http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := client.submit(requestURI, url.Values{
+				"csrf_token": {csrf},
+				"identifier": {tt.identifier},
+				"password":   {tt.password},
+				"decision":   {"approve"},
+			})
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status == http.StatusTooManyRequests && resp.Header.Get("Retry-After") != "30" {
+				t.Fatalf("Retry-After = %q, want 30", resp.Header.Get("Retry-After"))
+			}
+		})
+	}
+
+	// Denying the request redirects with access_denied and ends it
+	resp := client.submit(requestURI, url.Values{"csrf_token": {csrf}, "decision": {"deny"}})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("deny status = %d, want %d", resp.StatusCode, http.StatusSeeOther)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if location.Query().Get("error") != "access_denied" {
+		t.Fatalf("deny redirect = %s, want error=access_denied", location)
+	}
+	resp = client.submit(requestURI, url.Values{"csrf_token": {csrf}, "decision": {"approve"}})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("approve after deny = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestTokenPKCE(t *testing.T) {
+	_, ts, _ := newTestServer(t)
+	client := newTestClient(t, ts)
+	requestURI := client.par()
+	csrf := client.consent(requestURI)
+	resp := client.submit(requestURI, url.Values{
+		"csrf_token": {csrf},
+		"identifier": {"alice.test"},
+		"password":   {"hunter2"},
+		"decision":   {"approve"},
+	})
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := client.post("/oauth/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {testClientID},
+		"code":          {location.Query().Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {strings.Repeat("x", 43)},
+	})
+	if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+		t.Fatalf("exchange with the wrong verifier = %d %v, want invalid_grant", status, body)
+	}
+}
+
+func TestPARRequiresDPoPNonce(t *testing.T) {
+	_, ts, _ := newTestServer(t)
+	client := newTestClient(t, ts)
+
+	status, body := client.post("/oauth/par", url.Values{"client_id": {testClientID}})
+	if status != http.StatusBadRequest || body["error"] != "use_dpop_nonce" {
+		t.Fatalf("PAR without nonce = %d %v, want use_dpop_nonce", status, body)
+	}
+	if client.nonce == "" {
+		t.Fatal("PAR response has no DPoP-Nonce header")
+	}
+}
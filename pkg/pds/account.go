@@ -0,0 +1,87 @@
+package pds
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Account represents an account hosted on the PDS
+type Account struct {
+	DID        string    `json:"did"`
+	Handle     string    `json:"handle"`
+	SigningKey string    `json:"signingKey"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// AccountRepository handles account data access
+type AccountRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewAccountRepository creates a new account repository
+func NewAccountRepository(db *pgxpool.Pool) *AccountRepository {
+	return &AccountRepository{db: db}
+}
+
+// CreateAccount creates a new account
+func (r *AccountRepository) CreateAccount(ctx context.Context, account *Account) error {
+	query := `
+		INSERT INTO accounts (did, handle, signing_key, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	now := time.Now()
+	_, err := r.db.Exec(ctx, query,
+		account.DID,
+		account.Handle,
+		account.SigningKey,
+		now,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create account: %w", err)
+	}
+
+	account.CreatedAt = now
+	account.UpdatedAt = now
+
+	return nil
+}
+
+// GetAccount gets an account by DID
+func (r *AccountRepository) GetAccount(ctx context.Context, did string) (*Account, error) {
+	query := `
+		SELECT did, handle, signing_key, created_at, updated_at
+		FROM accounts
+		WHERE did = $1
+	`
+	var account Account
+	err := r.db.QueryRow(ctx, query, did).Scan(
+		&account.DID,
+		&account.Handle,
+		&account.SigningKey,
+		&account.CreatedAt,
+		&account.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get account: %w", err)
+	}
+	return &account, nil
+}
+
+// UpdateHandle changes the handle of an account. Unknown DIDs are ignored.
+func (r *AccountRepository) UpdateHandle(ctx context.Context, did, handle string) error {
+	query := `
+		UPDATE accounts
+		SET handle = $1, updated_at = $2
+		WHERE did = $3
+	`
+	_, err := r.db.Exec(ctx, query, handle, time.Now(), did)
+	if err != nil {
+		return fmt.Errorf("failed to update handle: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package pds
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Blob represents a binary object stored in a repository
+type Blob struct {
+	CID           string    `json:"cid"`
+	RepositoryDID string    `json:"repositoryDid"`
+	MimeType      string    `json:"mimeType"`
+	Data          []byte    `json:"data"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// BlobCID returns the content identifier of blob data
+func BlobCID(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// CreateBlob stores a blob, ignoring duplicates
+func (r *RepositoryRepository) CreateBlob(ctx context.Context, blob *Blob) error {
+	query := `
+		INSERT INTO blobs (cid, repository_did, mime_type, data, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (repository_did, cid) DO NOTHING
+	`
+	now := time.Now()
+	blob.CID = BlobCID(blob.Data)
+	_, err := r.db.Exec(ctx, query,
+		blob.CID,
+		blob.RepositoryDID,
+		blob.MimeType,
+		blob.Data,
+		now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+
+	blob.CreatedAt = now
+
+	return nil
+}
+
+// GetBlob gets a blob by CID
+func (r *RepositoryRepository) GetBlob(ctx context.Context, repositoryDID, cid string) (*Blob, error) {
+	query := `
+		SELECT cid, repository_did, mime_type, data, created_at
+		FROM blobs
+		WHERE repository_did = $1 AND cid = $2
+	`
+	var blob Blob
+	err := r.db.QueryRow(ctx, query, repositoryDID, cid).Scan(
+		&blob.CID,
+		&blob.RepositoryDID,
+		&blob.MimeType,
+		&blob.Data,
+		&blob.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+	return &blob, nil
+}
+
+// ListBlobs gets all blobs in a repository
+func (r *RepositoryRepository) ListBlobs(ctx context.Context, repositoryDID string) ([]*Blob, error) {
+	query := `
+		SELECT cid, repository_did, mime_type, data, created_at
+		FROM blobs
+		WHERE repository_did = $1
+		ORDER BY created_at ASC
+	`
+	rows, err := r.db.Query(ctx, query, repositoryDID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blobs: %w", err)
+	}
+	defer rows.Close()
+
+	var blobs []*Blob
+	for rows.Next() {
+		var blob Blob
+		err := rows.Scan(
+			&blob.CID,
+			&blob.RepositoryDID,
+			&blob.MimeType,
+			&blob.Data,
+			&blob.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan blob: %w", err)
+		}
+		blobs = append(blobs, &blob)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating blobs: %w", err)
+	}
+	return blobs, nil
+}
@@ -0,0 +1,135 @@
+package pds
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// Genesis repository errors
+var (
+	// ErrRepositoryExists is returned when creating a repository for an account that already has one
+	ErrRepositoryExists = errors.New("repository already exists")
+	// ErrHandleTaken is returned when creating an account with a handle another account has
+	ErrHandleTaken = errors.New("handle already taken")
+)
+
+// genesisOp is the data of the first commit of a repository
+type genesisOp struct {
+	Op        string `json:"op"`
+	DID       string `json:"did"`
+	Prev      string `json:"prev"`
+	CreatedAt string `json:"createdAt"`
+}
+
+// NewGenesisCommit creates the first commit of an empty repository, signed with
+// the account's signing key
+func NewGenesisCommit(did string, signingKey identity.PrivateKey) (*Commit, error) {
+	data, err := json.Marshal(genesisOp{
+		Op:        "init",
+		DID:       did,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal genesis commit: %w", err)
+	}
+
+	signature, err := signingKey.Sign(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign genesis commit: %w", err)
+	}
+
+	return &Commit{
+		ID:            BlobCID(data),
+		RepositoryDID: did,
+		Data:          data,
+		Signature:     signature,
+	}, nil
+}
+
+// VerifyGenesisCommit checks that a commit is the first commit of did's
+// repository and is signed with its signing key
+func VerifyGenesisCommit(commit *Commit, did string, signingKey identity.PublicKey) error {
+	if commit.RepositoryDID != did {
+		return fmt.Errorf("commit %s belongs to another repository", commit.ID)
+	}
+	if commit.Prev != "" {
+		return fmt.Errorf("genesis commit %s has a prev commit", commit.ID)
+	}
+	if BlobCID(commit.Data) != commit.ID {
+		return fmt.Errorf("commit %s does not match its content", commit.ID)
+	}
+
+	var op genesisOp
+	if err := json.Unmarshal(commit.Data, &op); err != nil {
+		return fmt.Errorf("invalid commit %s: %w", commit.ID, err)
+	}
+	if op.Op != "init" || op.DID != did || op.Prev != "" {
+		return fmt.Errorf("commit %s is not a genesis commit for %s", commit.ID, did)
+	}
+
+	if !signingKey.Verify(commit.Data, commit.Signature) {
+		return fmt.Errorf("invalid signature on genesis commit %s", commit.ID)
+	}
+	return nil
+}
+
+// CreateGenesisRepository creates an account and its repository with the
+// genesis commit as head, in one transaction
+func (r *RepositoryRepository) CreateGenesisRepository(ctx context.Context, account *Account, commit *Commit) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO accounts (did, handle, signing_key, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+	`, account.DID, account.Handle, account.SigningKey, now)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			if pgErr.ConstraintName == "accounts_handle_key" {
+				return ErrHandleTaken
+			}
+			return ErrRepositoryExists
+		}
+		return fmt.Errorf("failed to create account: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO repositories (did, head, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $4)
+	`, account.DID, commit.ID, RepoStatusActive, now)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return ErrRepositoryExists
+		}
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO commits (id, repository_did, prev, data, signature, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, commit.ID, account.DID, commit.Prev, commit.Data, commit.Signature, now)
+	if err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit repository: %w", err)
+	}
+
+	account.CreatedAt = now
+	account.UpdatedAt = now
+	commit.CreatedAt = now
+	return nil
+}
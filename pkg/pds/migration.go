@@ -0,0 +1,320 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// maxExportSize limits the size of a repository export fetched during migration
+const maxExportSize = 512 << 20
+
+// RepoExport is a full copy of a repository used for account migration
+type RepoExport struct {
+	DID       string      `json:"did"`
+	Head      string      `json:"head"`
+	Commits   []*Commit   `json:"commits"`
+	Documents []*Document `json:"documents"`
+	Blobs     []*Blob     `json:"blobs"`
+}
+
+// commitOp is the decoded data of a commit
+type commitOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+	Prev  *string         `json:"prev"`
+}
+
+// ExportRepository exports a repository with its commit chain, documents and blobs
+func (r *RepositoryRepository) ExportRepository(ctx context.Context, did string) (*RepoExport, error) {
+	repo, err := r.GetRepository(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk the commit chain back from head
+	var commits []*Commit
+	for id := repo.Head; id != ""; {
+		commit, err := r.GetCommit(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if commit.RepositoryDID != did {
+			return nil, fmt.Errorf("commit %s belongs to another repository", id)
+		}
+		commits = append(commits, commit)
+		id = commit.Prev
+	}
+
+	// Order commits from the first to head
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+
+	docs, err := r.getAllDocuments(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs, err := r.ListBlobs(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepoExport{
+		DID:       did,
+		Head:      repo.Head,
+		Commits:   commits,
+		Documents: docs,
+		Blobs:     blobs,
+	}, nil
+}
+
+// getAllDocuments gets every document in a repository
+func (r *RepositoryRepository) getAllDocuments(ctx context.Context, repositoryDID string) ([]*Document, error) {
+	query := `
+		SELECT id, repository_did, type, value, created_at, updated_at
+		FROM documents
+		WHERE repository_did = $1
+		ORDER BY created_at ASC
+	`
+	rows, err := r.db.Query(ctx, query, repositoryDID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get documents: %w", err)
+	}
+	defer rows.Close()
+
+	var docs []*Document
+	for rows.Next() {
+		var doc Document
+		err := rows.Scan(
+			&doc.ID,
+			&doc.RepositoryDID,
+			&doc.Type,
+			&doc.Value,
+			&doc.CreatedAt,
+			&doc.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan document: %w", err)
+		}
+		docs = append(docs, &doc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating documents: %w", err)
+	}
+	return docs, nil
+}
+
+// VerifyExport checks that an export's commit chain is intact, that its
+// documents are exactly the result of replaying the chain, and that its blobs
+// match their CIDs. signingKeys are the signing keys the account has had, oldest
+// first, the last being the current one. Every commit must name its prev commit
+// in its data and be signed by a key no older than the one that signed the
+// commit before it, and the head commit must be signed by the current key.
+func VerifyExport(export *RepoExport, signingKeys []identity.PublicKey) error {
+	if len(signingKeys) == 0 {
+		return fmt.Errorf("no signing key to verify the export with")
+	}
+	if len(export.Commits) == 0 {
+		return fmt.Errorf("export has no commits")
+	}
+
+	docs := make(map[string]json.RawMessage)
+
+	prev := ""
+	keyIndex := 0
+	for _, commit := range export.Commits {
+		if commit.RepositoryDID != export.DID {
+			return fmt.Errorf("commit %s belongs to another repository", commit.ID)
+		}
+		if commit.Prev != prev {
+			return fmt.Errorf("commit %s does not follow %q", commit.ID, prev)
+		}
+		if id := BlobCID(commit.Data); id != commit.ID {
+			return fmt.Errorf("commit %s does not match its content", commit.ID)
+		}
+
+		var op commitOp
+		if err := json.Unmarshal(commit.Data, &op); err != nil {
+			return fmt.Errorf("invalid commit %s: %w", commit.ID, err)
+		}
+		if op.Prev == nil || *op.Prev != commit.Prev {
+			return fmt.Errorf("commit %s has inconsistent prev", commit.ID)
+		}
+		if len(commit.Signature) == 0 {
+			return fmt.Errorf("commit %s is not signed", commit.ID)
+		}
+
+		// Keys are only rotated forward, so later commits cannot go back to an older key
+		for keyIndex < len(signingKeys) && !signingKeys[keyIndex].Verify(commit.Data, commit.Signature) {
+			keyIndex++
+		}
+		if keyIndex == len(signingKeys) {
+			return fmt.Errorf("invalid signature on commit %s", commit.ID)
+		}
+
+		// Replay the operation
+		switch op.Op {
+		case "create", "update":
+			docs[op.Path] = op.Value
+		case "delete":
+			delete(docs, op.Path)
+		}
+
+		prev = commit.ID
+	}
+	if prev != export.Head {
+		return fmt.Errorf("commit chain does not end at head %s", export.Head)
+	}
+	if keyIndex != len(signingKeys)-1 {
+		return fmt.Errorf("head commit %s is not signed by the current signing key", prev)
+	}
+
+	// Documents must match the replayed chain
+	if len(export.Documents) != len(docs) {
+		return fmt.Errorf("export has %d documents, commit chain has %d", len(export.Documents), len(docs))
+	}
+	for _, doc := range export.Documents {
+		value, ok := docs[doc.ID]
+		if !ok {
+			return fmt.Errorf("document %s is not in the commit chain", doc.ID)
+		}
+		if !jsonEqual(value, doc.Value) {
+			return fmt.Errorf("document %s does not match the commit chain", doc.ID)
+		}
+	}
+
+	for _, blob := range export.Blobs {
+		if BlobCID(blob.Data) != blob.CID {
+			return fmt.Errorf("blob %s does not match its content", blob.CID)
+		}
+	}
+
+	return nil
+}
+
+// jsonEqual compares two JSON values ignoring formatting and key order
+func jsonEqual(a, b json.RawMessage) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	ea, _ := json.Marshal(va)
+	eb, _ := json.Marshal(vb)
+	return bytes.Equal(ea, eb)
+}
+
+// ImportRepository verifies an export against the account's signing keys, oldest
+// first, and stores it as a new repository
+func (r *RepositoryRepository) ImportRepository(ctx context.Context, export *RepoExport, signingKeys []identity.PublicKey) error {
+	if err := VerifyExport(export, signingKeys); err != nil {
+		return fmt.Errorf("failed to verify repository: %w", err)
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	now := time.Now()
+	_, err = tx.Exec(ctx, `
+		INSERT INTO repositories (did, head, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, export.DID, export.Head, RepoStatusActive, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create repository: %w", err)
+	}
+
+	for _, commit := range export.Commits {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO commits (id, repository_did, prev, data, signature, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, commit.ID, export.DID, commit.Prev, commit.Data, commit.Signature, commit.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to import commit: %w", err)
+		}
+	}
+
+	for _, doc := range export.Documents {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO documents (id, repository_did, type, value, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+		`, doc.ID, export.DID, doc.Type, doc.Value, doc.CreatedAt, doc.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to import document: %w", err)
+		}
+	}
+
+	for _, blob := range export.Blobs {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO blobs (cid, repository_did, mime_type, data, created_at)
+			VALUES ($1, $2, $3, $4, $5)
+		`, blob.CID, export.DID, blob.MimeType, blob.Data, blob.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to import blob: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit import: %w", err)
+	}
+	return nil
+}
+
+// UpdateRepositoryStatus updates the status of a repository
+func (r *RepositoryRepository) UpdateRepositoryStatus(ctx context.Context, did, status string) error {
+	query := `
+		UPDATE repositories
+		SET status = $1, updated_at = $2
+		WHERE did = $3
+	`
+	tag, err := r.db.Exec(ctx, query, status, time.Now(), did)
+	if err != nil {
+		return fmt.Errorf("failed to update repository status: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("repository not found: %s", did)
+	}
+	return nil
+}
+
+// FetchExport downloads a repository export from another PDS, authorized by a service token
+func FetchExport(ctx context.Context, client *http.Client, source, did, serviceToken string) (*RepoExport, error) {
+	exportURL := strings.TrimSuffix(source, "/") + "/migration/export?did=" + url.QueryEscape(did)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, exportURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+serviceToken)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch export: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("source PDS returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var export RepoExport
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxExportSize)).Decode(&export); err != nil {
+		return nil, fmt.Errorf("invalid export: %w", err)
+	}
+	if export.DID != did {
+		return nil, fmt.Errorf("export is for %s, expected %s", export.DID, did)
+	}
+	return &export, nil
+}
@@ -0,0 +1,256 @@
+package pds
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+const testDID = "did:plc:alice234567abcdefghijklm"
+
+// testRepo builds exports of a small repository signed with key
+type testRepo struct {
+	t   *testing.T
+	key identity.PrivateKey
+	exp *RepoExport
+}
+
+func newTestRepo(t *testing.T, key identity.PrivateKey) *testRepo {
+	t.Helper()
+	genesis, err := NewGenesisCommit(testDID, key)
+	if err != nil {
+		t.Fatalf("NewGenesisCommit: %v", err)
+	}
+	return &testRepo{t: t, key: key, exp: &RepoExport{
+		DID:     testDID,
+		Head:    genesis.ID,
+		Commits: []*Commit{genesis},
+	}}
+}
+
+// commit appends a signed commit for an operation on path
+func (r *testRepo) commit(op, path, value string) *testRepo {
+	r.t.Helper()
+	prev := r.exp.Head
+	c := commitOp{Op: op, Path: path, Prev: &prev}
+	if value != "" {
+		c.Value = json.RawMessage(value)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	if err := CheckCommitData(data); err != nil {
+		r.t.Fatalf("CheckCommitData: %v", err)
+	}
+	sig, err := r.key.Sign(data)
+	if err != nil {
+		r.t.Fatal(err)
+	}
+	commit := &Commit{ID: BlobCID(data), RepositoryDID: testDID, Prev: prev, Data: data, Signature: sig}
+	r.exp.Commits = append(r.exp.Commits, commit)
+	r.exp.Head = commit.ID
+	return r
+}
+
+// document adds a document to the export
+func (r *testRepo) document(path, value string) *testRepo {
+	r.exp.Documents = append(r.exp.Documents, &Document{ID: path, RepositoryDID: testDID, Type: "app.bsky.feed.post", Value: json.RawMessage(value)})
+	return r
+}
+
+func TestVerifyExport(t *testing.T) {
+	key, err := identity.GenerateKey(identity.KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := identity.GenerateKey(identity.KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current := []identity.PublicKey{key.Public()}
+
+	// valid returns an export whose documents match its chain
+	valid := func() *testRepo {
+		return newTestRepo(t, key).
+			commit("create", "post/1", `{"text":"one"}`).
+			commit("create", "post/2", `{"text":"two"}`).
+			commit("update", "post/1", `{"text":"uno"}`).
+			commit("delete", "post/2", "").
+			document("post/1", `{ "text": "uno" }`)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(exp *RepoExport)
+		keys   []identity.PublicKey
+		ok     bool
+	}{
+		{"valid", func(exp *RepoExport) {}, current, true},
+		{"with matching blob", func(exp *RepoExport) {
+			exp.Blobs = []*Blob{{CID: BlobCID([]byte("image")), Data: []byte("image")}}
+		}, current, true},
+		{"no signing key", func(exp *RepoExport) {}, nil, false},
+		{"other signing key", func(exp *RepoExport) {}, []identity.PublicKey{other.Public()}, false},
+		{"no commits", func(exp *RepoExport) { exp.Commits = nil }, current, false},
+		{"unsigned commit", func(exp *RepoExport) { exp.Commits[1].Signature = nil }, current, false},
+		{"unsigned head", func(exp *RepoExport) { exp.Commits[len(exp.Commits)-1].Signature = nil }, current, false},
+		{"earlier commit signed by other key", func(exp *RepoExport) {
+			sig, err := other.Sign(exp.Commits[1].Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			exp.Commits[1].Signature = sig
+		}, current, false},
+		{"tampered earlier signature", func(exp *RepoExport) {
+			exp.Commits[2].Signature[len(exp.Commits[2].Signature)-1] ^= 1
+		}, current, false},
+		{"head signed by other key", func(exp *RepoExport) {
+			head := exp.Commits[len(exp.Commits)-1]
+			sig, err := other.Sign(head.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head.Signature = sig
+		}, current, false},
+		{"tampered commit", func(exp *RepoExport) {
+			exp.Commits[1].Data = []byte(`{"op":"create","path":"post/1","value":{"text":"evil"},"prev":"` + exp.Commits[0].ID + `"}`)
+		}, current, false},
+		{"missing commit", func(exp *RepoExport) {
+			exp.Commits = append(exp.Commits[:2], exp.Commits[3:]...)
+		}, current, false},
+		{"prev outside data", func(exp *RepoExport) { exp.Commits[2].Prev = exp.Commits[0].ID }, current, false},
+		{"other repository", func(exp *RepoExport) { exp.Commits[1].RepositoryDID = "did:plc:bob" }, current, false},
+		{"wrong head", func(exp *RepoExport) { exp.Head = exp.Commits[1].ID }, current, false},
+		{"stale document", func(exp *RepoExport) { exp.Documents[0].Value = json.RawMessage(`{"text":"one"}`) }, current, false},
+		{"deleted document", func(exp *RepoExport) {
+			exp.Documents = append(exp.Documents, &Document{ID: "post/2", Value: json.RawMessage(`{"text":"two"}`)})
+		}, current, false},
+		{"missing document", func(exp *RepoExport) { exp.Documents = nil }, current, false},
+		{"tampered blob", func(exp *RepoExport) {
+			exp.Blobs = []*Blob{{CID: BlobCID([]byte("image")), Data: []byte("other")}}
+		}, current, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exp := valid().exp
+			tt.modify(exp)
+			err := VerifyExport(exp, tt.keys)
+			if tt.ok && err != nil {
+				t.Fatalf("VerifyExport() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("VerifyExport() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestVerifyExportGenesisOnly(t *testing.T) {
+	key, err := identity.GenerateKey(identity.KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := newTestRepo(t, key).exp
+	if err := VerifyExport(exp, []identity.PublicKey{key.Public()}); err != nil {
+		t.Fatalf("VerifyExport() = %v, want nil", err)
+	}
+	if err := VerifyGenesisCommit(exp.Commits[0], testDID, key.Public()); err != nil {
+		t.Fatalf("VerifyGenesisCommit() = %v, want nil", err)
+	}
+	if err := VerifyGenesisCommit(exp.Commits[0], "did:plc:bob", key.Public()); err == nil {
+		t.Fatal("VerifyGenesisCommit() = nil for another DID")
+	}
+}
+
+func TestVerifyExportAfterKeyRotation(t *testing.T) {
+	oldKey, err := identity.GenerateKey(identity.KeyTypeSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := identity.GenerateKey(identity.KeyTypeP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := []identity.PublicKey{oldKey.Public(), newKey.Public()}
+
+	// rotated returns an export signed by the old key up to a rotation, then by the new one
+	rotated := func() *testRepo {
+		repo := newTestRepo(t, oldKey).commit("create", "post/1", `{"text":"one"}`)
+		repo.key = newKey
+		return repo.commit("create", "post/2", `{"text":"two"}`).
+			document("post/1", `{"text":"one"}`).
+			document("post/2", `{"text":"two"}`)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(repo *testRepo)
+		keys   []identity.PublicKey
+		ok     bool
+	}{
+		{"valid", func(repo *testRepo) {}, history, true},
+		{"only the current key", func(repo *testRepo) {}, history[1:], false},
+		{"head by the old key", func(repo *testRepo) {
+			repo.key = oldKey
+			repo.commit("delete", "post/2", "")
+			repo.exp.Documents = repo.exp.Documents[:1]
+		}, history, false},
+		{"old key after the new key", func(repo *testRepo) {
+			repo.key = oldKey
+			repo.commit("delete", "post/2", "")
+			repo.key = newKey
+			repo.commit("update", "post/1", `{"text":"uno"}`)
+			repo.exp.Documents = []*Document{{ID: "post/1", Value: json.RawMessage(`{"text":"uno"}`)}}
+		}, history, false},
+		{"tampered signature before the rotation", func(repo *testRepo) {
+			sig := repo.exp.Commits[1].Signature
+			sig[len(sig)-1] ^= 1
+		}, history, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := rotated()
+			tt.modify(repo)
+			err := VerifyExport(repo.exp, tt.keys)
+			if tt.ok && err != nil {
+				t.Fatalf("VerifyExport() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("VerifyExport() = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestCheckCommitData(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"create", `{"op":"create","path":"post/1","value":{},"prev":"abc"}`, true},
+		{"update", `{"op":"update","path":"post/1","value":{},"prev":"abc"}`, true},
+		{"delete", `{"op":"delete","path":"post/1","prev":"abc"}`, true},
+		{"genesis", `{"op":"init","did":"did:plc:alice","prev":""}`, false},
+		{"unknown op", `{"op":"rename","path":"post/1","prev":"abc"}`, false},
+		{"no path", `{"op":"create","value":{},"prev":"abc"}`, false},
+		{"no prev", `{"op":"create","path":"post/1","value":{}}`, false},
+		{"empty prev", `{"op":"create","path":"post/1","value":{},"prev":""}`, false},
+		{"jwt signing input", `eyJhbGciOiJFUzI1NksifQ.eyJpc3MiOiJkaWQ6cGxjOmFsaWNlIn0`, false},
+		{"not an object", `"create"`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckCommitData([]byte(tt.data))
+			if tt.ok && err != nil {
+				t.Fatalf("CheckCommitData() = %v, want nil", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("CheckCommitData() = nil, want an error")
+			}
+		})
+	}
+}
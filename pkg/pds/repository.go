@@ -11,10 +11,19 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
+// Repository statuses
+const (
+	RepoStatusActive      = "active"
+	RepoStatusDeactivated = "deactivated"
+	RepoStatusSuspended   = "suspended"
+	RepoStatusTakendown   = "takendown"
+)
+
 // Repository represents a user's repository
 type Repository struct {
 	DID       string    `json:"did"`
 	Head      string    `json:"head"`
+	Status    string    `json:"status"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -52,13 +61,14 @@ func NewRepositoryRepository(db *pgxpool.Pool) *RepositoryRepository {
 // CreateRepository creates a new repository
 func (r *RepositoryRepository) CreateRepository(ctx context.Context, repo *Repository) error {
 	query := `
-		INSERT INTO repositories (did, head, created_at, updated_at)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO repositories (did, head, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
 	`
 	now := time.Now()
 	_, err := r.db.Exec(ctx, query,
 		repo.DID,
 		repo.Head,
+		RepoStatusActive,
 		now,
 		now,
 	)
@@ -66,6 +76,7 @@ func (r *RepositoryRepository) CreateRepository(ctx context.Context, repo *Repos
 		return fmt.Errorf("failed to create repository: %w", err)
 	}
 
+	repo.Status = RepoStatusActive
 	repo.CreatedAt = now
 	repo.UpdatedAt = now
 
@@ -75,7 +86,7 @@ func (r *RepositoryRepository) CreateRepository(ctx context.Context, repo *Repos
 // GetRepository gets a repository by DID
 func (r *RepositoryRepository) GetRepository(ctx context.Context, did string) (*Repository, error) {
 	query := `
-		SELECT did, head, created_at, updated_at
+		SELECT did, head, status, created_at, updated_at
 		FROM repositories
 		WHERE did = $1
 	`
@@ -83,6 +94,7 @@ func (r *RepositoryRepository) GetRepository(ctx context.Context, did string) (*
 	err := r.db.QueryRow(ctx, query, did).Scan(
 		&repo.DID,
 		&repo.Head,
+		&repo.Status,
 		&repo.CreatedAt,
 		&repo.UpdatedAt,
 	)
@@ -243,19 +255,16 @@ func (r *RepositoryRepository) GetDocumentsByType(ctx context.Context, repositor
 	return docs, nil
 }
 
-// CreatePost creates a new post document
-func (r *RepositoryRepository) CreatePost(ctx context.Context, did string, content string) (*Document, error) {
+// CreatePost creates a new post document with a commit signed by signer
+func (r *RepositoryRepository) CreatePost(ctx context.Context, did string, content string, signer CommitSigner) (*Document, error) {
 	// Get repository
+	// Repositories are created with a genesis commit at registration
 	repo, err := r.GetRepository(ctx, did)
 	if err != nil {
-		// Create repository if it doesn't exist
-		repo = &Repository{
-			DID:  did,
-			Head: "",
-		}
-		if err := r.CreateRepository(ctx, repo); err != nil {
-			return nil, fmt.Errorf("failed to create repository: %w", err)
-		}
+		return nil, err
+	}
+	if repo.Status != RepoStatusActive {
+		return nil, fmt.Errorf("repository is %s", repo.Status)
 	}
 
 	// Create post value
@@ -271,28 +280,35 @@ func (r *RepositoryRepository) CreatePost(ctx context.Context, did string, conte
 	// Create document ID
 	postID := fmt.Sprintf("post/%s", time.Now().Format("20060102150405.000"))
 
-	// Create document
-	doc := &Document{
-		ID:           postID,
-		RepositoryDID: did,
-		Type:         "app.bsky.feed.post",
-		Value:        postValueJSON,
-	}
-	if err := r.CreateDocument(ctx, doc); err != nil {
-		return nil, fmt.Errorf("failed to create document: %w", err)
-	}
-
 	// Create commit data
 	commitData := map[string]interface{}{
 		"op":    "create",
 		"path":  postID,
 		"value": postValue,
+		"prev":  repo.Head,
 	}
 	commitDataJSON, err := json.Marshal(commitData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal commit data: %w", err)
 	}
 
+	// Sign commit before anything is written
+	signature, err := signer.SignCommit(ctx, did, commitDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create document
+	doc := &Document{
+		ID:           postID,
+		RepositoryDID: did,
+		Type:         "app.bsky.feed.post",
+		Value:        postValueJSON,
+	}
+	if err := r.CreateDocument(ctx, doc); err != nil {
+		return nil, fmt.Errorf("failed to create document: %w", err)
+	}
+
 	// Create commit ID
 	hash := sha256.Sum256(commitDataJSON)
 	commitID := hex.EncodeToString(hash[:])
@@ -303,6 +319,7 @@ func (r *RepositoryRepository) CreatePost(ctx context.Context, did string, conte
 		RepositoryDID: did,
 		Prev:         repo.Head,
 		Data:         commitDataJSON,
+		Signature:    signature,
 	}
 	if err := r.CreateCommit(ctx, commit); err != nil {
 		return nil, fmt.Errorf("failed to create commit: %w", err)
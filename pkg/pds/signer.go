@@ -0,0 +1,94 @@
+package pds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CommitSigner signs commit data with an account's signing key
+type CommitSigner interface {
+	SignCommit(ctx context.Context, did string, data []byte) ([]byte, error)
+}
+
+// SignCommitRequest asks the service holding the signing keys to sign a commit
+type SignCommitRequest struct {
+	DID  string `json:"did"`
+	Data []byte `json:"data"`
+}
+
+// SignCommitResponse carries the signature of a commit
+type SignCommitResponse struct {
+	Signature []byte `json:"signature"`
+}
+
+// RemoteSigner signs commits through the auth service's internal listener,
+// which keeps the account signing keys
+type RemoteSigner struct {
+	URL        string
+	HTTPClient *http.Client
+}
+
+// NewRemoteSigner creates a signer for the auth service's internal URL
+func NewRemoteSigner(authURL string) *RemoteSigner {
+	return &RemoteSigner{
+		URL:        strings.TrimSuffix(authURL, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// SignCommit implements CommitSigner
+func (s *RemoteSigner) SignCommit(ctx context.Context, did string, data []byte) ([]byte, error) {
+	body, err := json.Marshal(SignCommitRequest{DID: did, Data: data})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal sign request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL+"/repo/signCommit", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign commit: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to sign commit: unexpected status: %s", resp.Status)
+	}
+	var signed SignCommitResponse
+	if err := json.NewDecoder(resp.Body).Decode(&signed); err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	if len(signed.Signature) == 0 {
+		return nil, fmt.Errorf("failed to sign commit: empty signature")
+	}
+	return signed.Signature, nil
+}
+
+// CheckCommitData checks that data is a record operation commit that names its
+// prev commit, so that a signing service only signs commits
+func CheckCommitData(data []byte) error {
+	var op commitOp
+	if err := json.Unmarshal(data, &op); err != nil {
+		return fmt.Errorf("invalid commit: %w", err)
+	}
+	switch op.Op {
+	case "create", "update", "delete":
+	default:
+		return fmt.Errorf("invalid commit operation %q", op.Op)
+	}
+	if op.Path == "" {
+		return fmt.Errorf("commit has no path")
+	}
+	if op.Prev == nil || *op.Prev == "" {
+		return fmt.Errorf("commit has no prev commit")
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package pds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// GetRepositoryStatus returns the status of a repository, or an empty string if
+// the account has no repository yet
+func (r *RepositoryRepository) GetRepositoryStatus(ctx context.Context, did string) (string, error) {
+	var status string
+	err := r.db.QueryRow(ctx, `SELECT status FROM repositories WHERE did = $1`, did).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository status: %w", err)
+	}
+	return status, nil
+}
+
+// SetRepositoryStatus sets the status of an account's repository. An account
+// that is not active gets an empty repository if it has none yet, so that its
+// first write is rejected too.
+func (r *RepositoryRepository) SetRepositoryStatus(ctx context.Context, did, status string) error {
+	query := `
+		INSERT INTO repositories (did, head, status, created_at, updated_at)
+		VALUES ($1, '', $2, $3, $3)
+		ON CONFLICT (did) DO UPDATE
+		SET status = EXCLUDED.status, updated_at = EXCLUDED.updated_at
+	`
+	if status == RepoStatusActive {
+		query = `
+			UPDATE repositories
+			SET status = $2, updated_at = $3
+			WHERE did = $1
+		`
+	}
+	if _, err := r.db.Exec(ctx, query, did, status, time.Now()); err != nil {
+		return fmt.Errorf("failed to set repository status: %w", err)
+	}
+	return nil
+}
+
+// DeleteRepository deletes an account's repository with its documents, commits
+// and blobs, and the account itself
+func (r *RepositoryRepository) DeleteRepository(ctx context.Context, did string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	queries := []string{
+		`DELETE FROM documents WHERE repository_did = $1`,
+		`DELETE FROM blobs WHERE repository_did = $1`,
+		`DELETE FROM commits WHERE repository_did = $1`,
+		`DELETE FROM repositories WHERE did = $1`,
+		`DELETE FROM accounts WHERE did = $1`,
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(ctx, query, did); err != nil {
+			return fmt.Errorf("failed to delete repository: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit repository deletion: %w", err)
+	}
+	return nil
+}
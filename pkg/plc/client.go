@@ -0,0 +1,159 @@
+package plc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// Client is an HTTP client for a PLC directory
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewClient creates a new PLC directory client
+func NewClient(baseURL string) *Client {
+	return &Client{
+		BaseURL:    strings.TrimSuffix(baseURL, "/"),
+		HTTPClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// CreateDID registers a new DID with a genesis operation signed by the rotation key.
+// Recovery keys are listed as additional, lower-priority rotation keys.
+func (c *Client) CreateDID(ctx context.Context, rotationKey identity.PrivateKey, signingKey identity.PublicKey, handle, pdsEndpoint string, recoveryKeys ...identity.PublicKey) (string, error) {
+	rotationKeys := append([]identity.PublicKey{rotationKey.Public()}, recoveryKeys...)
+	op := NewGenesisOperation(rotationKeys, signingKey, handle, pdsEndpoint)
+	if err := op.Sign(rotationKey); err != nil {
+		return "", err
+	}
+
+	did, err := DeriveDID(op)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.Submit(ctx, did, op); err != nil {
+		return "", err
+	}
+	return did, nil
+}
+
+// PublishSigningKey updates the atproto signing key of a DID with an operation signed by the rotation key
+func (c *Client) PublishSigningKey(ctx context.Context, did string, signingKey identity.PublicKey, rotationKey identity.PrivateKey) error {
+	return c.Update(ctx, did, rotationKey, func(op *Operation) {
+		op.SetSigningKey(signingKey)
+	})
+}
+
+// Update applies a change to the latest operation of a DID and submits it signed by the rotation key
+func (c *Client) Update(ctx context.Context, did string, rotationKey identity.PrivateKey, change func(op *Operation)) error {
+	prev, err := c.GetLastOperation(ctx, did)
+	if err != nil {
+		return err
+	}
+
+	op, err := NewUpdateOperation(prev)
+	if err != nil {
+		return err
+	}
+	change(op)
+
+	if err := op.Sign(rotationKey); err != nil {
+		return err
+	}
+	return c.Submit(ctx, did, op)
+}
+
+// Tombstone deactivates a DID for good with an operation signed by the rotation key
+func (c *Client) Tombstone(ctx context.Context, did string, rotationKey identity.PrivateKey) error {
+	prev, err := c.GetLastOperation(ctx, did)
+	if err != nil {
+		return err
+	}
+	hash, err := prev.Hash()
+	if err != nil {
+		return err
+	}
+
+	op := &Operation{
+		Type: OpTypeTombstone,
+		Prev: &hash,
+	}
+	if err := op.Sign(rotationKey); err != nil {
+		return err
+	}
+	return c.Submit(ctx, did, op)
+}
+
+// Submit submits a signed operation for a DID
+func (c *Client) Submit(ctx context.Context, did string, op *Operation) error {
+	body, err := json.Marshal(op)
+	if err != nil {
+		return fmt.Errorf("failed to marshal operation: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/"+url.PathEscape(did), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to submit operation: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("PLC directory rejected operation: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// GetLastOperation gets the latest operation for a DID
+func (c *Client) GetLastOperation(ctx context.Context, did string) (*Operation, error) {
+	var op Operation
+	if err := c.get(ctx, "/"+url.PathEscape(did)+"/log/last", &op); err != nil {
+		return nil, err
+	}
+	return &op, nil
+}
+
+// GetAuditLog gets the full audit log for a DID
+func (c *Client) GetAuditLog(ctx context.Context, did string) ([]*LogEntry, error) {
+	var entries []*LogEntry
+	if err := c.get(ctx, "/"+url.PathEscape(did)+"/log/audit", &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// get performs a GET request and decodes the JSON response
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query PLC directory: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("PLC directory returned %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
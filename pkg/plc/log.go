@@ -0,0 +1,287 @@
+package plc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// ErrInvalidOperation is returned when an operation is rejected by the directory
+var ErrInvalidOperation = errors.New("invalid operation")
+
+// RecoveryWindow is how long after an operation a higher-priority rotation key
+// can still nullify it
+const RecoveryWindow = 72 * time.Hour
+
+// LogEntry represents an operation in a DID's audit log
+type LogEntry struct {
+	DID       string     `json:"did"`
+	Operation *Operation `json:"operation"`
+	CID       string     `json:"cid"`
+	Nullified bool       `json:"nullified"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// SigningKeys returns the atproto signing keys a DID has had according to its
+// audit log, oldest first, skipping nullified operations. The last key is the
+// current one, unless the DID has been tombstoned.
+func SigningKeys(entries []*LogEntry) ([]identity.PublicKey, error) {
+	var keys []identity.PublicKey
+	last := ""
+	for _, entry := range entries {
+		if entry.Nullified || entry.Operation == nil {
+			continue
+		}
+		key := entry.Operation.VerificationMethods["atproto"]
+		if key == "" || key == last {
+			continue
+		}
+		keyDID, err := identity.ParseKeyDID(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key in operation %s: %w", entry.CID, err)
+		}
+		keys = append(keys, keyDID.PublicKey)
+		last = key
+	}
+	return keys, nil
+}
+
+// OperationRepository handles PLC operation log data access
+type OperationRepository struct {
+	db *pgxpool.Pool
+}
+
+// NewOperationRepository creates a new operation repository
+func NewOperationRepository(db *pgxpool.Pool) *OperationRepository {
+	return &OperationRepository{db: db}
+}
+
+// SubmitOperation validates an operation against the DID's log and appends it,
+// nullifying the operations a recovery operation replaces
+func (r *OperationRepository) SubmitOperation(ctx context.Context, did string, op *Operation) (*LogEntry, error) {
+	if err := op.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the log, so that a recovery and an update cannot both build on it
+	rows, err := tx.Query(ctx, auditLogQuery+` FOR UPDATE`, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit log: %w", err)
+	}
+	entries, err := scanAuditLog(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	nullified, err := CheckOperation(did, op, entries, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// Nullify the operations a recovery replaces
+	if len(nullified) > 0 {
+		cids := make([]string, len(nullified))
+		for i, entry := range nullified {
+			cids[i] = entry.CID
+		}
+		if _, err := tx.Exec(ctx, `UPDATE operations SET nullified = TRUE WHERE did = $1 AND cid = ANY($2)`, did, cids); err != nil {
+			return nil, fmt.Errorf("failed to nullify operations: %w", err)
+		}
+	}
+
+	cid, err := op.Hash()
+	if err != nil {
+		return nil, err
+	}
+	entry := &LogEntry{
+		DID:       did,
+		Operation: op,
+		CID:       cid,
+		CreatedAt: now,
+	}
+	if err := createEntry(ctx, tx, entry); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit operation: %w", err)
+	}
+	return entry, nil
+}
+
+// CheckOperation checks that op can be appended to the audit log of a DID, or
+// that it is the DID's genesis operation if the log is empty, and returns the
+// entries it nullifies. An update normally follows the latest active operation.
+// To recover the DID, it can instead follow an earlier one, nullifying the
+// operations after it. A recovery must be signed by a rotation key listed before
+// the one that signed the first nullified operation, within RecoveryWindow of
+// it. The operation must have passed Validate.
+func CheckOperation(did string, op *Operation, entries []*LogEntry, now time.Time) ([]*LogEntry, error) {
+	if op.Prev == nil {
+		// Genesis operations must hash to the DID and be signed by their own rotation keys
+		if len(entries) > 0 {
+			return nil, fmt.Errorf("%w: %s already exists", ErrInvalidOperation, did)
+		}
+		derived, err := DeriveDID(op)
+		if err != nil {
+			return nil, err
+		}
+		if derived != did {
+			return nil, fmt.Errorf("%w: genesis operation does not match %s", ErrInvalidOperation, did)
+		}
+		if err := op.VerifySignature(op.RotationKeys); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+		}
+		return nil, nil
+	}
+
+	// Updates must follow an active operation and be signed by one of its rotation keys
+	var active []*LogEntry
+	for _, entry := range entries {
+		if !entry.Nullified {
+			active = append(active, entry)
+		}
+	}
+	if len(active) == 0 {
+		return nil, fmt.Errorf("%w: unknown DID %s", ErrInvalidOperation, did)
+	}
+	prevIndex := -1
+	for i, entry := range active {
+		if entry.CID == *op.Prev {
+			prevIndex = i
+		}
+	}
+	if prevIndex < 0 {
+		return nil, fmt.Errorf("%w: prev does not match an active operation", ErrInvalidOperation)
+	}
+	prev := active[prevIndex]
+	nullified := active[prevIndex+1:]
+
+	if len(nullified) == 0 {
+		if prev.Operation.Type == OpTypeTombstone {
+			return nil, fmt.Errorf("%w: DID has been tombstoned", ErrInvalidOperation)
+		}
+		if err := op.VerifySignature(prev.Operation.RotationKeys); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+		}
+		return nil, nil
+	}
+
+	// Recoveries need a key with higher priority than the one they overrule
+	disputed, err := nullified[0].Operation.signerIndex(prev.Operation.RotationKeys)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidOperation, err)
+	}
+	if _, err := op.signerIndex(prev.Operation.RotationKeys[:disputed]); err != nil {
+		return nil, fmt.Errorf("%w: only a higher-priority rotation key can nullify later operations", ErrInvalidOperation)
+	}
+	if now.Sub(nullified[0].CreatedAt) > RecoveryWindow {
+		return nil, fmt.Errorf("%w: recovery window has passed", ErrInvalidOperation)
+	}
+	return nullified, nil
+}
+
+// createEntry inserts an entry into the operation log
+func createEntry(ctx context.Context, tx pgx.Tx, entry *LogEntry) error {
+	opJSON, err := json.Marshal(entry.Operation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal operation: %w", err)
+	}
+
+	query := `
+		INSERT INTO operations (cid, did, prev, operation, nullified, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = tx.Exec(ctx, query,
+		entry.CID,
+		entry.DID,
+		entry.Operation.Prev,
+		opJSON,
+		entry.Nullified,
+		entry.CreatedAt,
+	)
+	if err != nil {
+		// A unique violation means another operation already claimed this position in the log
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return fmt.Errorf("%w: operation conflicts with the existing log", ErrInvalidOperation)
+		}
+		return fmt.Errorf("failed to create operation: %w", err)
+	}
+	return nil
+}
+
+// auditLogQuery selects the operations of a DID in order
+const auditLogQuery = `
+	SELECT did, operation, cid, nullified, created_at
+	FROM operations
+	WHERE did = $1
+	ORDER BY id ASC
+`
+
+// GetAuditLog gets all operations for a DID in order
+func (r *OperationRepository) GetAuditLog(ctx context.Context, did string) ([]*LogEntry, error) {
+	rows, err := r.db.Query(ctx, auditLogQuery, did)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit log: %w", err)
+	}
+	return scanAuditLog(rows)
+}
+
+// scanAuditLog reads the log entries selected by auditLogQuery
+func scanAuditLog(rows pgx.Rows) ([]*LogEntry, error) {
+	defer rows.Close()
+
+	var entries []*LogEntry
+	for rows.Next() {
+		var entry LogEntry
+		var opJSON []byte
+		err := rows.Scan(
+			&entry.DID,
+			&opJSON,
+			&entry.CID,
+			&entry.Nullified,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan operation: %w", err)
+		}
+		if err := json.Unmarshal(opJSON, &entry.Operation); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal operation: %w", err)
+		}
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating operations: %w", err)
+	}
+	return entries, nil
+}
+
+// GetLastOperation gets the latest active operation for a DID, or nil if the DID is unknown
+func (r *OperationRepository) GetLastOperation(ctx context.Context, did string) (*LogEntry, error) {
+	entries, err := r.GetAuditLog(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+
+	var last *LogEntry
+	for _, entry := range entries {
+		if !entry.Nullified {
+			last = entry
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,260 @@
+package plc
+
+import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+// Operation types
+const (
+	OpTypeOperation = "plc_operation"
+	OpTypeTombstone = "plc_tombstone"
+)
+
+// pdsServiceID is the service key for a PDS endpoint
+const pdsServiceID = "atproto_pds"
+
+// Operation represents a signed PLC operation
+type Operation struct {
+	Type                string             `json:"type"`
+	RotationKeys        []string           `json:"rotationKeys,omitempty"`
+	VerificationMethods map[string]string  `json:"verificationMethods,omitempty"`
+	AlsoKnownAs         []string           `json:"alsoKnownAs,omitempty"`
+	Services            map[string]Service `json:"services,omitempty"`
+	Prev                *string            `json:"prev"`
+	Sig                 string             `json:"sig,omitempty"`
+}
+
+// Service represents a service entry in a PLC operation
+type Service struct {
+	Type     string `json:"type"`
+	Endpoint string `json:"endpoint"`
+}
+
+// NewGenesisOperation creates an unsigned genesis operation. Rotation keys are listed in priority order.
+func NewGenesisOperation(rotationKeys []identity.PublicKey, signingKey identity.PublicKey, handle, pdsEndpoint string) *Operation {
+	op := &Operation{
+		Type:         OpTypeOperation,
+		RotationKeys: []string{},
+		VerificationMethods: map[string]string{
+			"atproto": identity.FormatKeyDID(signingKey),
+		},
+		AlsoKnownAs: []string{},
+		Services:    map[string]Service{},
+	}
+	for _, key := range rotationKeys {
+		op.RotationKeys = append(op.RotationKeys, identity.FormatKeyDID(key))
+	}
+	if handle != "" {
+		op.AlsoKnownAs = append(op.AlsoKnownAs, "at://"+handle)
+	}
+	if pdsEndpoint != "" {
+		op.Services[pdsServiceID] = Service{
+			Type:     "AtprotoPersonalDataServer",
+			Endpoint: pdsEndpoint,
+		}
+	}
+	return op
+}
+
+// NewUpdateOperation creates an unsigned operation that follows prev with the same contents
+func NewUpdateOperation(prev *Operation) (*Operation, error) {
+	if prev.Type != OpTypeOperation {
+		return nil, fmt.Errorf("cannot update after %s", prev.Type)
+	}
+	hash, err := prev.Hash()
+	if err != nil {
+		return nil, err
+	}
+
+	op := &Operation{
+		Type:                OpTypeOperation,
+		RotationKeys:        append([]string{}, prev.RotationKeys...),
+		VerificationMethods: make(map[string]string),
+		AlsoKnownAs:         append([]string{}, prev.AlsoKnownAs...),
+		Services:            make(map[string]Service),
+		Prev:                &hash,
+	}
+	for k, v := range prev.VerificationMethods {
+		op.VerificationMethods[k] = v
+	}
+	for k, v := range prev.Services {
+		op.Services[k] = v
+	}
+	return op, nil
+}
+
+// SetSigningKey sets the atproto signing key of the operation
+func (op *Operation) SetSigningKey(signingKey identity.PublicKey) {
+	if op.VerificationMethods == nil {
+		op.VerificationMethods = make(map[string]string)
+	}
+	op.VerificationMethods["atproto"] = identity.FormatKeyDID(signingKey)
+}
+
+// SetHandle replaces the handle aliases of the operation
+func (op *Operation) SetHandle(handle string) {
+	op.AlsoKnownAs = []string{"at://" + handle}
+}
+
+// SetPDSEndpoint sets the PDS service endpoint of the operation
+func (op *Operation) SetPDSEndpoint(endpoint string) {
+	if op.Services == nil {
+		op.Services = make(map[string]Service)
+	}
+	op.Services[pdsServiceID] = Service{
+		Type:     "AtprotoPersonalDataServer",
+		Endpoint: endpoint,
+	}
+}
+
+// unsignedBytes returns the encoding of the operation without its signature
+func (op *Operation) unsignedBytes() ([]byte, error) {
+	unsigned := *op
+	unsigned.Sig = ""
+	return json.Marshal(unsigned)
+}
+
+// Sign signs the operation with a rotation key
+func (op *Operation) Sign(privateKey identity.PrivateKey) error {
+	data, err := op.unsignedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to encode operation: %w", err)
+	}
+	sig, err := privateKey.Sign(data)
+	if err != nil {
+		return fmt.Errorf("failed to sign operation: %w", err)
+	}
+	op.Sig = base64.RawURLEncoding.EncodeToString(sig)
+	return nil
+}
+
+// VerifySignature checks that the operation is signed by one of the rotation keys
+func (op *Operation) VerifySignature(rotationKeys []string) error {
+	_, err := op.signerIndex(rotationKeys)
+	return err
+}
+
+// signerIndex returns the index of the rotation key that signed the operation
+func (op *Operation) signerIndex(rotationKeys []string) (int, error) {
+	sig, err := base64.RawURLEncoding.DecodeString(op.Sig)
+	if err != nil || op.Sig == "" {
+		return -1, fmt.Errorf("invalid signature encoding")
+	}
+	data, err := op.unsignedBytes()
+	if err != nil {
+		return -1, fmt.Errorf("failed to encode operation: %w", err)
+	}
+
+	for i, key := range rotationKeys {
+		keyDID, err := identity.ParseKeyDID(key)
+		if err != nil {
+			continue
+		}
+		if keyDID.Verify(data, sig) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("invalid signature")
+}
+
+// Hash returns the content hash of the signed operation
+func (op *Operation) Hash() (string, error) {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode operation: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:])), nil
+}
+
+// DeriveDID derives the did:plc identifier from a signed genesis operation
+func DeriveDID(genesis *Operation) (string, error) {
+	if genesis.Prev != nil {
+		return "", fmt.Errorf("not a genesis operation")
+	}
+	hash, err := genesis.Hash()
+	if err != nil {
+		return "", err
+	}
+	return "did:plc:" + hash[:24], nil
+}
+
+// Validate checks the structure of an operation
+func (op *Operation) Validate() error {
+	switch op.Type {
+	case OpTypeOperation:
+		if len(op.RotationKeys) == 0 || len(op.RotationKeys) > 5 {
+			return fmt.Errorf("operation must have between 1 and 5 rotation keys")
+		}
+		for _, key := range op.RotationKeys {
+			if _, err := identity.ParseKeyDID(key); err != nil {
+				return fmt.Errorf("invalid rotation key: %w", err)
+			}
+		}
+		for id, key := range op.VerificationMethods {
+			if _, err := identity.ParseKeyDID(key); err != nil {
+				return fmt.Errorf("invalid verification method %s: %w", id, err)
+			}
+		}
+		for _, aka := range op.AlsoKnownAs {
+			if !strings.HasPrefix(aka, "at://") {
+				return fmt.Errorf("invalid alsoKnownAs: %s", aka)
+			}
+		}
+	case OpTypeTombstone:
+		if op.Prev == nil {
+			return fmt.Errorf("tombstone must have a prev operation")
+		}
+	default:
+		return fmt.Errorf("unknown operation type: %s", op.Type)
+	}
+	return nil
+}
+
+// Document builds the DID document described by an operation
+func (op *Operation) Document(did string) *identity.Document {
+	doc := &identity.Document{
+		Context:     identity.DocumentContext,
+		ID:          did,
+		AlsoKnownAs: op.AlsoKnownAs,
+	}
+
+	for _, id := range sortedKeys(op.VerificationMethods) {
+		key := op.VerificationMethods[id]
+		doc.VerificationMethod = append(doc.VerificationMethod, identity.VerificationMethod{
+			ID:                 did + "#" + id,
+			Type:               "Multikey",
+			Controller:         did,
+			PublicKeyMultibase: strings.TrimPrefix(key, "did:key:"),
+		})
+	}
+
+	for _, id := range sortedKeys(op.Services) {
+		service := op.Services[id]
+		doc.Service = append(doc.Service, identity.Service{
+			ID:              "#" + id,
+			Type:            service.Type,
+			ServiceEndpoint: service.Endpoint,
+		})
+	}
+
+	return doc
+}
+
+// sortedKeys returns the keys of a map in sorted order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
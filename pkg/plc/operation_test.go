@@ -0,0 +1,360 @@
+package plc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+func mustGenerateKey(t *testing.T, keyType identity.KeyType) identity.PrivateKey {
+	t.Helper()
+	key, err := identity.GenerateKey(keyType)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+// newGenesis returns a signed genesis operation and its DID
+func newGenesis(t *testing.T, rotationKey identity.PrivateKey) (*Operation, string) {
+	t.Helper()
+	signingKey := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	op := NewGenesisOperation([]identity.PublicKey{rotationKey.Public()}, signingKey.Public(), "alice.example.com", "https://pds.example.com")
+	if err := op.Sign(rotationKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	did, err := DeriveDID(op)
+	if err != nil {
+		t.Fatalf("DeriveDID: %v", err)
+	}
+	return op, did
+}
+
+// entry returns the log entry of a signed operation
+func entry(t *testing.T, did string, op *Operation) *LogEntry {
+	t.Helper()
+	cid, err := op.Hash()
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	return &LogEntry{DID: did, Operation: op, CID: cid, CreatedAt: time.Now()}
+}
+
+func TestOperationSignature(t *testing.T) {
+	for _, keyType := range []identity.KeyType{identity.KeyTypeEd25519, identity.KeyTypeP256, identity.KeyTypeSecp256k1} {
+		t.Run(string(keyType), func(t *testing.T) {
+			rotationKey := mustGenerateKey(t, keyType)
+			op, _ := newGenesis(t, rotationKey)
+
+			if err := op.Validate(); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			if err := op.VerifySignature(op.RotationKeys); err != nil {
+				t.Fatalf("VerifySignature: %v", err)
+			}
+
+			// Another key does not verify
+			other := mustGenerateKey(t, keyType)
+			if err := op.VerifySignature([]string{identity.FormatKeyDID(other.Public())}); err == nil {
+				t.Error("VerifySignature() = nil error for a key that did not sign")
+			}
+
+			// Changing the operation breaks the signature
+			op.SetHandle("mallory.example.com")
+			if err := op.VerifySignature(op.RotationKeys); err == nil {
+				t.Error("VerifySignature() = nil error for a modified operation")
+			}
+		})
+	}
+}
+
+func TestOperationSignatureMissing(t *testing.T) {
+	rotationKey := mustGenerateKey(t, identity.KeyTypeP256)
+	op, _ := newGenesis(t, rotationKey)
+	op.Sig = ""
+	if err := op.VerifySignature(op.RotationKeys); err == nil {
+		t.Error("VerifySignature() = nil error for an unsigned operation")
+	}
+}
+
+func TestDeriveDID(t *testing.T) {
+	rotationKey := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	op, did := newGenesis(t, rotationKey)
+
+	if !strings.HasPrefix(did, "did:plc:") || len(did) != len("did:plc:")+24 {
+		t.Fatalf("DeriveDID() = %s, want did:plc: and 24 characters", did)
+	}
+	again, err := DeriveDID(op)
+	if err != nil || again != did {
+		t.Fatalf("DeriveDID() = %s, %v, want %s again", again, err, did)
+	}
+
+	update, err := NewUpdateOperation(op)
+	if err != nil {
+		t.Fatalf("NewUpdateOperation: %v", err)
+	}
+	if _, err := DeriveDID(update); err == nil {
+		t.Error("DeriveDID() = nil error for an update operation")
+	}
+}
+
+func TestCheckOperationChain(t *testing.T) {
+	rotationKey := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	newRotationKey := mustGenerateKey(t, identity.KeyTypeP256)
+	genesis, did := newGenesis(t, rotationKey)
+
+	if _, err := CheckOperation(did, genesis, nil, time.Now()); err != nil {
+		t.Fatalf("CheckOperation(genesis): %v", err)
+	}
+	log := []*LogEntry{entry(t, did, genesis)}
+
+	// Rotate to a new rotation key, signed by the old one
+	rotate, err := NewUpdateOperation(genesis)
+	if err != nil {
+		t.Fatalf("NewUpdateOperation: %v", err)
+	}
+	rotate.RotationKeys = []string{identity.FormatKeyDID(newRotationKey.Public())}
+	if err := rotate.Sign(rotationKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := CheckOperation(did, rotate, log, time.Now()); err != nil {
+		t.Fatalf("CheckOperation(rotate): %v", err)
+	}
+	log = append(log, entry(t, did, rotate))
+
+	// From now on only the new rotation key can sign
+	update, err := NewUpdateOperation(rotate)
+	if err != nil {
+		t.Fatalf("NewUpdateOperation: %v", err)
+	}
+	update.SetHandle("alice2.example.com")
+	if err := update.Sign(rotationKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := CheckOperation(did, update, log, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Fatalf("CheckOperation(update signed by the old key) = %v, want %v", err, ErrInvalidOperation)
+	}
+	if err := update.Sign(newRotationKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := CheckOperation(did, update, log, time.Now()); err != nil {
+		t.Fatalf("CheckOperation(update): %v", err)
+	}
+
+	// An operation that does not follow the latest one needs a higher-priority key
+	fork, err := NewUpdateOperation(genesis)
+	if err != nil {
+		t.Fatalf("NewUpdateOperation: %v", err)
+	}
+	if err := fork.Sign(rotationKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := CheckOperation(did, fork, log, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Fatalf("CheckOperation(fork) = %v, want %v", err, ErrInvalidOperation)
+	}
+
+	// Nothing follows a tombstone
+	log = append(log, entry(t, did, update))
+	prev := log[len(log)-1].CID
+	tombstone := &Operation{Type: OpTypeTombstone, Prev: &prev}
+	if err := tombstone.Sign(newRotationKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := CheckOperation(did, tombstone, log, time.Now()); err != nil {
+		t.Fatalf("CheckOperation(tombstone): %v", err)
+	}
+	log = append(log, entry(t, did, tombstone))
+	after, err := NewUpdateOperation(update)
+	if err != nil {
+		t.Fatalf("NewUpdateOperation: %v", err)
+	}
+	after.Prev = &log[len(log)-1].CID
+	if err := after.Sign(newRotationKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := CheckOperation(did, after, log, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Fatalf("CheckOperation(after tombstone) = %v, want %v", err, ErrInvalidOperation)
+	}
+}
+
+func TestCheckOperationGenesis(t *testing.T) {
+	rotationKey := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	genesis, did := newGenesis(t, rotationKey)
+	other, otherDID := newGenesis(t, rotationKey)
+
+	if _, err := CheckOperation(otherDID, genesis, nil, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("CheckOperation(genesis of another DID) = %v, want %v", err, ErrInvalidOperation)
+	}
+	if _, err := CheckOperation(did, genesis, []*LogEntry{entry(t, otherDID, other)}, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("CheckOperation(genesis of an existing DID) = %v, want %v", err, ErrInvalidOperation)
+	}
+
+	// A genesis operation signed by a key it does not list is refused
+	unlisted := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	if err := genesis.Sign(unlisted); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	did, err := DeriveDID(genesis)
+	if err != nil {
+		t.Fatalf("DeriveDID: %v", err)
+	}
+	if _, err := CheckOperation(did, genesis, nil, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("CheckOperation(genesis signed by an unlisted key) = %v, want %v", err, ErrInvalidOperation)
+	}
+
+	// Updates need an existing DID
+	update, err := NewUpdateOperation(other)
+	if err != nil {
+		t.Fatalf("NewUpdateOperation: %v", err)
+	}
+	if err := update.Sign(rotationKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := CheckOperation(otherDID, update, nil, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("CheckOperation(update of an unknown DID) = %v, want %v", err, ErrInvalidOperation)
+	}
+}
+
+func TestCheckOperationRecovery(t *testing.T) {
+	accountKey := mustGenerateKey(t, identity.KeyTypeP256)
+	serviceKey := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	signingKey := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+
+	// The account's own rotation key has priority over the service's
+	genesis := NewGenesisOperation([]identity.PublicKey{accountKey.Public(), serviceKey.Public()}, signingKey.Public(), "alice.example.com", "https://pds.example.com")
+	if err := genesis.Sign(accountKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	did, err := DeriveDID(genesis)
+	if err != nil {
+		t.Fatalf("DeriveDID: %v", err)
+	}
+	log := []*LogEntry{entry(t, did, genesis)}
+
+	// next returns an update following prev, signed by key
+	next := func(prev *LogEntry, endpoint string, key identity.PrivateKey) *Operation {
+		t.Helper()
+		op, err := NewUpdateOperation(prev.Operation)
+		if err != nil {
+			t.Fatalf("NewUpdateOperation: %v", err)
+		}
+		op.Prev = &prev.CID
+		op.SetPDSEndpoint(endpoint)
+		if err := op.Sign(key); err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		return op
+	}
+
+	// The service key moves the DID elsewhere, twice
+	for _, endpoint := range []string{"https://evil.example.com", "https://evil2.example.com"} {
+		op := next(log[len(log)-1], endpoint, serviceKey)
+		if _, err := CheckOperation(did, op, log, time.Now()); err != nil {
+			t.Fatalf("CheckOperation(update): %v", err)
+		}
+		log = append(log, entry(t, did, op))
+	}
+
+	// Only a higher-priority key can fork from an earlier operation
+	if _, err := CheckOperation(did, next(log[0], "https://pds2.example.com", serviceKey), log, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Fatalf("CheckOperation(fork signed by the same key) = %v, want %v", err, ErrInvalidOperation)
+	}
+	recovery := next(log[0], "https://pds.example.com", accountKey)
+	if _, err := CheckOperation(did, recovery, log, log[1].CreatedAt.Add(RecoveryWindow+time.Minute)); !errors.Is(err, ErrInvalidOperation) {
+		t.Fatalf("CheckOperation(recovery after the window) = %v, want %v", err, ErrInvalidOperation)
+	}
+	nullified, err := CheckOperation(did, recovery, log, time.Now())
+	if err != nil {
+		t.Fatalf("CheckOperation(recovery): %v", err)
+	}
+	if len(nullified) != 2 || nullified[0] != log[1] || nullified[1] != log[2] {
+		t.Fatalf("CheckOperation(recovery) nullified %v, want the two service key updates", nullified)
+	}
+	for _, e := range nullified {
+		e.Nullified = true
+	}
+	log = append(log, entry(t, did, recovery))
+
+	// Nullified operations cannot be built on, and the recovery is the latest operation
+	if _, err := CheckOperation(did, next(log[2], "https://evil3.example.com", serviceKey), log, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("CheckOperation(update of a nullified operation) = %v, want %v", err, ErrInvalidOperation)
+	}
+	if _, err := CheckOperation(did, next(log[3], "https://pds2.example.com", serviceKey), log, time.Now()); err != nil {
+		t.Errorf("CheckOperation(update after the recovery): %v", err)
+	}
+
+	// The lower-priority key cannot overrule the account's key
+	if _, err := CheckOperation(did, next(log[0], "https://evil3.example.com", serviceKey), log, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("CheckOperation(fork overruling a higher-priority key) = %v, want %v", err, ErrInvalidOperation)
+	}
+	unknown := "bafyunknown"
+	op := next(log[3], "https://pds2.example.com", accountKey)
+	op.Prev = &unknown
+	if err := op.Sign(accountKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if _, err := CheckOperation(did, op, log, time.Now()); !errors.Is(err, ErrInvalidOperation) {
+		t.Errorf("CheckOperation(unknown prev) = %v, want %v", err, ErrInvalidOperation)
+	}
+}
+
+func TestOperationDocument(t *testing.T) {
+	rotationKey := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	op, did := newGenesis(t, rotationKey)
+
+	doc := op.Document(did)
+	if doc.ID != did {
+		t.Errorf("document ID = %s, want %s", doc.ID, did)
+	}
+	if handles := doc.Handles(); len(handles) != 1 || handles[0] != "alice.example.com" {
+		t.Errorf("document handles = %v, want [alice.example.com]", handles)
+	}
+	if _, err := doc.DID(); err != nil {
+		t.Errorf("document has no usable signing key: %v", err)
+	}
+}
+
+func TestSigningKeys(t *testing.T) {
+	rotationKey := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	genesis, did := newGenesis(t, rotationKey)
+	log := []*LogEntry{entry(t, did, genesis)}
+
+	// update appends an operation changing the log, signed by the rotation key
+	update := func(change func(op *Operation)) *LogEntry {
+		t.Helper()
+		op, err := NewUpdateOperation(log[len(log)-1].Operation)
+		if err != nil {
+			t.Fatalf("NewUpdateOperation: %v", err)
+		}
+		change(op)
+		if err := op.Sign(rotationKey); err != nil {
+			t.Fatalf("Sign: %v", err)
+		}
+		e := entry(t, did, op)
+		log = append(log, e)
+		return e
+	}
+
+	second := mustGenerateKey(t, identity.KeyTypeP256)
+	third := mustGenerateKey(t, identity.KeyTypeSecp256k1)
+	update(func(op *Operation) { op.SetSigningKey(second.Public()) })
+	update(func(op *Operation) { op.SetHandle("alice2.example.com") })
+	update(func(op *Operation) { op.SetSigningKey(third.Public()) }).Nullified = true
+
+	keys, err := SigningKeys(log)
+	if err != nil {
+		t.Fatalf("SigningKeys: %v", err)
+	}
+	want := []string{genesis.VerificationMethods["atproto"], identity.FormatKeyDID(second.Public())}
+	if len(keys) != len(want) {
+		t.Fatalf("SigningKeys() returned %d keys, want %d", len(keys), len(want))
+	}
+	for i, key := range keys {
+		if got := identity.FormatKeyDID(key); got != want[i] {
+			t.Errorf("key %d = %s, want %s", i, got, want[i])
+		}
+	}
+}
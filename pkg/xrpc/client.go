@@ -0,0 +1,96 @@
+package xrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls XRPC methods on a host
+type Client struct {
+	Host       string
+	HTTPClient *http.Client
+	// AccessToken, if set, is sent as a bearer token with every call
+	AccessToken string
+}
+
+// NewClient creates a new XRPC client for a host like https://pds.example.com
+func NewClient(host string) *Client {
+	return &Client{
+		Host:       strings.TrimSuffix(host, "/"),
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Query calls a query method. The JSON response is decoded into out, unless
+// out is nil or a *[]byte, which receives the raw response body.
+func (c *Client) Query(ctx context.Context, nsid string, params url.Values, out interface{}) error {
+	return c.Do(ctx, http.MethodGet, nsid, params, "", nil, out)
+}
+
+// Procedure calls a procedure method with a JSON input, which may be nil
+func (c *Client) Procedure(ctx context.Context, nsid string, params url.Values, input, out interface{}) error {
+	if input == nil {
+		return c.Do(ctx, http.MethodPost, nsid, params, "", nil, out)
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal input: %w", err)
+	}
+	return c.Do(ctx, http.MethodPost, nsid, params, "application/json", bytes.NewReader(data), out)
+}
+
+// Do calls an XRPC method with a body of any content type. Error responses
+// are returned as *Error.
+func (c *Client) Do(ctx context.Context, method, nsid string, params url.Values, contentType string, body io.Reader, out interface{}) error {
+	endpoint := c.Host + "/xrpc/" + nsid
+	if len(params) > 0 {
+		endpoint += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.AccessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AccessToken)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %w", nsid, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		xrpcErr := &Error{StatusCode: resp.StatusCode}
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(xrpcErr); err != nil || xrpcErr.Name == "" {
+			xrpcErr.Name = http.StatusText(resp.StatusCode)
+		}
+		return xrpcErr
+	}
+
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+		*out = data
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode %s response: %w", nsid, err)
+	}
+	return nil
+}
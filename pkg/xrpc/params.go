@@ -0,0 +1,191 @@
+package xrpc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EncodeParams encodes a struct of query parameters into URL values. Fields
+// are named by their json tags and may be strings, integers, booleans,
+// pointers to them or slices of them. Nil pointers are left out.
+func EncodeParams(params interface{}) url.Values {
+	values := url.Values{}
+	v := reflect.ValueOf(params)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return values
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return values
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, omitEmpty := paramName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Pointer {
+			if field.IsNil() {
+				continue
+			}
+			field = field.Elem()
+		}
+		if field.Kind() == reflect.Slice {
+			for j := 0; j < field.Len(); j++ {
+				values.Add(name, formatParam(field.Index(j)))
+			}
+			continue
+		}
+		if omitEmpty && field.IsZero() {
+			continue
+		}
+		values.Set(name, formatParam(field))
+	}
+	return values
+}
+
+// DecodeParams decodes the parameters a Handler receives into a struct of the
+// kind EncodeParams encodes. Fields without omitempty in their json tag are
+// required. Errors are InvalidRequest errors.
+func DecodeParams(params map[string]interface{}, out interface{}) error {
+	v := reflect.ValueOf(out).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, omitEmpty := paramName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		raw, ok := params[name]
+		if !ok || raw == nil {
+			if !omitEmpty {
+				return NewError(http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("%s is required", name))
+			}
+			continue
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Pointer {
+			field.Set(reflect.New(field.Type().Elem()))
+			field = field.Elem()
+		}
+		var err error
+		if field.Kind() == reflect.Slice {
+			err = parseParamList(field, raw)
+		} else {
+			err = parseParam(field, raw)
+		}
+		if err != nil {
+			return NewError(http.StatusBadRequest, "InvalidRequest", fmt.Sprintf("invalid %s: %v", name, err))
+		}
+	}
+	return nil
+}
+
+// DecodeInput decodes the JSON body fields a Handler receives into a struct
+func DecodeInput(params map[string]interface{}, out interface{}) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return NewError(http.StatusBadRequest, "InvalidRequest", err.Error())
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return NewError(http.StatusBadRequest, "InvalidRequest", err.Error())
+	}
+	return nil
+}
+
+// paramName returns the name a field is encoded under, and whether it has omitempty
+func paramName(field reflect.StructField) (string, bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" || !field.IsExported() {
+		return "", false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "" {
+		name = field.Name
+	}
+	return name, strings.Contains(opts, "omitempty")
+}
+
+func formatParam(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10)
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+func parseParamList(field reflect.Value, raw interface{}) error {
+	var items []interface{}
+	switch raw := raw.(type) {
+	case []string:
+		for _, s := range raw {
+			items = append(items, s)
+		}
+	case []interface{}:
+		items = raw
+	default:
+		items = []interface{}{raw}
+	}
+
+	list := reflect.MakeSlice(field.Type(), len(items), len(items))
+	for i, item := range items {
+		if err := parseParam(list.Index(i), item); err != nil {
+			return err
+		}
+	}
+	field.Set(list)
+	return nil
+}
+
+// parseParam sets a field from a query string value, or from a value decoded from JSON
+func parseParam(field reflect.Value, raw interface{}) error {
+	if list, ok := raw.([]string); ok {
+		if len(list) == 0 {
+			return fmt.Errorf("no value")
+		}
+		raw = list[0]
+	}
+	s, isString := raw.(string)
+
+	switch field.Kind() {
+	case reflect.String:
+		if !isString {
+			return fmt.Errorf("must be a string")
+		}
+		field.SetString(s)
+	case reflect.Bool:
+		if b, ok := raw.(bool); ok {
+			field.SetBool(b)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil || !isString {
+			return fmt.Errorf("must be a boolean")
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if f, ok := raw.(float64); ok && f == float64(int64(f)) {
+			field.SetInt(int64(f))
+			return nil
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || !isString {
+			return fmt.Errorf("must be an integer")
+		}
+		field.SetInt(n)
+	default:
+		return fmt.Errorf("unsupported parameter type %s", field.Type())
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package xrpc
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -14,6 +15,33 @@ type Handler func(ctx context.Context, params map[string]interface{}) (interface
 // HandlerMap maps procedure names to handlers
 type HandlerMap map[string]Handler
 
+// Error is an XRPC error with an HTTP status and an error name
+type Error struct {
+	StatusCode int    `json:"-"`
+	Name       string `json:"error"`
+	Message    string `json:"message,omitempty"`
+}
+
+// Error implements the error interface
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return e.Name
+	}
+	return e.Name + ": " + e.Message
+}
+
+// NewError creates a new XRPC error
+func NewError(statusCode int, name, message string) *Error {
+	return &Error{StatusCode: statusCode, Name: name, Message: message}
+}
+
+// WriteError writes an XRPC error response
+func WriteError(w http.ResponseWriter, err *Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.StatusCode)
+	json.NewEncoder(w).Encode(err)
+}
+
 // Server is an XRPC server
 type Server struct {
 	handlers HandlerMap
@@ -69,6 +97,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Execute handler
 	result, err := handler(r.Context(), params)
 	if err != nil {
+		var xrpcErr *Error
+		if errors.As(err, &xrpcErr) {
+			WriteError(w, xrpcErr)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -85,5 +118,6 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func Register(mux *http.ServeMux, procedure string, handler Handler) {
 	server := NewServer()
 	server.Register(procedure, handler)
+	mux.Handle("/xrpc/"+procedure, server)
 	mux.Handle("/xrpc/"+procedure+"/", server)
 }
@@ -24,6 +24,7 @@ var services = map[string]Service{
 	"auth": {Name: "Authentication Service", URL: "http://localhost:8081"},
 	"pds":  {Name: "Personal Data Server", URL: "http://localhost:8082"},
 	"bgs":  {Name: "Big Graph Service", URL: "http://localhost:8083"},
+	"plc":  {Name: "PLC Directory", URL: "http://localhost:8084"},
 }
 
 // ProxyHandler forwards requests to the appropriate service
@@ -43,7 +44,7 @@ func ProxyHandler(service Service) http.HandlerFunc {
 		// Update the headers to allow for SSL redirection
 		r.URL.Host = remote.Host
 		r.URL.Scheme = remote.Scheme
-		r.Header.Set("X-Forwarded-Host", r.Header.Get("Host"))
+		r.Header.Set("X-Forwarded-Host", r.Host)
 		
 		// Note that ServeHttp is non-blocking
 		proxy.ServeHTTP(w, r)
@@ -57,21 +58,31 @@ func main() {
 	// Auth service routes
 	mux.HandleFunc("/auth/", func(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/auth")
+		r.Header.Set("X-Forwarded-Prefix", "/auth")
 		ProxyHandler(services["auth"])(w, r)
 	})
 
 	// PDS routes
 	mux.HandleFunc("/pds/", func(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/pds")
+		r.Header.Set("X-Forwarded-Prefix", "/pds")
 		ProxyHandler(services["pds"])(w, r)
 	})
 
 	// BGS routes
 	mux.HandleFunc("/bgs/", func(w http.ResponseWriter, r *http.Request) {
 		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/bgs")
+		r.Header.Set("X-Forwarded-Prefix", "/bgs")
 		ProxyHandler(services["bgs"])(w, r)
 	})
 
+	// PLC directory routes
+	mux.HandleFunc("/plc/", func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, "/plc")
+		r.Header.Set("X-Forwarded-Prefix", "/plc")
+		ProxyHandler(services["plc"])(w, r)
+	})
+
 	// Health check endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
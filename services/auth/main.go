@@ -1,37 +1,178 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/subtle"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	netmail "net/mail"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/yourusername/atprogo/pkg/api"
 	"github.com/yourusername/atprogo/pkg/auth"
 	"github.com/yourusername/atprogo/pkg/db"
+	"github.com/yourusername/atprogo/pkg/identity"
+	"github.com/yourusername/atprogo/pkg/mail"
+	"github.com/yourusername/atprogo/pkg/oauth"
+	"github.com/yourusername/atprogo/pkg/pds"
+	"github.com/yourusername/atprogo/pkg/plc"
+	"github.com/yourusername/atprogo/pkg/xrpc"
 )
 
+// serviceTokenTTL is the lifetime of service tokens issued for migration
+const serviceTokenTTL = 5 * time.Minute
+
+// Lifetimes of emailed tokens
+const (
+	emailTokenTTL        = 15 * time.Minute
+	emailConfirmationTTL = 24 * time.Hour
+)
+
+// privilegedServiceMethods are the methods getServiceAuth does not issue service
+// tokens for. They migrate the account, so their tokens need the emailed PLC
+// operation token and are only issued by ServiceTokenHandler.
+var privilegedServiceMethods = map[string]bool{
+	auth.LxmCreateAccount: true,
+	auth.LxmImportRepo:    true,
+}
+
 // RegisterRequest represents a registration request
 type RegisterRequest struct {
-	Username string `json:"username"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	InviteCode string `json:"inviteCode,omitempty"`
 }
 
 // LoginRequest represents a login request
 type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username        string `json:"username"`
+	Password        string `json:"password"`
+	AuthFactorToken string `json:"authFactorToken,omitempty"`
 }
 
 // AuthResponse represents an authentication response
 type AuthResponse struct {
-	Token string     `json:"token"`
-	User  *auth.User `json:"user"`
+	AccessJwt  string     `json:"accessJwt"`
+	RefreshJwt string     `json:"refreshJwt"`
+	User       *auth.User `json:"user"`
+}
+
+// CreateSessionRequest represents a com.atproto.server.createSession request
+type CreateSessionRequest struct {
+	Identifier      string `json:"identifier"`
+	Password        string `json:"password"`
+	AuthFactorToken string `json:"authFactorToken,omitempty"`
+}
+
+// AppPasswordRequest represents a request to create or revoke an app password
+type AppPasswordRequest struct {
+	Name string `json:"name"`
+}
+
+// SessionResponse represents a session returned by the session endpoints
+type SessionResponse struct {
+	AccessJwt      string `json:"accessJwt,omitempty"`
+	RefreshJwt     string `json:"refreshJwt,omitempty"`
+	Handle         string `json:"handle"`
+	DID            string `json:"did"`
+	Email          string `json:"email,omitempty"`
+	EmailConfirmed bool   `json:"emailConfirmed"`
+	Status         string `json:"status,omitempty"`
+}
+
+// EmailRequest represents a request carrying an email address and an emailed token
+type EmailRequest struct {
+	Email string `json:"email"`
+	Token string `json:"token,omitempty"`
+}
+
+// ResetPasswordRequest represents a com.atproto.server.resetPassword request
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// DeleteAccountRequest represents a com.atproto.server.deleteAccount request
+type DeleteAccountRequest struct {
+	DID             string `json:"did"`
+	Password        string `json:"password"`
+	Token           string `json:"token"`
+	AuthFactorToken string `json:"authFactorToken,omitempty"`
+}
+
+// AccountStatusRequest represents an admin request to change an account's status
+type AccountStatusRequest struct {
+	DID    string `json:"did"`
+	Status string `json:"status"`
+}
+
+// CreateInviteCodesRequest represents an admin request to create invite codes
+type CreateInviteCodesRequest struct {
+	CodeCount  int    `json:"codeCount"`
+	UseCount   int    `json:"useCount"`
+	ForAccount string `json:"forAccount,omitempty"`
+}
+
+// DisableInviteCodesRequest represents an admin request to disable invite codes
+type DisableInviteCodesRequest struct {
+	Codes    []string `json:"codes"`
+	Accounts []string `json:"accounts"`
+}
+
+// TOTPCodeRequest represents a request carrying a TOTP or recovery code
+type TOTPCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// ServiceTokenRequest represents a request for a service token
+type ServiceTokenRequest struct {
+	Audience      string `json:"aud"`
+	LexiconMethod string `json:"lxm"`
+	// Token is the emailed PLC operation token
+	Token string `json:"token"`
+}
+
+// MigrationAccountRequest represents a request to create an account migrating from another PDS
+type MigrationAccountRequest struct {
+	Username   string `json:"username"`
+	Email      string `json:"email"`
+	Password   string `json:"password"`
+	InviteCode string `json:"inviteCode,omitempty"`
+}
+
+// MigrationAccountResponse describes the keys and endpoint the DID must be updated to
+type MigrationAccountResponse struct {
+	DID         string `json:"did"`
+	SigningKey  string `json:"signingKey"`
+	RotationKey string `json:"rotationKey"`
+	PDSEndpoint string `json:"pdsEndpoint"`
+}
+
+// SignPlcOperationRequest represents a request to point a DID at a new PDS
+type SignPlcOperationRequest struct {
+	// Token is the emailed PLC operation token
+	Token       string `json:"token"`
+	SigningKey  string `json:"signingKey"`
+	RotationKey string `json:"rotationKey"`
+	PDSEndpoint string `json:"pdsEndpoint"`
+}
+
+// UpdateHandleRequest represents a com.atproto.identity.updateHandle request
+type UpdateHandleRequest struct {
+	Handle string `json:"handle"`
 }
 
 // ErrorResponse represents an error response
@@ -41,13 +182,46 @@ type ErrorResponse struct {
 
 // AuthHandler handles authentication requests
 type AuthHandler struct {
-	userRepo *auth.UserRepository
+	userRepo       *auth.UserRepository
+	sessionStore   auth.SessionStore
+	sessions       *auth.SessionManager
+	twoFactor      *auth.TwoFactorStore
+	throttle       *auth.LoginThrottle
+	keystore       *identity.Keystore
+	plcClient      *plc.Client
+	didResolver    *identity.CachingResolver
+	handleResolver *identity.HandleResolver
+	pds            *pdsClient
+	accounts       *auth.Accounts
+	mailer         mail.Mailer
+	rotationKey    identity.PrivateKey
+	pdsEndpoint    string
+
+	// invitesPerUser is the number of invite codes each account is given
+	invitesPerUser int
+	// handleDomains are the domains, with a leading dot, under which accounts
+	// can take a handle without proving domain ownership
+	handleDomains []string
 }
 
 // NewAuthHandler creates a new auth handler
-func NewAuthHandler(userRepo *auth.UserRepository) *AuthHandler {
+func NewAuthHandler(userRepo *auth.UserRepository, sessionStore auth.SessionStore, sessions *auth.SessionManager, twoFactor *auth.TwoFactorStore, throttle *auth.LoginThrottle, keystore *identity.Keystore, plcClient *plc.Client, didResolver *identity.CachingResolver, handleResolver *identity.HandleResolver, mailer mail.Mailer, rotationKey identity.PrivateKey, pdsEndpoint string) *AuthHandler {
+	pds := &pdsClient{endpoint: pdsEndpoint, httpClient: &http.Client{Timeout: 30 * time.Second}}
 	return &AuthHandler{
-		userRepo: userRepo,
+		userRepo:       userRepo,
+		sessionStore:   sessionStore,
+		sessions:       sessions,
+		twoFactor:      twoFactor,
+		throttle:       throttle,
+		keystore:       keystore,
+		plcClient:      plcClient,
+		didResolver:    didResolver,
+		handleResolver: handleResolver,
+		pds:            pds,
+		accounts:       auth.NewAccounts(userRepo, keystore, plcClient, pds, sessions, twoFactor, pdsEndpoint, rotationKey.Public()),
+		mailer:         mailer,
+		rotationKey:    rotationKey,
+		pdsEndpoint:    pdsEndpoint,
 	}
 }
 
@@ -69,31 +243,154 @@ func (h *AuthHandler) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Username, email, and password are required", http.StatusBadRequest)
 		return
 	}
+	if !validEmail(req.Email) {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+
+	// Check the username, which becomes the account's handle
+	handle, err := identity.NormalizeHandle(req.Username)
+	if err != nil {
+		http.Error(w, "Invalid username: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if xrpcErr := h.checkNewHandle(handle); xrpcErr != nil {
+		http.Error(w, xrpcErr.Message, xrpcErr.StatusCode)
+		return
+	}
+	req.Username = handle
+
+	// Check invite code
+	if h.accounts.InviteRequired {
+		if req.InviteCode == "" {
+			http.Error(w, "Invite code required", http.StatusBadRequest)
+			return
+		}
+		if err := h.userRepo.CheckInviteCode(r.Context(), req.InviteCode); err != nil {
+			writeInviteCodeError(w, err)
+			return
+		}
+	}
+
+	// Check that the username and email are free before registering a DID
+	if _, err := h.userRepo.GetUserByUsername(r.Context(), req.Username); err == nil {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	}
+	if _, err := h.userRepo.GetUserByEmail(r.Context(), req.Email); err == nil {
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
 
-	// Create user
+	// Register DID, keys, user, session and repository
 	user := &auth.User{
-		DID:      "did:plc:" + uuid.New().String(),
 		Username: req.Username,
 		Email:    req.Email,
 	}
-
-	// Save user
-	if err := h.userRepo.CreateUser(r.Context(), user, req.Password); err != nil {
-		log.Printf("Failed to create user: %v", err)
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	tokens, err := h.accounts.Register(r.Context(), user, req.Password, req.InviteCode)
+	if err != nil {
+		log.Printf("Failed to register %s: %v", req.Username, err)
+		writeCreateAccountError(w, err)
 		return
 	}
 
-	// Create token (in a real implementation, this would be a JWT)
-	token := uuid.New().String()
+	// Ask the user to confirm their email
+	if err := h.sendEmailConfirmation(r.Context(), user); err != nil {
+		log.Printf("Failed to send email confirmation: %v", err)
+	}
 
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(AuthResponse{
-		Token: token,
-		User:  user,
+		AccessJwt:  tokens.AccessJwt,
+		RefreshJwt: tokens.RefreshJwt,
+		User:       user,
+	})
+}
+
+// writeCreateAccountError writes the error response for an account that could
+// not be created
+func writeCreateAccountError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidInviteCode):
+		http.Error(w, "Invalid invite code", http.StatusBadRequest)
+	case errors.Is(err, auth.ErrDuplicateHandle):
+		http.Error(w, "Username already taken", http.StatusConflict)
+	case errors.Is(err, auth.ErrUpstream):
+		http.Error(w, "Failed to create account", http.StatusBadGateway)
+	default:
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	}
+}
+
+// pdsClient calls the internal endpoints of the local PDS
+type pdsClient struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// CreateRepository asks the PDS to create the account and repository of a new
+// DID, with a genesis commit signed by the account's signing key. A conflict,
+// which for a new DID means another account has the handle, is reported as
+// auth.ErrDuplicateHandle.
+func (c *pdsClient) CreateRepository(ctx context.Context, did, handle string, signingKey identity.PrivateKey) error {
+	commit, err := pds.NewGenesisCommit(did, signingKey)
+	if err != nil {
+		return err
+	}
+	token, err := auth.NewServiceToken(did, c.endpoint, auth.LxmCreateAccount, signingKey, auth.DefaultServiceTokenTTL)
+	if err != nil {
+		return fmt.Errorf("failed to sign service token: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"handle": handle,
+		"commit": commit,
 	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal repository request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/repo/create", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		return auth.ErrDuplicateHandle
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// DeactivateRepository asks the PDS to deactivate a repository that has migrated away
+func (c *pdsClient) DeactivateRepository(ctx context.Context, did string) error {
+	deactivateURL := c.endpoint + "/migration/deactivate?did=" + url.QueryEscape(did)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, deactivateURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
 }
 
 // LoginHandler handles user login
@@ -117,80 +414,2292 @@ func (h *AuthHandler) LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	// Get user
 	user, err := h.userRepo.GetUserByUsername(r.Context(), req.Username)
+
+	// Refuse attempts while the account or client is locked out
+	account := throttleAccount(req.Username, user, err)
+	if wait := h.loginLockout(r, req.Username, account); wait > 0 {
+		w.Header().Set("Retry-After", retryAfter(wait))
+		http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+		return
+	}
+
 	if err != nil {
-		log.Printf("Failed to get user: %v", err)
+		h.recordFailedLogin(r, req.Username, account, auth.LoginFailureInvalidCredentials)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Verify password
-	if !h.userRepo.VerifyPassword(user, req.Password) {
+	// Verify password and create session
+	tokens, ok, err := h.accounts.Login(r.Context(), user, req.Password, req.AuthFactorToken)
+	if !ok {
+		h.recordFailedLogin(r, req.Username, account, auth.LoginFailureInvalidCredentials)
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
-
-	// Create token (in a real implementation, this would be a JWT)
-	token := uuid.New().String()
+	if errors.Is(err, auth.ErrInvalidAuthFactor) {
+		h.recordFailedLogin(r, req.Username, account, auth.LoginFailureInvalidAuthFactor)
+	}
+	if writeLoginError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		http.Error(w, "Failed to create session", http.StatusInternalServerError)
+		return
+	}
+	h.resetLoginFailures(r, account)
 
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(AuthResponse{
-		Token: token,
-		User:  user,
+		AccessJwt:  tokens.AccessJwt,
+		RefreshJwt: tokens.RefreshJwt,
+		User:       user,
 	})
 }
 
-func main() {
-	// Create context
-	ctx := context.Background()
+// CreateSessionHandler handles com.atproto.server.createSession
+func (h *AuthHandler) CreateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateSessionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	// Validate request
+	if req.Identifier == "" || req.Password == "" {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Identifier and password are required"))
+		return
+	}
+
+	// Get user by handle or DID
+	var user *auth.User
+	var err error
+	if strings.HasPrefix(req.Identifier, "did:") {
+		user, err = h.userRepo.GetUserByDID(r.Context(), req.Identifier)
+	} else {
+		user, err = h.userRepo.GetUserByUsername(r.Context(), req.Identifier)
+	}
+
+	// Refuse attempts while the account or client is locked out
+	account := throttleAccount(req.Identifier, user, err)
+	if wait := h.loginLockout(r, req.Identifier, account); wait > 0 {
+		w.Header().Set("Retry-After", retryAfter(wait))
+		xrpc.WriteError(w, xrpc.NewError(http.StatusTooManyRequests, "RateLimitExceeded", "Too many failed login attempts"))
+		return
+	}
 
-	// Connect to database
-	dbPool, err := db.GetDBPool(ctx)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		h.recordFailedLogin(r, req.Identifier, account, auth.LoginFailureInvalidCredentials)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AuthenticationRequired", "Invalid identifier or password"))
+		return
 	}
-	defer dbPool.Close()
 
-	// Create repositories
-	userRepo := auth.NewUserRepository(dbPool)
+	// Verify password and create session
+	tokens, ok, err := h.accounts.Login(r.Context(), user, req.Password, req.AuthFactorToken)
+	if !ok {
+		h.recordFailedLogin(r, req.Identifier, account, auth.LoginFailureInvalidCredentials)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AuthenticationRequired", "Invalid identifier or password"))
+		return
+	}
+	if errors.Is(err, auth.ErrInvalidAuthFactor) {
+		h.recordFailedLogin(r, req.Identifier, account, auth.LoginFailureInvalidAuthFactor)
+	}
+	if writeLoginError(w, err) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create session: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to create session"))
+		return
+	}
+	h.resetLoginFailures(r, account)
 
-	// Create handlers
-	authHandler := NewAuthHandler(userRepo)
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionResponse{
+		AccessJwt:      tokens.AccessJwt,
+		RefreshJwt:     tokens.RefreshJwt,
+		Handle:         user.Username,
+		DID:            user.DID,
+		Email:          user.Email,
+		EmailConfirmed: user.EmailConfirmedAt != nil,
+		Status:         user.Status,
+	})
+}
 
-	// Create HTTP server
-	mux := http.NewServeMux()
-	mux.HandleFunc("/register", authHandler.RegisterHandler)
-	mux.HandleFunc("/login", authHandler.LoginHandler)
+// throttleAccount returns the key failed logins of identifier are counted under:
+// the account's DID if it exists, so that a handle and a DID share one limit
+func throttleAccount(identifier string, user *auth.User, err error) string {
+	if err == nil {
+		return user.DID
+	}
+	return strings.ToLower(identifier)
+}
 
-	// Health check endpoint
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+// loginLockout returns how long the account and client must wait before trying
+// to log in again, auditing the attempt if they must. Attempts are let through if
+// the throttle cannot be checked.
+func (h *AuthHandler) loginLockout(r *http.Request, identifier, account string) time.Duration {
+	wait, err := h.throttle.Check(r.Context(), account, h.throttle.ClientIP(r))
+	if err != nil {
+		log.Printf("Failed to check login throttle: %v", err)
+		return 0
+	}
+	if wait > 0 {
+		h.auditFailedLogin(r, identifier, account, auth.LoginFailureThrottled)
+	}
+	return wait
+}
+
+// recordFailedLogin counts a failed login against the account and client and audits it
+func (h *AuthHandler) recordFailedLogin(r *http.Request, identifier, account, reason string) {
+	if err := h.throttle.Fail(r.Context(), account, h.throttle.ClientIP(r)); err != nil {
+		log.Printf("Failed to record login failure: %v", err)
+	}
+	h.auditFailedLogin(r, identifier, account, reason)
+}
+
+// auditFailedLogin writes an audit record of a failed login
+func (h *AuthHandler) auditFailedLogin(r *http.Request, identifier, account, reason string) {
+	attempt := &auth.FailedLogin{
+		Identifier: identifier,
+		IP:         h.throttle.ClientIP(r),
+		UserAgent:  r.UserAgent(),
+		Reason:     reason,
+	}
+	if strings.HasPrefix(account, "did:") {
+		attempt.DID = account
+	}
+	if err := h.userRepo.RecordFailedLogin(r.Context(), attempt); err != nil {
+		log.Printf("Failed to audit failed login: %v", err)
+	}
+}
+
+// resetLoginFailures forgets the failed logins of an account after it logged in
+func (h *AuthHandler) resetLoginFailures(r *http.Request, account string) {
+	if err := h.throttle.Succeed(r.Context(), account); err != nil {
+		log.Printf("Failed to reset login failures: %v", err)
+	}
+}
+
+// retryAfter formats a wait as a Retry-After header value in whole seconds
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int((wait + time.Second - 1) / time.Second))
+}
+
+// writeLoginError writes the error response if err is a missing or invalid
+// second factor or an account that cannot log in, and reports whether it did
+func writeLoginError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, auth.ErrAccountSuspended):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AccountSuspended", "Account is suspended"))
+	case errors.Is(err, auth.ErrAccountTakendown):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AccountTakedown", "Account has been taken down"))
+	case errors.Is(err, auth.ErrAccountDeleted):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AccountDeleted", "Account has been deleted"))
+	case errors.Is(err, auth.ErrAuthFactorRequired):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AuthFactorTokenRequired", "A TOTP or recovery code is required"))
+	case errors.Is(err, auth.ErrInvalidAuthFactor):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "InvalidAuthFactorToken", "Invalid TOTP or recovery code"))
+	default:
+		return false
+	}
+	return true
+}
+
+// RefreshSessionHandler handles com.atproto.server.refreshSession
+func (h *AuthHandler) RefreshSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Rotate refresh token
+	claims, tokens, err := h.sessions.RefreshSession(r.Context(), auth.BearerToken(r))
+	if errors.Is(err, auth.ErrRefreshTokenReused) {
+		log.Printf("Refresh token reused for session %s, session revoked", claims.SessionID)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "ExpiredToken", "Refresh token has already been used"))
+		return
+	}
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "InvalidToken", "Invalid refresh token"))
+		return
+	}
+
+	// Get user
+	user, err := h.userRepo.GetUserByDID(r.Context(), claims.Subject)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AccountNotFound", "Account not found"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionResponse{
+		AccessJwt:  tokens.AccessJwt,
+		RefreshJwt: tokens.RefreshJwt,
+		Handle:     user.Username,
+		DID:        user.DID,
+		Status:     user.Status,
 	})
+}
 
-	// Create server
-	server := &http.Server{
-		Addr:    ":8081",
-		Handler: mux,
+// GetSessionHandler handles com.atproto.server.getSession
+func (h *AuthHandler) GetSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
 
-	// Start server in a goroutine
-	go func() {
-		log.Printf("Starting auth service on port 8081")
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
-		}
-	}()
+	// Verify access token
+	claims, err := auth.VerifyRequest(h.sessions, r)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "InvalidToken", "Invalid access token"))
+		return
+	}
 
-	// Wait for interrupt signal
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-	<-stop
+	// Get user
+	user, err := h.userRepo.GetUserByDID(r.Context(), claims.Subject)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AccountNotFound", "Account not found"))
+		return
+	}
 
-	// Graceful shutdown
-	log.Println("Shutting down server...")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SessionResponse{
+		Handle:         user.Username,
+		DID:            user.DID,
+		Email:          user.Email,
+		EmailConfirmed: user.EmailConfirmedAt != nil,
+		Status:         user.Status,
+	})
+}
+
+// DeleteSessionHandler handles com.atproto.server.deleteSession
+func (h *AuthHandler) DeleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Revoke session
+	if err := h.sessions.DeleteSession(r.Context(), auth.BearerToken(r)); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "InvalidToken", "Invalid refresh token"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ListSessionsHandler lists the authenticated user's active sessions
+func (h *AuthHandler) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	did, _ := auth.DIDFromContext(r.Context())
+	sessions, err := h.sessionStore.ListSessions(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to list sessions: %v", err)
+		http.Error(w, "Failed to list sessions", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sessions": sessions,
+	})
+}
+
+// RevokeAllSessionsHandler logs the authenticated user out everywhere
+func (h *AuthHandler) RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	did, _ := auth.DIDFromContext(r.Context())
+	if err := h.sessions.RevokeAllSessions(r.Context(), did); err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// GetServiceAuthHandler handles com.atproto.server.getServiceAuth. It signs a
+// short-lived service token with the account's DID key so that another service
+// can act on the user's behalf.
+func (h *AuthHandler) GetServiceAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Validate request
+	audience := r.URL.Query().Get("aud")
+	lexiconMethod := r.URL.Query().Get("lxm")
+	if audience == "" || lexiconMethod == "" {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "aud and lxm are required"))
+		return
+	}
+
+	if privilegedServiceMethods[lexiconMethod] {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusForbidden, "InvalidRequest", "Service tokens for "+lexiconMethod+" are issued by /migration/serviceToken"))
+		return
+	}
+	claims, _ := auth.ClaimsFromContext(r.Context())
+
+	// Sign token with the account's signing key
+	signingKey, err := h.keystore.GetKey(r.Context(), claims.Subject, identity.KeyPurposeSigning)
+	if err != nil {
+		log.Printf("Failed to get signing key: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to create service token"))
+		return
+	}
+	token, err := auth.NewServiceToken(claims.Subject, audience, lexiconMethod, signingKey, auth.DefaultServiceTokenTTL)
+	if err != nil {
+		log.Printf("Failed to sign service token: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to create service token"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// CreateAppPasswordHandler handles com.atproto.server.createAppPassword
+func (h *AuthHandler) CreateAppPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AppPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	// Validate request
+	if req.Name == "" {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Name is required"))
+		return
+	}
+
+	// Create app password
+	did, _ := auth.DIDFromContext(r.Context())
+	appPassword, password, err := h.userRepo.CreateAppPassword(r.Context(), did, req.Name)
+	if errors.Is(err, auth.ErrDuplicateAppPassword) {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "DuplicateName", "An app password with this name already exists"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to create app password: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to create app password"))
+		return
+	}
+
+	// Return response; the password is only shown once
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":      appPassword.Name,
+		"password":  password,
+		"createdAt": appPassword.CreatedAt,
+	})
+}
+
+// ListAppPasswordsHandler handles com.atproto.server.listAppPasswords
+func (h *AuthHandler) ListAppPasswordsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	did, _ := auth.DIDFromContext(r.Context())
+	appPasswords, err := h.userRepo.ListAppPasswords(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to list app passwords: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to list app passwords"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"passwords": appPasswords,
+	})
+}
+
+// RevokeAppPasswordHandler handles com.atproto.server.revokeAppPassword
+func (h *AuthHandler) RevokeAppPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AppPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	// Delete app password
+	did, _ := auth.DIDFromContext(r.Context())
+	found, err := h.userRepo.RevokeAppPassword(r.Context(), did, req.Name)
+	if err != nil {
+		log.Printf("Failed to revoke app password: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to revoke app password"))
+		return
+	}
+	if !found {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "App password not found"))
+		return
+	}
+
+	// End the sessions it created
+	if err := h.sessions.RevokeAppPasswordSessions(r.Context(), did, req.Name); err != nil {
+		log.Printf("Failed to revoke app password sessions: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to revoke app password sessions"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RevokedSessionsHandler publishes the sessions whose access tokens must be rejected
+func (h *AuthHandler) RevokedSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	revoked, err := h.sessions.RevokedTokens(r.Context())
+	if err != nil {
+		log.Printf("Failed to list revoked sessions: %v", err)
+		http.Error(w, "Failed to list revoked sessions", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"revoked": revoked,
+	})
+}
+
+// JWKSHandler publishes the public keys session tokens are signed with, for
+// other services to verify tokens on their own
+func (h *AuthHandler) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(h.sessions.JWKS())
+}
+
+// RequestEmailConfirmationHandler handles com.atproto.server.requestEmailConfirmation
+func (h *AuthHandler) RequestEmailConfirmationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+
+	if err := h.sendEmailConfirmation(r.Context(), user); err != nil {
+		log.Printf("Failed to send email confirmation: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to send email"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ConfirmEmailHandler handles com.atproto.server.confirmEmail
+func (h *AuthHandler) ConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+	if !strings.EqualFold(user.Email, req.Email) {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidEmail", "Email does not match the account"))
+		return
+	}
+
+	// Check token
+	if _, err := h.userRepo.ConsumeEmailToken(r.Context(), auth.EmailTokenConfirmEmail, req.Token, did); err != nil {
+		writeEmailTokenError(w, err)
+		return
+	}
+
+	if err := h.userRepo.ConfirmEmail(r.Context(), did); err != nil {
+		log.Printf("Failed to confirm email: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to confirm email"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RequestPasswordResetHandler handles com.atproto.server.requestPasswordReset. It
+// succeeds whether or not the email belongs to an account.
+func (h *AuthHandler) RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	// Send token if the account exists
+	user, err := h.userRepo.GetUserByEmail(r.Context(), req.Email)
+	if err == nil {
+		err = h.sendEmailToken(r.Context(), user.DID, user.Email, auth.EmailTokenResetPassword, emailTokenTTL,
+			"Reset your password",
+			"Someone requested a password reset for your account "+user.Username+". Enter this code to choose a new password:")
+		if err != nil {
+			log.Printf("Failed to send password reset: %v", err)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ResetPasswordHandler handles com.atproto.server.resetPassword. Resetting the
+// password ends every session of the account.
+func (h *AuthHandler) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+	if req.Token == "" || req.Password == "" {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Token and password are required"))
+		return
+	}
+
+	// Check token
+	did, err := h.userRepo.ConsumeEmailToken(r.Context(), auth.EmailTokenResetPassword, req.Token, "")
+	if err != nil {
+		writeEmailTokenError(w, err)
+		return
+	}
+
+	// Update password and log out everywhere
+	if err := h.userRepo.UpdatePassword(r.Context(), did, req.Password); err != nil {
+		log.Printf("Failed to update password: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to reset password"))
+		return
+	}
+	if err := h.sessions.RevokeAllSessions(r.Context(), did); err != nil {
+		log.Printf("Failed to revoke sessions: %v", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RequestEmailUpdateHandler handles com.atproto.server.requestEmailUpdate. If the
+// current email is confirmed, a token is sent to it that updateEmail requires.
+func (h *AuthHandler) RequestEmailUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+
+	tokenRequired := user.EmailConfirmedAt != nil
+	if tokenRequired {
+		err := h.sendEmailToken(r.Context(), user.DID, user.Email, auth.EmailTokenUpdateEmail, emailTokenTTL,
+			"Update your email",
+			"Someone requested to change the email of your account "+user.Username+". Enter this code to confirm the change:")
+		if err != nil {
+			log.Printf("Failed to send email update token: %v", err)
+			xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to send email"))
+			return
+		}
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"tokenRequired": tokenRequired})
+}
+
+// UpdateEmailHandler handles com.atproto.server.updateEmail. The new email is
+// unconfirmed and a confirmation is sent to it.
+func (h *AuthHandler) UpdateEmailHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req EmailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+	if !validEmail(req.Email) {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid email address"))
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+
+	// A confirmed email can only be changed with a token sent to it
+	if user.EmailConfirmedAt != nil {
+		if req.Token == "" {
+			xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "TokenRequired", "Confirmation token required"))
+			return
+		}
+		if _, err := h.userRepo.ConsumeEmailToken(r.Context(), auth.EmailTokenUpdateEmail, req.Token, did); err != nil {
+			writeEmailTokenError(w, err)
+			return
+		}
+	}
+
+	// Update email
+	err = h.userRepo.UpdateEmail(r.Context(), did, req.Email)
+	if errors.Is(err, auth.ErrDuplicateEmail) {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "This email address is already in use"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to update email: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to update email"))
+		return
+	}
+
+	user.Email = req.Email
+	if err := h.sendEmailConfirmation(r.Context(), user); err != nil {
+		log.Printf("Failed to send email confirmation: %v", err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// EnrollTOTPHandler starts TOTP enrollment and returns the secret and its
+// provisioning URI. TOTP is not required for login until it is confirmed.
+func (h *AuthHandler) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+
+	// Generate secret
+	secret, err := h.twoFactor.Enroll(r.Context(), did)
+	if errors.Is(err, auth.ErrTOTPEnabled) {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AlreadyEnabled", "TOTP is already enabled"))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to enroll TOTP: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to enroll TOTP"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"secret": secret,
+		"uri":    auth.TOTPProvisioningURI(h.totpIssuer(), user.Username, secret),
+	})
+}
+
+// ConfirmTOTPHandler enables an enrolled TOTP secret with a code from the
+// authenticator app and returns the account's recovery codes
+func (h *AuthHandler) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	did, _ := auth.DIDFromContext(r.Context())
+	codes, err := h.twoFactor.Confirm(r.Context(), did, req.Code)
+	if h.writeTOTPError(w, err) {
+		return
+	}
+
+	// Return response; the recovery codes are only shown once
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableTOTPHandler turns TOTP off after checking a current code
+func (h *AuthHandler) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	did, _ := auth.DIDFromContext(r.Context())
+	if h.writeTOTPError(w, h.verifyTOTP(r, did, req.Code)) {
+		return
+	}
+
+	if err := h.twoFactor.Disable(r.Context(), did); err != nil {
+		log.Printf("Failed to disable TOTP: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to disable TOTP"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RegenerateRecoveryCodesHandler replaces the account's recovery codes after checking a current code
+func (h *AuthHandler) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TOTPCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	did, _ := auth.DIDFromContext(r.Context())
+	if h.writeTOTPError(w, h.verifyTOTP(r, did, req.Code)) {
+		return
+	}
+
+	codes, err := h.twoFactor.RegenerateRecoveryCodes(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to regenerate recovery codes: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to regenerate recovery codes"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"recoveryCodes": codes,
+	})
+}
+
+// verifyTOTP checks a TOTP or recovery code of an account with TOTP enabled.
+// As in the login endpoints, locked out accounts and clients are refused and
+// wrong codes are counted and audited, so that a stolen access token cannot be
+// used to guess the code.
+func (h *AuthHandler) verifyTOTP(r *http.Request, did, code string) error {
+	if wait := h.loginLockout(r, did, did); wait > 0 {
+		return &auth.ThrottledError{Wait: wait}
+	}
+
+	ok, err := h.twoFactor.Verify(r.Context(), did, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		h.recordFailedLogin(r, did, did, auth.LoginFailureInvalidAuthFactor)
+		return auth.ErrInvalidAuthFactor
+	}
+	h.resetLoginFailures(r, did)
+	return nil
+}
+
+// writeTOTPError writes the error response for a failed TOTP operation, and reports whether there was one
+func (h *AuthHandler) writeTOTPError(w http.ResponseWriter, err error) bool {
+	var throttled *auth.ThrottledError
+	switch {
+	case err == nil:
+		return false
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", retryAfter(throttled.Wait))
+		xrpc.WriteError(w, xrpc.NewError(http.StatusTooManyRequests, "RateLimitExceeded", "Too many failed attempts"))
+	case errors.Is(err, auth.ErrInvalidAuthFactor):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidAuthFactorToken", "Invalid TOTP or recovery code"))
+	case errors.Is(err, auth.ErrTOTPNotEnrolled):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "NotEnrolled", "TOTP is not enrolled"))
+	case errors.Is(err, auth.ErrTOTPEnabled):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AlreadyEnabled", "TOTP is already enabled"))
+	default:
+		log.Printf("Failed to verify TOTP: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to verify TOTP"))
+	}
+	return true
+}
+
+// totpIssuer returns the issuer name authenticator apps show for this server
+func (h *AuthHandler) totpIssuer() string {
+	if u, err := url.Parse(h.pdsEndpoint); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	return "atprogo"
+}
+
+// sendEmailConfirmation emails a token for confirming the user's email
+func (h *AuthHandler) sendEmailConfirmation(ctx context.Context, user *auth.User) error {
+	return h.sendEmailToken(ctx, user.DID, user.Email, auth.EmailTokenConfirmEmail, emailConfirmationTTL,
+		"Confirm your email",
+		"Welcome, "+user.Username+"! Enter this code to confirm your email address:")
+}
+
+// sendEmailToken creates a one-time token for purpose and emails it with an introduction
+func (h *AuthHandler) sendEmailToken(ctx context.Context, did, to, purpose string, ttl time.Duration, subject, intro string) error {
+	token, err := h.userRepo.CreateEmailToken(ctx, did, purpose, ttl)
+	if err != nil {
+		return err
+	}
+
+	return h.mailer.Send(ctx, &mail.Message{
+		To:      to,
+		Subject: subject,
+		Body:    fmt.Sprintf("%s\n\n%s\n\nThe code expires in %s. If you did not request it, you can ignore this email.\n", intro, token, ttl),
+	})
+}
+
+// writeEmailTokenError writes the error response for an invalid emailed token
+func writeEmailTokenError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrExpiredEmailToken):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "ExpiredToken", "Token has expired"))
+	case errors.Is(err, auth.ErrInvalidEmailToken):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidToken", "Token is invalid"))
+	default:
+		log.Printf("Failed to check email token: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to check token"))
+	}
+}
+
+// validEmail reports whether email is a plain email address
+func validEmail(email string) bool {
+	addr, err := netmail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// DeactivateAccountHandler handles com.atproto.server.deactivateAccount. The
+// account's repository and follows are hidden until it is reactivated; the user
+// can still log in to do so.
+func (h *AuthHandler) DeactivateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+	if user.Status != auth.StatusActive && user.Status != auth.StatusDeactivated {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Account is "+user.Status))
+		return
+	}
+
+	if err := h.userRepo.UpdateStatus(r.Context(), did, auth.StatusDeactivated); err != nil {
+		log.Printf("Failed to deactivate account: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to deactivate account"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ActivateAccountHandler handles com.atproto.server.activateAccount. Accounts
+// that have migrated to another PDS cannot be reactivated here.
+func (h *AuthHandler) ActivateAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+	if user.Status != auth.StatusActive && user.Status != auth.StatusDeactivated {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Account is "+user.Status))
+		return
+	}
+
+	// Check the current DID document, bypassing the cache
+	h.didResolver.Invalidate(did)
+	doc, err := h.didResolver.ResolveDocument(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to resolve DID: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadGateway, "UpstreamFailure", "Failed to resolve DID"))
+		return
+	}
+	if doc.PDSEndpoint() != h.pdsEndpoint {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Account has migrated to another PDS"))
+		return
+	}
+
+	if err := h.userRepo.UpdateStatus(r.Context(), did, auth.StatusActive); err != nil {
+		log.Printf("Failed to activate account: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to activate account"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// RequestAccountDeleteHandler handles com.atproto.server.requestAccountDelete by
+// emailing the token deleteAccount requires
+func (h *AuthHandler) RequestAccountDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+
+	err = h.sendEmailToken(r.Context(), user.DID, user.Email, auth.EmailTokenDeleteAccount, emailTokenTTL,
+		"Delete your account",
+		"Someone requested to delete your account "+user.Username+". Enter this code to confirm the deletion. This cannot be undone:")
+	if err != nil {
+		log.Printf("Failed to send account deletion token: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to send email"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteAccountHandler handles com.atproto.server.deleteAccount. The deletion is
+// confirmed by the account password and the emailed token.
+func (h *AuthHandler) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DeleteAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+	if req.DID == "" || req.Password == "" || req.Token == "" {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "DID, password and token are required"))
+		return
+	}
+
+	// Verify password, throttled like a login; app passwords cannot delete the account
+	user, err := h.authenticate(r, req.DID, req.Password, req.AuthFactorToken)
+	if err != nil {
+		writeAuthenticateError(w, err)
+		return
+	}
+
+	// Check token
+	if _, err := h.userRepo.ConsumeEmailToken(r.Context(), auth.EmailTokenDeleteAccount, req.Token, user.DID); err != nil {
+		writeEmailTokenError(w, err)
+		return
+	}
+
+	if err := h.accounts.Delete(r.Context(), user.DID); err != nil {
+		log.Printf("Failed to delete account %s: %v", user.DID, err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to delete account"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// UpdateAccountStatusHandler lets an admin suspend, take down or restore an
+// account. Suspending or taking down an account ends its sessions.
+func (h *AuthHandler) UpdateAccountStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AccountStatusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	// Validate request
+	switch req.Status {
+	case auth.StatusActive, auth.StatusSuspended, auth.StatusTakendown:
+	default:
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Status must be active, suspended or takendown"))
+		return
+	}
+
+	// Get user
+	user, err := h.userRepo.GetUserByDID(r.Context(), req.DID)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+	if user.Status == auth.StatusDeleted {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Account has been deleted"))
+		return
+	}
+
+	if err := h.userRepo.UpdateStatus(r.Context(), user.DID, req.Status); err != nil {
+		log.Printf("Failed to update account status: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to update account status"))
+		return
+	}
+	if req.Status != auth.StatusActive {
+		if err := h.sessions.RevokeAllSessions(r.Context(), user.DID); err != nil {
+			log.Printf("Failed to revoke sessions: %v", err)
+		}
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"did":    user.DID,
+		"status": req.Status,
+	})
+}
+
+// AccountStatusesHandler publishes the account status changes since the given
+// time for the PDS and BGS to hide or delete the accounts' data
+func (h *AuthHandler) AccountStatusesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since time.Time
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339Nano, sinceStr)
+		if err != nil {
+			http.Error(w, "Invalid since", http.StatusBadRequest)
+			return
+		}
+	}
+
+	changes, err := h.userRepo.ListStatusChanges(r.Context(), since)
+	if err != nil {
+		log.Printf("Failed to list account status changes: %v", err)
+		http.Error(w, "Failed to list account statuses", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"accounts": changes,
+	})
+}
+
+// GetAccountInviteCodesHandler handles com.atproto.server.getAccountInviteCodes.
+// With createAvailable=true, the account is first given the rest of its invite
+// code allowance.
+func (h *AuthHandler) GetAccountInviteCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	did, _ := auth.DIDFromContext(r.Context())
+	if r.URL.Query().Get("createAvailable") == "true" && h.invitesPerUser > 0 {
+		if _, err := h.userRepo.EnsureInviteCodes(r.Context(), did, h.invitesPerUser); err != nil {
+			log.Printf("Failed to create invite codes: %v", err)
+			xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to create invite codes"))
+			return
+		}
+	}
+
+	codes, err := h.userRepo.ListInviteCodes(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to list invite codes: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to list invite codes"))
+		return
+	}
+
+	// Leave out used up codes unless asked for
+	if r.URL.Query().Get("includeUsed") == "false" {
+		unused := codes[:0]
+		for _, code := range codes {
+			if len(code.Uses) < code.Available {
+				unused = append(unused, code)
+			}
+		}
+		codes = unused
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"codes": codes,
+	})
+}
+
+// CreateInviteCodesHandler lets an admin create invite codes, for an account or
+// for anyone
+func (h *AuthHandler) CreateInviteCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateInviteCodesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+
+	// Validate request
+	if req.CodeCount == 0 {
+		req.CodeCount = 1
+	}
+	if req.CodeCount < 1 || req.CodeCount > 100 || req.UseCount < 1 {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "codeCount must be between 1 and 100 and useCount at least 1"))
+		return
+	}
+	if req.ForAccount == "" {
+		req.ForAccount = auth.InviteCodeAdmin
+	} else if _, err := h.userRepo.GetUserByDID(r.Context(), req.ForAccount); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+
+	codes, err := h.userRepo.CreateInviteCodes(r.Context(), req.ForAccount, auth.InviteCodeAdmin, req.CodeCount, req.UseCount)
+	if err != nil {
+		log.Printf("Failed to create invite codes: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to create invite codes"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"codes": codes,
+	})
+}
+
+// GetInviteCodesHandler lets an admin list all invite codes and who used them
+func (h *AuthHandler) GetInviteCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	codes, err := h.userRepo.ListInviteCodes(r.Context(), r.URL.Query().Get("account"))
+	if err != nil {
+		log.Printf("Failed to list invite codes: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to list invite codes"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"codes": codes,
+	})
+}
+
+// DisableInviteCodesHandler lets an admin disable invite codes, by code or by account
+func (h *AuthHandler) DisableInviteCodesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req DisableInviteCodesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+	if len(req.Codes) == 0 && len(req.Accounts) == 0 {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Codes or accounts are required"))
+		return
+	}
+
+	disabled, err := h.userRepo.DisableInviteCodes(r.Context(), req.Codes, req.Accounts)
+	if err != nil {
+		log.Printf("Failed to disable invite codes: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to disable invite codes"))
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"disabled": disabled})
+}
+
+// writeInviteCodeError writes the error response for an invite code that cannot be used
+func writeInviteCodeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, auth.ErrInvalidInviteCode) {
+		http.Error(w, "Invalid invite code", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Failed to use invite code: %v", err)
+	http.Error(w, "Failed to check invite code", http.StatusInternalServerError)
+}
+
+// requireAdmin wraps a handler so that it requires HTTP basic auth as "admin"
+// with password. Admin endpoints are disabled if password is empty.
+func requireAdmin(password string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, given, ok := r.BasicAuth()
+		if password == "" || !ok || username != "admin" || subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="admin"`)
+			xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AuthenticationRequired", "Admin authentication required"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// loadAdminPassword loads the admin password from ADMIN_PASSWORD, or from the
+// file named by ADMIN_PASSWORD_FILE. It must be set: the admin endpoints can
+// suspend and take down any account, so there is no default.
+func loadAdminPassword() (string, error) {
+	password := os.Getenv("ADMIN_PASSWORD")
+	if password == "" {
+		path := os.Getenv("ADMIN_PASSWORD_FILE")
+		if path == "" {
+			return "", fmt.Errorf("ADMIN_PASSWORD or ADMIN_PASSWORD_FILE must be set")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read admin password file: %w", err)
+		}
+		password = strings.TrimSpace(string(data))
+	}
+	if password == "" {
+		return "", fmt.Errorf("admin password is empty")
+	}
+	return password, nil
+}
+
+// errInvalidCredentials is returned by authenticate for an unknown username or a wrong password
+var errInvalidCredentials = errors.New("invalid credentials")
+
+// authenticate looks up a user by handle or DID and verifies their password.
+// As in the login endpoints, locked out accounts and clients are refused and
+// failures are counted and audited; suspended and taken down accounts are
+// refused; and accounts with TOTP also need authFactorToken. App passwords are
+// not accepted.
+func (h *AuthHandler) authenticate(r *http.Request, identifier, password, authFactorToken string) (*auth.User, error) {
+	// Get user by handle or DID
+	var user *auth.User
+	var err error
+	if strings.HasPrefix(identifier, "did:") {
+		user, err = h.userRepo.GetUserByDID(r.Context(), identifier)
+	} else {
+		user, err = h.userRepo.GetUserByUsername(r.Context(), identifier)
+	}
+
+	// Refuse attempts while the account or client is locked out
+	account := throttleAccount(identifier, user, err)
+	if wait := h.loginLockout(r, identifier, account); wait > 0 {
+		return nil, &auth.ThrottledError{Wait: wait}
+	}
+
+	if err != nil || !h.userRepo.VerifyPassword(user, password) {
+		h.recordFailedLogin(r, identifier, account, auth.LoginFailureInvalidCredentials)
+		return nil, errInvalidCredentials
+	}
+	if err := user.CheckLogin(); err != nil {
+		return nil, err
+	}
+	if err := h.twoFactor.Check(r.Context(), user.DID, authFactorToken); err != nil {
+		if errors.Is(err, auth.ErrInvalidAuthFactor) {
+			h.recordFailedLogin(r, identifier, account, auth.LoginFailureInvalidAuthFactor)
+		}
+		return nil, err
+	}
+	h.resetLoginFailures(r, account)
+	return user, nil
+}
+
+// writeAuthenticateError writes the error response for credentials authenticate refused
+func writeAuthenticateError(w http.ResponseWriter, err error) {
+	var throttled *auth.ThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		w.Header().Set("Retry-After", retryAfter(throttled.Wait))
+		xrpc.WriteError(w, xrpc.NewError(http.StatusTooManyRequests, "RateLimitExceeded", "Too many failed login attempts"))
+	case errors.Is(err, errInvalidCredentials):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusUnauthorized, "AuthenticationRequired", "Invalid identifier or password"))
+	case writeLoginError(w, err):
+	default:
+		log.Printf("Failed to authenticate: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to authenticate"))
+	}
+}
+
+// Authenticate verifies the credentials entered on the OAuth consent screen and
+// returns the account's DID, with the same checks as authenticate
+func (h *AuthHandler) Authenticate(r *http.Request, identifier, password, authFactorToken string) (string, error) {
+	user, err := h.authenticate(r, identifier, password, authFactorToken)
+	switch {
+	case err == nil:
+		return user.DID, nil
+	case errors.Is(err, auth.ErrAuthFactorRequired):
+		return "", oauth.ErrAuthFactorRequired
+	case errors.Is(err, errInvalidCredentials), errors.Is(err, auth.ErrInvalidAuthFactor),
+		errors.Is(err, auth.ErrAccountSuspended), errors.Is(err, auth.ErrAccountTakendown), errors.Is(err, auth.ErrAccountDeleted):
+		return "", oauth.ErrInvalidCredentials
+	}
+	return "", err
+}
+
+// RequestPlcOperationSignatureHandler handles
+// com.atproto.identity.requestPlcOperationSignature by emailing the token the
+// migration endpoints require
+func (h *AuthHandler) RequestPlcOperationSignatureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+
+	err = h.sendEmailToken(r.Context(), user.DID, user.Email, auth.EmailTokenPlcOperation, emailTokenTTL,
+		"Confirm your account migration",
+		"Someone requested to move your account "+user.Username+" to another server, which changes the keys that control it. Enter this code to confirm the migration:")
+	if err != nil {
+		log.Printf("Failed to send PLC operation token: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to send email"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// migratingUser returns the active account of a full access session, or writes
+// the error response
+func (h *AuthHandler) migratingUser(w http.ResponseWriter, r *http.Request) (*auth.User, bool) {
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.accounts.MigratingUser(r.Context(), did)
+	switch {
+	case err == nil:
+		return user, true
+	case errors.Is(err, auth.ErrAccountNotFound):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+	case errors.Is(err, auth.ErrAccountNotActive):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountDeactivated", "Account is not active"))
+	case !writeLoginError(w, err):
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to get account"))
+	}
+	return nil, false
+}
+
+// ServiceTokenHandler issues a short-lived service token signed with the
+// account's signing key. It requires a full access session and the emailed PLC
+// operation token, which is left for signPlcOperation to use.
+func (h *AuthHandler) ServiceTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ServiceTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Audience == "" || req.LexiconMethod == "" || req.Token == "" {
+		http.Error(w, "Audience, lexicon method and token are required", http.StatusBadRequest)
+		return
+	}
+
+	// Get user and check token
+	user, ok := h.migratingUser(w, r)
+	if !ok {
+		return
+	}
+	if err := h.userRepo.CheckEmailToken(r.Context(), auth.EmailTokenPlcOperation, req.Token, user.DID); err != nil {
+		writeEmailTokenError(w, err)
+		return
+	}
+
+	// Sign token with the account's signing key
+	signingKey, err := h.keystore.GetKey(r.Context(), user.DID, identity.KeyPurposeSigning)
+	if err != nil {
+		log.Printf("Failed to get signing key: %v", err)
+		http.Error(w, "Failed to create service token", http.StatusInternalServerError)
+		return
+	}
+	token, err := auth.NewServiceToken(user.DID, req.Audience, req.LexiconMethod, signingKey, serviceTokenTTL)
+	if err != nil {
+		log.Printf("Failed to sign service token: %v", err)
+		http.Error(w, "Failed to create service token", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// MigrationAccountHandler creates an account for a DID migrating in from another PDS.
+// The request must carry a service token signed by the DID's current signing key.
+func (h *AuthHandler) MigrationAccountHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Verify service token
+	claims, err := auth.VerifyServiceToken(r.Context(), auth.BearerToken(r), h.didResolver,
+		auth.WithAudience(h.pdsEndpoint), auth.WithLexiconMethod(auth.LxmCreateAccount))
+	if err != nil {
+		http.Error(w, "Invalid service token", http.StatusUnauthorized)
+		return
+	}
+	did := claims.Issuer
+
+	var req MigrationAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Username == "" || req.Email == "" || req.Password == "" {
+		http.Error(w, "Username, email, and password are required", http.StatusBadRequest)
+		return
+	}
+	if !validEmail(req.Email) {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	if h.accounts.InviteRequired && req.InviteCode == "" {
+		http.Error(w, "Invite code required", http.StatusBadRequest)
+		return
+	}
+
+	// Check the username, which becomes the account's handle; a custom domain
+	// must already point at the DID
+	handle, err := identity.NormalizeHandle(req.Username)
+	if err != nil {
+		http.Error(w, "Invalid username: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if xrpcErr := h.checkHandle(r.Context(), did, handle); xrpcErr != nil {
+		http.Error(w, xrpcErr.Message, xrpcErr.StatusCode)
+		return
+	}
+	req.Username = handle
+
+	// Refuse DIDs that already have an account here before touching their keys
+	if _, err := h.userRepo.GetUserByDID(r.Context(), did); err == nil {
+		http.Error(w, "Account already exists", http.StatusConflict)
+		return
+	}
+	if _, err := h.userRepo.GetUserByUsername(r.Context(), handle); err == nil {
+		http.Error(w, "Username already taken", http.StatusConflict)
+		return
+	}
+	if _, err := h.userRepo.GetUserByEmail(r.Context(), req.Email); err == nil {
+		http.Error(w, "Email already in use", http.StatusConflict)
+		return
+	}
+
+	// Store keys, use invite code and create user
+	user := &auth.User{
+		DID:      did,
+		Username: req.Username,
+		Email:    req.Email,
+	}
+	signingDID, rotationDID, err := h.accounts.CreateMigratedAccount(r.Context(), user, req.Password, req.InviteCode)
+	if err != nil {
+		log.Printf("Failed to create migrated account %s: %v", did, err)
+		writeCreateAccountError(w, err)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(MigrationAccountResponse{
+		DID:         did,
+		SigningKey:  signingDID.String(),
+		RotationKey: rotationDID.String(),
+		PDSEndpoint: h.pdsEndpoint,
+	})
+}
+
+// SignPlcOperationHandler points an account's DID at a new PDS and deactivates
+// the local account. It requires a full access session and consumes the emailed
+// PLC operation token; only then is the caller's rotation key put ahead of the
+// account's own.
+func (h *AuthHandler) SignPlcOperationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SignPlcOperationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.Token == "" || req.SigningKey == "" || req.PDSEndpoint == "" {
+		http.Error(w, "Token, signing key and PDS endpoint are required", http.StatusBadRequest)
+		return
+	}
+	signingDID, err := identity.ParseKeyDID(req.SigningKey)
+	if err != nil {
+		http.Error(w, "Invalid signing key", http.StatusBadRequest)
+		return
+	}
+	if req.RotationKey != "" {
+		if _, err := identity.ParseKeyDID(req.RotationKey); err != nil {
+			http.Error(w, "Invalid rotation key", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get user and consume token
+	user, ok := h.migratingUser(w, r)
+	if !ok {
+		return
+	}
+	if _, err := h.userRepo.ConsumeEmailToken(r.Context(), auth.EmailTokenPlcOperation, req.Token, user.DID); err != nil {
+		writeEmailTokenError(w, err)
+		return
+	}
+
+	// Update DID with the account's rotation key
+	rotationKey, err := h.keystore.GetKey(r.Context(), user.DID, identity.KeyPurposeRotation)
+	if err != nil {
+		log.Printf("Failed to get rotation key: %v", err)
+		http.Error(w, "Failed to update DID", http.StatusInternalServerError)
+		return
+	}
+	err = h.plcClient.Update(r.Context(), user.DID, rotationKey, func(op *plc.Operation) {
+		op.SetSigningKey(signingDID.PublicKey)
+		op.SetPDSEndpoint(req.PDSEndpoint)
+		if req.RotationKey != "" {
+			op.RotationKeys = append([]string{req.RotationKey}, op.RotationKeys...)
+		}
+	})
+	if err != nil {
+		log.Printf("Failed to update DID: %v", err)
+		http.Error(w, "Failed to update DID", http.StatusBadGateway)
+		return
+	}
+	h.didResolver.Invalidate(user.DID)
+
+	// Deactivate local account and repository
+	if err := h.userRepo.UpdateStatus(r.Context(), user.DID, auth.StatusDeactivated); err != nil {
+		log.Printf("Failed to deactivate user: %v", err)
+		http.Error(w, "Failed to deactivate account", http.StatusInternalServerError)
+		return
+	}
+	if err := h.pds.DeactivateRepository(r.Context(), user.DID); err != nil {
+		// The PDS can be asked again; the DID already points elsewhere
+		log.Printf("Failed to deactivate repository for %s: %v", user.DID, err)
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"did":         user.DID,
+		"pdsEndpoint": req.PDSEndpoint,
+		"status":      auth.StatusDeactivated,
+	})
+}
+
+// SignCommitHandler signs a repository commit with an account's signing key for
+// the PDS. It is only served on the internal listener, and only signs record
+// operation commits, never other data such as service tokens.
+func (h *AuthHandler) SignCommitHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req pds.SignCommitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Validate request
+	if req.DID == "" {
+		http.Error(w, "DID is required", http.StatusBadRequest)
+		return
+	}
+	if err := pds.CheckCommitData(req.Data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only active accounts can write to their repository
+	user, err := h.userRepo.GetUserByDID(r.Context(), req.DID)
+	if err != nil {
+		http.Error(w, "Account not found", http.StatusNotFound)
+		return
+	}
+	if user.Status != auth.StatusActive {
+		http.Error(w, "Account is not active", http.StatusForbidden)
+		return
+	}
+
+	// Sign commit
+	signingKey, err := h.keystore.GetKey(r.Context(), req.DID, identity.KeyPurposeSigning)
+	if err != nil {
+		log.Printf("Failed to get signing key: %v", err)
+		http.Error(w, "Failed to sign commit", http.StatusInternalServerError)
+		return
+	}
+	signature, err := signingKey.Sign(req.Data)
+	if err != nil {
+		log.Printf("Failed to sign commit: %v", err)
+		http.Error(w, "Failed to sign commit", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pds.SignCommitResponse{Signature: signature})
+}
+
+// UpdateHandleHandler handles com.atproto.identity.updateHandle. The new handle
+// is written to the DID document and published as an identity event.
+func (h *AuthHandler) UpdateHandleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Parse request
+	var req UpdateHandleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Invalid request body"))
+		return
+	}
+	handle, err := identity.NormalizeHandle(req.Handle)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidHandle", err.Error()))
+		return
+	}
+
+	// Get user
+	did, _ := auth.DIDFromContext(r.Context())
+	user, err := h.userRepo.GetUserByDID(r.Context(), did)
+	if err != nil {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "AccountNotFound", "Account not found"))
+		return
+	}
+	if user.Status != auth.StatusActive {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", "Account is "+user.Status))
+		return
+	}
+	if user.Username == handle {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	// Check the handle
+	if xrpcErr := h.checkHandle(r.Context(), did, handle); xrpcErr != nil {
+		xrpc.WriteError(w, xrpcErr)
+		return
+	}
+	if other, err := h.userRepo.GetUserByUsername(r.Context(), handle); err == nil && other.DID != did {
+		xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "HandleNotAvailable", "Handle already taken"))
+		return
+	}
+
+	// Point the DID document at the new handle. did:web documents are served
+	// by the PDS, which picks the handle up from the identity event.
+	if strings.HasPrefix(did, "did:plc:") {
+		if err := h.publishHandle(r.Context(), did, handle); err != nil {
+			log.Printf("Failed to publish handle %s for %s: %v", handle, did, err)
+			xrpc.WriteError(w, xrpc.NewError(http.StatusBadGateway, "InternalServerError", "Failed to update DID document"))
+			return
+		}
+	}
+
+	// Update handle and emit identity event
+	if _, err := h.userRepo.UpdateHandle(r.Context(), did, handle); err != nil {
+		// Put the old handle back if the DID document already has the new one
+		if strings.HasPrefix(did, "did:plc:") {
+			if err := h.publishHandle(r.Context(), did, user.Username); err != nil {
+				log.Printf("Failed to restore handle %s for %s: %v", user.Username, did, err)
+			}
+		}
+		if errors.Is(err, auth.ErrDuplicateHandle) {
+			xrpc.WriteError(w, xrpc.NewError(http.StatusBadRequest, "HandleNotAvailable", "Handle already taken"))
+			return
+		}
+		log.Printf("Failed to update handle: %v", err)
+		xrpc.WriteError(w, xrpc.NewError(http.StatusInternalServerError, "InternalServerError", "Failed to update handle"))
+		return
+	}
+	h.didResolver.Invalidate(did)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// checkHandle checks that an account may take a handle. Handles under one of
+// the service's domains must be a single name that is not reserved; handles on
+// other domains must resolve to the account's DID.
+func (h *AuthHandler) checkHandle(ctx context.Context, did, handle string) *xrpc.Error {
+	if xrpcErr, ok := h.checkServiceHandle(handle); ok {
+		return xrpcErr
+	}
+
+	// Custom domains must point at the account
+	resolved, err := h.handleResolver.LookupHandle(ctx, handle)
+	if err != nil {
+		log.Printf("Failed to look up handle %s: %v", handle, err)
+		return xrpc.NewError(http.StatusBadRequest, "UnsupportedDomain", "Handle does not resolve to the account's DID")
+	}
+	if resolved != did {
+		return xrpc.NewError(http.StatusBadRequest, "UnsupportedDomain", "Handle does not resolve to the account's DID")
+	}
+	return nil
+}
+
+// checkServiceHandle checks a handle under one of the service's domains, which
+// must be a single name that is not reserved. The boolean reports whether the
+// handle is under one of the domains.
+func (h *AuthHandler) checkServiceHandle(handle string) (*xrpc.Error, bool) {
+	for _, domain := range h.handleDomains {
+		if !strings.HasSuffix(handle, domain) {
+			continue
+		}
+		name := strings.TrimSuffix(handle, domain)
+		if strings.Contains(name, ".") {
+			return xrpc.NewError(http.StatusBadRequest, "InvalidHandle", "Handle cannot contain dots before "+domain), true
+		}
+		if len(name) < 3 || len(name) > 18 {
+			return xrpc.NewError(http.StatusBadRequest, "InvalidHandle", "Handle name must be between 3 and 18 characters"), true
+		}
+		if identity.IsReservedHandle(handle) {
+			return xrpc.NewError(http.StatusBadRequest, "HandleNotAvailable", "Handle is reserved"), true
+		}
+		return nil, true
+	}
+	return nil, false
+}
+
+// checkNewHandle checks the handle of an account that is being registered.
+// Without a DID yet, no custom domain can point at the account, so the handle
+// must be under one of the service's domains.
+func (h *AuthHandler) checkNewHandle(handle string) *xrpc.Error {
+	if xrpcErr, ok := h.checkServiceHandle(handle); ok {
+		return xrpcErr
+	}
+	if len(h.handleDomains) == 0 {
+		return xrpc.NewError(http.StatusBadRequest, "UnsupportedDomain", "This service does not offer handles")
+	}
+	return xrpc.NewError(http.StatusBadRequest, "UnsupportedDomain", "Handle must end in one of "+strings.Join(h.handleDomains, ", "))
+}
+
+// publishHandle sets the handle of a did:plc with an operation signed by the account's rotation key
+func (h *AuthHandler) publishHandle(ctx context.Context, did, handle string) error {
+	rotationKey, err := h.keystore.GetKey(ctx, did, identity.KeyPurposeRotation)
+	if err != nil {
+		return fmt.Errorf("failed to get rotation key: %w", err)
+	}
+	return h.plcClient.Update(ctx, did, rotationKey, func(op *plc.Operation) {
+		op.SetHandle(handle)
+	})
+}
+
+// IdentityEventsHandler publishes the handle changes after the given cursor for
+// the PDS and BGS to update their indexes and DID caches
+func (h *AuthHandler) IdentityEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var cursor int64
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		var err error
+		cursor, err = strconv.ParseInt(cursorStr, 10, 64)
+		if err != nil || cursor < 0 {
+			http.Error(w, "Invalid cursor", http.StatusBadRequest)
+			return
+		}
+	}
+
+	events, err := h.userRepo.ListIdentityEvents(r.Context(), cursor, 500)
+	if err != nil {
+		log.Printf("Failed to list identity events: %v", err)
+		http.Error(w, "Failed to list identity events", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"events": events,
+	})
+}
+
+// IdentityResolveHandle handles com.atproto.identity.resolveHandle
+func (h *AuthHandler) IdentityResolveHandle(ctx context.Context, params *api.IdentityResolveHandle_Params) (*api.IdentityResolveHandle_Output, error) {
+	handle, err := identity.NormalizeHandle(params.Handle)
+	if err != nil {
+		return nil, xrpc.NewError(http.StatusBadRequest, "InvalidRequest", err.Error())
+	}
+
+	// Handles of local accounts are answered from the database
+	if user, err := h.userRepo.GetUserByUsername(ctx, handle); err == nil {
+		return &api.IdentityResolveHandle_Output{DID: user.DID}, nil
+	}
+
+	// Resolve and verify the handle
+	did, err := h.handleResolver.ResolveHandle(ctx, handle)
+	if err != nil {
+		log.Printf("Failed to resolve handle %s: %v", handle, err)
+		return nil, xrpc.NewError(http.StatusBadRequest, "HandleNotFound", "Unable to resolve handle")
+	}
+
+	return &api.IdentityResolveHandle_Output{DID: did}, nil
+}
+
+// loadKeystore creates the keystore from KEYSTORE_MASTER_KEY. If
+// KEYSTORE_PREVIOUS_MASTER_KEY is set, keys stored under it are re-encrypted.
+func loadKeystore(ctx context.Context, dbPool *pgxpool.Pool) (*identity.Keystore, error) {
+	masterKey, err := identity.LoadMasterKey("KEYSTORE_MASTER_KEY")
+	if err != nil {
+		return nil, err
+	}
+
+	var previousKeys [][]byte
+	if os.Getenv("KEYSTORE_PREVIOUS_MASTER_KEY") != "" || os.Getenv("KEYSTORE_PREVIOUS_MASTER_KEY_FILE") != "" {
+		previousKey, err := identity.LoadMasterKey("KEYSTORE_PREVIOUS_MASTER_KEY")
+		if err != nil {
+			return nil, err
+		}
+		previousKeys = append(previousKeys, previousKey)
+	}
+
+	keystore, err := identity.NewKeystore(dbPool, masterKey, previousKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(previousKeys) > 0 {
+		count, err := keystore.Reencrypt(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to re-encrypt keys: %w", err)
+		}
+		log.Printf("Re-encrypted %d keys with the new master key", count)
+	}
+
+	return keystore, nil
+}
+
+// loadPrivateKey loads a hex-encoded private key from envVar, or from the file
+// named by <envVar>_FILE, of the type named by <envVar>_TYPE (ed25519 by
+// default). If neither is set, an ephemeral key is generated if allowEphemeral
+// is true, and an error is returned otherwise.
+func loadPrivateKey(envVar string, allowEphemeral bool) (identity.PrivateKey, error) {
+	keyType := identity.KeyType(os.Getenv(envVar + "_TYPE"))
+	if keyType == "" {
+		keyType = identity.KeyTypeEd25519
+	}
+
+	keyHex := os.Getenv(envVar)
+	if keyHex == "" {
+		if path := os.Getenv(envVar + "_FILE"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s_FILE: %w", envVar, err)
+			}
+			keyHex = string(data)
+		}
+	}
+	if keyHex == "" {
+		if !allowEphemeral {
+			return nil, fmt.Errorf("%s or %s_FILE must be set", envVar, envVar)
+		}
+		log.Printf("%s not set, generating an ephemeral key", envVar)
+		return identity.GenerateKey(keyType)
+	}
+
+	raw, err := hex.DecodeString(strings.TrimSpace(keyHex))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envVar, err)
+	}
+	return identity.ParsePrivateKey(keyType, raw)
+}
+
+// loadSigningKeys loads the session signing keys from the directory in
+// JWT_KEY_DIR, and returns how often they rotate: every JWT_KEY_ROTATION, 30
+// days by default. Without a key directory, the single key in JWT_SIGNING_KEY
+// is used and never rotated.
+func loadSigningKeys() (*auth.KeyManager, time.Duration, error) {
+	dir := os.Getenv("JWT_KEY_DIR")
+	if dir == "" {
+		key, err := loadPrivateKey("JWT_SIGNING_KEY", true)
+		if err != nil {
+			return nil, 0, err
+		}
+		return auth.NewKeyManager(key), 0, nil
+	}
+
+	keys, err := auth.LoadKeyManager(dir)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rotation := 30 * 24 * time.Hour
+	if rotationStr := os.Getenv("JWT_KEY_ROTATION"); rotationStr != "" {
+		rotation, err = time.ParseDuration(rotationStr)
+		if err != nil || rotation <= 0 {
+			return nil, 0, fmt.Errorf("invalid JWT_KEY_ROTATION: %s", rotationStr)
+		}
+	}
+	return keys, rotation, nil
+}
+
+func main() {
+	// Create context
+	ctx := context.Background()
+
+	// Connect to database
+	dbPool, err := db.GetDBPool(ctx)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbPool.Close()
+
+	// Create repositories
+	userRepo := auth.NewUserRepository(dbPool)
+
+	// Create keystore
+	keystore, err := loadKeystore(ctx, dbPool)
+	if err != nil {
+		log.Fatalf("Failed to create keystore: %v", err)
+	}
+
+	// Load PLC rotation key. It is the recovery key of every DID registered
+	// here, so it must persist across restarts.
+	rotationKey, err := loadPrivateKey("PLC_ROTATION_KEY", false)
+	if err != nil {
+		log.Fatalf("Failed to load PLC rotation key: %v", err)
+	}
+
+	// Create PLC client
+	plcURL := os.Getenv("PLC_URL")
+	if plcURL == "" {
+		plcURL = "http://localhost:8084"
+	}
+	plcClient := plc.NewClient(plcURL)
+
+	pdsEndpoint := os.Getenv("PDS_URL")
+	if pdsEndpoint == "" {
+		pdsEndpoint = "http://localhost:8082"
+	}
+
+	// Create session manager
+	signingKeys, keyRotation, err := loadSigningKeys()
+	if err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+	sessionStore := auth.NewPostgresSessionStore(dbPool)
+	sessions := auth.NewSessionManager(pdsEndpoint, signingKeys, sessionStore)
+	signingKeys.Retention = sessions.RefreshTTL
+
+	// Pick up keys rotated to by other instances and rotate when the current key is due
+	if keyRotation > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+			for range ticker.C {
+				if err := signingKeys.Reload(); err != nil {
+					log.Printf("Failed to reload signing keys: %v", err)
+					continue
+				}
+				rotated, err := signingKeys.RotateIfOlder(keyRotation)
+				if err != nil {
+					log.Printf("Failed to rotate signing key: %v", err)
+				} else if rotated {
+					log.Printf("Rotated session signing key to %s", signingKeys.Current().ID)
+				}
+			}
+		}()
+	}
+	if err := sessions.LoadRevocations(ctx); err != nil {
+		log.Fatalf("Failed to load revoked sessions: %v", err)
+	}
+
+	// Create OAuth request store
+	oauthRequests := oauth.NewPostgresRequestStore(dbPool)
+
+	// Refresh revoked sessions and delete expired sessions and OAuth requests
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := sessions.LoadRevocations(context.Background()); err != nil {
+				log.Printf("Failed to load revoked sessions: %v", err)
+			}
+			if _, err := sessionStore.DeleteExpiredSessions(context.Background(), time.Now()); err != nil {
+				log.Printf("Failed to delete expired sessions: %v", err)
+			}
+			if _, err := oauthRequests.DeleteExpiredRequests(context.Background(), time.Now()); err != nil {
+				log.Printf("Failed to delete expired OAuth requests: %v", err)
+			}
+		}
+	}()
+
+	// Create resolvers
+	didResolver := identity.NewCachingResolver(identity.NewResolver(plcURL), time.Hour, 5*time.Minute, 24*time.Hour)
+	handleResolver := identity.NewHandleResolver(didResolver)
+
+	// Create mailer
+	mailer, err := mail.LoadMailer()
+	if err != nil {
+		log.Fatalf("Failed to create mailer: %v", err)
+	}
+
+	// Create two-factor store
+	twoFactor := auth.NewTwoFactorStore(dbPool, keystore)
+
+	// Create login throttle; the Postgres store is shared by all instances
+	var throttleStore auth.ThrottleStore = auth.NewPostgresThrottleStore(dbPool)
+	if os.Getenv("LOGIN_THROTTLE_STORE") == "memory" {
+		throttleStore = auth.NewMemoryThrottleStore()
+	}
+	throttle := auth.NewLoginThrottle(throttleStore)
+	throttle.TrustProxy = os.Getenv("TRUST_PROXY") == "true"
+
+	// Forget login failures older than the throttle windows
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := throttle.DeleteExpired(context.Background()); err != nil {
+				log.Printf("Failed to delete expired login failures: %v", err)
+			}
+		}
+	}()
+
+	// Create handlers
+	authHandler := NewAuthHandler(userRepo, sessionStore, sessions, twoFactor, throttle, keystore, plcClient, didResolver, handleResolver, mailer, rotationKey, pdsEndpoint)
+
+	// Load invite code settings
+	authHandler.accounts.InviteRequired = os.Getenv("INVITE_REQUIRED") == "true"
+	if invitesPerUser := os.Getenv("INVITES_PER_USER"); invitesPerUser != "" {
+		authHandler.invitesPerUser, err = strconv.Atoi(invitesPerUser)
+		if err != nil || authHandler.invitesPerUser < 0 {
+			log.Fatalf("Invalid INVITES_PER_USER: %s", invitesPerUser)
+		}
+	}
+
+	// Load the handle domains offered to accounts
+	for _, domain := range strings.Split(os.Getenv("HANDLE_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			authHandler.handleDomains = append(authHandler.handleDomains, "."+strings.TrimPrefix(domain, "."))
+		}
+	}
+
+	// Create OAuth authorization server
+	oauthIssuer := os.Getenv("OAUTH_ISSUER")
+	if oauthIssuer == "" {
+		oauthIssuer = "http://localhost:8081"
+	}
+	oauthServer := oauth.NewServer(oauthIssuer, oauth.NewClientResolver(), oauthRequests, authHandler, sessions)
+	oauthServer.AccessTokenTTL = sessions.AccessTTL
+
+	adminPassword, err := loadAdminPassword()
+	if err != nil {
+		log.Fatalf("Failed to load admin password: %v", err)
+	}
+
+	// Create HTTP server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/register", authHandler.RegisterHandler)
+	mux.HandleFunc("/login", authHandler.LoginHandler)
+	mux.HandleFunc("/xrpc/com.atproto.server.createSession", authHandler.CreateSessionHandler)
+	mux.HandleFunc("/xrpc/com.atproto.server.refreshSession", authHandler.RefreshSessionHandler)
+	mux.HandleFunc("/xrpc/com.atproto.server.getSession", authHandler.GetSessionHandler)
+	mux.HandleFunc("/xrpc/com.atproto.server.deleteSession", authHandler.DeleteSessionHandler)
+	mux.HandleFunc("/sessions", auth.RequireAuth(sessions, authHandler.ListSessionsHandler))
+	mux.HandleFunc("/sessions/revokeAll", auth.RequireFullAccess(sessions, authHandler.RevokeAllSessionsHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.getServiceAuth", auth.RequireAuth(sessions, authHandler.GetServiceAuthHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.createAppPassword", auth.RequireFullAccess(sessions, authHandler.CreateAppPasswordHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.listAppPasswords", auth.RequireAuth(sessions, authHandler.ListAppPasswordsHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.revokeAppPassword", auth.RequireFullAccess(sessions, authHandler.RevokeAppPasswordHandler))
+	mux.HandleFunc("/.well-known/jwks.json", authHandler.JWKSHandler)
+	mux.HandleFunc("/xrpc/com.atproto.server.requestEmailConfirmation", auth.RequireAuth(sessions, authHandler.RequestEmailConfirmationHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.confirmEmail", auth.RequireAuth(sessions, authHandler.ConfirmEmailHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.requestPasswordReset", authHandler.RequestPasswordResetHandler)
+	mux.HandleFunc("/xrpc/com.atproto.server.resetPassword", authHandler.ResetPasswordHandler)
+	mux.HandleFunc("/xrpc/com.atproto.server.requestEmailUpdate", auth.RequireFullAccess(sessions, authHandler.RequestEmailUpdateHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.updateEmail", auth.RequireFullAccess(sessions, authHandler.UpdateEmailHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.deactivateAccount", auth.RequireFullAccess(sessions, authHandler.DeactivateAccountHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.activateAccount", auth.RequireFullAccess(sessions, authHandler.ActivateAccountHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.requestAccountDelete", auth.RequireFullAccess(sessions, authHandler.RequestAccountDeleteHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.deleteAccount", authHandler.DeleteAccountHandler)
+	mux.HandleFunc("/admin/updateAccountStatus", requireAdmin(adminPassword, authHandler.UpdateAccountStatusHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.getAccountInviteCodes", auth.RequireAuth(sessions, authHandler.GetAccountInviteCodesHandler))
+	mux.HandleFunc("/xrpc/com.atproto.server.createInviteCodes", requireAdmin(adminPassword, authHandler.CreateInviteCodesHandler))
+	mux.HandleFunc("/xrpc/com.atproto.admin.getInviteCodes", requireAdmin(adminPassword, authHandler.GetInviteCodesHandler))
+	mux.HandleFunc("/xrpc/com.atproto.admin.disableInviteCodes", requireAdmin(adminPassword, authHandler.DisableInviteCodesHandler))
+	mux.HandleFunc("/2fa/totp/enroll", auth.RequireFullAccess(sessions, authHandler.EnrollTOTPHandler))
+	mux.HandleFunc("/2fa/totp/confirm", auth.RequireFullAccess(sessions, authHandler.ConfirmTOTPHandler))
+	mux.HandleFunc("/2fa/totp/disable", auth.RequireFullAccess(sessions, authHandler.DisableTOTPHandler))
+	mux.HandleFunc("/2fa/recoveryCodes", auth.RequireFullAccess(sessions, authHandler.RegenerateRecoveryCodesHandler))
+	mux.HandleFunc("/xrpc/com.atproto.identity.requestPlcOperationSignature", auth.RequireFullAccess(sessions, authHandler.RequestPlcOperationSignatureHandler))
+	mux.HandleFunc("/migration/serviceToken", auth.RequireFullAccess(sessions, authHandler.ServiceTokenHandler))
+	mux.HandleFunc("/migration/createAccount", authHandler.MigrationAccountHandler)
+	mux.HandleFunc("/migration/signPlcOperation", auth.RequireFullAccess(sessions, authHandler.SignPlcOperationHandler))
+	mux.HandleFunc("/xrpc/com.atproto.identity.updateHandle", auth.RequireFullAccess(sessions, authHandler.UpdateHandleHandler))
+	api.RegisterIdentityResolveHandle(mux, authHandler)
+	oauthServer.Register(mux)
+
+	// Health check endpoint
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+	})
+
+	// Create internal HTTP server for the feeds the PDS and BGS sync from and
+	// for signing commits. It must only be reachable from other services, never
+	// through the gateway.
+	internalMux := http.NewServeMux()
+	internalMux.HandleFunc("/sessions/revoked", authHandler.RevokedSessionsHandler)
+	internalMux.HandleFunc("/accounts/statuses", authHandler.AccountStatusesHandler)
+	internalMux.HandleFunc("/identity/events", authHandler.IdentityEventsHandler)
+	internalMux.HandleFunc("/repo/signCommit", authHandler.SignCommitHandler)
+
+	internalAddr := os.Getenv("INTERNAL_ADDR")
+	if internalAddr == "" {
+		internalAddr = ":9081"
+	}
+
+	// Create servers
+	server := &http.Server{
+		Addr:    ":8081",
+		Handler: mux,
+	}
+	internalServer := &http.Server{
+		Addr:    internalAddr,
+		Handler: internalMux,
+	}
+
+	// Start servers in goroutines
+	go func() {
+		log.Printf("Starting auth service on port 8081")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+	go func() {
+		log.Printf("Starting internal auth service on %s", internalAddr)
+		if err := internalServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Internal server error: %v", err)
+		}
+	}()
+
+	// Wait for interrupt signal
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	// Graceful shutdown
+	log.Println("Shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := internalServer.Shutdown(ctx); err != nil {
+		log.Printf("Internal server forced to shutdown: %v", err)
+	}
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
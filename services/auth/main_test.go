@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/yourusername/atprogo/pkg/auth"
+	"github.com/yourusername/atprogo/pkg/identity"
+)
+
+const testIssuer = "https://auth.example.com"
+
+// testAccessToken signs an access token of a session with scope
+func testAccessToken(t *testing.T, key identity.PrivateKey, did, scope string) string {
+	t.Helper()
+	token := auth.NewJWT(testIssuer, did, auth.DefaultAccessTokenTTL)
+	token.Claims.Audience = testIssuer
+	token.Claims.SessionID = "session"
+	token.Claims.Scope = scope
+	token.Header["kid"] = auth.KeyID(key.Public())
+	signed, err := token.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestGetServiceAuthRefusesMigrationMethods(t *testing.T) {
+	key, err := identity.GenerateKey(identity.KeyTypeEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := auth.NewSessionVerifier(testIssuer, auth.NewStaticKeySet(key.Public()))
+	handler := auth.RequireAuth(verifier, (&AuthHandler{}).GetServiceAuthHandler)
+
+	for _, scope := range []string{auth.ScopeAccess, auth.ScopeAppPass} {
+		for _, lxm := range []string{auth.LxmCreateAccount, auth.LxmImportRepo} {
+			t.Run(scope+" "+lxm, func(t *testing.T) {
+				query := url.Values{"aud": {"https://pds.example.com"}, "lxm": {lxm}}
+				req := httptest.NewRequest(http.MethodGet, "/xrpc/com.atproto.server.getServiceAuth?"+query.Encode(), nil)
+				req.Header.Set("Authorization", "Bearer "+testAccessToken(t, key, "did:plc:alice", scope))
+				rec := httptest.NewRecorder()
+				handler(rec, req)
+
+				if rec.Code != http.StatusForbidden {
+					t.Errorf("status = %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body)
+				}
+			})
+		}
+	}
+}
@@ -11,8 +11,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/yourusername/atprogo/pkg/auth"
 	"github.com/yourusername/atprogo/pkg/bgs"
 	"github.com/yourusername/atprogo/pkg/db"
+	"github.com/yourusername/atprogo/pkg/identity"
 )
 
 // FollowRequest represents a follow request
@@ -24,12 +26,18 @@ type FollowRequest struct {
 // BGSHandler handles BGS requests
 type BGSHandler struct {
 	followRepo *bgs.FollowRepository
+	resolver   *identity.CachingResolver
+
+	// pdsEndpoint is the PDS whose auth service publishes the account statuses
+	pdsEndpoint string
 }
 
 // NewBGSHandler creates a new BGS handler
-func NewBGSHandler(followRepo *bgs.FollowRepository) *BGSHandler {
+func NewBGSHandler(followRepo *bgs.FollowRepository, resolver *identity.CachingResolver, pdsEndpoint string) *BGSHandler {
 	return &BGSHandler{
-		followRepo: followRepo,
+		followRepo:  followRepo,
+		resolver:    resolver,
+		pdsEndpoint: pdsEndpoint,
 	}
 }
 
@@ -47,8 +55,20 @@ func (h *BGSHandler) FollowHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate request
-	if req.Follower == "" || req.Following == "" {
-		http.Error(w, "Follower and following are required", http.StatusBadRequest)
+	if req.Following == "" {
+		http.Error(w, "Following is required", http.StatusBadRequest)
+		return
+	}
+
+	// The authenticated user is always the follower
+	follower, ok := authorizeFollower(w, r, req.Follower)
+	if !ok {
+		return
+	}
+	req.Follower = follower
+
+	// Accounts that are not active cannot be followed
+	if !h.checkAccountActive(w, r, req.Following) {
 		return
 	}
 
@@ -83,11 +103,18 @@ func (h *BGSHandler) UnfollowHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate request
-	if req.Follower == "" || req.Following == "" {
-		http.Error(w, "Follower and following are required", http.StatusBadRequest)
+	if req.Following == "" {
+		http.Error(w, "Following is required", http.StatusBadRequest)
 		return
 	}
 
+	// The authenticated user is always the follower
+	follower, ok := authorizeFollower(w, r, req.Follower)
+	if !ok {
+		return
+	}
+	req.Follower = follower
+
 	// Delete follow
 	if err := h.followRepo.DeleteFollow(r.Context(), req.Follower, req.Following); err != nil {
 		log.Printf("Failed to delete follow: %v", err)
@@ -114,6 +141,9 @@ func (h *BGSHandler) GetFollowersHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "DID is required", http.StatusBadRequest)
 		return
 	}
+	if !h.checkAccountActive(w, r, did) {
+		return
+	}
 
 	// Get limit and offset from query parameters
 	limit := 50
@@ -167,6 +197,9 @@ func (h *BGSHandler) GetFollowingHandler(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "DID is required", http.StatusBadRequest)
 		return
 	}
+	if !h.checkAccountActive(w, r, did) {
+		return
+	}
 
 	// Get limit and offset from query parameters
 	limit := 50
@@ -207,6 +240,51 @@ func (h *BGSHandler) GetFollowingHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// checkAccountActive writes a not found response and returns false if the account is not active
+func (h *BGSHandler) checkAccountActive(w http.ResponseWriter, r *http.Request, did string) bool {
+	status, err := h.followRepo.GetAccountStatus(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to get account status: %v", err)
+		http.Error(w, "Failed to get account", http.StatusInternalServerError)
+		return false
+	}
+	if status != bgs.AccountStatusActive {
+		http.Error(w, "Account is "+status, http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// applyAccountStatus applies an account status change published by the auth
+// service to the account's follows. Deactivations of accounts that migrated to
+// another PDS leave their follows visible.
+func (h *BGSHandler) applyAccountStatus(ctx context.Context, change auth.AccountStatus) error {
+	if change.Status == auth.StatusDeleted {
+		return h.followRepo.DeleteAccount(ctx, change.DID)
+	}
+	if change.Status == auth.StatusDeactivated && h.pdsEndpoint != "" {
+		h.resolver.Invalidate(change.DID)
+		migrated, err := bgs.MigratedAway(ctx, h.resolver, change.DID, h.pdsEndpoint)
+		if err != nil {
+			return err
+		}
+		if migrated {
+			return h.followRepo.SetAccountStatus(ctx, change.DID, bgs.AccountStatusActive)
+		}
+	}
+	return h.followRepo.SetAccountStatus(ctx, change.DID, change.Status)
+}
+
+// authorizeFollower returns the authenticated DID, rejecting requests that name a different follower
+func authorizeFollower(w http.ResponseWriter, r *http.Request, follower string) (string, bool) {
+	did, _ := auth.DIDFromContext(r.Context())
+	if follower != "" && follower != did {
+		http.Error(w, "Cannot follow on behalf of another user", http.StatusForbidden)
+		return "", false
+	}
+	return did, true
+}
+
 func main() {
 	// Create context
 	ctx := context.Background()
@@ -221,13 +299,53 @@ func main() {
 	// Create repositories
 	followRepo := bgs.NewFollowRepository(dbPool)
 
+	// Create session verifier
+	verifier, err := auth.LoadSessionVerifier()
+	if err != nil {
+		log.Fatalf("Failed to load session verifier: %v", err)
+	}
+
+	// Sync revoked sessions from the auth service
+	if authURL := os.Getenv("AUTH_INTERNAL_URL"); authURL != "" {
+		revocations := auth.NewRevocationList()
+		verifier.SetRevocationList(revocations)
+		go auth.SyncRevocations(ctx, revocations, authURL+"/sessions/revoked", 30*time.Second)
+	}
+
+	// Create DID resolver for service tokens
+	plcURL := os.Getenv("PLC_URL")
+	if plcURL == "" {
+		plcURL = "http://localhost:8084"
+	}
+	resolver := identity.NewCachingResolver(identity.NewResolver(plcURL), time.Hour, 5*time.Minute, 24*time.Hour)
+
+	endpoint := os.Getenv("BGS_URL")
+	if endpoint == "" {
+		endpoint = "http://localhost:8083"
+	}
+
 	// Create handlers
-	bgsHandler := NewBGSHandler(followRepo)
+	pdsEndpoint := os.Getenv("PDS_URL")
+	if pdsEndpoint == "" {
+		log.Printf("PDS_URL not set, deactivations of migrated accounts will hide their follows")
+	}
+	bgsHandler := NewBGSHandler(followRepo, resolver, pdsEndpoint)
+
+	// Hide and delete follows as account statuses change
+	if authURL := os.Getenv("AUTH_INTERNAL_URL"); authURL != "" {
+		go auth.SyncAccountStatuses(ctx, authURL+"/accounts/statuses", 30*time.Second, bgsHandler.applyAccountStatus)
+
+		// Forget cached DID documents whose handle changed
+		go auth.SyncIdentityEvents(ctx, authURL+"/identity/events", 30*time.Second, func(ctx context.Context, event auth.IdentityEvent) error {
+			resolver.Invalidate(event.DID)
+			return nil
+		})
+	}
 
 	// Create HTTP server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/follow", bgsHandler.FollowHandler)
-	mux.HandleFunc("/unfollow", bgsHandler.UnfollowHandler)
+	mux.HandleFunc("/follow", auth.RequireServiceAuth(verifier, resolver, endpoint, auth.LxmCreateRecord, bgsHandler.FollowHandler))
+	mux.HandleFunc("/unfollow", auth.RequireServiceAuth(verifier, resolver, endpoint, auth.LxmDeleteRecord, bgsHandler.UnfollowHandler))
 	mux.HandleFunc("/followers", bgsHandler.GetFollowersHandler)
 	mux.HandleFunc("/following", bgsHandler.GetFollowingHandler)
 
@@ -3,15 +3,22 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/yourusername/atprogo/pkg/auth"
 	"github.com/yourusername/atprogo/pkg/db"
+	"github.com/yourusername/atprogo/pkg/identity"
 	"github.com/yourusername/atprogo/pkg/pds"
+	"github.com/yourusername/atprogo/pkg/plc"
 )
 
 // CreatePostRequest represents a create post request
@@ -20,20 +27,49 @@ type CreatePostRequest struct {
 	Content string `json:"content"`
 }
 
+// maxBlobSize limits the size of uploaded blobs
+const maxBlobSize = 5 << 20
+
 // GetPostsRequest represents a get posts request
 type GetPostsRequest struct {
 	DID string `json:"did"`
 }
 
+// CreateRepositoryRequest represents a request to create the repository of a new account
+type CreateRepositoryRequest struct {
+	Handle string      `json:"handle"`
+	Commit *pds.Commit `json:"commit"`
+}
+
+// ImportRequest represents a repository import request
+type ImportRequest struct {
+	Source      string `json:"source"`
+	ExportToken string `json:"exportToken"`
+}
+
 // PDSHandler handles PDS requests
 type PDSHandler struct {
-	repoRepo *pds.RepositoryRepository
+	repoRepo    *pds.RepositoryRepository
+	accountRepo *pds.AccountRepository
+	resolver    *identity.CachingResolver
+	plcClient   *plc.Client
+	httpClient  *http.Client
+	endpoint    string
+
+	// signer signs commits with the account signing keys the auth service
+	// keeps; posts cannot be created without one
+	signer pds.CommitSigner
 }
 
 // NewPDSHandler creates a new PDS handler
-func NewPDSHandler(repoRepo *pds.RepositoryRepository) *PDSHandler {
+func NewPDSHandler(repoRepo *pds.RepositoryRepository, accountRepo *pds.AccountRepository, resolver *identity.CachingResolver, plcClient *plc.Client, endpoint string) *PDSHandler {
 	return &PDSHandler{
-		repoRepo: repoRepo,
+		repoRepo:    repoRepo,
+		accountRepo: accountRepo,
+		resolver:    resolver,
+		plcClient:   plcClient,
+		httpClient:  &http.Client{Timeout: 5 * time.Minute},
+		endpoint:    endpoint,
 	}
 }
 
@@ -51,13 +87,24 @@ func (h *PDSHandler) CreatePostHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Validate request
-	if req.DID == "" || req.Content == "" {
-		http.Error(w, "DID and content are required", http.StatusBadRequest)
+	if req.Content == "" {
+		http.Error(w, "Content is required", http.StatusBadRequest)
+		return
+	}
+
+	// Posts are always created in the authenticated user's repository
+	did, _ := auth.DIDFromContext(r.Context())
+	if req.DID != "" && req.DID != did {
+		http.Error(w, "Cannot create posts for another user", http.StatusForbidden)
 		return
 	}
 
 	// Create post
-	doc, err := h.repoRepo.CreatePost(r.Context(), req.DID, req.Content)
+	if h.signer == nil {
+		http.Error(w, "Commit signing is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	doc, err := h.repoRepo.CreatePost(r.Context(), did, req.Content, h.signer)
 	if err != nil {
 		log.Printf("Failed to create post: %v", err)
 		http.Error(w, "Failed to create post", http.StatusInternalServerError)
@@ -84,6 +131,10 @@ func (h *PDSHandler) GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.checkRepositoryActive(w, r, did, http.StatusNotFound) {
+		return
+	}
+
 	// Get posts
 	docs, err := h.repoRepo.GetDocumentsByType(r.Context(), did, "app.bsky.feed.post")
 	if err != nil {
@@ -97,6 +148,412 @@ func (h *PDSHandler) GetPostsHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(docs)
 }
 
+// DIDDocumentHandler serves the DID document of the did:web account for the requested host
+func (h *PDSHandler) DIDDocumentHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get account for host
+	did := identity.WebDID(r.Host)
+	account, err := h.accountRepo.GetAccount(r.Context(), did)
+	if err != nil {
+		http.Error(w, "DID not found", http.StatusNotFound)
+		return
+	}
+
+	// Decode signing key
+	signingKey, err := identity.DecodeMultikey(account.SigningKey)
+	if err != nil {
+		log.Printf("Invalid signing key for %s: %v", did, err)
+		http.Error(w, "Failed to build DID document", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/did+ld+json")
+	json.NewEncoder(w).Encode(identity.NewDocument(did, signingKey, []string{account.Handle}, h.endpoint))
+}
+
+// UploadBlobHandler handles blob uploads
+func (h *PDSHandler) UploadBlobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Blobs are always uploaded to the authenticated user's repository
+	did, _ := auth.DIDFromContext(r.Context())
+	if queryDID := r.URL.Query().Get("did"); queryDID != "" && queryDID != did {
+		http.Error(w, "Cannot upload blobs for another user", http.StatusForbidden)
+		return
+	}
+	if !h.checkRepositoryActive(w, r, did, http.StatusForbidden) {
+		return
+	}
+
+	// Read blob
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxBlobSize+1))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxBlobSize {
+		http.Error(w, "Blob too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	mimeType := r.Header.Get("Content-Type")
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	// Store blob
+	blob := &pds.Blob{
+		RepositoryDID: did,
+		MimeType:      mimeType,
+		Data:          data,
+	}
+	if err := h.repoRepo.CreateBlob(r.Context(), blob); err != nil {
+		log.Printf("Failed to create blob: %v", err)
+		http.Error(w, "Failed to upload blob", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"cid":      blob.CID,
+		"mimeType": blob.MimeType,
+		"size":     len(blob.Data),
+	})
+}
+
+// GetBlobHandler handles getting a blob
+func (h *PDSHandler) GetBlobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	did := r.URL.Query().Get("did")
+	cid := r.URL.Query().Get("cid")
+	if did == "" || cid == "" {
+		http.Error(w, "DID and CID are required", http.StatusBadRequest)
+		return
+	}
+	if !h.checkRepositoryActive(w, r, did, http.StatusNotFound) {
+		return
+	}
+
+	// Get blob
+	blob, err := h.repoRepo.GetBlob(r.Context(), did, cid)
+	if err != nil {
+		http.Error(w, "Blob not found", http.StatusNotFound)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", blob.MimeType)
+	w.Write(blob.Data)
+}
+
+// ExportHandler handles exporting a repository to another PDS during migration
+func (h *PDSHandler) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	did := r.URL.Query().Get("did")
+	if did == "" {
+		http.Error(w, "DID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Verify service token
+	_, err := auth.VerifyServiceToken(r.Context(), auth.BearerToken(r), h.resolver,
+		auth.WithAudience(h.endpoint), auth.WithIssuers(did), auth.WithLexiconMethod(auth.LxmGetRepo))
+	if err != nil {
+		http.Error(w, "Invalid service token", http.StatusUnauthorized)
+		return
+	}
+
+	// Deactivated repositories can still be exported to move them elsewhere
+	status, err := h.repoRepo.GetRepositoryStatus(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to get repository status: %v", err)
+		http.Error(w, "Failed to export repository", http.StatusInternalServerError)
+		return
+	}
+	if status == pds.RepoStatusSuspended || status == pds.RepoStatusTakendown {
+		http.Error(w, "Repository is "+status, http.StatusForbidden)
+		return
+	}
+
+	// Export repository
+	export, err := h.repoRepo.ExportRepository(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to export repository: %v", err)
+		http.Error(w, "Failed to export repository", http.StatusInternalServerError)
+		return
+	}
+
+	// Verify the chain before handing it out
+	signingKeys, err := h.signingKeys(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to resolve DID: %v", err)
+		http.Error(w, "Failed to resolve DID", http.StatusBadGateway)
+		return
+	}
+	if err := pds.VerifyExport(export, signingKeys); err != nil {
+		log.Printf("Repository %s failed verification: %v", did, err)
+		http.Error(w, "Repository failed verification", http.StatusConflict)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(export)
+}
+
+// signingKeys returns the signing keys an account has had, oldest first. The
+// history of a did:plc comes from its audit log; other DIDs only have their
+// current key.
+func (h *PDSHandler) signingKeys(ctx context.Context, did string) ([]identity.PublicKey, error) {
+	if !strings.HasPrefix(did, "did:plc:") {
+		signer, err := h.resolver.Resolve(ctx, did)
+		if err != nil {
+			return nil, err
+		}
+		return []identity.PublicKey{signer.PublicKey}, nil
+	}
+
+	entries, err := h.plcClient.GetAuditLog(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	return plc.SigningKeys(entries)
+}
+
+// ImportHandler handles importing a repository from another PDS during migration
+func (h *PDSHandler) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Verify service token
+	claims, err := auth.VerifyServiceToken(r.Context(), auth.BearerToken(r), h.resolver,
+		auth.WithAudience(h.endpoint), auth.WithLexiconMethod(auth.LxmImportRepo))
+	if err != nil {
+		http.Error(w, "Invalid service token", http.StatusUnauthorized)
+		return
+	}
+	did := claims.Issuer
+
+	var req ImportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Source == "" || req.ExportToken == "" {
+		http.Error(w, "Source and export token are required", http.StatusBadRequest)
+		return
+	}
+
+	// Fetch repository from the old PDS
+	export, err := pds.FetchExport(r.Context(), h.httpClient, req.Source, did, req.ExportToken)
+	if err != nil {
+		log.Printf("Failed to fetch export: %v", err)
+		http.Error(w, "Failed to fetch repository", http.StatusBadGateway)
+		return
+	}
+
+	// Verify and import against the DID's signing keys
+	signingKeys, err := h.signingKeys(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to resolve DID: %v", err)
+		http.Error(w, "Failed to resolve DID", http.StatusBadGateway)
+		return
+	}
+	if err := h.repoRepo.ImportRepository(r.Context(), export, signingKeys); err != nil {
+		log.Printf("Failed to import repository: %v", err)
+		http.Error(w, "Failed to import repository", http.StatusBadRequest)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"did":     did,
+		"head":    export.Head,
+		"commits": len(export.Commits),
+		"blobs":   len(export.Blobs),
+	})
+}
+
+// CreateRepositoryHandler creates the account and repository of a newly
+// registered DID from a genesis commit signed with the DID's signing key. The
+// DID document must name this PDS and list the handle.
+func (h *PDSHandler) CreateRepositoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Verify service token
+	claims, err := auth.VerifyServiceToken(r.Context(), auth.BearerToken(r), h.resolver,
+		auth.WithAudience(h.endpoint), auth.WithLexiconMethod(auth.LxmCreateAccount))
+	if err != nil {
+		http.Error(w, "Invalid service token", http.StatusUnauthorized)
+		return
+	}
+	did := claims.Issuer
+
+	var req CreateRepositoryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Handle == "" || req.Commit == nil {
+		http.Error(w, "Handle and commit are required", http.StatusBadRequest)
+		return
+	}
+
+	// Check that the DID document claims this PDS and the handle
+	doc, err := h.resolver.ResolveDocument(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to resolve DID: %v", err)
+		http.Error(w, "Failed to resolve DID", http.StatusBadGateway)
+		return
+	}
+	if strings.TrimSuffix(doc.PDSEndpoint(), "/") != strings.TrimSuffix(h.endpoint, "/") {
+		http.Error(w, "DID document does not name this PDS", http.StatusBadRequest)
+		return
+	}
+	if !slices.Contains(doc.Handles(), req.Handle) {
+		http.Error(w, "DID document does not list the handle", http.StatusBadRequest)
+		return
+	}
+
+	// Verify the genesis commit against the DID's signing key
+	signer, err := doc.DID()
+	if err != nil {
+		log.Printf("Invalid DID document for %s: %v", did, err)
+		http.Error(w, "Invalid DID document", http.StatusBadRequest)
+		return
+	}
+	if err := pds.VerifyGenesisCommit(req.Commit, did, signer.PublicKey); err != nil {
+		log.Printf("Invalid genesis commit for %s: %v", did, err)
+		http.Error(w, "Invalid genesis commit", http.StatusBadRequest)
+		return
+	}
+
+	// Create account and repository
+	account := &pds.Account{
+		DID:        did,
+		Handle:     req.Handle,
+		SigningKey: identity.FormatKeyDID(signer.PublicKey),
+	}
+	if err := h.repoRepo.CreateGenesisRepository(r.Context(), account, req.Commit); err != nil {
+		if errors.Is(err, pds.ErrRepositoryExists) {
+			http.Error(w, "Repository already exists", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, pds.ErrHandleTaken) {
+			http.Error(w, "Handle already taken", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to create repository: %v", err)
+		http.Error(w, "Failed to create repository", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"did":  did,
+		"head": req.Commit.ID,
+	})
+}
+
+// DeactivateMigratedHandler deactivates a repository whose DID document no longer points at this PDS
+func (h *PDSHandler) DeactivateMigratedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	did := r.URL.Query().Get("did")
+	if did == "" {
+		http.Error(w, "DID is required", http.StatusBadRequest)
+		return
+	}
+
+	// Check the current DID document, bypassing the cache
+	h.resolver.Invalidate(did)
+	doc, err := h.resolver.ResolveDocument(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to resolve DID: %v", err)
+		http.Error(w, "Failed to resolve DID", http.StatusBadGateway)
+		return
+	}
+	if doc.PDSEndpoint() == h.endpoint {
+		http.Error(w, "Account has not migrated", http.StatusBadRequest)
+		return
+	}
+
+	// Deactivate repository
+	if err := h.repoRepo.UpdateRepositoryStatus(r.Context(), did, pds.RepoStatusDeactivated); err != nil {
+		log.Printf("Failed to deactivate repository: %v", err)
+		http.Error(w, "Failed to deactivate repository", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// checkRepositoryActive writes an error response with code and returns false if
+// the repository of did exists but is not active
+func (h *PDSHandler) checkRepositoryActive(w http.ResponseWriter, r *http.Request, did string, code int) bool {
+	status, err := h.repoRepo.GetRepositoryStatus(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to get repository status: %v", err)
+		http.Error(w, "Failed to get repository", http.StatusInternalServerError)
+		return false
+	}
+	if status != "" && status != pds.RepoStatusActive {
+		http.Error(w, "Repository is "+status, code)
+		return false
+	}
+	return true
+}
+
+// applyAccountStatus applies an account status change published by the auth
+// service to the account's repository
+func (h *PDSHandler) applyAccountStatus(ctx context.Context, change auth.AccountStatus) error {
+	if change.Status == auth.StatusDeleted {
+		return h.repoRepo.DeleteRepository(ctx, change.DID)
+	}
+	return h.repoRepo.SetRepositoryStatus(ctx, change.DID, change.Status)
+}
+
+// applyIdentityEvent applies a handle change published by the auth service to
+// the account's did:web document and the cached DID documents
+func (h *PDSHandler) applyIdentityEvent(ctx context.Context, event auth.IdentityEvent) error {
+	if err := h.accountRepo.UpdateHandle(ctx, event.DID, event.Handle); err != nil {
+		return err
+	}
+	h.resolver.Invalidate(event.DID)
+	return nil
+}
+
 func main() {
 	// Create context
 	ctx := context.Background()
@@ -111,13 +568,60 @@ func main() {
 	// Create repositories
 	repoRepo := pds.NewRepositoryRepository(dbPool)
 
+	accountRepo := pds.NewAccountRepository(dbPool)
+
+	endpoint := os.Getenv("PDS_URL")
+	if endpoint == "" {
+		endpoint = "http://localhost:8082"
+	}
+
+	// Create DID resolver
+	plcURL := os.Getenv("PLC_URL")
+	if plcURL == "" {
+		plcURL = "http://localhost:8084"
+	}
+	resolver := identity.NewCachingResolver(identity.NewResolver(plcURL), time.Hour, 5*time.Minute, 24*time.Hour)
+
+	// Create session verifier
+	verifier, err := auth.LoadSessionVerifier()
+	if err != nil {
+		log.Fatalf("Failed to load session verifier: %v", err)
+	}
+
+	// Sync revoked sessions from the auth service
+	if authURL := os.Getenv("AUTH_INTERNAL_URL"); authURL != "" {
+		revocations := auth.NewRevocationList()
+		verifier.SetRevocationList(revocations)
+		go auth.SyncRevocations(ctx, revocations, authURL+"/sessions/revoked", 30*time.Second)
+	}
+
 	// Create handlers
-	pdsHandler := NewPDSHandler(repoRepo)
+	pdsHandler := NewPDSHandler(repoRepo, accountRepo, resolver, plc.NewClient(plcURL), endpoint)
+
+	// Sign commits with the keys the auth service keeps
+	if authURL := os.Getenv("AUTH_INTERNAL_URL"); authURL != "" {
+		pdsHandler.signer = pds.NewRemoteSigner(authURL)
+	} else {
+		log.Printf("AUTH_INTERNAL_URL not set, posts cannot be created")
+	}
+
+	// Hide and delete repositories as account statuses change, and follow handle changes
+	if authURL := os.Getenv("AUTH_INTERNAL_URL"); authURL != "" {
+		go auth.SyncAccountStatuses(ctx, authURL+"/accounts/statuses", 30*time.Second, pdsHandler.applyAccountStatus)
+		go auth.SyncIdentityEvents(ctx, authURL+"/identity/events", 30*time.Second, pdsHandler.applyIdentityEvent)
+	}
 
 	// Create HTTP server
 	mux := http.NewServeMux()
-	mux.HandleFunc("/posts/create", pdsHandler.CreatePostHandler)
+	mux.HandleFunc("/posts/create", auth.RequireAuth(verifier, pdsHandler.CreatePostHandler))
 	mux.HandleFunc("/posts/get", pdsHandler.GetPostsHandler)
+	mux.HandleFunc("/.well-known/did.json", pdsHandler.DIDDocumentHandler)
+	mux.HandleFunc("/blobs/upload", auth.RequireAuth(verifier, pdsHandler.UploadBlobHandler))
+	mux.HandleFunc("/blobs/get", pdsHandler.GetBlobHandler)
+	mux.HandleFunc("/repo/create", pdsHandler.CreateRepositoryHandler)
+	mux.HandleFunc("/migration/export", pdsHandler.ExportHandler)
+	mux.HandleFunc("/migration/import", pdsHandler.ImportHandler)
+	mux.HandleFunc("/migration/deactivate", pdsHandler.DeactivateMigratedHandler)
 
 	// Health check endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
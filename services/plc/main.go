@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yourusername/atprogo/pkg/db"
+	"github.com/yourusername/atprogo/pkg/identity"
+	"github.com/yourusername/atprogo/pkg/plc"
+)
+
+// PLCHandler handles PLC directory requests
+type PLCHandler struct {
+	opRepo *plc.OperationRepository
+}
+
+// NewPLCHandler creates a new PLC handler
+func NewPLCHandler(opRepo *plc.OperationRepository) *PLCHandler {
+	return &PLCHandler{
+		opRepo: opRepo,
+	}
+}
+
+// ServeHTTP routes /{did}, /{did}/log/last and /{did}/log/audit
+func (h *PLCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	did, rest, _ := strings.Cut(path, "/")
+	if _, _, err := identity.SplitDID(did); err != nil || !strings.HasPrefix(did, "did:plc:") {
+		http.Error(w, "Invalid DID", http.StatusBadRequest)
+		return
+	}
+
+	switch rest {
+	case "":
+		switch r.Method {
+		case http.MethodGet:
+			h.GetDocumentHandler(w, r, did)
+		case http.MethodPost:
+			h.SubmitOperationHandler(w, r, did)
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	case "log/last":
+		h.GetLastOperationHandler(w, r, did)
+	case "log/audit":
+		h.GetAuditLogHandler(w, r, did)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// GetDocumentHandler handles resolving a DID document
+func (h *PLCHandler) GetDocumentHandler(w http.ResponseWriter, r *http.Request, did string) {
+	// Get latest operation
+	last, err := h.opRepo.GetLastOperation(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to get operation: %v", err)
+		http.Error(w, "Failed to resolve DID", http.StatusInternalServerError)
+		return
+	}
+	if last == nil {
+		http.Error(w, "DID not registered", http.StatusNotFound)
+		return
+	}
+	if last.Operation.Type == plc.OpTypeTombstone {
+		http.Error(w, "DID not available", http.StatusGone)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/did+ld+json")
+	json.NewEncoder(w).Encode(last.Operation.Document(did))
+}
+
+// SubmitOperationHandler handles submitting a signed operation
+func (h *PLCHandler) SubmitOperationHandler(w http.ResponseWriter, r *http.Request, did string) {
+	var op plc.Operation
+	if err := json.NewDecoder(r.Body).Decode(&op); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Apply operation
+	if _, err := h.opRepo.SubmitOperation(r.Context(), did, &op); err != nil {
+		if errors.Is(err, plc.ErrInvalidOperation) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Failed to submit operation: %v", err)
+		http.Error(w, "Failed to submit operation", http.StatusInternalServerError)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"success": true})
+}
+
+// GetLastOperationHandler handles getting the latest operation for a DID
+func (h *PLCHandler) GetLastOperationHandler(w http.ResponseWriter, r *http.Request, did string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get latest operation
+	last, err := h.opRepo.GetLastOperation(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to get operation: %v", err)
+		http.Error(w, "Failed to get operation", http.StatusInternalServerError)
+		return
+	}
+	if last == nil {
+		http.Error(w, "DID not registered", http.StatusNotFound)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(last.Operation)
+}
+
+// GetAuditLogHandler handles getting the audit log for a DID
+func (h *PLCHandler) GetAuditLogHandler(w http.ResponseWriter, r *http.Request, did string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Get audit log
+	entries, err := h.opRepo.GetAuditLog(r.Context(), did)
+	if err != nil {
+		log.Printf("Failed to get audit log: %v", err)
+		http.Error(w, "Failed to get audit log", http.StatusInternalServerError)
+		return
+	}
+	if len(entries) == 0 {
+		http.Error(w, "DID not registered", http.StatusNotFound)
+		return
+	}
+
+	// Return response
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func main() {
+	// Create context
+	ctx := context.Background()
+
+	// Connect to database
+	dbPool, err := db.GetDBPool(ctx)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer dbPool.Close()
+
+	// Create repositories
+	opRepo := plc.NewOperationRepository(dbPool)
+
+	// Create handlers
+	plcHandler := NewPLCHandler(opRepo)
+
+	// Create HTTP server
+	mux := http.NewServeMux()
+	mux.Handle("/", plcHandler)
+
+	// Health check endpoint
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": "healthy"})
+	})
+
+	// Create server
+	server := &http.Server{
+		Addr:    ":8084",
+		Handler: mux,
+	}
+
+	// Start server in a goroutine
+	go func() {
+		log.Printf("Starting PLC directory on port 8084")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	// Wait for interrupt signal
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	// Graceful shutdown
+	log.Println("Shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Fatalf("Server forced to shutdown: %v", err)
+	}
+	log.Println("Server stopped")
+}